@@ -0,0 +1,154 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package diff
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestUnified(t *testing.T) {
+	for _, test := range []struct {
+		desc     string
+		old, new string
+		want     string
+	}{{
+		desc: "same",
+		old:  "a\nb\n",
+		new:  "a\nb\n",
+	}, {
+		desc: "one change",
+		old:  "1\n2\n3\n4\nimage: ko://foo\n6\n7\n8\n9\n",
+		new:  "1\n2\n3\n4\nimage: gcr.io/foo@sha256:abc\n6\n7\n8\n9\n",
+		want: `--- a
++++ b
+@@ -2,7 +2,7 @@
+ 2
+ 3
+ 4
+-image: ko://foo
++image: gcr.io/foo@sha256:abc
+ 6
+ 7
+ 8
+`,
+	}, {
+		desc: "two hunks",
+		old:  "a\n1\n2\n3\n4\n5\n6\n7\nb\n",
+		new:  "A\n1\n2\n3\n4\n5\n6\n7\nB\n",
+		want: `--- a
++++ b
+@@ -1,4 +1,4 @@
+-a
++A
+ 1
+ 2
+ 3
+@@ -6,4 +6,4 @@
+ 5
+ 6
+ 7
+-b
++B
+`,
+	}, {
+		desc: "merged hunks",
+		old:  "a\n1\n2\n3\n4\n5\n6\nb\n",
+		new:  "A\n1\n2\n3\n4\n5\n6\nB\n",
+		want: `--- a
++++ b
+@@ -1,8 +1,8 @@
+-a
++A
+ 1
+ 2
+ 3
+ 4
+ 5
+ 6
+-b
++B
+`,
+	}, {
+		desc: "insert and delete",
+		old:  "a\nb\n",
+		new:  "b\nc\n",
+		want: `--- a
++++ b
+@@ -1,2 +1,2 @@
+-a
+ b
++c
+`,
+	}, {
+		desc: "from empty",
+		old:  "",
+		new:  "a\n",
+		want: `--- a
++++ b
+@@ -0,0 +1 @@
++a
+`,
+	}, {
+		desc: "no newline at end",
+		old:  "a\nb",
+		new:  "a\nc",
+		want: `--- a
++++ b
+@@ -1,2 +1,2 @@
+ a
+-b
+\ No newline at end of file
++c
+\ No newline at end of file
+`,
+	}} {
+		t.Run(test.desc, func(t *testing.T) {
+			got := string(Unified("a", "b", []byte(test.old), []byte(test.new)))
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("Unified(); (-want +got) = %v", diff)
+			}
+		})
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	r := rand.New(rand.NewSource(0))
+	random := func() []string {
+		lines := make([]string, r.Intn(20))
+		for i := range lines {
+			lines[i] = string(rune('a' + r.Intn(4)))
+		}
+		return lines
+	}
+	for i := 0; i < 1000; i++ {
+		a, b := random(), random()
+		var gotA, gotB []string
+		for _, e := range diffLines(a, b) {
+			if e.op != opInsert {
+				gotA = append(gotA, e.line)
+			}
+			if e.op != opDelete {
+				gotB = append(gotB, e.line)
+			}
+		}
+		if strings.Join(gotA, "") != strings.Join(a, "") || strings.Join(gotB, "") != strings.Join(b, "") {
+			t.Fatalf("diffLines(%v, %v) doesn't edit one into the other", a, b)
+		}
+	}
+}
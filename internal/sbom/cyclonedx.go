@@ -15,12 +15,13 @@
 package sbom
 
 import (
-	"bytes"
 	"encoding/base64"
 	"encoding/hex"
-	"encoding/json"
 	"strings"
 
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/sigstore/cosign/pkg/oci"
 )
 
@@ -35,7 +36,10 @@ func h1ToSHA256(s string) string {
 	return hex.EncodeToString(b)
 }
 
-func GenerateImageCycloneDX(mod []byte) ([]byte, error) {
+// cycloneDXSchema is the JSON schema of the CycloneDX version we generate.
+const cycloneDXSchema = "http://cyclonedx.org/schema/bom-1.5.schema.json"
+
+func GenerateImageCycloneDX(mod []byte, img oci.SignedImage) ([]byte, error) {
 	var err error
 	mod, err = massageGoVersionM(mod)
 	if err != nil {
@@ -47,22 +51,45 @@ func GenerateImageCycloneDX(mod []byte) ([]byte, error) {
 		return nil, err
 	}
 
+	imgDigest, err := img.Digest()
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := img.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	m, err := img.Manifest()
+	if err != nil {
+		return nil, err
+	}
+
+	// image -> main module -> transitive deps
+	//       -> base image
+	imageRef := ociRef("image", imgDigest, qualifier{
+		key:   "mediaType",
+		value: string(m.MediaType),
+	})
+	mainRef := bomRef(&bi.Main)
 	doc := document{
+		Schema:      cycloneDXSchema,
 		BOMFormat:   "CycloneDX",
-		SpecVersion: "1.4",
+		SpecVersion: "1.5",
 		Version:     1,
 		Metadata: metadata{
-			Component: component{
-				BOMRef:  bomRef(&bi.Main),
-				Type:    "application",
-				Name:    bi.Main.Path,
-				Version: bi.Main.Version,
-				Purl:    bomRef(&bi.Main),
-				ExternalReferences: []externalReference{{
-					URL:  "https://" + bi.Main.Path,
-					Type: "vcs",
+			Timestamp: cfg.Created.UTC().Format(dateFormat),
+			Tools: &tools{
+				Components: []component{{
+					Type: "application",
+					Name: "ko",
 				}},
 			},
+			Component: component{
+				BOMRef: imageRef,
+				Type:   "container",
+				Name:   imgDigest.String(),
+				Purl:   imageRef,
+			},
 			Properties: []property{{
 				Name:  "cdx:gomod:binary:name",
 				Value: "out",
@@ -71,19 +98,46 @@ func GenerateImageCycloneDX(mod []byte) ([]byte, error) {
 			// TODO: include go version
 			// TODO: include bi.Settings?
 		},
+		Components: []component{{
+			BOMRef:  mainRef,
+			Type:    "application",
+			Name:    bi.Main.Path,
+			Version: bi.Main.Version,
+			Purl:    mainRef,
+			ExternalReferences: []externalReference{{
+				URL:  "https://" + bi.Main.Path,
+				Type: "vcs",
+			}},
+		}},
 		Dependencies: []dependency{{
-			Ref: bomRef(&bi.Main),
+			Ref:       imageRef,
+			DependsOn: []string{mainRef},
+		}, {
+			Ref: mainRef,
 		}},
 		Compositions: []composition{{
-			Aggregate: "complete",
-			Dependencies: []string{
-				bomRef(&bi.Main),
-			},
+			Aggregate:    "complete",
+			Dependencies: []string{imageRef, mainRef},
 		}, {
 			Aggregate:    "unknown",
 			Dependencies: []string{},
 		}},
 	}
+
+	base, err := baseImageComponent(m.Annotations)
+	if err != nil {
+		return nil, err
+	}
+	if base != nil {
+		doc.Components = append(doc.Components, *base)
+		doc.Dependencies[0].DependsOn = append(doc.Dependencies[0].DependsOn, base.BOMRef)
+		doc.Dependencies = append(doc.Dependencies, dependency{
+			Ref: base.BOMRef,
+		})
+		// We don't know what the base image contains.
+		doc.Compositions[1].Dependencies = append(doc.Compositions[1].Dependencies, base.BOMRef)
+	}
+
 	for _, dep := range bi.Deps {
 		// Don't include replaced deps
 		if dep.Replace != nil {
@@ -108,7 +162,7 @@ func GenerateImageCycloneDX(mod []byte) ([]byte, error) {
 			}}
 		}
 		doc.Components = append(doc.Components, comp)
-		doc.Dependencies[0].DependsOn = append(doc.Dependencies[0].DependsOn, bomRef(dep))
+		doc.Dependencies[1].DependsOn = append(doc.Dependencies[1].DependsOn, bomRef(dep))
 		doc.Dependencies = append(doc.Dependencies, dependency{
 			Ref: bomRef(dep),
 		})
@@ -116,13 +170,50 @@ func GenerateImageCycloneDX(mod []byte) ([]byte, error) {
 		doc.Compositions[1].Dependencies = append(doc.Compositions[1].Dependencies, bomRef(dep))
 	}
 
-	var buf bytes.Buffer
-	enc := json.NewEncoder(&buf)
-	enc.SetIndent("", "  ")
-	if err := enc.Encode(doc); err != nil {
+	return encode(doc)
+}
+
+// baseImageComponent returns the component for the base image recorded in
+// the annotations of an image, if any.
+func baseImageComponent(annotations map[string]string) (*component, error) {
+	base, ok := annotations[specsv1.AnnotationBaseImageName]
+	if !ok {
+		return nil, nil
+	}
+	rawHash, ok := annotations[specsv1.AnnotationBaseImageDigest]
+	if !ok {
+		return nil, nil
+	}
+	ref, err := name.ParseReference(base)
+	if err != nil {
+		return nil, err
+	}
+	h, err := v1.NewHash(rawHash)
+	if err != nil {
 		return nil, err
 	}
-	return buf.Bytes(), nil
+
+	qual := []qualifier{{
+		key:   "repository_url",
+		value: ref.Context().Name(),
+	}}
+	if t, ok := ref.(name.Tag); ok {
+		qual = append(qual, qualifier{
+			key:   "tag",
+			value: t.Identifier(),
+		})
+	}
+	purl := ociRef("image", h, qual...)
+	return &component{
+		BOMRef: purl,
+		Type:   "container",
+		Name:   ref.Context().Digest(h.String()).String(),
+		Purl:   purl,
+		Hashes: []hash{{
+			Alg:     "SHA-256",
+			Content: h.Hex,
+		}},
+	}, nil
 }
 
 func GenerateIndexCycloneDX(sii oci.SignedImageIndex) ([]byte, error) {
@@ -130,6 +221,7 @@ func GenerateIndexCycloneDX(sii oci.SignedImageIndex) ([]byte, error) {
 }
 
 type document struct {
+	Schema       string        `json:"$schema,omitempty"`
 	BOMFormat    string        `json:"bomFormat"`
 	SpecVersion  string        `json:"specVersion"`
 	Version      int           `json:"version"`
@@ -139,18 +231,23 @@ type document struct {
 	Compositions []composition `json:"compositions,omitempty"`
 }
 type metadata struct {
+	Timestamp  string     `json:"timestamp,omitempty"`
+	Tools      *tools     `json:"tools,omitempty"`
 	Component  component  `json:"component"`
 	Properties []property `json:"properties,omitempty"`
 }
+type tools struct {
+	Components []component `json:"components,omitempty"`
+}
 type component struct {
-	BOMRef             string              `json:"bom-ref"`
+	BOMRef             string              `json:"bom-ref,omitempty"`
 	Type               string              `json:"type"`
 	Name               string              `json:"name"`
-	Version            string              `json:"version"`
+	Version            string              `json:"version,omitempty"`
 	Scope              string              `json:"scope,omitempty"`
 	Hashes             []hash              `json:"hashes,omitempty"`
-	Purl               string              `json:"purl"`
-	ExternalReferences []externalReference `json:"externalReferences"`
+	Purl               string              `json:"purl,omitempty"`
+	ExternalReferences []externalReference `json:"externalReferences,omitempty"`
 }
 type hash struct {
 	Alg     string `json:"alg"`
@@ -0,0 +1,84 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sbom
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sigstore/cosign/pkg/oci/signed"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+const goVersionM = `out: go1.18
+	path	github.com/google/ko
+	mod	github.com/google/ko	(devel)	
+	dep	github.com/google/go-containerregistry	v0.11.0	h1:6rOrSOiWvSzTNc3qPvhV3nkzRLasYv0WPs1Cr1zWx+w=
+	dep	github.com/sigstore/cosign	v1.10.0	
+	=>	github.com/sigstore/cosign	v1.10.1	
+`
+
+func TestGenerateImageCycloneDX(t *testing.T) {
+	img, err := mutate.ConfigFile(empty.Image, &v1.ConfigFile{
+		Created: v1.Time{Time: time.Date(2022, 8, 1, 12, 0, 0, 0, time.UTC)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	img = mutate.Annotations(img, map[string]string{
+		specsv1.AnnotationBaseImageName:   "gcr.io/distroless/static:nonroot",
+		specsv1.AnnotationBaseImageDigest: "sha256:1111111111111111111111111111111111111111111111111111111111111111",
+	}).(v1.Image)
+
+	got, err := GenerateImageCycloneDX([]byte(goVersionM), signed.Image(img))
+	if err != nil {
+		t.Fatalf("GenerateImageCycloneDX() = %v", err)
+	}
+
+	var doc map[string]interface{}
+	if err := json.Unmarshal(got, &doc); err != nil {
+		t.Fatalf("Unmarshal() = %v", err)
+	}
+	if got, want := doc["specVersion"], "1.5"; got != want {
+		t.Errorf("specVersion = %v, want %v", got, want)
+	}
+	if got, want := doc["$schema"], cycloneDXSchema; got != want {
+		t.Errorf("$schema = %v, want %v", got, want)
+	}
+
+	golden := filepath.Join("testdata", "cyclonedx.json")
+	if *update {
+		if err := ioutil.WriteFile(golden, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("GenerateImageCycloneDX() = %s\nwant %s\n(run with -update to regenerate %s)", got, want, golden)
+	}
+}
@@ -0,0 +1,315 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sbom
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	ctypes "github.com/sigstore/cosign/pkg/types"
+)
+
+// GoVersionMMediaType is the media type of SBOMs that are the output of
+// `go version -m`.
+const GoVersionMMediaType types.MediaType = "application/vnd.go.version-m"
+
+// MergeBase merges base, the SBOM of a base image, into doc, the SBOM
+// generated for an image built on top of it, so that the packages of the
+// base image are listed too. doc may be SPDX or CycloneDX, and base may be
+// either of those in the same format, or the output of `go version -m`.
+func MergeBase(doc []byte, mt types.MediaType, base []byte, baseMT types.MediaType) ([]byte, error) {
+	switch {
+	case mt == ctypes.SPDXJSONMediaType && baseMT == ctypes.SPDXJSONMediaType:
+		return mergeSPDX(doc, base)
+	case mt == ctypes.SPDXJSONMediaType && baseMT == GoVersionMMediaType:
+		return mergeSPDXGoVersionM(doc, base)
+	case mt == ctypes.CycloneDXJSONMediaType && baseMT == ctypes.CycloneDXJSONMediaType:
+		return mergeCycloneDX(doc, base)
+	case mt == ctypes.CycloneDXJSONMediaType && baseMT == GoVersionMMediaType:
+		return mergeCycloneDXGoVersionM(doc, base)
+	default:
+		return nil, fmt.Errorf("merging a %s SBOM into a %s SBOM is not supported", baseMT, mt)
+	}
+}
+
+// baseParent returns the ID of the package that the packages of the base
+// image's SBOM belong to: the base image if doc lists it, or else the image
+// that doc describes.
+func baseParent(doc *Document) string {
+	for _, r := range doc.Relationships {
+		if r.Type == "DESCENDANT_OF" {
+			return r.Related
+		}
+	}
+	if len(doc.DocumentDescribes) > 0 {
+		return doc.DocumentDescribes[0]
+	}
+	return ""
+}
+
+func mergeSPDX(doc, base []byte) ([]byte, error) {
+	var d, b Document
+	if err := json.Unmarshal(doc, &d); err != nil {
+		return nil, fmt.Errorf("parsing SPDX SBOM: %w", err)
+	}
+	if err := json.Unmarshal(base, &b); err != nil {
+		return nil, fmt.Errorf("parsing base image SPDX SBOM: %w", err)
+	}
+	parent := baseParent(&d)
+
+	// Prefix the IDs from the base SBOM, so they don't collide with ours.
+	rename := func(id string) string {
+		return "SPDXRef-Base-" + strings.TrimPrefix(id, "SPDXRef-")
+	}
+	ids := make(map[string]bool, len(b.Packages))
+	for _, p := range b.Packages {
+		ids[p.ID] = true
+		p.ID = rename(p.ID)
+		d.Packages = append(d.Packages, p)
+	}
+
+	// Whatever the base SBOM describes is contained in the base image.
+	roots := b.DocumentDescribes
+	for _, r := range b.Relationships {
+		if r.Element == b.ID && r.Type == "DESCRIBES" {
+			roots = append(roots, r.Related)
+		}
+	}
+	added := map[string]bool{}
+	for _, root := range roots {
+		if !ids[root] || added[root] {
+			continue
+		}
+		added[root] = true
+		d.Relationships = append(d.Relationships, Relationship{
+			Element: parent,
+			Type:    "CONTAINS",
+			Related: rename(root),
+		})
+	}
+	// Keep the relationships between the packages of the base SBOM, and
+	// drop those to files or other documents that we don't copy.
+	for _, r := range b.Relationships {
+		if !ids[r.Element] || !ids[r.Related] {
+			continue
+		}
+		d.Relationships = append(d.Relationships, Relationship{
+			Element: rename(r.Element),
+			Type:    r.Type,
+			Related: rename(r.Related),
+		})
+	}
+	return encode(d)
+}
+
+func mergeSPDXGoVersionM(doc, base []byte) ([]byte, error) {
+	var d Document
+	if err := json.Unmarshal(doc, &d); err != nil {
+		return nil, fmt.Errorf("parsing SPDX SBOM: %w", err)
+	}
+	base, err := massageGoVersionM(base)
+	if err != nil {
+		return nil, err
+	}
+	bi, err := ParseBuildInfo(string(base))
+	if err != nil {
+		return nil, err
+	}
+	parent := baseParent(&d)
+
+	ids := make(map[string]bool, len(d.Packages))
+	for _, p := range d.Packages {
+		ids[p.ID] = true
+	}
+	mainID := modulePackageName(&bi.Main)
+	d.Relationships = append(d.Relationships, Relationship{
+		Element: parent,
+		Type:    "CONTAINS",
+		Related: mainID,
+	})
+	if !ids[mainID] {
+		ids[mainID] = true
+		d.Packages = append(d.Packages, Package{
+			ID:               mainID,
+			Name:             bi.Main.Path,
+			DownloadLocation: "https://" + bi.Main.Path,
+			FilesAnalyzed:    false,
+			LicenseConcluded: NOASSERTION,
+			LicenseDeclared:  NOASSERTION,
+			CopyrightText:    NOASSERTION,
+			ExternalRefs: []ExternalRef{{
+				Category: "PACKAGE-MANAGER",
+				Type:     "purl",
+				Locator:  goRef(&bi.Main),
+			}},
+		})
+	}
+	for _, dep := range bi.Deps {
+		depID := modulePackageName(dep)
+		d.Relationships = append(d.Relationships, Relationship{
+			Element: mainID,
+			Type:    "DEPENDS_ON",
+			Related: depID,
+		})
+		// Our own binary may depend on the same modules.
+		if ids[depID] {
+			continue
+		}
+		ids[depID] = true
+		pkg := Package{
+			ID:               depID,
+			Name:             dep.Path,
+			Version:          dep.Version,
+			DownloadLocation: fmt.Sprintf("https://proxy.golang.org/%s/@v/%s.zip", dep.Path, dep.Version),
+			FilesAnalyzed:    false,
+			LicenseConcluded: NOASSERTION,
+			LicenseDeclared:  NOASSERTION,
+			CopyrightText:    NOASSERTION,
+			ExternalRefs: []ExternalRef{{
+				Category: "PACKAGE-MANAGER",
+				Type:     "purl",
+				Locator:  goRef(dep),
+			}},
+		}
+		if dep.Sum != "" {
+			pkg.Checksums = []Checksum{{
+				Algorithm: "SHA256",
+				Value:     h1ToSHA256(dep.Sum),
+			}}
+		}
+		d.Packages = append(d.Packages, pkg)
+	}
+	return encode(d)
+}
+
+func mergeCycloneDX(doc, base []byte) ([]byte, error) {
+	var d, b document
+	if err := json.Unmarshal(doc, &d); err != nil {
+		return nil, fmt.Errorf("parsing CycloneDX SBOM: %w", err)
+	}
+	if err := json.Unmarshal(base, &b); err != nil {
+		return nil, fmt.Errorf("parsing base image CycloneDX SBOM: %w", err)
+	}
+
+	comps := b.Components
+	if b.Metadata.Component.Name != "" {
+		// The component the base SBOM describes, e.g. the base image.
+		comps = append([]component{b.Metadata.Component}, comps...)
+	}
+	added := map[string]bool{}
+	for _, c := range comps {
+		addCycloneDXComponent(&d, c, added)
+	}
+	for _, dep := range b.Dependencies {
+		if added[dep.Ref] {
+			d.Dependencies = append(d.Dependencies, dep)
+		}
+	}
+	return encode(d)
+}
+
+func mergeCycloneDXGoVersionM(doc, base []byte) ([]byte, error) {
+	var d document
+	if err := json.Unmarshal(doc, &d); err != nil {
+		return nil, fmt.Errorf("parsing CycloneDX SBOM: %w", err)
+	}
+	base, err := massageGoVersionM(base)
+	if err != nil {
+		return nil, err
+	}
+	bi, err := ParseBuildInfo(string(base))
+	if err != nil {
+		return nil, err
+	}
+
+	added := map[string]bool{}
+	addCycloneDXComponent(&d, component{
+		BOMRef:  bomRef(&bi.Main),
+		Type:    "application",
+		Name:    bi.Main.Path,
+		Version: bi.Main.Version,
+		Purl:    bomRef(&bi.Main),
+		ExternalReferences: []externalReference{{
+			URL:  "https://" + bi.Main.Path,
+			Type: "vcs",
+		}},
+	}, added)
+	for _, dep := range bi.Deps {
+		if dep.Replace != nil {
+			continue
+		}
+		comp := component{
+			BOMRef:  bomRef(dep),
+			Type:    "library",
+			Name:    dep.Path,
+			Version: dep.Version,
+			Scope:   "required",
+			Purl:    bomRef(dep),
+			ExternalReferences: []externalReference{{
+				URL:  "https://" + dep.Path,
+				Type: "vcs",
+			}},
+		}
+		if dep.Sum != "" {
+			comp.Hashes = []hash{{
+				Alg:     "SHA-256",
+				Content: h1ToSHA256(dep.Sum),
+			}}
+		}
+		addCycloneDXComponent(&d, comp, added)
+	}
+	return encode(d)
+}
+
+// addCycloneDXComponent adds c to d, unless d already has a component with
+// the same bom-ref, and records in added that it did.
+func addCycloneDXComponent(d *document, c component, added map[string]bool) {
+	if c.BOMRef == "" {
+		c.BOMRef = c.Purl
+	}
+	if c.BOMRef == "" {
+		c.BOMRef = c.Name + "@" + c.Version
+	}
+	if c.BOMRef == d.Metadata.Component.BOMRef {
+		return
+	}
+	for _, existing := range d.Components {
+		if existing.BOMRef == c.BOMRef {
+			return
+		}
+	}
+	added[c.BOMRef] = true
+	d.Components = append(d.Components, c)
+	// We don't know whether the base SBOM lists all the dependencies.
+	for i := range d.Compositions {
+		if d.Compositions[i].Aggregate == "unknown" {
+			d.Compositions[i].Dependencies = append(d.Compositions[i].Dependencies, c.BOMRef)
+			break
+		}
+	}
+}
+
+func encode(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
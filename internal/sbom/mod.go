@@ -29,9 +29,8 @@ import (
 )
 
 func modulePackageName(mod *Module) string {
-	return fmt.Sprintf("SPDXRef-Package-%s-%s",
-		strings.ReplaceAll(mod.Path, "/", "."),
-		mod.Version)
+	return "SPDXRef-Package-" + invalidIDChars.ReplaceAllString(
+		strings.ReplaceAll(mod.Path, "/", ".")+"-"+mod.Version, "-")
 }
 
 func bomRef(mod *Module) string {
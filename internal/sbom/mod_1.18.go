@@ -35,9 +35,8 @@ func ParseBuildInfo(data string) (*BuildInfo, error) {
 }
 
 func modulePackageName(mod *debug.Module) string {
-	return fmt.Sprintf("SPDXRef-Package-%s-%s",
-		strings.ReplaceAll(mod.Path, "/", "."),
-		mod.Version)
+	return "SPDXRef-Package-" + invalidIDChars.ReplaceAllString(
+		strings.ReplaceAll(mod.Path, "/", ".")+"-"+mod.Version, "-")
 }
 
 func bomRef(mod *debug.Module) string {
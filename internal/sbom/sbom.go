@@ -51,3 +51,24 @@ func massageGoVersionM(b []byte) ([]byte, error) {
 	}
 	return out.Bytes(), nil
 }
+
+// ParseGoVersionM parses the output of `go version -m` for a single binary.
+func ParseGoVersionM(mod []byte) (*BuildInfo, error) {
+	massaged, err := massageGoVersionM(mod)
+	if err != nil {
+		return nil, err
+	}
+	bi, err := ParseBuildInfo(string(massaged))
+	if err != nil {
+		return nil, err
+	}
+	if bi.GoVersion == "" {
+		// The first line, which massageGoVersionM drops, is
+		// "<file>: <go version>".
+		line := strings.SplitN(string(mod), "\n", 2)[0]
+		if i := strings.LastIndex(line, ": "); i >= 0 {
+			bi.GoVersion = strings.TrimSpace(line[i+len(": "):])
+		}
+	}
+	return bi, nil
+}
@@ -0,0 +1,100 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sbom
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sigstore/cosign/pkg/oci/signed"
+)
+
+var validID = regexp.MustCompile(`^SPDXRef-[a-zA-Z0-9.-]+$`)
+
+func TestGenerateImageSPDX(t *testing.T) {
+	img, err := mutate.ConfigFile(empty.Image, &v1.ConfigFile{
+		Created: v1.Time{Time: time.Date(2022, 8, 1, 12, 0, 0, 0, time.UTC)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	img = mutate.Annotations(img, map[string]string{
+		specsv1.AnnotationBaseImageName:   "gcr.io/distroless/static:nonroot",
+		specsv1.AnnotationBaseImageDigest: "sha256:1111111111111111111111111111111111111111111111111111111111111111",
+	}).(v1.Image)
+
+	got, err := GenerateImageSPDX("v0.0.0", []byte(goVersionM), signed.Image(img))
+	if err != nil {
+		t.Fatalf("GenerateImageSPDX() = %v", err)
+	}
+
+	var doc Document
+	if err := json.Unmarshal(got, &doc); err != nil {
+		t.Fatalf("Unmarshal() = %v", err)
+	}
+	if got, want := doc.Version, "SPDX-2.3"; got != want {
+		t.Errorf("spdxVersion = %v, want %v", got, want)
+	}
+	if got, want := doc.DataLicense, "CC0-1.0"; got != want {
+		t.Errorf("dataLicense = %v, want %v", got, want)
+	}
+	// Every relationship is between elements of the document, and the
+	// document describes the image.
+	ids := map[string]bool{doc.ID: true}
+	for _, p := range doc.Packages {
+		if p.ID == "" || p.Name == "" || p.DownloadLocation == "" {
+			t.Errorf("package %+v lacks a required field", p)
+		}
+		if !validID.MatchString(p.ID) {
+			t.Errorf("package SPDXID %q isn't a valid identifier", p.ID)
+		}
+		ids[p.ID] = true
+	}
+	describes := false
+	for _, r := range doc.Relationships {
+		if !ids[r.Element] || !ids[r.Related] {
+			t.Errorf("relationship %+v refers to an unknown element", r)
+		}
+		if r.Element == doc.ID && r.Type == "DESCRIBES" && r.Related == doc.Packages[0].ID {
+			describes = true
+		}
+	}
+	if !describes {
+		t.Errorf("document doesn't describe the image %s", doc.Packages[0].ID)
+	}
+
+	golden := filepath.Join("testdata", "spdx.json")
+	if *update {
+		if err := ioutil.WriteFile(golden, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("GenerateImageSPDX() = %s\nwant %s\n(run with -update to regenerate %s)", got, want, golden)
+	}
+}
@@ -0,0 +1,263 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package sign implements sigstore "keyless" signing of images: the
+// signatures are made with an ephemeral key, which Fulcio certifies for the
+// OIDC identity of the signer, and are recorded in the Rekor transparency log.
+package sign
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sigstore/cosign/pkg/cosign/bundle"
+	"github.com/sigstore/cosign/pkg/oci"
+	"github.com/sigstore/cosign/pkg/oci/static"
+	"github.com/sigstore/rekor/pkg/generated/models"
+)
+
+const (
+	// DefaultFulcioURL is the URL of the public Fulcio instance.
+	DefaultFulcioURL = "https://fulcio.sigstore.dev"
+	// DefaultRekorURL is the URL of the public Rekor instance.
+	DefaultRekorURL = "https://rekor.sigstore.dev"
+)
+
+// Keyless signs payloads with an ephemeral key. The key is generated, and
+// certified by Fulcio, the first time it's needed, and again whenever its
+// certificate is about to expire.
+type Keyless struct {
+	// FulcioURL and RekorURL are the sigstore instances to use.
+	FulcioURL string
+	RekorURL  string
+	// IDToken is the OIDC identity token to request certificates with. If
+	// it's empty, it is discovered from the environment with IDToken.
+	IDToken string
+	// Client is used for all requests. If nil, http.DefaultClient is used.
+	Client *http.Client
+
+	mu    sync.Mutex
+	key   *ecdsa.PrivateKey
+	leaf  *x509.Certificate
+	cert  []byte
+	chain []byte
+}
+
+// Sign signs payload and records the signature in Rekor, returning a
+// signature that carries the certificate and the Rekor bundle, which lets it
+// be verified offline by `cosign verify`.
+func (k *Keyless) Sign(ctx context.Context, payload []byte) (oci.Signature, error) {
+	key, cert, chain, err := k.certificate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	h := sha256.Sum256(payload)
+	sig, err := ecdsa.SignASN1(rand.Reader, key, h[:])
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := k.upload(ctx, h[:], sig, cert)
+	if err != nil {
+		return nil, err
+	}
+	return static.NewSignature(payload, base64.StdEncoding.EncodeToString(sig),
+		static.WithCertChain(cert, chain), static.WithBundle(b))
+}
+
+func (k *Keyless) client() *http.Client {
+	if k.Client != nil {
+		return k.Client
+	}
+	return http.DefaultClient
+}
+
+// certificate returns the ephemeral key and its PEM encoded certificate and
+// chain, requesting a new certificate from Fulcio if we don't have one that is
+// valid for at least another minute.
+func (k *Keyless) certificate(ctx context.Context) (*ecdsa.PrivateKey, []byte, []byte, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.leaf != nil && time.Now().Add(time.Minute).Before(k.leaf.NotAfter) {
+		return k.key, k.cert, k.chain, nil
+	}
+
+	tok := k.IDToken
+	if tok == "" {
+		var err error
+		if tok, err = IDToken(ctx, k.client()); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	subject, err := tokenSubject(tok)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	pub, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	// Fulcio checks that we hold the private key by having us sign the
+	// subject of the token.
+	h := sha256.Sum256([]byte(subject))
+	proof, err := ecdsa.SignASN1(rand.Reader, key, h[:])
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	body, err := json.Marshal(certificateRequest{
+		PublicKey: publicKey{
+			Content:   pub,
+			Algorithm: "ecdsa",
+		},
+		SignedEmailAddress: proof,
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(k.FulcioURL, "/")+"/api/v1/signingCert", bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+tok)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/pem-certificate-chain")
+	resp, err := k.client().Do(req)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("requesting certificate from Fulcio: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return nil, nil, nil, fmt.Errorf("requesting certificate from Fulcio: %s", responseError(resp))
+	}
+	pems, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	block, rest := pem.Decode(pems)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, nil, nil, errors.New("fulcio returned no certificate")
+	}
+	leaf, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("parsing Fulcio certificate: %w", err)
+	}
+
+	k.key = key
+	k.leaf = leaf
+	k.cert = pem.EncodeToMemory(block)
+	k.chain = bytes.TrimSpace(rest)
+	return k.key, k.cert, k.chain, nil
+}
+
+// upload records the signature sig of the payload with digest h in Rekor, and
+// returns the bundle that proves it.
+func (k *Keyless) upload(ctx context.Context, h, sig, cert []byte) (*bundle.RekorBundle, error) {
+	apiVersion := "0.0.1"
+	alg := models.HashedrekordV001SchemaDataHashAlgorithmSha256
+	value := hex.EncodeToString(h)
+	body, err := json.Marshal(&models.Hashedrekord{
+		APIVersion: &apiVersion,
+		Spec: models.HashedrekordV001Schema{
+			Data: &models.HashedrekordV001SchemaData{
+				Hash: &models.HashedrekordV001SchemaDataHash{
+					Algorithm: &alg,
+					Value:     &value,
+				},
+			},
+			Signature: &models.HashedrekordV001SchemaSignature{
+				Content: sig,
+				PublicKey: &models.HashedrekordV001SchemaSignaturePublicKey{
+					Content: cert,
+				},
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(k.RekorURL, "/")+"/api/v1/log/entries", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := k.client().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("uploading signature to Rekor: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return nil, fmt.Errorf("uploading signature to Rekor: %s", responseError(resp))
+	}
+
+	var entries models.LogEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("parsing Rekor response: %w", err)
+	}
+	for _, e := range entries {
+		e := e
+		if e.IntegratedTime == nil || e.LogIndex == nil || e.LogID == nil {
+			return nil, errors.New("rekor returned an incomplete log entry")
+		}
+		if b := bundle.EntryToBundle(&e); b != nil {
+			return b, nil
+		}
+		return nil, errors.New("rekor returned a log entry without a signed entry timestamp")
+	}
+	return nil, errors.New("rekor returned no log entry")
+}
+
+type certificateRequest struct {
+	PublicKey          publicKey `json:"publicKey"`
+	SignedEmailAddress []byte    `json:"signedEmailAddress"`
+}
+
+type publicKey struct {
+	Content   []byte `json:"content"`
+	Algorithm string `json:"algorithm"`
+}
+
+func responseError(resp *http.Response) string {
+	b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	if msg := strings.TrimSpace(string(b)); msg != "" {
+		return fmt.Sprintf("%s: %s", resp.Status, msg)
+	}
+	return resp.Status
+}
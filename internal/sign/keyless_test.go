@@ -0,0 +1,268 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sign
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// fakeToken returns an unsigned JWT with the given claims.
+func fakeToken(t *testing.T, claims map[string]string) string {
+	t.Helper()
+	b, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return "eyJhbGciOiJub25lIn0." + base64.RawURLEncoding.EncodeToString(b) + ".c2ln"
+}
+
+// fakeFulcio issues certificates for the email user@example.com, from a
+// throwaway CA, counting how many it has issued.
+func fakeFulcio(t *testing.T, tok string, issued *int) *httptest.Server {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "fake fulcio"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, caKey.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/signingCert" || r.Method != http.MethodPost {
+			http.NotFound(w, r)
+			return
+		}
+		if got, want := r.Header.Get("Authorization"), "Bearer "+tok; got != want {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		var req certificateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		pub, err := x509.ParsePKIXPublicKey(req.PublicKey.Content)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		h := sha256.Sum256([]byte("user@example.com"))
+		if !ecdsa.VerifyASN1(pub.(*ecdsa.PublicKey), h[:], req.SignedEmailAddress) {
+			http.Error(w, "bad proof of possession", http.StatusBadRequest)
+			return
+		}
+		leaf := &x509.Certificate{
+			SerialNumber:   big.NewInt(int64(*issued + 2)),
+			EmailAddresses: []string{"user@example.com"},
+			NotBefore:      time.Now(),
+			NotAfter:       time.Now().Add(10 * time.Minute),
+			KeyUsage:       x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, leaf, caTmpl, pub, caKey)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		*issued++
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		w.WriteHeader(http.StatusCreated)
+		pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+		pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: caDER})
+	}))
+}
+
+func fakeRekor(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/log/entries" || r.Method != http.MethodPost {
+			http.NotFound(w, r)
+			return
+		}
+		var entry struct {
+			Kind string `json:"kind"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&entry); err != nil || entry.Kind != "hashedrekord" {
+			http.Error(w, "bad entry", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"deadbeef": map[string]interface{}{
+				"body":           "Ym9keQ==",
+				"integratedTime": time.Now().Unix(),
+				"logID":          "c0ffee",
+				"logIndex":       42,
+				"verification": map[string]interface{}{
+					"signedEntryTimestamp": "c2V0",
+				},
+			},
+		})
+	}))
+}
+
+func TestKeylessSign(t *testing.T) {
+	tok := fakeToken(t, map[string]string{"email": "user@example.com", "sub": "1234"})
+	var issued int
+	fulcio := fakeFulcio(t, tok, &issued)
+	defer fulcio.Close()
+	rekor := fakeRekor(t)
+	defer rekor.Close()
+
+	k := &Keyless{
+		FulcioURL: fulcio.URL,
+		RekorURL:  rekor.URL,
+		IDToken:   tok,
+	}
+	for _, payload := range []string{"first", "second"} {
+		sig, err := k.Sign(context.Background(), []byte(payload))
+		if err != nil {
+			t.Fatalf("Sign() = %v", err)
+		}
+
+		cert, err := sig.Cert()
+		if err != nil || cert == nil {
+			t.Fatalf("Cert() = %v, %v", cert, err)
+		}
+		if got := cert.EmailAddresses; len(got) != 1 || got[0] != "user@example.com" {
+			t.Errorf("certificate emails = %v, wanted user@example.com", got)
+		}
+		chain, err := sig.Chain()
+		if err != nil || len(chain) != 1 {
+			t.Errorf("Chain() = %v, %v, wanted the CA", chain, err)
+		}
+
+		b64, err := sig.Base64Signature()
+		if err != nil {
+			t.Fatalf("Base64Signature() = %v", err)
+		}
+		raw, err := base64.StdEncoding.DecodeString(b64)
+		if err != nil {
+			t.Fatalf("DecodeString() = %v", err)
+		}
+		h := sha256.Sum256([]byte(payload))
+		if !ecdsa.VerifyASN1(cert.PublicKey.(*ecdsa.PublicKey), h[:], raw) {
+			t.Errorf("signature of %q doesn't verify with the certificate", payload)
+		}
+
+		b, err := sig.Bundle()
+		if err != nil || b == nil {
+			t.Fatalf("Bundle() = %v, %v", b, err)
+		}
+		if b.Payload.LogIndex != 42 || b.Payload.LogID != "c0ffee" {
+			t.Errorf("Bundle() = %+v, wanted the Rekor entry", b.Payload)
+		}
+	}
+	// The certificate is reused while it is valid.
+	if issued != 1 {
+		t.Errorf("Fulcio issued %d certificates, wanted 1", issued)
+	}
+}
+
+func TestKeylessSignUnauthorized(t *testing.T) {
+	var issued int
+	fulcio := fakeFulcio(t, "other", &issued)
+	defer fulcio.Close()
+
+	k := &Keyless{
+		FulcioURL: fulcio.URL,
+		IDToken:   fakeToken(t, map[string]string{"email": "user@example.com"}),
+	}
+	if _, err := k.Sign(context.Background(), []byte("payload")); err == nil {
+		t.Error("Sign() = nil, wanted an error from Fulcio")
+	}
+}
+
+func TestIDToken(t *testing.T) {
+	ctx := context.Background()
+
+	t.Setenv("SIGSTORE_ID_TOKEN", "")
+	t.Setenv("ACTIONS_ID_TOKEN_REQUEST_URL", "")
+	t.Setenv("ACTIONS_ID_TOKEN_REQUEST_TOKEN", "")
+	if _, err := IDToken(ctx, http.DefaultClient); err == nil {
+		t.Error("IDToken() without a token = nil, wanted an error")
+	}
+
+	gha := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer request-token" || r.URL.Query().Get("audience") != "sigstore" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"value": "gha-token"})
+	}))
+	defer gha.Close()
+	t.Setenv("ACTIONS_ID_TOKEN_REQUEST_URL", gha.URL+"/token?api-version=2.0")
+	t.Setenv("ACTIONS_ID_TOKEN_REQUEST_TOKEN", "request-token")
+	if got, err := IDToken(ctx, http.DefaultClient); err != nil || got != "gha-token" {
+		t.Errorf("IDToken() in GitHub Actions = %q, %v, wanted gha-token", got, err)
+	}
+
+	t.Setenv("SIGSTORE_ID_TOKEN", "env-token")
+	if got, err := IDToken(ctx, http.DefaultClient); err != nil || got != "env-token" {
+		t.Errorf("IDToken() with SIGSTORE_ID_TOKEN = %q, %v, wanted env-token", got, err)
+	}
+}
+
+func TestTokenSubject(t *testing.T) {
+	for _, tc := range []struct {
+		claims  map[string]string
+		want    string
+		wantErr bool
+	}{{
+		claims: map[string]string{"email": "user@example.com", "sub": "1234"},
+		want:   "user@example.com",
+	}, {
+		claims: map[string]string{"sub": "repo:google/ko:ref:refs/heads/main"},
+		want:   "repo:google/ko:ref:refs/heads/main",
+	}, {
+		claims:  map[string]string{"iss": "https://example.com"},
+		wantErr: true,
+	}} {
+		got, err := tokenSubject(fakeToken(t, tc.claims))
+		if (err != nil) != tc.wantErr {
+			t.Errorf("tokenSubject(%v) = %v, wantErr %t", tc.claims, err, tc.wantErr)
+		}
+		if got != tc.want {
+			t.Errorf("tokenSubject(%v) = %q, wanted %q", tc.claims, got, tc.want)
+		}
+	}
+	if _, err := tokenSubject("not-a-jwt"); err == nil {
+		t.Error("tokenSubject(not-a-jwt) = nil, wanted an error")
+	}
+}
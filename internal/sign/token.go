@@ -0,0 +1,90 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sign
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// IDToken discovers an OIDC identity token for Fulcio: it's the value of
+// $SIGSTORE_ID_TOKEN if set, or else one requested from GitHub Actions, when
+// running in a workflow that has the id-token: write permission.
+func IDToken(ctx context.Context, client *http.Client) (string, error) {
+	if tok := os.Getenv("SIGSTORE_ID_TOKEN"); tok != "" {
+		return tok, nil
+	}
+
+	url, reqTok := os.Getenv("ACTIONS_ID_TOKEN_REQUEST_URL"), os.Getenv("ACTIONS_ID_TOKEN_REQUEST_TOKEN")
+	if url == "" || reqTok == "" {
+		return "", errors.New("no OIDC identity token found: set SIGSTORE_ID_TOKEN, or run in GitHub Actions with the id-token: write permission")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url+"&audience=sigstore", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+reqTok)
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("requesting GitHub Actions identity token: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("requesting GitHub Actions identity token: %s", responseError(resp))
+	}
+	var body struct {
+		Value string `json:"value"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("parsing GitHub Actions identity token: %w", err)
+	}
+	if body.Value == "" {
+		return "", errors.New("GitHub Actions returned an empty identity token")
+	}
+	return body.Value, nil
+}
+
+// tokenSubject returns the identity Fulcio certifies for the token: its email
+// claim if it has one, or else its subject.
+func tokenSubject(tok string) (string, error) {
+	parts := strings.Split(tok, ".")
+	if len(parts) != 3 {
+		return "", errors.New("malformed OIDC identity token")
+	}
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return "", fmt.Errorf("malformed OIDC identity token: %w", err)
+	}
+	var claims struct {
+		Email   string `json:"email"`
+		Subject string `json:"sub"`
+	}
+	if err := json.Unmarshal(b, &claims); err != nil {
+		return "", fmt.Errorf("malformed OIDC identity token: %w", err)
+	}
+	if claims.Email != "" {
+		return claims.Email, nil
+	}
+	if claims.Subject != "" {
+		return claims.Subject, nil
+	}
+	return "", errors.New("OIDC identity token has no email or subject")
+}
@@ -0,0 +1,89 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// AddedFile is a file on the host that is added to images, outside of
+// kodata.
+type AddedFile struct {
+	// Source is the path of the file on the host.
+	Source string
+	// Destination is the absolute path of the file in the image.
+	Destination string
+	// Mode is the file's permissions in the image.
+	Mode int64
+}
+
+// tarAddedFiles returns a layer tarball with files, in order. The files'
+// parent directories are left out, so that those the base image has keep
+// their permissions.
+func tarAddedFiles(files []AddedFile, platform *v1.Platform, creationTime v1.Time) (*bytes.Buffer, error) {
+	buf := bytes.NewBuffer(nil)
+	tw := tar.NewWriter(buf)
+	defer tw.Close()
+
+	// As for kodata, the layer of a Windows image must contain a Hives/
+	// directory, and the root of the actual filesystem goes in Files/.
+	prefix := ""
+	if platform.OS == "windows" {
+		prefix = "Files"
+		for _, dir := range []string{"Hives", "Files"} {
+			if err := tw.WriteHeader(&tar.Header{
+				Name:     dir,
+				Typeflag: tar.TypeDir,
+				Mode:     0555,
+				ModTime:  creationTime.Time,
+			}); err != nil {
+				return nil, fmt.Errorf("writing dir %q: %w", dir, err)
+			}
+		}
+	}
+
+	for _, f := range files {
+		b, err := ioutil.ReadFile(f.Source)
+		if err != nil {
+			return nil, fmt.Errorf("reading added file: %w", err)
+		}
+		header := &tar.Header{
+			Name:     prefix + f.Destination,
+			Size:     int64(len(b)),
+			Typeflag: tar.TypeReg,
+			Mode:     f.Mode,
+			ModTime:  creationTime.Time,
+		}
+		if platform.OS == "windows" {
+			// See walkRecursive.
+			header.Mode = 0555
+			header.PAXRecords = map[string]string{
+				"MSWINDOWS.rawsd": userOwnerAndGroupSID,
+			}
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return nil, fmt.Errorf("tar.Writer.WriteHeader(%q): %w", header.Name, err)
+		}
+		if _, err := tw.Write(b); err != nil {
+			return nil, fmt.Errorf("writing %q: %w", header.Name, err)
+		}
+	}
+	return buf, nil
+}
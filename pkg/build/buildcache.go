@@ -0,0 +1,337 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"golang.org/x/tools/go/packages"
+)
+
+// buildCache is a content-addressed on-disk cache of built binaries. Binaries
+// are keyed on the import path, platform, compiler version, build flags,
+// environment and the contents of every non-standard-library source file that
+// goes into them, so that a hit can skip compiling entirely.
+type buildCache struct {
+	dir   string
+	build builder
+
+	// versions memoizes the version of each compiler.
+	versions sync.Map
+}
+
+// cachingBuilder returns a builder that consults the build cache in dir
+// before falling back to b.
+func cachingBuilder(dir string, b builder) builder {
+	c := &buildCache{dir: dir, build: b}
+	return c.get
+}
+
+func (c *buildCache) get(ctx context.Context, ip string, dir string, platform v1.Platform, config Config) (string, error) {
+	key, err := c.key(ctx, ip, dir, platform, config)
+	if err != nil {
+		// Don't fail the build just because we can't cache it.
+		log.Printf("Not caching build of %s: %v", ip, err)
+		return c.build(ctx, ip, dir, platform, config)
+	}
+	cached := filepath.Join(c.dir, key)
+
+	if _, err := os.Stat(cached); err == nil {
+		log.Printf("Using cached build of %s for %s", ip, platform)
+		outDir, err := outputDir(ip, platform)
+		if err != nil {
+			return "", err
+		}
+		file := filepath.Join(outDir, "out")
+		if err := copyFile(cached, file); err != nil {
+			return "", fmt.Errorf("reading %s from build cache: %w", ip, err)
+		}
+		return file, nil
+	}
+
+	file, err := c.build(ctx, ip, dir, platform, config)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(c.dir, os.ModePerm); err != nil {
+		return "", fmt.Errorf("creating build cache dir %s: %w", c.dir, err)
+	}
+	// Write to a temporary file first, so that concurrent builds never
+	// observe a partially written binary. Each build gets its own, as the
+	// same key may be built by several goroutines at once.
+	f, err := ioutil.TempFile(c.dir, key+".*.tmp")
+	if err != nil {
+		return "", fmt.Errorf("writing %s to build cache: %w", ip, err)
+	}
+	tmp := f.Name()
+	f.Close()
+	if err := copyFile(file, tmp); err != nil {
+		os.Remove(tmp)
+		return "", fmt.Errorf("writing %s to build cache: %w", ip, err)
+	}
+	if err := os.Rename(tmp, cached); err != nil {
+		os.Remove(tmp)
+		return "", fmt.Errorf("writing %s to build cache: %w", ip, err)
+	}
+	return file, nil
+}
+
+// key computes the cache key for building ip with the given settings.
+func (c *buildCache) key(ctx context.Context, ip string, dir string, platform v1.Platform, config Config) (string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "importpath %s\n", ip)
+	fmt.Fprintf(h, "platform %s\n", platform.String())
+
+	version, err := c.compilerVersion(ctx, config)
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprintf(h, "compiler %s\n", version)
+
+	args, err := createBuildArgs(config)
+	if err != nil {
+		return "", err
+	}
+	for _, arg := range args {
+		fmt.Fprintf(h, "arg %s\n", arg)
+	}
+
+	env, err := buildEnv(platform, os.Environ(), config.Env)
+	if err != nil {
+		return "", err
+	}
+	for _, kv := range buildKeyEnv(env) {
+		fmt.Fprintf(h, "env %s\n", kv)
+	}
+
+	// A go.work file changes which modules are built from local sources.
+	work, err := goWork(ctx, dir, env)
+	if err != nil {
+		return "", err
+	}
+	if work != "" {
+		sum, err := hashFile(work)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "workspace %s\n", sum)
+	}
+
+	// The go tool stamps the commit and dirty state of the checkout into
+	// the binary's build info, which the sources alone don't capture.
+	if vcs := vcsState(ctx, dir); vcs != "" {
+		fmt.Fprintf(h, "vcs %s\n", vcs)
+	}
+
+	if err := hashSources(ctx, h, ip, dir, env); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// goWork returns the path of the go.work file in effect in dir, if any.
+func goWork(ctx context.Context, dir string, env []string) (string, error) {
+	cmd := exec.CommandContext(ctx, "go", "env", "GOWORK")
+	cmd.Dir = dir
+	cmd.Env = env
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("running go env GOWORK: %w", err)
+	}
+	work := strings.TrimSpace(string(out))
+	if work == "off" {
+		return "", nil
+	}
+	return work, nil
+}
+
+// vcsState returns the git commit of dir and whether the checkout has
+// uncommitted changes, or "" if dir isn't in a git checkout.
+func vcsState(ctx context.Context, dir string) string {
+	cmd := exec.CommandContext(ctx, "git", "rev-parse", "HEAD")
+	cmd.Dir = dir
+	rev, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+	cmd = exec.CommandContext(ctx, "git", "status", "--porcelain")
+	cmd.Dir = dir
+	status, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%s modified=%t", strings.TrimSpace(string(rev)), len(bytes.TrimSpace(status)) > 0)
+}
+
+func (c *buildCache) compilerVersion(ctx context.Context, config Config) (string, error) {
+	gobin, err := compiler(config)
+	if err != nil {
+		return "", err
+	}
+	if v, ok := c.versions.Load(gobin); ok {
+		return v.(string), nil
+	}
+	out, err := exec.CommandContext(ctx, gobin, "version").Output()
+	if err != nil {
+		return "", fmt.Errorf("running %s version: %w", gobin, err)
+	}
+	v := strings.TrimSpace(string(out))
+	c.versions.Store(gobin, v)
+	return v, nil
+}
+
+// buildKeyEnv returns the sorted effective values of the variables in env
+// that can affect the output of a build, including the C toolchain for cgo.
+func buildKeyEnv(env []string) []string {
+	vars := map[string]string{}
+	for _, kv := range env {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		switch parts[0] {
+		case "CC", "CXX", "AR", "PKG_CONFIG":
+			// These pick the C toolchain used by cgo.
+		default:
+			if !strings.HasPrefix(parts[0], "GO") && !strings.HasPrefix(parts[0], "CGO_") {
+				continue
+			}
+		}
+		switch parts[0] {
+		case "GOCACHE", "GOENV", "GOMODCACHE", "GOPATH", "GOROOT", "GOTMPDIR":
+			// These locate things on this machine, rather than
+			// changing what is built.
+			continue
+		}
+		// Later values take precedence, as they do for the go tool.
+		vars[parts[0]] = parts[1]
+	}
+	keyEnv := make([]string, 0, len(vars))
+	for k, v := range vars {
+		keyEnv = append(keyEnv, k+"="+v)
+	}
+	sort.Strings(keyEnv)
+	return keyEnv
+}
+
+// hashSources writes a digest of the source files of ip and all of its
+// dependencies to h. Standard library packages are covered by the compiler
+// version, and packages from versioned modules by their module version.
+func hashSources(ctx context.Context, h hash.Hash, ip string, dir string, env []string) error {
+	cfg := &packages.Config{
+		Context: ctx,
+		Mode:    packages.NeedName | packages.NeedFiles | packages.NeedEmbedFiles | packages.NeedImports | packages.NeedDeps | packages.NeedModule,
+		Dir:     dir,
+		Env:     env,
+	}
+	pkgs, err := packages.Load(cfg, ip)
+	if err != nil {
+		return err
+	}
+
+	var entries []string
+	var walkErr error
+	packages.Visit(pkgs, nil, func(p *packages.Package) {
+		if walkErr != nil {
+			return
+		}
+		if len(p.Errors) > 0 {
+			walkErr = fmt.Errorf("loading %s: %v", p.PkgPath, p.Errors[0])
+			return
+		}
+		switch {
+		case p.Module == nil:
+			// Standard library.
+			return
+		case p.Module.Replace == nil && p.Module.Version != "":
+			entries = append(entries, fmt.Sprintf("module %s %s@%s", p.PkgPath, p.Module.Path, p.Module.Version))
+			return
+		}
+
+		var files []string
+		for _, fs := range [][]string{p.GoFiles, p.OtherFiles, p.EmbedFiles, p.IgnoredFiles} {
+			files = append(files, fs...)
+		}
+		pkgDir := ""
+		if len(p.GoFiles) > 0 {
+			pkgDir = filepath.Dir(p.GoFiles[0])
+		}
+		for _, f := range files {
+			sum, err := hashFile(f)
+			if err != nil {
+				walkErr = err
+				return
+			}
+			// Key on the path relative to the package, so that the
+			// same sources checked out elsewhere hit the cache.
+			name := f
+			if rel, err := filepath.Rel(pkgDir, f); err == nil && pkgDir != "" {
+				name = filepath.ToSlash(rel)
+			}
+			entries = append(entries, fmt.Sprintf("file %s/%s %s", p.PkgPath, name, sum))
+		}
+	})
+	if walkErr != nil {
+		return walkErr
+	}
+
+	sort.Strings(entries)
+	for _, e := range entries {
+		fmt.Fprintln(h, e)
+	}
+	return nil
+}
+
+func hashFile(path string) (string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0755)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,86 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// BuildCommand is the command a binary of an image was built with.
+type BuildCommand struct {
+	// Image is the import path of the image, without the ko:// scheme.
+	Image string
+	// ImportPath is the import path of the binary, which differs from
+	// Image for the additional binaries of an image.
+	ImportPath string
+	// Platform is the platform the binary was built for.
+	Platform v1.Platform
+	// Dir is the directory the compiler ran in.
+	Dir string
+	// Args is the command line, starting with the compiler, without the
+	// temporary output file.
+	Args []string
+	// Env is the environment the compiler ran with.
+	Env []string
+}
+
+// GoVersion returns the Go version reported by the compiler of c, like
+// "go1.19.3". For TinyGo, it's the whole output of `tinygo version`.
+func (c BuildCommand) GoVersion(ctx context.Context) (string, error) {
+	if len(c.Args) == 0 {
+		return "", fmt.Errorf("no command recorded for %s", c.ImportPath)
+	}
+	gobin := c.Args[0]
+	args := []string{"env", "GOVERSION"}
+	if gobin == "tinygo" {
+		args = []string{"version"}
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, gobin, args...)
+	cmd.Dir = c.Dir
+	cmd.Env = c.Env
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("getting the version of %s: %w: %s", gobin, err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// recordBuildCommand passes the command that built the binary ip of image
+// for platform to g.recordCommand, if it's set.
+func (g *gobuild) recordBuildCommand(image, ip string, platform v1.Platform, config Config) error {
+	if g.recordCommand == nil {
+		return nil
+	}
+	gobin, args, env, err := buildCommand(ip, platform, config)
+	if err != nil {
+		return err
+	}
+	g.recordCommand(BuildCommand{
+		Image:      image,
+		ImportPath: ip,
+		Platform:   platform,
+		Dir:        g.dir,
+		Args:       append(append([]string{gobin}, args...), ip),
+		Env:        env,
+	})
+	return nil
+}
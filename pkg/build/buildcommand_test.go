@@ -0,0 +1,100 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+)
+
+func TestGoBuildRecordsCommands(t *testing.T) {
+	var adds []mutate.IndexAddendum
+	for _, arch := range []string{"amd64", "arm64"} {
+		img, err := random.Image(1024, 1)
+		if err != nil {
+			t.Fatalf("random.Image() = %v", err)
+		}
+		adds = append(adds, mutate.IndexAddendum{
+			Add: img,
+			Descriptor: v1.Descriptor{
+				Platform: &v1.Platform{OS: "linux", Architecture: arch},
+			},
+		})
+	}
+	base := mutate.AppendManifests(empty.Index, adds...)
+	importpath := "github.com/google/ko/test"
+
+	var mu sync.Mutex
+	var got []BuildCommand
+	ng, err := NewGo(
+		context.Background(),
+		"",
+		WithBaseImages(func(context.Context, string) (name.Reference, Result, error) { return baseRef, base, nil }),
+		WithPlatforms("all"),
+		WithConfig(map[string]Config{
+			importpath: {
+				Ldflags: StringArray{"-s", "-w"},
+				Env:     StringArray{"CGO_ENABLED=0", "EXTRA=1"},
+			},
+		}),
+		WithTrimpath(true),
+		WithBuildCommandRecorder(func(c BuildCommand) {
+			mu.Lock()
+			defer mu.Unlock()
+			got = append(got, c)
+		}),
+		withBuilder(writeTempFile),
+		withSBOMber(fauxSBOM),
+	)
+	if err != nil {
+		t.Fatalf("NewGo() = %v", err)
+	}
+	if _, err := ng.Build(context.Background(), StrictScheme+importpath); err != nil {
+		t.Fatalf("Build() = %v", err)
+	}
+
+	if len(got) != 2 {
+		t.Fatalf("recorded %d commands, want one per platform: %v", len(got), got)
+	}
+	for _, c := range got {
+		if c.Image != importpath || c.ImportPath != importpath {
+			t.Errorf("recorded image %q and import path %q, want %q", c.Image, c.ImportPath, importpath)
+		}
+		args := strings.Join(c.Args, " ")
+		if want := "go build -trimpath -ldflags=-s -w " + importpath; args != want {
+			t.Errorf("recorded args %q, want %q", args, want)
+		}
+		vars := envVars(c.Env)
+		if vars["GOOS"] != c.Platform.OS || vars["GOARCH"] != c.Platform.Architecture || vars["EXTRA"] != "1" {
+			t.Errorf("recorded env for %s lacks the platform or config env: %v", c.Platform, vars)
+		}
+	}
+
+	version, err := got[0].GoVersion(context.Background())
+	if err != nil {
+		t.Fatalf("GoVersion() = %v", err)
+	}
+	if !strings.HasPrefix(version, "go") {
+		t.Errorf("GoVersion() = %q, want a Go version", version)
+	}
+}
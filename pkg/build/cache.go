@@ -36,6 +36,9 @@ type buildIDToDiffID map[string]v1.Hash
 type layerCache struct {
 	buildToDiff map[string]buildIDToDiffID
 	diffToDesc  map[string]diffIDToDescriptor
+	// suffix is appended to the name of the on-disk descriptor cache, which
+	// depends on how layers are compressed.
+	suffix string
 	sync.Mutex
 }
 
@@ -141,7 +144,7 @@ func (c *layerCache) put(ctx context.Context, file string, layer v1.Layer) error
 	}
 	defer btodf.Close()
 
-	dtodf, err := os.OpenFile(filepath.Join(filepath.Dir(file), "diffid-to-descriptor"), os.O_RDWR|os.O_CREATE, 0755)
+	dtodf, err := os.OpenFile(filepath.Join(filepath.Dir(file), "diffid-to-descriptor"+c.suffix), os.O_RDWR|os.O_CREATE, 0755)
 	if err != nil {
 		return err
 	}
@@ -167,7 +170,7 @@ func (c *layerCache) readDiffToDesc(file string) (diffIDToDescriptor, error) {
 		return dtod, nil
 	}
 
-	dtodf, err := os.Open(filepath.Join(filepath.Dir(file), "diffid-to-descriptor"))
+	dtodf, err := os.Open(filepath.Join(filepath.Dir(file), "diffid-to-descriptor"+c.suffix))
 	if err != nil {
 		return nil, err
 	}
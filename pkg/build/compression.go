@@ -0,0 +1,148 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// GzipCompression compresses layers with gzip. This is the default.
+	GzipCompression = "gzip"
+	// ZstdCompression compresses layers with zstd.
+	ZstdCompression = "zstd"
+
+	// OCILayerZstd is the OCI media type for zstd-compressed layers.
+	OCILayerZstd types.MediaType = "application/vnd.oci.image.layer.v1.tar+zstd"
+)
+
+// layerCompression describes how the layers ko produces are compressed.
+type layerCompression struct {
+	algorithm string
+	// level is the compression level, or 0 for the algorithm's default.
+	level int
+}
+
+// cacheSuffix distinguishes the cached descriptors of layers compressed with
+// non-default settings, whose digests differ from the default ones.
+func (c layerCompression) cacheSuffix() string {
+	algorithm := c.algorithm
+	if algorithm == "" {
+		algorithm = GzipCompression
+	}
+	switch {
+	case c.level != 0:
+		return fmt.Sprintf("-%s-%d", algorithm, c.level)
+	case algorithm != GzipCompression:
+		return "-" + algorithm
+	default:
+		return ""
+	}
+}
+
+// layer returns a layer for the given uncompressed tarball contents.
+func (c layerCompression) layer(b []byte, mt types.MediaType, opts ...tarball.LayerOption) (v1.Layer, error) {
+	if c.algorithm == ZstdCompression {
+		return newZstdLayer(b, c.level)
+	}
+	opts = append([]tarball.LayerOption{tarball.WithCompressedCaching}, opts...)
+	opts = append(opts, tarball.WithMediaType(mt))
+	if c.level != 0 {
+		opts = append(opts, tarball.WithCompressionLevel(c.level))
+	}
+	return tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewBuffer(b)), nil
+	}, opts...)
+}
+
+// zstdLayer is a layer whose contents are compressed with zstd up front, so
+// that its digest is known without recompressing.
+type zstdLayer struct {
+	uncompressed []byte
+	compressed   []byte
+	digest       v1.Hash
+	diffID       v1.Hash
+}
+
+var _ v1.Layer = (*zstdLayer)(nil)
+
+func newZstdLayer(b []byte, level int) (*zstdLayer, error) {
+	// A single encoder goroutine keeps the output, and so the digest,
+	// deterministic.
+	eopts := []zstd.EOption{zstd.WithEncoderConcurrency(1)}
+	if level != 0 {
+		eopts = append(eopts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+	}
+	enc, err := zstd.NewWriter(nil, eopts...)
+	if err != nil {
+		return nil, fmt.Errorf("creating zstd encoder: %w", err)
+	}
+	compressed := enc.EncodeAll(b, nil)
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	digest, _, err := v1.SHA256(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, err
+	}
+	diffID, _, err := v1.SHA256(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	return &zstdLayer{
+		uncompressed: b,
+		compressed:   compressed,
+		digest:       digest,
+		diffID:       diffID,
+	}, nil
+}
+
+// Digest implements v1.Layer
+func (l *zstdLayer) Digest() (v1.Hash, error) {
+	return l.digest, nil
+}
+
+// DiffID implements v1.Layer
+func (l *zstdLayer) DiffID() (v1.Hash, error) {
+	return l.diffID, nil
+}
+
+// Compressed implements v1.Layer
+func (l *zstdLayer) Compressed() (io.ReadCloser, error) {
+	return ioutil.NopCloser(bytes.NewReader(l.compressed)), nil
+}
+
+// Uncompressed implements v1.Layer
+func (l *zstdLayer) Uncompressed() (io.ReadCloser, error) {
+	return ioutil.NopCloser(bytes.NewReader(l.uncompressed)), nil
+}
+
+// Size implements v1.Layer
+func (l *zstdLayer) Size() (int64, error) {
+	return int64(len(l.compressed)), nil
+}
+
+// MediaType implements v1.Layer
+func (l *zstdLayer) MediaType() (types.MediaType, error) {
+	return OCILayerZstd, nil
+}
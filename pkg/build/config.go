@@ -79,9 +79,68 @@ type Config struct {
 	Ldflags StringArray `yaml:",omitempty"`
 	Flags   FlagArray   `yaml:",omitempty"`
 
+	// PlatformFlags and PlatformLdflags replace Flags and Ldflags when
+	// building for a matching platform. They are keyed by
+	// <os>[/<arch>[/<variant>]], and the most specific matching key is used
+	PlatformFlags   map[string]FlagArray   `yaml:",omitempty"`
+	PlatformLdflags map[string]StringArray `yaml:",omitempty"`
+
 	// Env allows setting environment variables for `go build`
 	Env []string `yaml:",omitempty"`
 
+	// ImageEnv lists KEY=VALUE environment variables to set in the image
+	// config, after those of the base image and KO_DATA_PATH, replacing any
+	// existing value of the same variable
+	ImageEnv []string `yaml:",omitempty"`
+
+	// Cgo enables cgo (CGO_ENABLED=1), which is otherwise disabled
+	Cgo bool `yaml:",omitempty"`
+
+	// PlatformEnv adds environment variables for `go build` when building
+	// for a matching platform, after Env, e.g. to set CC, CXX or CGO_CFLAGS
+	// for a C cross-compiler. It is keyed by <os>[/<arch>[/<variant>]], and
+	// the most specific matching key is used
+	PlatformEnv map[string][]string `yaml:",omitempty"`
+
+	// Binaries lists additional main packages that are built with the same
+	// settings and placed next to the main binary, all in the same image
+	Binaries []string `yaml:",omitempty"`
+
+	// Entrypoint is the file name of the binary the image runs, which
+	// defaults to the binary built from Main
+	Entrypoint string `yaml:",omitempty"`
+
+	// DefaultArgs are the default arguments to the entrypoint, set as the
+	// image's Cmd so they can be overridden separately from the entrypoint
+	DefaultArgs []string `yaml:",omitempty"`
+
+	// BinaryPath is the absolute path the binary is placed at in the image,
+	// and that the image entrypoint points at, which defaults to
+	// /ko-app/<name>. Any other Binaries are placed next to it
+	BinaryPath string `yaml:",omitempty"`
+
+	// DataPath is the absolute path the kodata directory is placed at in the
+	// image, and that KO_DATA_PATH is set to, which defaults to /var/run/ko
+	DataPath string `yaml:",omitempty"`
+
+	// WorkingDir is the absolute path of the working directory the image
+	// starts in, which defaults to that of the base image
+	WorkingDir string `yaml:",omitempty"`
+
+	// Builder selects the compiler used to produce the binary, either "go"
+	// (the default) or "tinygo"
+	Builder string `yaml:",omitempty"`
+
+	// Buildmode is passed to `go build` as -buildmode, e.g. "pie" for a
+	// position-independent executable. Only build modes that produce an
+	// executable (default, exe and pie) are supported
+	Buildmode string `yaml:",omitempty"`
+
+	// Trimpath controls whether `go build` is passed -trimpath, overriding
+	// the default (usually true) for this build. Setting it to false keeps
+	// full source paths in the binary, e.g. for debuggers
+	Trimpath *bool `yaml:",omitempty"`
+
 	// Other GoReleaser fields that are not supported or do not make sense
 	// in the context of ko, for reference or for future use:
 	// Goos         []string    `yaml:",omitempty"`
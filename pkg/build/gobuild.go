@@ -31,9 +31,12 @@ import (
 	"path"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"text/template"
+	"time"
 
 	"github.com/containerd/stargz-snapshotter/estargz"
 	"github.com/google/go-containerregistry/pkg/name"
@@ -56,11 +59,35 @@ import (
 
 const (
 	defaultAppFilename = "ko-app"
+
+	// goBuilder and tinygoBuilder are the values accepted for Config.Builder.
+	goBuilder     = "go"
+	tinygoBuilder = "tinygo"
 )
 
+// buildModes are the values accepted for Config.Buildmode. Other build modes
+// don't produce an executable that can be an image's entrypoint.
+var buildModes = []string{"default", "exe", "pie"}
+
 // GetBase takes an importpath and returns a base image reference and base image (or index).
 type GetBase func(context.Context, string) (name.Reference, Result, error)
 
+// PlatformBase is a base image (or index) used in place of the default base
+// for a single platform.
+type PlatformBase struct {
+	Ref  name.Reference
+	Base Result
+}
+
+// GetPlatformBases takes an importpath and returns the base images that
+// override the default base for specific platforms, keyed by platform spec
+// (<os>/<arch>[/<variant>]).
+type GetPlatformBases func(context.Context, string) (map[string]PlatformBase, error)
+
+// GetBaseSBOM takes the digest of a base image and returns its SBOM and the
+// media type of the SBOM, or nil if the base image has no SBOM.
+type GetBaseSBOM func(context.Context, name.Digest) ([]byte, types.MediaType, error)
+
 type builder func(context.Context, string, string, v1.Platform, Config) (string, error)
 
 type sbomber func(context.Context, string, string, oci.SignedEntity) ([]byte, types.MediaType, error)
@@ -73,19 +100,42 @@ type platformMatcher struct {
 type gobuild struct {
 	ctx                  context.Context
 	getBase              GetBase
+	getPlatformBases     GetPlatformBases
 	creationTime         v1.Time
 	kodataCreationTime   v1.Time
 	build                builder
 	sbom                 sbomber
+	getBaseSBOM          GetBaseSBOM
 	disableOptimizations bool
+	race                 bool
 	trimpath             bool
+	stripSymbols         bool
+	gitState             func() (GitState, error)
 	buildConfigs         map[string]Config
 	platformMatcher      *platformMatcher
 	dir                  string
 	labels               map[string]string
+	sourceURL            string
+	user                 string
 	semaphore            *semaphore.Weighted
+	compression          layerCompression
+	// mediaTypes is the kind of media types of the images produced, or ""
+	// to keep the base image's.
+	mediaTypes string
+	// omitEmptyKoData leaves out the kodata layer when there's no kodata.
+	omitEmptyKoData bool
+	// addedFiles are added to every image, in a layer of their own.
+	addedFiles []AddedFile
+	// recordCommand, if set, is called with the command of each binary built.
+	recordCommand func(BuildCommand)
+	// keepBaseEntrypoint keeps the base image's entrypoint, and passes our
+	// binary to it as the first argument.
+	keepBaseEntrypoint bool
 
 	cache *layerCache
+	// supported memoizes the references IsSupportedReference accepted, so
+	// that Build doesn't load packages again after callers checked them.
+	supported sync.Map
 }
 
 // Option is a functional option for NewGo.
@@ -94,17 +144,32 @@ type Option func(*gobuildOpener) error
 type gobuildOpener struct {
 	ctx                  context.Context
 	getBase              GetBase
+	getPlatformBases     GetPlatformBases
 	creationTime         v1.Time
 	kodataCreationTime   v1.Time
 	build                builder
 	sbom                 sbomber
+	getBaseSBOM          GetBaseSBOM
 	disableOptimizations bool
+	race                 bool
 	trimpath             bool
+	stripSymbols         bool
+	gitState             func() (GitState, error)
 	buildConfigs         map[string]Config
 	platforms            []string
 	labels               map[string]string
+	sourceURL            string
+	user                 string
 	dir                  string
 	jobs                 int
+	compression          layerCompression
+	mediaTypes           string
+	omitEmptyKoData      bool
+	addedFiles           []AddedFile
+	recordCommand        func(BuildCommand)
+	keepBaseEntrypoint   bool
+	buildCacheDir        string
+	buildTimeout         time.Duration
 }
 
 func (gbo *gobuildOpener) Open() (Interface, error) {
@@ -118,22 +183,46 @@ func (gbo *gobuildOpener) Open() (Interface, error) {
 	if gbo.jobs == 0 {
 		gbo.jobs = runtime.GOMAXPROCS(0)
 	}
+	if gbo.mediaTypes == DockerMediaTypes && gbo.compression.algorithm == ZstdCompression {
+		return nil, errors.New("zstd layers can't have Docker media types")
+	}
+	build := gbo.build
+	if gbo.buildCacheDir != "" {
+		build = cachingBuilder(gbo.buildCacheDir, build)
+	}
+	if gbo.buildTimeout != 0 {
+		build = timeoutBuilder(gbo.buildTimeout, build)
+	}
 	return &gobuild{
 		ctx:                  gbo.ctx,
 		getBase:              gbo.getBase,
+		getPlatformBases:     gbo.getPlatformBases,
 		creationTime:         gbo.creationTime,
 		kodataCreationTime:   gbo.kodataCreationTime,
-		build:                gbo.build,
+		build:                build,
 		sbom:                 gbo.sbom,
+		getBaseSBOM:          gbo.getBaseSBOM,
 		disableOptimizations: gbo.disableOptimizations,
+		race:                 gbo.race,
 		trimpath:             gbo.trimpath,
+		stripSymbols:         gbo.stripSymbols,
+		gitState:             gbo.gitState,
 		buildConfigs:         gbo.buildConfigs,
 		labels:               gbo.labels,
+		sourceURL:            gbo.sourceURL,
+		user:                 gbo.user,
 		dir:                  gbo.dir,
 		platformMatcher:      matcher,
+		compression:          gbo.compression,
+		mediaTypes:           gbo.mediaTypes,
+		omitEmptyKoData:      gbo.omitEmptyKoData,
+		addedFiles:           gbo.addedFiles,
+		recordCommand:        gbo.recordCommand,
+		keepBaseEntrypoint:   gbo.keepBaseEntrypoint,
 		cache: &layerCache{
 			buildToDiff: map[string]buildIDToDiffID{},
 			diffToDesc:  map[string]diffIDToDescriptor{},
+			suffix:      gbo.compression.cacheSuffix(),
 		},
 		semaphore: semaphore.NewWeighted(int64(gbo.jobs)),
 	}, nil
@@ -200,6 +289,13 @@ func (g *gobuild) QualifyImport(importpath string) (string, error) {
 // Only valid importpaths that provide commands (i.e., are "package main") are
 // supported.
 func (g *gobuild) IsSupportedReference(s string) error {
+	s, err := qualifyLocalRef(g, s)
+	if err != nil {
+		return err
+	}
+	if _, ok := g.supported.Load(s); ok {
+		return nil
+	}
 	ref := newRef(s)
 	if !ref.IsStrict() {
 		return errors.New("importpath does not start with ko://")
@@ -215,12 +311,82 @@ func (g *gobuild) IsSupportedReference(s string) error {
 	if len(pkgs) != 1 {
 		return fmt.Errorf("found %d local packages, expected 1", len(pkgs))
 	}
+	if pkgs[0].Name == "" && len(pkgs[0].Errors) > 0 {
+		return missingPackageError(ref.Path(), dir)
+	}
 	if pkgs[0].Name != "main" {
-		return errors.New("importpath is not `package main`")
+		return fmt.Errorf("importpath is `package %s`, not `package main`", pkgs[0].Name)
 	}
+	g.supported.Store(s, struct{}{})
 	return nil
 }
 
+// maxSuggestions is how many main packages missingPackageError suggests.
+const maxSuggestions = 3
+
+// missingPackageError returns the error for importpath not naming a package,
+// rather than go's, suggesting the main packages next to where it would be
+// whose import paths are the most like it.
+func missingPackageError(importpath, dir string) error {
+	var mains []string
+	for parent := path.Dir(importpath); strings.Contains(parent, "/") && len(mains) == 0; parent = path.Dir(parent) {
+		pkgs, err := packages.Load(&packages.Config{Dir: dir, Mode: packages.NeedName}, parent+"/...")
+		if err != nil {
+			break
+		}
+		for _, pkg := range pkgs {
+			if pkg.Name == "main" {
+				mains = append(mains, pkg.PkgPath)
+			}
+		}
+	}
+	if len(mains) == 0 {
+		return fmt.Errorf("no such package %s", importpath)
+	}
+
+	distance := make(map[string]int, len(mains))
+	for _, m := range mains {
+		distance[m] = editDistance(importpath, m)
+	}
+	sort.Slice(mains, func(i, j int) bool {
+		if distance[mains[i]] != distance[mains[j]] {
+			return distance[mains[i]] < distance[mains[j]]
+		}
+		return mains[i] < mains[j]
+	})
+	if len(mains) > maxSuggestions {
+		mains = mains[:maxSuggestions]
+	}
+	return fmt.Errorf("no such package %s, did you mean %s?", importpath, strings.Join(mains, " or "))
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if d := prev[j] + 1; d < cur[j] {
+				cur[j] = d
+			}
+			if d := cur[j-1] + 1; d < cur[j] {
+				cur[j] = d
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
 func getGoarm(platform v1.Platform) (string, error) {
 	if !strings.HasPrefix(platform.Variant, "v") {
 		return "", fmt.Errorf("strange arm variant: %v", platform.Variant)
@@ -241,10 +407,87 @@ func getGoarm(platform v1.Platform) (string, error) {
 	return "", nil
 }
 
-func build(ctx context.Context, ip string, dir string, platform v1.Platform, config Config) (string, error) {
+// racePlatforms are the platforms the race detector supports.
+// See: https://go.dev/doc/articles/race_detector#Requirements
+var racePlatforms = map[string]bool{
+	"linux/amd64":   true,
+	"linux/arm64":   true,
+	"linux/ppc64le": true,
+	"linux/s390x":   true,
+	"freebsd/amd64": true,
+	"netbsd/amd64":  true,
+	"darwin/amd64":  true,
+	"darwin/arm64":  true,
+	"windows/amd64": true,
+}
+
+// checkRace checks that a -race build for platform, with the environment env,
+// can succeed: the race detector must support the platform, and it needs cgo.
+func checkRace(platform v1.Platform, env []string) error {
+	target := platform.OS + "/" + platform.Architecture
+	if !racePlatforms[target] {
+		return fmt.Errorf("the race detector does not support %s", target)
+	}
+	if envVars(env)["CGO_ENABLED"] != "1" {
+		return errors.New("the race detector requires CGO_ENABLED=1")
+	}
+	return nil
+}
+
+// checkCgo checks that a build for platform, with the environment env, has a
+// C compiler if it uses cgo. The go tool would otherwise use the host's C
+// compiler when cross-compiling, which fails or produces a broken binary.
+func checkCgo(platform v1.Platform, env []string) error {
+	vars := envVars(env)
+	if vars["CGO_ENABLED"] != "1" {
+		return nil
+	}
+	target := platform.OS + "/" + platform.Architecture
+	if target != runtime.GOOS+"/"+runtime.GOARCH && vars["CC"] == "" {
+		return fmt.Errorf("cgo is enabled, but cross-compiling for %s from %s/%s needs a C cross-compiler, set CC in the build config's env or platformEnv", target, runtime.GOOS, runtime.GOARCH)
+	}
+	return nil
+}
+
+// envVars returns the effective values of the variables in env.
+func envVars(env []string) map[string]string {
+	vars := map[string]string{}
+	for _, kv := range env {
+		if parts := strings.SplitN(kv, "=", 2); len(parts) == 2 {
+			// Later values take precedence, as they do for the go tool.
+			vars[parts[0]] = parts[1]
+		}
+	}
+	return vars
+}
+
+// compiler returns the name of the binary used to build the given config,
+// and checks that it can be found on PATH.
+func compiler(config Config) (string, error) {
+	switch config.Builder {
+	case "", goBuilder:
+		return "go", nil
+	case tinygoBuilder:
+		if _, err := exec.LookPath("tinygo"); err != nil {
+			return "", fmt.Errorf("builder %q requested, but tinygo was not found on PATH: %w", config.Builder, err)
+		}
+		return "tinygo", nil
+	default:
+		return "", fmt.Errorf("unsupported builder %q, must be one of %q or %q", config.Builder, goBuilder, tinygoBuilder)
+	}
+}
+
+// buildCommand returns the compiler, the arguments before the output file
+// and import path, and the environment to build ip for platform with config.
+func buildCommand(ip string, platform v1.Platform, config Config) (string, []string, []string, error) {
+	gobin, err := compiler(config)
+	if err != nil {
+		return "", nil, nil, err
+	}
+
 	buildArgs, err := createBuildArgs(config)
 	if err != nil {
-		return "", err
+		return "", nil, nil, err
 	}
 
 	args := make([]string, 0, 4+len(buildArgs))
@@ -253,36 +496,40 @@ func build(ctx context.Context, ip string, dir string, platform v1.Platform, con
 
 	env, err := buildEnv(platform, os.Environ(), config.Env)
 	if err != nil {
-		return "", fmt.Errorf("could not create env for %s: %w", ip, err)
+		return "", nil, nil, fmt.Errorf("could not create env for %s: %w", ip, err)
+	}
+	for _, arg := range buildArgs {
+		if arg == "-race" {
+			if err := checkRace(platform, env); err != nil {
+				return "", nil, nil, fmt.Errorf("cannot build %s with -race: %w", ip, err)
+			}
+			break
+		}
+	}
+	if config.Builder != tinygoBuilder {
+		// TinyGo brings its own C toolchain.
+		if err := checkCgo(platform, env); err != nil {
+			return "", nil, nil, fmt.Errorf("cannot build %s: %w", ip, err)
+		}
 	}
+	return gobin, args, env, nil
+}
 
-	tmpDir, err := ioutil.TempDir("", "ko")
+func build(ctx context.Context, ip string, dir string, platform v1.Platform, config Config) (string, error) {
+	gobin, args, env, err := buildCommand(ip, platform, config)
 	if err != nil {
 		return "", err
 	}
 
-	if dir := os.Getenv("KOCACHE"); dir != "" {
-		dirInfo, err := os.Stat(dir)
-		if os.IsNotExist(err) {
-			if err := os.MkdirAll(dir, os.ModePerm); err != nil && !os.IsExist(err) {
-				return "", fmt.Errorf("could not create KOCACHE dir %s: %w", dir, err)
-			}
-		} else if !dirInfo.IsDir() {
-			return "", fmt.Errorf("KOCACHE should be a directory, %s is not a directory", dir)
-		}
-
-		// TODO(#264): if KOCACHE is unset, default to filepath.Join(os.TempDir(), "ko").
-		tmpDir = filepath.Join(dir, "bin", ip, platform.String())
-		if err := os.MkdirAll(tmpDir, os.ModePerm); err != nil {
-			return "", err
-		}
+	tmpDir, err := outputDir(ip, platform)
+	if err != nil {
+		return "", err
 	}
-
 	file := filepath.Join(tmpDir, "out")
 
 	args = append(args, "-o", file)
 	args = append(args, ip)
-	cmd := exec.CommandContext(ctx, "go", args...)
+	cmd := exec.CommandContext(ctx, gobin, args...)
 	cmd.Dir = dir
 	cmd.Env = env
 
@@ -295,12 +542,50 @@ func build(ctx context.Context, ip string, dir string, platform v1.Platform, con
 		if os.Getenv("KOCACHE") == "" {
 			os.RemoveAll(tmpDir)
 		}
-		log.Printf("Unexpected error running \"go build\": %v\n%v", err, output.String())
-		return "", err
+		return "", fmt.Errorf("running \"%s build\" for %s: %w\n%s", gobin, platform, err, strings.TrimSpace(output.String()))
 	}
 	return file, nil
 }
 
+// timeoutBuilder returns a builder that cancels each build by b that takes
+// longer than timeout, which kills the compiler.
+func timeoutBuilder(timeout time.Duration, b builder) builder {
+	return func(ctx context.Context, ip string, dir string, platform v1.Platform, config Config) (string, error) {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		file, err := b(ctx, ip, dir, platform, config)
+		if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return "", fmt.Errorf("building %s for %s timed out after %s: %w", ip, platform, timeout, ctx.Err())
+		}
+		return file, err
+	}
+}
+
+// outputDir returns the directory the binary for ip should be written to.
+// This is a fresh temporary directory, unless KOCACHE is set.
+func outputDir(ip string, platform v1.Platform) (string, error) {
+	dir := os.Getenv("KOCACHE")
+	if dir == "" {
+		return ioutil.TempDir("", "ko")
+	}
+
+	dirInfo, err := os.Stat(dir)
+	if os.IsNotExist(err) {
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil && !os.IsExist(err) {
+			return "", fmt.Errorf("could not create KOCACHE dir %s: %w", dir, err)
+		}
+	} else if !dirInfo.IsDir() {
+		return "", fmt.Errorf("KOCACHE should be a directory, %s is not a directory", dir)
+	}
+
+	// TODO(#264): if KOCACHE is unset, default to filepath.Join(os.TempDir(), "ko").
+	tmpDir := filepath.Join(dir, "bin", ip, platform.String())
+	if err := os.MkdirAll(tmpDir, os.ModePerm); err != nil {
+		return "", err
+	}
+	return tmpDir, nil
+}
+
 func goversionm(ctx context.Context, file string, appPath string, se oci.SignedEntity) ([]byte, types.MediaType, error) {
 	switch se.(type) {
 	case oci.SignedImage:
@@ -358,7 +643,7 @@ func cycloneDX() sbomber {
 				return nil, "", err
 			}
 
-			b, err = sbom.GenerateImageCycloneDX(b)
+			b, err = sbom.GenerateImageCycloneDX(b, obj)
 			if err != nil {
 				return nil, "", err
 			}
@@ -424,7 +709,34 @@ func appFilename(importpath string) string {
 // owner: BUILTIN/Users group: BUILTIN/Users ($sddlValue="O:BUG:BU")
 const userOwnerAndGroupSID = "AQAAgBQAAAAkAAAAAAAAAAAAAAABAgAAAAAABSAAAAAhAgAAAQIAAAAAAAUgAAAAIQIAAA=="
 
-func tarBinary(name, binary string, platform *v1.Platform) (*bytes.Buffer, error) {
+// binaryPath returns the path the binary for ref is placed at in the image.
+func binaryPath(ref reference, config Config) (string, error) {
+	if config.BinaryPath == "" {
+		return path.Join("/ko-app", appFilename(ref.Path())), nil
+	}
+	p := path.Clean(config.BinaryPath)
+	if !path.IsAbs(p) || p == "/" {
+		return "", fmt.Errorf("binaryPath %q for %s must be an absolute path to a file", config.BinaryPath, ref.Path())
+	}
+	return p, nil
+}
+
+// executableName returns the name a binary at p must have to be executable on
+// platform, which on Windows means adding an .exe extension.
+func executableName(platform *v1.Platform, p string) string {
+	if platform.OS == "windows" && !strings.HasSuffix(p, ".exe") {
+		return p + ".exe"
+	}
+	return p
+}
+
+// appBinary is a built executable and the path it is placed at in the image.
+type appBinary struct {
+	name string
+	file string
+}
+
+func tarBinary(platform *v1.Platform, appDir string, binaries ...appBinary) (*bytes.Buffer, error) {
 	buf := bytes.NewBuffer(nil)
 	tw := tar.NewWriter(buf)
 	defer tw.Close()
@@ -432,37 +744,56 @@ func tarBinary(name, binary string, platform *v1.Platform) (*bytes.Buffer, error
 	// Write the parent directories to the tarball archive.
 	// For Windows, the layer must contain a Hives/ directory, and the root
 	// of the actual filesystem goes in a Files/ directory.
-	// For Linux, the binary goes into /ko-app/
-	dirs := []string{"ko-app"}
+	// For Linux, the binary goes into appDir (/ko-app/ by default).
+	// Use a fixed Mode, so that this isn't sensitive to the directory and umask
+	// under which it was created. Additionally, windows can only set 0222,
+	// 0444, or 0666, none of which are executable.
+	type dirEntry struct {
+		name string
+		mode int64
+	}
+	dirs := []dirEntry{{name: strings.TrimPrefix(appDir, "/"), mode: 0555}}
+	// Any other parents of appDir likely exist in the base image already, so
+	// give them conventional permissions.
+	for dir := path.Dir(appDir); dir != "/"; dir = path.Dir(dir) {
+		dirs = append([]dirEntry{{name: strings.TrimPrefix(dir, "/"), mode: 0755}}, dirs...)
+	}
+	prefix := ""
 	if platform.OS == "windows" {
-		dirs = []string{
-			"Hives",
-			"Files",
-			"Files/ko-app",
+		for i := range dirs {
+			dirs[i].name = "Files/" + dirs[i].name
 		}
-		name = "Files" + name
+		dirs = append([]dirEntry{{name: "Hives", mode: 0555}, {name: "Files", mode: 0555}}, dirs...)
+		prefix = "Files"
 	}
 	for _, dir := range dirs {
 		if err := tw.WriteHeader(&tar.Header{
-			Name:     dir,
+			Name:     dir.name,
 			Typeflag: tar.TypeDir,
-			// Use a fixed Mode, so that this isn't sensitive to the directory and umask
-			// under which it was created. Additionally, windows can only set 0222,
-			// 0444, or 0666, none of which are executable.
-			Mode: 0555,
+			Mode:     dir.mode,
 		}); err != nil {
-			return nil, fmt.Errorf("writing dir %q: %w", dir, err)
+			return nil, fmt.Errorf("writing dir %q: %w", dir.name, err)
+		}
+	}
+
+	for _, bin := range binaries {
+		if err := writeBinary(tw, prefix+bin.name, bin.file, platform); err != nil {
+			return nil, err
 		}
 	}
 
+	return buf, nil
+}
+
+func writeBinary(tw *tar.Writer, name, binary string, platform *v1.Platform) error {
 	file, err := os.Open(binary)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer file.Close()
 	stat, err := file.Stat()
 	if err != nil {
-		return nil, err
+		return err
 	}
 	header := &tar.Header{
 		Name:     name,
@@ -482,14 +813,11 @@ func tarBinary(name, binary string, platform *v1.Platform) (*bytes.Buffer, error
 	}
 	// write the header to the tarball archive
 	if err := tw.WriteHeader(header); err != nil {
-		return nil, err
+		return err
 	}
 	// copy the file data to the tarball
-	if _, err := io.Copy(tw, file); err != nil {
-		return nil, err
-	}
-
-	return buf, nil
+	_, err = io.Copy(tw, file)
+	return err
 }
 
 func (g *gobuild) kodataPath(ref reference) (string, error) {
@@ -510,13 +838,30 @@ func (g *gobuild) kodataPath(ref reference) (string, error) {
 	return filepath.Join(filepath.Dir(pkgs[0].GoFiles[0]), "kodata"), nil
 }
 
-// Where kodata lives in the image.
+// Where kodata lives in the image, by default.
 const kodataRoot = "/var/run/ko"
 
+// dataPath returns where kodata for ref is placed in the image.
+func dataPath(ref reference, config Config) (string, error) {
+	if config.DataPath == "" {
+		return kodataRoot, nil
+	}
+	p := path.Clean(config.DataPath)
+	if !path.IsAbs(p) || p == "/" {
+		return "", fmt.Errorf("dataPath %q for %s must be an absolute path to a directory other than /", config.DataPath, ref.Path())
+	}
+	return p, nil
+}
+
 // walkRecursive performs a filepath.Walk of the given root directory adding it
-// to the provided tar.Writer with root -> chroot.  All symlinks are dereferenced,
+// to the provided tar.Writer with root -> chroot.  Relative symlinks that stay
+// within root are kept as symlinks. All other symlinks are dereferenced,
 // which is what leads to recursion when we encounter a directory symlink.
-func walkRecursive(tw *tar.Writer, root, chroot string, creationTime v1.Time, platform *v1.Platform) error {
+// filepath.Walk visits each directory's entries in lexical order, so the
+// tarball doesn't depend on the order the files were created in.
+// Files and directories for which ignored, if not nil, returns true, given
+// their path in the tarball, are left out.
+func walkRecursive(tw *tar.Writer, root, chroot string, creationTime v1.Time, platform *v1.Platform, ignored func(name string, isDir bool) bool) error {
 	return filepath.Walk(root, func(hostPath string, info os.FileInfo, err error) error {
 		if hostPath == root {
 			return nil
@@ -524,11 +869,17 @@ func walkRecursive(tw *tar.Writer, root, chroot string, creationTime v1.Time, pl
 		if err != nil {
 			return fmt.Errorf("filepath.Walk(%q): %w", root, err)
 		}
+		newPath := path.Join(chroot, filepath.ToSlash(hostPath[len(root):]))
+		if ignored != nil && ignored(newPath, info.Mode().IsDir()) {
+			if info.Mode().IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
 		// Skip other directories.
 		if info.Mode().IsDir() {
 			return nil
 		}
-		newPath := path.Join(chroot, filepath.ToSlash(hostPath[len(root):]))
 
 		// Don't chase symlinks on Windows, where cross-compiled symlink support is not possible.
 		if platform.OS == "windows" {
@@ -538,6 +889,25 @@ func walkRecursive(tw *tar.Writer, root, chroot string, creationTime v1.Time, pl
 			}
 		}
 
+		if info.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Readlink(hostPath)
+			if err != nil {
+				return fmt.Errorf("os.Readlink(%q): %w", hostPath, err)
+			}
+			if withinRoot(root, hostPath, target) {
+				if err := tw.WriteHeader(&tar.Header{
+					Name:     newPath,
+					Typeflag: tar.TypeSymlink,
+					Linkname: filepath.ToSlash(target),
+					Mode:     0777,
+					ModTime:  creationTime.Time,
+				}); err != nil {
+					return fmt.Errorf("tar.Writer.WriteHeader(%q): %w", newPath, err)
+				}
+				return nil
+			}
+		}
+
 		evalPath, err := filepath.EvalSymlinks(hostPath)
 		if err != nil {
 			return fmt.Errorf("filepath.EvalSymlinks(%q): %w", hostPath, err)
@@ -550,7 +920,10 @@ func walkRecursive(tw *tar.Writer, root, chroot string, creationTime v1.Time, pl
 		}
 		// Skip other directories.
 		if info.Mode().IsDir() {
-			return walkRecursive(tw, evalPath, newPath, creationTime, platform)
+			if ignored != nil && ignored(newPath, true) {
+				return nil
+			}
+			return walkRecursive(tw, evalPath, newPath, creationTime, platform, ignored)
 		}
 
 		// Open the file to copy it into the tarball.
@@ -565,13 +938,16 @@ func walkRecursive(tw *tar.Writer, root, chroot string, creationTime v1.Time, pl
 			Name:     newPath,
 			Size:     info.Size(),
 			Typeflag: tar.TypeReg,
-			// Use a fixed Mode, so that this isn't sensitive to the directory and umask
-			// under which it was created. Additionally, windows can only set 0222,
-			// 0444, or 0666, none of which are executable.
-			Mode:    0555,
+			// Keep the file's execute permissions, but make it read-only
+			// and readable by everyone, so that this isn't sensitive to the
+			// umask under which it was created and non-root users can read it.
+			Mode:    int64(info.Mode().Perm()&^0222 | 0444),
 			ModTime: creationTime.Time,
 		}
 		if platform.OS == "windows" {
+			// Windows can only set 0222, 0444, or 0666, none of which are
+			// executable, so use a fixed Mode.
+			header.Mode = 0555
 			// This magic value is for some reason needed for Windows to be
 			// able to execute the binary.
 			header.PAXRecords = map[string]string{
@@ -588,7 +964,20 @@ func walkRecursive(tw *tar.Writer, root, chroot string, creationTime v1.Time, pl
 	})
 }
 
-func (g *gobuild) tarKoData(ref reference, platform *v1.Platform) (*bytes.Buffer, error) {
+// withinRoot returns whether the symlink at hostPath, pointing at target,
+// is relative and resolves to a path within root.
+func withinRoot(root, hostPath, target string) bool {
+	if filepath.IsAbs(target) {
+		return false
+	}
+	rel, err := filepath.Rel(root, filepath.Join(filepath.Dir(hostPath), target))
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func (g *gobuild) tarKoData(ref reference, platform *v1.Platform, dataPath string) (*bytes.Buffer, error) {
 	buf := bytes.NewBuffer(nil)
 	tw := tar.NewWriter(buf)
 	defer tw.Close()
@@ -603,22 +992,18 @@ func (g *gobuild) tarKoData(ref reference, platform *v1.Platform) (*bytes.Buffer
 	// Write the parent directories to the tarball archive.
 	// For Windows, the layer must contain a Hives/ directory, and the root
 	// of the actual filesystem goes in a Files/ directory.
-	// For Linux, kodata starts at /var/run/ko.
-	chroot := kodataRoot
-	dirs := []string{
-		"/var",
-		"/var/run",
-		"/var/run/ko",
+	// For Linux, kodata starts at dataPath (/var/run/ko by default).
+	chroot := dataPath
+	var dirs []string
+	for dir := dataPath; dir != "/"; dir = path.Dir(dir) {
+		dirs = append([]string{dir}, dirs...)
 	}
 	if platform.OS == "windows" {
-		chroot = "Files" + kodataRoot
-		dirs = []string{
-			"Hives",
-			"Files",
-			"Files/var",
-			"Files/var/run",
-			"Files/var/run/ko",
+		chroot = "Files" + dataPath
+		for i, dir := range dirs {
+			dirs[i] = "Files" + dir
 		}
+		dirs = append([]string{"Hives", "Files"}, dirs...)
 	}
 	for _, dir := range dirs {
 		if err := tw.WriteHeader(&tar.Header{
@@ -634,10 +1019,76 @@ func (g *gobuild) tarKoData(ref reference, platform *v1.Platform) (*bytes.Buffer
 		}
 	}
 
-	return buf, walkRecursive(tw, root, chroot, creationTime, platform)
+	ignored, err := koDataIgnored(root, chroot)
+	if err != nil {
+		return nil, err
+	}
+	return buf, walkRecursive(tw, root, chroot, creationTime, platform, ignored)
 }
 
-func createTemplateData() map[string]interface{} {
+// isEmptyKoData returns whether the kodata directory root doesn't exist, or
+// has no files or symlinks, even in subdirectories, other than .ko-ignore.
+func isEmptyKoData(root string) (bool, error) {
+	empty := true
+	err := filepath.Walk(root, func(hostPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			if hostPath == root && os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !info.IsDir() && hostPath != filepath.Join(root, koIgnoreFile) {
+			empty = false
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	return empty, err
+}
+
+// GitState is the state of the git repository that images are built from,
+// available to the templates in flags and ldflags as {{.GitTag}},
+// {{.GitCommit}}, {{.GitCommitShort}} and {{.GitTreeState}}.
+type GitState struct {
+	// Tag is the most recent tag reachable from HEAD, or empty if there is
+	// none.
+	Tag string
+	// Commit is the full SHA of HEAD, and CommitShort its abbreviation.
+	Commit      string
+	CommitShort string
+	// TreeState is "clean" if there are no uncommitted changes, and "dirty"
+	// otherwise.
+	TreeState string
+}
+
+// templateData is the data for the templates in flags and ldflags. The git
+// state is only read once a template refers to it.
+type templateData struct {
+	Env map[string]string
+	git func() (GitState, error)
+}
+
+func (d templateData) GitTag() (string, error) {
+	s, err := d.git()
+	return s.Tag, err
+}
+
+func (d templateData) GitCommit() (string, error) {
+	s, err := d.git()
+	return s.Commit, err
+}
+
+func (d templateData) GitCommitShort() (string, error) {
+	s, err := d.git()
+	return s.CommitShort, err
+}
+
+func (d templateData) GitTreeState() (string, error) {
+	s, err := d.git()
+	return s.TreeState, err
+}
+
+func createTemplateData(git func() (GitState, error)) templateData {
 	envVars := map[string]string{
 		"LDFLAGS": "",
 	}
@@ -645,13 +1096,39 @@ func createTemplateData() map[string]interface{} {
 		kv := strings.SplitN(entry, "=", 2)
 		envVars[kv[0]] = kv[1]
 	}
+	if git == nil {
+		git = func() (GitState, error) {
+			return GitState{}, errors.New("no git state, see build.WithGitState")
+		}
+	}
 
-	return map[string]interface{}{
-		"Env": envVars,
+	return templateData{
+		Env: envVars,
+		git: git,
 	}
 }
 
-func applyTemplating(list []string, data map[string]interface{}) error {
+// ValidateTemplates checks that the templates in the flags and ldflags of
+// config parse and only refer to the data available to them, without reading
+// the git state.
+func ValidateTemplates(config Config) error {
+	data := createTemplateData(func() (GitState, error) { return GitState{}, nil })
+	lists := [][]string{config.Flags, config.Ldflags}
+	for _, flags := range config.PlatformFlags {
+		lists = append(lists, flags)
+	}
+	for _, ldflags := range config.PlatformLdflags {
+		lists = append(lists, ldflags)
+	}
+	for _, list := range lists {
+		if err := applyTemplating(append([]string(nil), list...), data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func applyTemplating(list []string, data templateData) error {
 	for i, entry := range list {
 		tmpl, err := template.New("argsTmpl").Option("missingkey=error").Parse(entry)
 		if err != nil {
@@ -672,21 +1149,28 @@ func applyTemplating(list []string, data map[string]interface{}) error {
 func createBuildArgs(buildCfg Config) ([]string, error) {
 	var args []string
 
-	data := createTemplateData()
-
-	if len(buildCfg.Flags) > 0 {
-		if err := applyTemplating(buildCfg.Flags, data); err != nil {
-			return nil, err
+	if buildCfg.Buildmode != "" {
+		if buildCfg.Builder == tinygoBuilder {
+			return nil, fmt.Errorf("buildmode %q is not supported by the %s builder", buildCfg.Buildmode, tinygoBuilder)
 		}
-
-		args = append(args, buildCfg.Flags...)
+		supported := false
+		for _, m := range buildModes {
+			if buildCfg.Buildmode == m {
+				supported = true
+				break
+			}
+		}
+		if !supported {
+			return nil, fmt.Errorf("unsupported buildmode %q, must be one of %q", buildCfg.Buildmode, buildModes)
+		}
+		args = append(args, "-buildmode="+buildCfg.Buildmode)
 	}
 
-	if len(buildCfg.Ldflags) > 0 {
-		if err := applyTemplating(buildCfg.Ldflags, data); err != nil {
-			return nil, err
-		}
+	// The templates in them have already been executed, by
+	// configForImportPath.
+	args = append(args, buildCfg.Flags...)
 
+	if len(buildCfg.Ldflags) > 0 {
 		args = append(args, fmt.Sprintf("-ldflags=%s", strings.Join(buildCfg.Ldflags, " ")))
 	}
 
@@ -703,24 +1187,137 @@ func createBuildArgs(buildCfg Config) ([]string, error) {
 	return args, nil
 }
 
-func (g *gobuild) configForImportPath(ip string) Config {
+// stripLdflags are the linker flags that strip binaries of their symbols.
+const stripLdflags = "-s -w"
+
+// goflagsLdflags returns the ldflags set in GOFLAGS, by env or else ko's
+// environment.
+func goflagsLdflags(env []string) StringArray {
+	goflags := os.Getenv("GOFLAGS")
+	for _, e := range env {
+		if strings.HasPrefix(e, "GOFLAGS=") {
+			goflags = strings.TrimPrefix(e, "GOFLAGS=")
+		}
+	}
+	var ldflags StringArray
+	for _, f := range strings.Fields(goflags) {
+		for _, prefix := range []string{"-ldflags=", "--ldflags="} {
+			if strings.HasPrefix(f, prefix) {
+				ldflags = StringArray{strings.TrimPrefix(f, prefix)}
+			}
+		}
+	}
+	return ldflags
+}
+
+func (g *gobuild) configForImportPath(ip string, platform v1.Platform) (Config, error) {
 	config := g.buildConfigs[ip]
-	if g.trimpath {
-		// The `-trimpath` flag removes file system paths from the resulting binary, to aid reproducibility.
-		// Ref: https://pkg.go.dev/cmd/go#hdr-Compile_packages_and_dependencies
-		config.Flags = append(config.Flags, "-trimpath")
+	// Copy the flags before adding to them, since the platforms of an
+	// index are built concurrently from the same build config.
+	flags := make([]string, 0, len(config.PlatformFlags))
+	for spec := range config.PlatformFlags {
+		flags = append(flags, spec)
+	}
+	if key := platformKey(flags, &platform); key != "" {
+		config.Flags = append(FlagArray(nil), config.PlatformFlags[key]...)
+	} else {
+		config.Flags = append(FlagArray(nil), config.Flags...)
+	}
+	ldflags := make([]string, 0, len(config.PlatformLdflags))
+	for spec := range config.PlatformLdflags {
+		ldflags = append(ldflags, spec)
+	}
+	if key := platformKey(ldflags, &platform); key != "" {
+		config.Ldflags = append(StringArray(nil), config.PlatformLdflags[key]...)
+	} else {
+		config.Ldflags = append(StringArray(nil), config.Ldflags...)
 	}
+	data := createTemplateData(g.gitState)
+	if err := applyTemplating(config.Flags, data); err != nil {
+		return Config{}, fmt.Errorf("flags of %s: %w", ip, err)
+	}
+	if err := applyTemplating(config.Ldflags, data); err != nil {
+		return Config{}, fmt.Errorf("ldflags of %s: %w", ip, err)
+	}
+	if config.Builder == tinygoBuilder {
+		if g.race {
+			return Config{}, fmt.Errorf("the race detector is not supported by the %s builder of %s", tinygoBuilder, ip)
+		}
+		// TinyGo doesn't understand -trimpath or -gcflags, and has its own
+		// flag for the optimization level instead.
+		if g.disableOptimizations {
+			config.Flags = append(config.Flags, "-opt=0")
+		}
+	} else {
+		trimpath := g.trimpath
+		if config.Trimpath != nil {
+			trimpath = *config.Trimpath
+		}
+		if trimpath {
+			// The `-trimpath` flag removes file system paths from the resulting binary, to aid reproducibility.
+			// Ref: https://pkg.go.dev/cmd/go#hdr-Compile_packages_and_dependencies
+			config.Flags = append(config.Flags, "-trimpath")
+		}
+
+		if g.disableOptimizations {
+			// Disable optimizations (-N) and inlining (-l).
+			config.Flags = append(config.Flags, "-gcflags", "all=-N -l")
+		}
 
-	if g.disableOptimizations {
-		// Disable optimizations (-N) and inlining (-l).
-		config.Flags = append(config.Flags, "-gcflags", "all=-N -l")
+		if g.race {
+			// The race detector needs cgo.
+			config.Flags = append(config.Flags, "-race")
+			config.Cgo = true
+		}
+	}
+
+	env := make([]string, 0, 1+len(config.Env))
+	if config.Cgo {
+		// Enable cgo unless the build config's env says otherwise.
+		env = append(env, "CGO_ENABLED=1")
+	}
+	env = append(env, config.Env...)
+	envs := make([]string, 0, len(config.PlatformEnv))
+	for spec := range config.PlatformEnv {
+		envs = append(envs, spec)
+	}
+	if key := platformKey(envs, &platform); key != "" {
+		env = append(env, config.PlatformEnv[key]...)
+	}
+	config.Env = env
+
+	if g.stripSymbols && config.Builder != tinygoBuilder {
+		// Leave out the symbol table (-s) and DWARF (-w), which take up
+		// much of the binary. They go before the user's ldflags, wherever
+		// those are set, so that those win.
+		inFlags := false
+		for i, f := range config.Flags {
+			switch {
+			case strings.HasPrefix(f, "-ldflags=") || strings.HasPrefix(f, "--ldflags="):
+				eq := strings.Index(f, "=")
+				config.Flags[i] = f[:eq+1] + stripLdflags + " " + f[eq+1:]
+				inFlags = true
+			case (f == "-ldflags" || f == "--ldflags") && i+1 < len(config.Flags):
+				config.Flags[i+1] = stripLdflags + " " + config.Flags[i+1]
+				inFlags = true
+			}
+		}
+		// The -ldflags that createBuildArgs adds for config.Ldflags would
+		// replace the ones in config.Flags, and those in GOFLAGS.
+		if !inFlags || len(config.Ldflags) > 0 {
+			ldflags := config.Ldflags
+			if len(ldflags) == 0 {
+				ldflags = goflagsLdflags(config.Env)
+			}
+			config.Ldflags = append(StringArray{stripLdflags}, ldflags...)
+		}
 	}
 
 	if config.ID != "" {
 		log.Printf("Using build config %s for %s", config.ID, ip)
 	}
 
-	return config
+	return config, nil
 }
 
 func (g *gobuild) buildOne(ctx context.Context, refStr string, base v1.Image, platform *v1.Platform) (oci.SignedImage, error) {
@@ -731,6 +1328,13 @@ func (g *gobuild) buildOne(ctx context.Context, refStr string, base v1.Image, pl
 
 	ref := newRef(refStr)
 
+	if g.mediaTypes != "" {
+		var err error
+		if base, err = convertMediaTypes(base, g.mediaTypes); err != nil {
+			return nil, fmt.Errorf("converting the base image of %s to %s media types: %w", ref.Path(), g.mediaTypes, err)
+		}
+	}
+
 	// Layers should be typed to match the underlying image, since some
 	// registries reject mixed-type layers.
 	var layerMediaType types.MediaType
@@ -744,6 +1348,10 @@ func (g *gobuild) buildOne(ctx context.Context, refStr string, base v1.Image, pl
 	case types.DockerManifestSchema2:
 		layerMediaType = types.DockerLayer
 	}
+	if g.compression.algorithm == ZstdCompression {
+		// zstd is only defined as an OCI layer media type.
+		layerMediaType = OCILayerZstd
+	}
 
 	cf, err := base.ConfigFile()
 	if err != nil {
@@ -754,54 +1362,156 @@ func (g *gobuild) buildOne(ctx context.Context, refStr string, base v1.Image, pl
 			OS:           cf.OS,
 			Architecture: cf.Architecture,
 			OSVersion:    cf.OSVersion,
+			Variant:      cf.Variant,
 		}
 	}
 
 	// Do the build into a temporary file.
-	file, err := g.build(ctx, ref.Path(), g.dir, *platform, g.configForImportPath(ref.Path()))
+	config, err := g.configForImportPath(ref.Path(), *platform)
+	if err != nil {
+		return nil, err
+	}
+	file, err := g.build(ctx, ref.Path(), g.dir, *platform, config)
 	if err != nil {
 		return nil, err
 	}
 	if os.Getenv("KOCACHE") == "" {
 		defer os.RemoveAll(filepath.Dir(file))
 	}
+	if err := g.recordBuildCommand(ref.Path(), ref.Path(), *platform, config); err != nil {
+		return nil, err
+	}
 
+	appPath, err := binaryPath(ref, config)
+	if err != nil {
+		return nil, err
+	}
+	appPath = executableName(platform, appPath)
+	appDir := path.Dir(appPath)
+	binaries := []appBinary{{name: appPath, file: file}}
+
+	// Build any additional binaries that share this image.
+	for _, ip := range config.Binaries {
+		name := executableName(platform, path.Join(appDir, appFilename(ip)))
+		for _, bin := range binaries {
+			if bin.name == name {
+				return nil, fmt.Errorf("binaries for %s both map to %s", ref.Path(), name)
+			}
+		}
+		f, err := g.build(ctx, ip, g.dir, *platform, config)
+		if err != nil {
+			return nil, err
+		}
+		if os.Getenv("KOCACHE") == "" {
+			defer os.RemoveAll(filepath.Dir(f))
+		}
+		if err := g.recordBuildCommand(ref.Path(), ip, *platform, config); err != nil {
+			return nil, err
+		}
+		binaries = append(binaries, appBinary{name: name, file: f})
+	}
+	// Keep the layer contents independent of the order binaries are listed in.
+	extra := binaries[1:]
+	sort.Slice(extra, func(i, j int) bool { return extra[i].name < extra[j].name })
+
+	entrypoint := appPath
+	if config.Entrypoint != "" {
+		entrypoint = executableName(platform, path.Join(appDir, config.Entrypoint))
+		found := false
+		for _, bin := range binaries {
+			if bin.name == entrypoint {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("entrypoint %q is not one of the binaries built for %s", config.Entrypoint, ref.Path())
+		}
+	}
+
+	// The layers are appended to the base image's in a fixed order, kodata,
+	// the added files, then the binaries, so that the digest only depends
+	// on their contents.
 	var layers []mutate.Addendum
 
 	// Create a layer from the kodata directory under this import path.
-	dataLayerBuf, err := g.tarKoData(ref, platform)
+	dataDir, err := dataPath(ref, config)
 	if err != nil {
 		return nil, err
 	}
-	dataLayerBytes := dataLayerBuf.Bytes()
-	dataLayer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
-		return ioutil.NopCloser(bytes.NewBuffer(dataLayerBytes)), nil
-	}, tarball.WithCompressedCaching, tarball.WithMediaType(layerMediaType))
-	if err != nil {
-		return nil, err
+	omitKoData := false
+	if g.omitEmptyKoData {
+		root, err := g.kodataPath(ref)
+		if err != nil {
+			return nil, err
+		}
+		if omitKoData, err = isEmptyKoData(root); err != nil {
+			return nil, err
+		}
+	}
+	if !omitKoData {
+		dataLayerBuf, err := g.tarKoData(ref, platform, dataDir)
+		if err != nil {
+			return nil, err
+		}
+		dataLayer, err := g.compression.layer(dataLayerBuf.Bytes(), layerMediaType)
+		if err != nil {
+			return nil, err
+		}
+		layers = append(layers, mutate.Addendum{
+			Layer: dataLayer,
+			History: v1.History{
+				Author:    "ko",
+				CreatedBy: "ko build " + ref.String(),
+				Created:   g.kodataCreationTime,
+				Comment:   "kodata contents, at $KO_DATA_PATH",
+			},
+		})
 	}
-	layers = append(layers, mutate.Addendum{
-		Layer: dataLayer,
-		History: v1.History{
-			Author:    "ko",
-			CreatedBy: "ko build " + ref.String(),
-			Created:   g.kodataCreationTime,
-			Comment:   "kodata contents, at $KO_DATA_PATH",
-		},
-	})
 
-	appDir := "/ko-app"
-	appPath := path.Join(appDir, appFilename(ref.Path()))
+	if len(g.addedFiles) > 0 {
+		filesLayerBuf, err := tarAddedFiles(g.addedFiles, platform, g.kodataCreationTime)
+		if err != nil {
+			return nil, err
+		}
+		filesLayer, err := g.compression.layer(filesLayerBuf.Bytes(), layerMediaType)
+		if err != nil {
+			return nil, err
+		}
+		layers = append(layers, mutate.Addendum{
+			Layer: filesLayer,
+			History: v1.History{
+				Author:    "ko",
+				CreatedBy: "ko build " + ref.String(),
+				Created:   g.kodataCreationTime,
+				Comment:   "added files",
+			},
+		})
+	}
 
+	// The binaries go alone in the topmost layer, above kodata and the base
+	// image's layers (CA certificates, tzdata, ...), which change less
+	// often, so that editing the code only changes, and pushes, this layer.
 	miss := func() (v1.Layer, error) {
-		return buildLayer(appPath, file, platform, layerMediaType)
+		return buildLayer(platform, layerMediaType, g.compression, appDir, binaries...)
 	}
 
-	binaryLayer, err := g.cache.get(ctx, file, miss)
+	var binaryLayer v1.Layer
+	if len(binaries) == 1 && config.BinaryPath == "" {
+		binaryLayer, err = g.cache.get(ctx, file, miss)
+	} else {
+		// The layer cache is keyed on a single binary's build ID, and
+		// assumes it is placed at the default path.
+		binaryLayer, err = miss()
+	}
 	if err != nil {
 		return nil, err
 	}
 
+	outputPath := appPath
+	if len(binaries) > 1 {
+		outputPath = appDir
+	}
 	layers = append(layers, mutate.Addendum{
 		Layer:     binaryLayer,
 		MediaType: layerMediaType,
@@ -809,7 +1519,7 @@ func (g *gobuild) buildOne(ctx context.Context, refStr string, base v1.Image, pl
 			Author:    "ko",
 			Created:   g.creationTime,
 			CreatedBy: "ko build " + ref.String(),
-			Comment:   "go build output, at " + appPath,
+			Comment:   "go build output, at " + outputPath,
 		},
 	})
 
@@ -827,25 +1537,63 @@ func (g *gobuild) buildOne(ctx context.Context, refStr string, base v1.Image, pl
 	}
 
 	cfg = cfg.DeepCopy()
-	cfg.Config.Entrypoint = []string{appPath}
+	baseEntrypoint := cfg.Config.Entrypoint
+	if g.keepBaseEntrypoint && len(baseEntrypoint) == 0 {
+		return nil, fmt.Errorf("cannot keep the base image's entrypoint for %s, the base image for %s has none", ref.Path(), platform)
+	}
+	if platform.OS == "windows" {
+		entrypoint = `C:` + strings.ReplaceAll(appDir, "/", `\`) + `\` + path.Base(entrypoint)
+	}
+	cfg.Config.Entrypoint = []string{entrypoint}
+	// Arguments are passed to the entrypoint, and can be overridden without
+	// overriding the entrypoint.
 	cfg.Config.Cmd = nil
+	if len(config.DefaultArgs) > 0 {
+		cfg.Config.Cmd = append([]string(nil), config.DefaultArgs...)
+	}
+	if g.keepBaseEntrypoint {
+		// The base's entrypoint, e.g. an init shim, runs our binary.
+		cfg.Config.Entrypoint = baseEntrypoint
+		cfg.Config.Cmd = append([]string{entrypoint}, cfg.Config.Cmd...)
+	}
 	if platform.OS == "windows" {
-		cfg.Config.Entrypoint = []string{`C:\ko-app\` + appFilename(ref.Path())}
-		updatePath(cfg, `C:\ko-app`)
-		cfg.Config.Env = append(cfg.Config.Env, `KO_DATA_PATH=C:\var\run\ko`)
+		winAppDir := `C:` + strings.ReplaceAll(appDir, "/", `\`)
+		updatePath(cfg, winAppDir)
+		cfg.Config.Env = append(cfg.Config.Env, `KO_DATA_PATH=C:`+strings.ReplaceAll(dataDir, "/", `\`))
 	} else {
 		updatePath(cfg, appDir)
-		cfg.Config.Env = append(cfg.Config.Env, "KO_DATA_PATH="+kodataRoot)
+		cfg.Config.Env = append(cfg.Config.Env, "KO_DATA_PATH="+dataDir)
+	}
+	for _, kv := range config.ImageEnv {
+		setEnv(cfg, kv)
 	}
 	cfg.Author = "github.com/google/ko"
+	if cfg.Variant == "" && cfg.Architecture == platform.Architecture {
+		// Some bases only record the variant in their index, so record
+		// the one we built for (e.g. GOARM) in the image too.
+		cfg.Variant = platform.Variant
+	}
 
 	if cfg.Config.Labels == nil {
 		cfg.Config.Labels = map[string]string{}
 	}
+	if g.sourceURL != "" {
+		cfg.Config.Labels[specsv1.AnnotationSource] = g.sourceURL
+	}
 	for k, v := range g.labels {
 		cfg.Config.Labels[k] = v
 	}
 
+	if g.user != "" {
+		cfg.Config.User = g.user
+	}
+	if config.WorkingDir != "" {
+		if !path.IsAbs(config.WorkingDir) {
+			return nil, fmt.Errorf("workingDir %q for %s must be an absolute path", config.WorkingDir, ref.Path())
+		}
+		cfg.Config.WorkingDir = config.WorkingDir
+	}
+
 	empty := v1.Time{}
 	if g.creationTime != empty {
 		cfg.Created = g.creationTime
@@ -858,11 +1606,17 @@ func (g *gobuild) buildOne(ctx context.Context, refStr string, base v1.Image, pl
 
 	si := signed.Image(image)
 
-	if g.sbom != nil {
+	// TinyGo binaries have no Go build info for an SBOM to be made from.
+	if g.sbom != nil && config.Builder != tinygoBuilder {
 		sbom, mt, err := g.sbom(ctx, file, appPath, si)
 		if err != nil {
 			return nil, err
 		}
+		if g.getBaseSBOM != nil {
+			if sbom, err = g.mergeBaseSBOM(ctx, si, sbom, mt); err != nil {
+				return nil, err
+			}
+		}
 		f, err := static.NewFile(sbom, static.WithLayerMediaType(mt))
 		if err != nil {
 			return nil, err
@@ -875,19 +1629,58 @@ func (g *gobuild) buildOne(ctx context.Context, refStr string, base v1.Image, pl
 	return si, nil
 }
 
-func buildLayer(appPath, file string, platform *v1.Platform, layerMediaType types.MediaType) (v1.Layer, error) {
-	// Construct a tarball with the binary and produce a layer.
-	binaryLayerBuf, err := tarBinary(appPath, file, platform)
+// mergeBaseSBOM merges the SBOM of the base image of img, if it has one,
+// into doc, the SBOM we generated for img.
+func (g *gobuild) mergeBaseSBOM(ctx context.Context, img v1.Image, doc []byte, mt types.MediaType) ([]byte, error) {
+	if mt == sbom.GoVersionMMediaType {
+		return doc, nil
+	}
+	m, err := img.Manifest()
+	if err != nil {
+		return nil, err
+	}
+	baseName, ok := m.Annotations[specsv1.AnnotationBaseImageName]
+	if !ok {
+		return doc, nil
+	}
+	baseDigest, ok := m.Annotations[specsv1.AnnotationBaseImageDigest]
+	if !ok {
+		return doc, nil
+	}
+	baseRef, err := name.ParseReference(baseName)
+	if err != nil {
+		return nil, err
+	}
+	dig := baseRef.Context().Digest(baseDigest)
+
+	base, baseMT, err := g.getBaseSBOM(ctx, dig)
+	if err != nil {
+		// The base image's SBOM is only nice to have, so don't fail
+		// the build if we can't get it.
+		log.Printf("Unable to get the SBOM of base image %s: %v", dig, err)
+		return doc, nil
+	}
+	if base == nil {
+		return doc, nil
+	}
+	merged, err := sbom.MergeBase(doc, mt, base, baseMT)
+	if err != nil {
+		log.Printf("Unable to merge the SBOM of base image %s: %v", dig, err)
+		return doc, nil
+	}
+	return merged, nil
+}
+
+func buildLayer(platform *v1.Platform, layerMediaType types.MediaType, compression layerCompression, appDir string, binaries ...appBinary) (v1.Layer, error) {
+	// Construct a tarball with the binaries and produce a layer.
+	binaryLayerBuf, err := tarBinary(platform, appDir, binaries...)
 	if err != nil {
 		return nil, err
 	}
-	binaryLayerBytes := binaryLayerBuf.Bytes()
-	return tarball.LayerFromOpener(func() (io.ReadCloser, error) {
-		return ioutil.NopCloser(bytes.NewBuffer(binaryLayerBytes)), nil
-	}, tarball.WithCompressedCaching, tarball.WithEstargzOptions(estargz.WithPrioritizedFiles([]string{
+	return compression.layer(binaryLayerBuf.Bytes(), layerMediaType, tarball.WithEstargzOptions(estargz.WithPrioritizedFiles([]string{
 		// When using estargz, prioritize downloading the binary entrypoint.
-		appPath,
-	})), tarball.WithMediaType(layerMediaType))
+		binaries[0].name,
+	})))
 }
 
 // Append appPath to the PATH environment variable, if it exists. Otherwise,
@@ -911,8 +1704,32 @@ func updatePath(cf *v1.ConfigFile, appPath string) {
 	cf.Config.Env = append(cf.Config.Env, "PATH="+appPath)
 }
 
+// setEnv sets the KEY=VALUE environment variable kv in the config, after any
+// existing variables, replacing any existing value of KEY.
+func setEnv(cf *v1.ConfigFile, kv string) {
+	key := strings.SplitN(kv, "=", 2)[0]
+	env := make([]string, 0, len(cf.Config.Env)+1)
+	for _, e := range cf.Config.Env {
+		if strings.SplitN(e, "=", 2)[0] != key {
+			env = append(env, e)
+		}
+	}
+	cf.Config.Env = append(env, kv)
+}
+
 // Build implements build.Interface
 func (g *gobuild) Build(ctx context.Context, s string) (Result, error) {
+	s, err := qualifyLocalRef(g, s)
+	if err != nil {
+		return nil, err
+	}
+
+	// Refuse to build anything but commands: building a library package
+	// would produce an image without a binary to run.
+	if err := g.IsSupportedReference(s); err != nil {
+		return nil, fmt.Errorf("not building %s: %w", strings.TrimPrefix(s, StrictScheme), err)
+	}
+
 	// Determine the appropriate base image for this import path.
 	// We use the overall gobuild.ctx because the Build ctx gets cancelled
 	// early, and we lazily use the ctx within ggcr's remote package.
@@ -921,6 +1738,15 @@ func (g *gobuild) Build(ctx context.Context, s string) (Result, error) {
 		return nil, err
 	}
 
+	// Determine whether any platforms use a different base.
+	var overrides map[string]PlatformBase
+	if g.getPlatformBases != nil {
+		overrides, err = g.getPlatformBases(g.ctx, s)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	// Determine what kind of base we have and if we should publish an image or an index.
 	mt, err := base.MediaType()
 	if err != nil {
@@ -949,25 +1775,149 @@ func (g *gobuild) Build(ctx context.Context, s string) (Result, error) {
 		if !ok {
 			return nil, fmt.Errorf("failed to interpret base as index: %v", base)
 		}
-		return g.buildAll(ctx, s, baseRef, baseIndex)
+		return g.buildAll(ctx, s, baseRef, baseIndex, overrides)
 	case types.OCIManifestSchema1, types.DockerManifestSchema2:
 		baseImage, ok := base.(v1.Image)
 		if !ok {
 			return nil, fmt.Errorf("failed to interpret base as image: %v", base)
 		}
+		if len(overrides) > 0 {
+			cf, err := baseImage.ConfigFile()
+			if err != nil {
+				return nil, err
+			}
+			platform := &v1.Platform{OS: cf.OS, Architecture: cf.Architecture, Variant: cf.Variant}
+			if err := checkPlatformOverrides(s, overrides, []v1.Descriptor{{Platform: platform}}); err != nil {
+				return nil, err
+			}
+			if o := platformOverride(overrides, platform); o != nil {
+				baseImage, err = overrideImage(o, platform)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
 		return g.buildOne(ctx, s, baseImage, nil)
 	default:
 		return nil, fmt.Errorf("base image media type: %s", mt)
 	}
 }
 
-func (g *gobuild) buildAll(ctx context.Context, ref string, baseRef name.Reference, baseIndex v1.ImageIndex) (Result, error) {
+// platformOverride returns the most specific base override matching the
+// given platform, or nil if there is none.
+func platformOverride(overrides map[string]PlatformBase, platform *v1.Platform) *PlatformBase {
+	specs := make([]string, 0, len(overrides))
+	for spec := range overrides {
+		specs = append(specs, spec)
+	}
+	match := platformKey(specs, platform)
+	if match == "" {
+		return nil
+	}
+	o := overrides[match]
+	return &o
+}
+
+// platformKey returns the most specific of the given platform specs that
+// matches platform, or "" if there is none.
+func platformKey(specs []string, platform *v1.Platform) string {
+	var match string
+	for _, spec := range specs {
+		pm, err := parseSpec([]string{spec})
+		if err != nil || !pm.matches(platform) {
+			continue
+		}
+		if len(spec) > len(match) || (len(spec) == len(match) && spec < match) {
+			match = spec
+		}
+	}
+	return match
+}
+
+// checkPlatformOverrides returns an error if any base override would not be
+// used by one of the platforms being built.
+func checkPlatformOverrides(ref string, overrides map[string]PlatformBase, building []v1.Descriptor) error {
+	for spec := range overrides {
+		pm, err := parseSpec([]string{spec})
+		if err != nil {
+			return fmt.Errorf("base image override for %q: %w", ref, err)
+		}
+		used := false
+		for _, desc := range building {
+			if pm.matches(desc.Platform) {
+				used = true
+				break
+			}
+		}
+		if !used {
+			return fmt.Errorf("base image override for platform %q of %q, but that platform is not being built", spec, ref)
+		}
+	}
+	return nil
+}
+
+// overrideImage selects the image for the given platform from a base
+// override, and annotates it with the override's reference and digest.
+func overrideImage(o *PlatformBase, platform *v1.Platform) (v1.Image, error) {
+	mt, err := o.Base.MediaType()
+	if err != nil {
+		return nil, err
+	}
+
+	var img v1.Image
+	var digest v1.Hash
+	switch {
+	case mt.IsIndex():
+		idx, ok := o.Base.(v1.ImageIndex)
+		if !ok {
+			return nil, fmt.Errorf("failed to interpret base %s as index: %v", o.Ref, o.Base)
+		}
+		im, err := idx.IndexManifest()
+		if err != nil {
+			return nil, err
+		}
+		pm := &platformMatcher{platforms: []v1.Platform{*platform}}
+		for _, desc := range im.Manifests {
+			if pm.matches(desc.Platform) {
+				digest = desc.Digest
+				img, err = idx.Image(desc.Digest)
+				if err != nil {
+					return nil, err
+				}
+				break
+			}
+		}
+		if img == nil {
+			return nil, fmt.Errorf("base %s has no image for platform %s", o.Ref, platform)
+		}
+	case mt.IsImage():
+		var ok bool
+		img, ok = o.Base.(v1.Image)
+		if !ok {
+			return nil, fmt.Errorf("failed to interpret base %s as image: %v", o.Ref, o.Base)
+		}
+		digest, err = img.Digest()
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("base image media type: %s", mt)
+	}
+
+	return mutate.Annotations(img, map[string]string{
+		specsv1.AnnotationBaseImageDigest: digest.String(),
+		specsv1.AnnotationBaseImageName:   o.Ref.Name(),
+	}).(v1.Image), nil
+}
+
+func (g *gobuild) buildAll(ctx context.Context, ref string, baseRef name.Reference, baseIndex v1.ImageIndex, overrides map[string]PlatformBase) (Result, error) {
 	im, err := baseIndex.IndexManifest()
 	if err != nil {
 		return nil, err
 	}
 
 	matches := []v1.Descriptor{}
+	available := []*v1.Platform{}
 	for _, desc := range im.Manifests {
 		// Nested index is pretty rare. We could support this in theory, but return an error for now.
 		if desc.MediaType != types.OCIManifestSchema1 && desc.MediaType != types.DockerManifestSchema2 {
@@ -977,62 +1927,90 @@ func (g *gobuild) buildAll(ctx context.Context, ref string, baseRef name.Referen
 		if g.platformMatcher.matches(desc.Platform) {
 			matches = append(matches, desc)
 		}
+		if desc.Platform != nil {
+			available = append(available, desc.Platform)
+		}
 	}
-	if len(matches) == 0 {
-		return nil, errors.New("no matching platforms in base image index")
+	// Fail before building anything, rather than leave out some of the
+	// requested platforms.
+	if missing := g.platformMatcher.missing(available); len(matches) == 0 || len(missing) > 0 {
+		if len(missing) == 0 {
+			missing = g.platformMatcher.spec
+		}
+		return nil, fmt.Errorf("base image index %s for %s doesn't provide requested platforms %s, it only provides %s",
+			baseRef, ref, strings.Join(missing, ","), platformList(available))
 	}
-	if len(matches) == 1 {
-		// Filters resulted in a single matching platform; just produce
-		// a single-platform image.
-		img, err := baseIndex.Image(matches[0].Digest)
+	if err := checkPlatformOverrides(ref, overrides, matches); err != nil {
+		return nil, err
+	}
+
+	// baseImage returns the base for the platform of the given descriptor,
+	// which is either the image from the base index or a platform override.
+	baseImage := func(desc v1.Descriptor) (v1.Image, error) {
+		if o := platformOverride(overrides, desc.Platform); o != nil {
+			return overrideImage(o, desc.Platform)
+		}
+
+		img, err := baseIndex.Image(desc.Digest)
 		if err != nil {
 			return nil, fmt.Errorf("error getting matching image from index: %w", err)
 		}
+
 		// Decorate the image with the ref of the index, and the matching
-		// platform's digest.
-		img = mutate.Annotations(img, map[string]string{
-			specsv1.AnnotationBaseImageDigest: matches[0].Digest.String(),
+		// platform's digest.  The ref of the index encodes the critical
+		// repository information for fetching the base image's digest, but
+		// we leave `name` pointing at the index's full original ref to that
+		// folks could conceivably check for updates to the index over time.
+		// While the `digest` doesn't give us enough information to check
+		// for changes with a simple HEAD (because we need the full index
+		// manifest to get the per-architecture image), that optimization
+		// mainly matters for DockerHub where HEAD's are exempt from rate
+		// limiting.  However, in practice, the way DockerHub updates the
+		// indices for official images is to rebuild per-arch images and
+		// replace the per-arch images in the existing index, so an index
+		// with N manifest receives N updates.  If we only record the digest
+		// of the index here, then we cannot tell when the index updates are
+		// no-ops for us because we didn't record the digest of the actual
+		// image we used, and we would potentially end up doing Nx more work
+		// than we really need to do.
+		return mutate.Annotations(img, map[string]string{
+			specsv1.AnnotationBaseImageDigest: desc.Digest.String(),
 			specsv1.AnnotationBaseImageName:   baseRef.Name(),
-		}).(v1.Image)
+		}).(v1.Image), nil
+	}
+
+	if len(matches) == 1 {
+		// Filters resulted in a single matching platform; just produce
+		// a single-platform image.
+		img, err := baseImage(matches[0])
+		if err != nil {
+			return nil, err
+		}
 		return g.buildOne(ctx, ref, img, matches[0].Platform)
 	}
 
 	// Build an image for each matching platform from the base and append
-	// it to a new index to produce the result. We use the indices to
-	// preserve the base image ordering here.
+	// it to a new index to produce the result. The platforms are built
+	// concurrently, bounded by the jobs semaphore in buildOne, and the first
+	// failure cancels the rest. We use the indices to preserve the base image
+	// ordering here, so the result doesn't depend on which finishes first.
 	errg, ctx := errgroup.WithContext(ctx)
 	adds := make([]ocimutate.IndexAddendum, len(matches))
 	for i, desc := range matches {
 		i, desc := i, desc
 		errg.Go(func() error {
-			baseImage, err := baseIndex.Image(desc.Digest)
+			base, err := baseImage(desc)
 			if err != nil {
-				return err
+				return fmt.Errorf("%s: %w", platformName(desc.Platform), err)
 			}
 
-			// Decorate the image with the ref of the index, and the matching
-			// platform's digest.  The ref of the index encodes the critical
-			// repository information for fetching the base image's digest, but
-			// we leave `name` pointing at the index's full original ref to that
-			// folks could conceivably check for updates to the index over time.
-			// While the `digest` doesn't give us enough information to check
-			// for changes with a simple HEAD (because we need the full index
-			// manifest to get the per-architecture image), that optimization
-			// mainly matters for DockerHub where HEAD's are exempt from rate
-			// limiting.  However, in practice, the way DockerHub updates the
-			// indices for official images is to rebuild per-arch images and
-			// replace the per-arch images in the existing index, so an index
-			// with N manifest receives N updates.  If we only record the digest
-			// of the index here, then we cannot tell when the index updates are
-			// no-ops for us because we didn't record the digest of the actual
-			// image we used, and we would potentially end up doing Nx more work
-			// than we really need to do.
-			baseImage = mutate.Annotations(baseImage, map[string]string{
-				specsv1.AnnotationBaseImageDigest: desc.Digest.String(),
-				specsv1.AnnotationBaseImageName:   baseRef.Name(),
-			}).(v1.Image)
-
-			img, err := g.buildOne(ctx, ref, baseImage, desc.Platform)
+			img, err := g.buildOne(ctx, ref, base, desc.Platform)
+			if err != nil {
+				return fmt.Errorf("building %s for %s: %w", ref, platformName(desc.Platform), err)
+			}
+			// Platform overrides may use a different manifest media type
+			// than the base index, so take it from the image we built.
+			mt, err := img.MediaType()
 			if err != nil {
 				return err
 			}
@@ -1040,7 +2018,7 @@ func (g *gobuild) buildAll(ctx context.Context, ref string, baseRef name.Referen
 				Add: img,
 				Descriptor: v1.Descriptor{
 					URLs:        desc.URLs,
-					MediaType:   desc.MediaType,
+					MediaType:   mt,
 					Annotations: desc.Annotations,
 					Platform:    desc.Platform,
 				},
@@ -1056,11 +2034,22 @@ func (g *gobuild) buildAll(ctx context.Context, ref string, baseRef name.Referen
 	if err != nil {
 		return nil, err
 	}
+	if g.mediaTypes != "" {
+		_, _, baseType = manifestMediaTypes(g.mediaTypes)
+	}
 
+	annotations := im.Annotations
+	if g.sourceURL != "" {
+		annotations = make(map[string]string, len(im.Annotations)+1)
+		for k, v := range im.Annotations {
+			annotations[k] = v
+		}
+		annotations[specsv1.AnnotationSource] = g.sourceURL
+	}
 	idx := ocimutate.AppendManifests(
 		mutate.Annotations(
 			mutate.IndexMediaType(empty.Index, baseType),
-			im.Annotations).(v1.ImageIndex),
+			annotations).(v1.ImageIndex),
 		adds...)
 
 	if g.sbom != nil {
@@ -1083,6 +2072,14 @@ func (g *gobuild) buildAll(ctx context.Context, ref string, baseRef name.Referen
 	return idx, nil
 }
 
+// platformName returns the name of p for use in error messages.
+func platformName(p *v1.Platform) string {
+	if p == nil {
+		return "unknown platform"
+	}
+	return p.String()
+}
+
 func parseSpec(spec []string) (*platformMatcher, error) {
 	// Don't bother parsing "all".
 	// Empty slice should never happen because we default to linux/amd64 (or GOOS/GOARCH).
@@ -1101,6 +2098,38 @@ func parseSpec(spec []string) (*platformMatcher, error) {
 	return &platformMatcher{spec: spec, platforms: platforms}, nil
 }
 
+// missing returns the requested platforms, as specified, that none of
+// available matches. Nothing is missing when all platforms are requested.
+func (pm *platformMatcher) missing(available []*v1.Platform) []string {
+	var missing []string
+	for i, p := range pm.platforms {
+		one := &platformMatcher{platforms: []v1.Platform{p}}
+		found := false
+		for _, a := range available {
+			if one.matches(a) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, pm.spec[i])
+		}
+	}
+	return missing
+}
+
+// platformList formats platforms for error messages.
+func platformList(platforms []*v1.Platform) string {
+	if len(platforms) == 0 {
+		return "no platforms"
+	}
+	names := make([]string, 0, len(platforms))
+	for _, p := range platforms {
+		names = append(names, p.String())
+	}
+	return strings.Join(names, ",")
+}
+
 func (pm *platformMatcher) matches(base *v1.Platform) bool {
 	if len(pm.spec) > 0 && pm.spec[0] == "all" {
 		return true
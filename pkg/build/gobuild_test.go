@@ -18,17 +18,23 @@ package build
 
 import (
 	"archive/tar"
+	"bytes"
 	"context"
+	"debug/elf"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
+	"os/exec"
 	"path"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -40,8 +46,11 @@ import (
 	"github.com/google/go-containerregistry/pkg/v1/mutate"
 	"github.com/google/go-containerregistry/pkg/v1/random"
 	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/google/ko/internal/sbom"
+	"github.com/klauspost/compress/zstd"
 	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/sigstore/cosign/pkg/oci"
+	ctypes "github.com/sigstore/cosign/pkg/types"
 )
 
 func repoRootDir() (string, error) {
@@ -175,6 +184,93 @@ func TestGoBuildIsSupportedRef(t *testing.T) {
 	}
 }
 
+func TestGoBuildIsSupportedRefMissingPackage(t *testing.T) {
+	ng, err := NewGo(context.Background(), "", WithBaseImages(func(context.Context, string) (name.Reference, Result, error) { return baseRef, empty.Image, nil }))
+	if err != nil {
+		t.Fatalf("NewGo() = %v", err)
+	}
+
+	err = ng.IsSupportedReference("ko://github.com/google/ko/tset")
+	if err == nil {
+		t.Fatal("IsSupportedReference() = nil, want error")
+	}
+	want := "no such package github.com/google/ko/tset, did you mean github.com/google/ko/test or "
+	if !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("IsSupportedReference() = %v, want %s...", err, want)
+	}
+}
+
+func TestGoBuildNotMain(t *testing.T) {
+	ng, err := NewGo(context.Background(), "", WithBaseImages(func(context.Context, string) (name.Reference, Result, error) { return baseRef, empty.Image, nil }))
+	if err != nil {
+		t.Fatalf("NewGo() = %v", err)
+	}
+
+	// A library builds fine, but there would be no binary in the image.
+	_, err = ng.Build(context.Background(), StrictScheme+"github.com/google/ko/pkg/build")
+	if err == nil {
+		t.Fatal("Build() = nil, want error")
+	}
+	if want := "importpath is `package build`, not `package main`"; !strings.Contains(err.Error(), want) {
+		t.Errorf("Build() = %v, want it to contain %q", err, want)
+	}
+}
+
+func TestGoBuildLocalReference(t *testing.T) {
+	var got string
+	ng, err := NewGo(context.Background(), "../..", WithBaseImages(func(_ context.Context, s string) (name.Reference, Result, error) {
+		got = s
+		return baseRef, empty.Image, nil
+	}))
+	if err != nil {
+		t.Fatalf("NewGo() = %v", err)
+	}
+
+	if err := ng.IsSupportedReference(StrictScheme + "./test"); err != nil {
+		t.Errorf("IsSupportedReference() = %v", err)
+	}
+	if _, err := ng.Build(context.Background(), StrictScheme+"./test"); err != nil {
+		t.Fatalf("Build() = %v", err)
+	}
+	// Everything past the reference is done for the complete import path.
+	if want := StrictScheme + "github.com/google/ko/test"; got != want {
+		t.Errorf("base image looked up for %q, want %q", got, want)
+	}
+}
+
+func TestGoBuildChecksSupportedReferenceOnce(t *testing.T) {
+	ng, err := NewGo(context.Background(), "", WithBaseImages(func(context.Context, string) (name.Reference, Result, error) { return baseRef, empty.Image, nil }))
+	if err != nil {
+		t.Fatalf("NewGo() = %v", err)
+	}
+	ref := StrictScheme + "github.com/google/ko/test"
+	if err := ng.IsSupportedReference(ref); err != nil {
+		t.Fatalf("IsSupportedReference() = %v", err)
+	}
+	// Build checks the reference again, which shouldn't load packages twice.
+	if _, ok := ng.(*gobuild).supported.Load(ref); !ok {
+		t.Errorf("IsSupportedReference(%s) wasn't remembered for Build", ref)
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	for _, tc := range []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"cmd/server", "cmd/server", 0},
+		{"cmd/srver", "cmd/server", 1},
+		{"cmd/sevrer", "cmd/server", 2},
+		{"", "abc", 3},
+		{"kitten", "sitting", 3},
+	} {
+		if got := editDistance(tc.a, tc.b); got != tc.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
+
 func TestGoBuildIsSupportedRefWithModules(t *testing.T) {
 	base, err := random.Image(1024, 3)
 	if err != nil {
@@ -269,6 +365,16 @@ func TestBuildEnv(t *testing.T) {
 			"GOARCH": "arm64",
 			"GOARM":  "",
 		},
+	}, {
+		description: "riscv64",
+		platform: v1.Platform{
+			OS:           "linux",
+			Architecture: "riscv64",
+		},
+		expectedEnvs: map[string]string{
+			"GOOS":   "linux",
+			"GOARCH": "riscv64",
+		},
 	}, {
 		description: "amd64 variant",
 		platform: v1.Platform{
@@ -301,10 +407,21 @@ func TestBuildEnv(t *testing.T) {
 }
 
 func TestBuildConfig(t *testing.T) {
+	yes, no := true, false
+	platformFlags := map[string]FlagArray{
+		"linux/amd64":  {"-tags=netgo"},
+		"linux/arm64":  {"-tags=arm"},
+		"linux/arm/v7": {"-tags=armv7"},
+		"linux/arm":    {"-tags=arm32"},
+	}
+	platformLdflags := map[string]StringArray{
+		"linux": {"-w"},
+	}
 	tests := []struct {
 		description  string
 		options      []Option
 		importpath   string
+		platform     v1.Platform
 		expectConfig Config
 	}{
 		{
@@ -362,6 +479,100 @@ func TestBuildConfig(t *testing.T) {
 				Flags: FlagArray{"-trimpath"},
 			},
 		},
+		{
+			description: "build config disables trimpath",
+			options: []Option{
+				WithBaseImages(nilGetBase),
+				WithConfig(map[string]Config{
+					"example.com/debug": {
+						Trimpath: &no,
+					},
+				}),
+				WithTrimpath(true),
+			},
+			importpath: "example.com/debug",
+			expectConfig: Config{
+				Trimpath: &no,
+			},
+		},
+		{
+			description: "build config enables trimpath",
+			options: []Option{
+				WithBaseImages(nilGetBase),
+				WithConfig(map[string]Config{
+					"example.com/release": {
+						Trimpath: &yes,
+					},
+				}),
+				WithTrimpath(false),
+			},
+			importpath: "example.com/release",
+			expectConfig: Config{
+				Trimpath: &yes,
+				Flags:    FlagArray{"-trimpath"},
+			},
+		},
+		{
+			description: "platform flags override global flags",
+			options: []Option{
+				WithBaseImages(nilGetBase),
+				WithConfig(map[string]Config{
+					"example.com/multi": {
+						Flags:           FlagArray{"-v"},
+						Ldflags:         StringArray{"-s"},
+						PlatformFlags:   platformFlags,
+						PlatformLdflags: platformLdflags,
+					},
+				}),
+				WithTrimpath(true),
+			},
+			importpath: "example.com/multi",
+			platform:   v1.Platform{OS: "linux", Architecture: "arm64"},
+			expectConfig: Config{
+				Flags:           FlagArray{"-tags=arm", "-trimpath"},
+				Ldflags:         StringArray{"-w"},
+				PlatformFlags:   platformFlags,
+				PlatformLdflags: platformLdflags,
+			},
+		},
+		{
+			description: "most specific platform flags win",
+			options: []Option{
+				WithBaseImages(nilGetBase),
+				WithConfig(map[string]Config{
+					"example.com/multi": {
+						Flags:         FlagArray{"-v"},
+						PlatformFlags: platformFlags,
+					},
+				}),
+			},
+			importpath: "example.com/multi",
+			platform:   v1.Platform{OS: "linux", Architecture: "arm", Variant: "v7"},
+			expectConfig: Config{
+				Flags:         FlagArray{"-tags=armv7"},
+				PlatformFlags: platformFlags,
+			},
+		},
+		{
+			description: "global flags for other platforms",
+			options: []Option{
+				WithBaseImages(nilGetBase),
+				WithConfig(map[string]Config{
+					"example.com/multi": {
+						Flags:         FlagArray{"-v"},
+						Ldflags:       StringArray{"-s"},
+						PlatformFlags: platformFlags,
+					},
+				}),
+			},
+			importpath: "example.com/multi",
+			platform:   v1.Platform{OS: "linux", Architecture: "s390x"},
+			expectConfig: Config{
+				Flags:         FlagArray{"-v"},
+				Ldflags:       StringArray{"-s"},
+				PlatformFlags: platformFlags,
+			},
+		},
 		{
 			description: "disable optimizations",
 			options: []Option{
@@ -372,6 +583,143 @@ func TestBuildConfig(t *testing.T) {
 				Flags: FlagArray{"-gcflags", "all=-N -l"},
 			},
 		},
+		{
+			description: "race detector",
+			options: []Option{
+				WithBaseImages(nilGetBase),
+				WithConfig(map[string]Config{
+					"example.com/racy": {
+						Env: []string{"CC=x86_64-linux-gnu-gcc"},
+					},
+				}),
+				WithRace(),
+			},
+			importpath: "example.com/racy",
+			expectConfig: Config{
+				Flags: FlagArray{"-race"},
+				Cgo:   true,
+				Env:   []string{"CGO_ENABLED=1", "CC=x86_64-linux-gnu-gcc"},
+			},
+		},
+		{
+			description: "cgo with platform env",
+			options: []Option{
+				WithBaseImages(nilGetBase),
+				WithConfig(map[string]Config{
+					"example.com/cgo": {
+						Cgo: true,
+						Env: []string{"CGO_CFLAGS=-O2"},
+						PlatformEnv: map[string][]string{
+							"linux/arm64": {"CC=aarch64-linux-gnu-gcc", "CXX=aarch64-linux-gnu-g++"},
+							"linux":       {"CC=gcc"},
+						},
+					},
+				}),
+			},
+			importpath: "example.com/cgo",
+			platform:   v1.Platform{OS: "linux", Architecture: "arm64"},
+			expectConfig: Config{
+				Cgo: true,
+				Env: []string{"CGO_ENABLED=1", "CGO_CFLAGS=-O2", "CC=aarch64-linux-gnu-gcc", "CXX=aarch64-linux-gnu-g++"},
+				PlatformEnv: map[string][]string{
+					"linux/arm64": {"CC=aarch64-linux-gnu-gcc", "CXX=aarch64-linux-gnu-g++"},
+					"linux":       {"CC=gcc"},
+				},
+			},
+		},
+		{
+			description: "strip symbols",
+			options: []Option{
+				WithBaseImages(nilGetBase),
+				WithStripSymbols(true),
+			},
+			expectConfig: Config{
+				Ldflags: StringArray{"-s -w"},
+			},
+		},
+		{
+			description: "strip symbols with ldflags",
+			options: []Option{
+				WithBaseImages(nilGetBase),
+				WithConfig(map[string]Config{
+					"example.com/ldflags": {
+						Ldflags: StringArray{"-X main.version=1.2.3"},
+					},
+				}),
+				WithStripSymbols(true),
+			},
+			importpath: "example.com/ldflags",
+			expectConfig: Config{
+				Ldflags: StringArray{"-s -w", "-X main.version=1.2.3"},
+			},
+		},
+		{
+			description: "strip symbols with ldflags in flags",
+			options: []Option{
+				WithBaseImages(nilGetBase),
+				WithConfig(map[string]Config{
+					"example.com/flags": {
+						Flags: FlagArray{"-ldflags=-X main.version=1.2.3"},
+					},
+				}),
+				WithStripSymbols(true),
+			},
+			importpath: "example.com/flags",
+			expectConfig: Config{
+				Flags: FlagArray{"-ldflags=-s -w -X main.version=1.2.3"},
+			},
+		},
+		{
+			description: "strip symbols with separate ldflags flag",
+			options: []Option{
+				WithBaseImages(nilGetBase),
+				WithConfig(map[string]Config{
+					"example.com/flags": {
+						Flags: FlagArray{"-ldflags", "-X main.version=1.2.3"},
+					},
+				}),
+				WithStripSymbols(true),
+			},
+			importpath: "example.com/flags",
+			expectConfig: Config{
+				Flags: FlagArray{"-ldflags", "-s -w -X main.version=1.2.3"},
+			},
+		},
+		{
+			description: "strip symbols with ldflags in GOFLAGS",
+			options: []Option{
+				WithBaseImages(nilGetBase),
+				WithConfig(map[string]Config{
+					"example.com/goflags": {
+						Env: []string{"GOFLAGS=-mod=mod -ldflags=-X=main.version=1.2.3"},
+					},
+				}),
+				WithStripSymbols(true),
+			},
+			importpath: "example.com/goflags",
+			expectConfig: Config{
+				Env:     []string{"GOFLAGS=-mod=mod -ldflags=-X=main.version=1.2.3"},
+				Ldflags: StringArray{"-s -w", "-X=main.version=1.2.3"},
+			},
+		},
+		{
+			description: "tinygo builder ignores trimpath and uses -opt",
+			options: []Option{
+				WithBaseImages(nilGetBase),
+				WithConfig(map[string]Config{
+					"example.com/tiny": {
+						Builder: "tinygo",
+					},
+				}),
+				WithTrimpath(true),
+				WithDisabledOptimizations(),
+			},
+			importpath: "example.com/tiny",
+			expectConfig: Config{
+				Builder: "tinygo",
+				Flags:   FlagArray{"-opt=0"},
+			},
+		},
 	}
 	for _, test := range tests {
 		t.Run(test.description, func(t *testing.T) {
@@ -383,7 +731,10 @@ func TestBuildConfig(t *testing.T) {
 			if !ok {
 				t.Fatal("NewGo() did not return *gobuild{} as expected")
 			}
-			config := gb.configForImportPath(test.importpath)
+			config, err := gb.configForImportPath(test.importpath, test.platform)
+			if err != nil {
+				t.Fatalf("configForImportPath() = %v", err)
+			}
 			if diff := cmp.Diff(test.expectConfig, config, cmpopts.EquateEmpty(),
 				cmpopts.SortSlices(func(x, y string) bool { return x < y })); diff != "" {
 				t.Errorf("%T differ (-got, +want): %s", test.expectConfig, diff)
@@ -392,69 +743,342 @@ func TestBuildConfig(t *testing.T) {
 	}
 }
 
-func nilGetBase(context.Context, string) (name.Reference, Result, error) {
-	return nil, nil, nil
-}
-
-const wantSBOM = "This is our fake SBOM"
-
-// A helper method we use to substitute for the default "build" method.
-func fauxSBOM(context.Context, string, string, oci.SignedEntity) ([]byte, types.MediaType, error) {
-	return []byte(wantSBOM), "application/vnd.garbage", nil
-}
-
-// A helper method we use to substitute for the default "build" method.
-func writeTempFile(_ context.Context, s string, _ string, _ v1.Platform, _ Config) (string, error) {
-	tmpDir, err := ioutil.TempDir("", "ko")
+func TestGitStateTemplates(t *testing.T) {
+	calls := 0
+	ng, err := NewGo(context.Background(), "",
+		WithBaseImages(nilGetBase),
+		WithConfig(map[string]Config{
+			"example.com/stamped": {
+				Ldflags: StringArray{"-X main.version={{.GitTag}}-{{.GitCommitShort}}", "-X main.state={{.GitTreeState}}"},
+			},
+			"example.com/plain": {
+				Ldflags: StringArray{"-X main.home={{.Env.HOME}}"},
+			},
+		}),
+		WithGitState(func() (GitState, error) {
+			calls++
+			return GitState{Tag: "v1.2.3", Commit: "abcdef0123456789", CommitShort: "abcdef0", TreeState: "clean"}, nil
+		}),
+	)
 	if err != nil {
-		return "", err
+		t.Fatalf("NewGo() = %v", err)
 	}
+	gb := ng.(*gobuild)
 
-	file, err := ioutil.TempFile(tmpDir, "out")
+	config, err := gb.configForImportPath("example.com/plain", v1.Platform{})
 	if err != nil {
-		return "", err
+		t.Fatalf("configForImportPath() = %v", err)
 	}
-	defer file.Close()
-	if _, err := file.WriteString(filepath.ToSlash(s)); err != nil {
-		return "", err
+	if calls != 0 {
+		t.Errorf("git state read %d times for ldflags without git templates", calls)
 	}
-	return file.Name(), nil
-}
-
-func TestGoBuildNoKoData(t *testing.T) {
-	baseLayers := int64(3)
-	base, err := random.Image(1024, baseLayers)
-	if err != nil {
-		t.Fatalf("random.Image() = %v", err)
+	if want := (StringArray{"-X main.home=" + os.Getenv("HOME")}); !cmp.Equal(config.Ldflags, want) {
+		t.Errorf("Ldflags = %q, want %q", config.Ldflags, want)
 	}
-	importpath := "github.com/google/ko"
 
-	creationTime := v1.Time{Time: time.Unix(5000, 0)}
-	ng, err := NewGo(
-		context.Background(),
-		"",
-		WithCreationTime(creationTime),
-		WithBaseImages(func(context.Context, string) (name.Reference, Result, error) { return baseRef, base, nil }),
-		withBuilder(writeTempFile),
-		withSBOMber(fauxSBOM),
-	)
-	if err != nil {
-		t.Fatalf("NewGo() = %v", err)
+	for i := 0; i < 2; i++ {
+		config, err := gb.configForImportPath("example.com/stamped", v1.Platform{})
+		if err != nil {
+			t.Fatalf("configForImportPath() = %v", err)
+		}
+		if want := (StringArray{"-X main.version=v1.2.3-abcdef0", "-X main.state=clean"}); !cmp.Equal(config.Ldflags, want) {
+			t.Errorf("Ldflags = %q, want %q", config.Ldflags, want)
+		}
 	}
+	if calls != 1 {
+		t.Errorf("git state read %d times, want once", calls)
+	}
+}
 
-	result, err := ng.Build(context.Background(), StrictScheme+importpath)
-	if err != nil {
-		t.Fatalf("Build() = %v", err)
+func TestValidateTemplates(t *testing.T) {
+	for _, tc := range []struct {
+		config  Config
+		wantErr bool
+	}{{
+		config: Config{Ldflags: StringArray{"-X main.version={{.GitTag}}", "-X main.commit={{.GitCommit}}"}},
+	}, {
+		config: Config{Flags: FlagArray{"-tags={{.Env.HOME}}"}},
+	}, {
+		config:  Config{Ldflags: StringArray{"-X main.version={{.GitVersion}}"}},
+		wantErr: true,
+	}, {
+		config:  Config{Ldflags: StringArray{"-X main.version={{.GitTag"}},
+		wantErr: true,
+	}, {
+		config:  Config{PlatformLdflags: map[string]StringArray{"linux": {"-X main.foo={{.Env.KO_TEST_UNSET_VARIABLE}}"}}},
+		wantErr: true,
+	}} {
+		if err := ValidateTemplates(tc.config); (err != nil) != tc.wantErr {
+			t.Errorf("ValidateTemplates(%+v) = %v, wantErr %v", tc.config, err, tc.wantErr)
+		}
 	}
+}
 
-	img, ok := result.(v1.Image)
-	if !ok {
-		t.Fatalf("Build() not an Image: %T", result)
+func TestCompiler(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+
+	for _, builder := range []string{"", "go"} {
+		got, err := compiler(Config{Builder: builder})
+		if err != nil {
+			t.Errorf("compiler(%q) = %v", builder, err)
+		}
+		if got != "go" {
+			t.Errorf("compiler(%q) = %s, want go", builder, got)
+		}
 	}
 
-	ls, err := img.Layers()
-	if err != nil {
-		t.Fatalf("Layers() = %v", err)
+	// tinygo isn't on our (empty) PATH.
+	if _, err := compiler(Config{Builder: "tinygo"}); err == nil {
+		t.Error("compiler(tinygo) = nil, want error when tinygo is not on PATH")
+	}
+
+	if _, err := compiler(Config{Builder: "gccgo"}); err == nil {
+		t.Error("compiler(gccgo) = nil, want error for unsupported builder")
+	}
+}
+
+func TestCheckRace(t *testing.T) {
+	host := v1.Platform{OS: runtime.GOOS, Architecture: runtime.GOARCH}
+	other := v1.Platform{OS: "linux", Architecture: "arm64"}
+	if host.OS == "linux" && host.Architecture == "arm64" {
+		other = v1.Platform{OS: "linux", Architecture: "amd64"}
+	}
+
+	for _, tc := range []struct {
+		description string
+		platform    v1.Platform
+		env         []string
+		wantErr     string
+	}{{
+		description: "unsupported platform",
+		platform:    v1.Platform{OS: "linux", Architecture: "riscv64"},
+		env:         []string{"CGO_ENABLED=1", "CC=riscv64-linux-gnu-gcc"},
+		wantErr:     "does not support linux/riscv64",
+	}, {
+		description: "cgo disabled",
+		platform:    other,
+		env:         []string{"CGO_ENABLED=1", "CC=gcc", "CGO_ENABLED=0"},
+		wantErr:     "requires CGO_ENABLED=1",
+	}, {
+		description: "supported platform",
+		platform:    other,
+		env:         []string{"CGO_ENABLED=1"},
+	}} {
+		t.Run(tc.description, func(t *testing.T) {
+			err := checkRace(tc.platform, tc.env)
+			switch {
+			case tc.wantErr == "" && err != nil:
+				t.Errorf("checkRace() = %v", err)
+			case tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)):
+				t.Errorf("checkRace() = %v, want error containing %q", err, tc.wantErr)
+			}
+		})
+	}
+
+}
+
+func TestCheckCgo(t *testing.T) {
+	host := v1.Platform{OS: runtime.GOOS, Architecture: runtime.GOARCH}
+	other := v1.Platform{OS: "linux", Architecture: "arm64"}
+	if host.OS == "linux" && host.Architecture == "arm64" {
+		other = v1.Platform{OS: "linux", Architecture: "amd64"}
+	}
+
+	for _, tc := range []struct {
+		description string
+		platform    v1.Platform
+		env         []string
+		wantErr     bool
+	}{{
+		description: "cgo disabled",
+		platform:    other,
+		env:         []string{"CGO_ENABLED=0"},
+	}, {
+		description: "native build",
+		platform:    host,
+		env:         []string{"CGO_ENABLED=1"},
+	}, {
+		description: "cross-compiling without CC",
+		platform:    other,
+		env:         []string{"CGO_ENABLED=1"},
+		wantErr:     true,
+	}, {
+		description: "cross-compiling with CC",
+		platform:    other,
+		env:         []string{"CGO_ENABLED=1", "CC=cross-gcc"},
+	}} {
+		t.Run(tc.description, func(t *testing.T) {
+			err := checkCgo(tc.platform, tc.env)
+			if tc.wantErr && (err == nil || !strings.Contains(err.Error(), "needs a C cross-compiler")) {
+				t.Errorf("checkCgo() = %v, want error about a C cross-compiler", err)
+			} else if !tc.wantErr && err != nil {
+				t.Errorf("checkCgo() = %v", err)
+			}
+		})
+	}
+}
+
+func TestCreateBuildArgsBuildmode(t *testing.T) {
+	for _, tc := range []struct {
+		config  Config
+		want    []string
+		wantErr bool
+	}{{
+		config: Config{},
+		want:   nil,
+	}, {
+		config: Config{Buildmode: "pie", Flags: FlagArray{"-v"}},
+		want:   []string{"-buildmode=pie", "-v"},
+	}, {
+		config:  Config{Buildmode: "plugin"},
+		wantErr: true,
+	}, {
+		config:  Config{Buildmode: "pie", Builder: "tinygo"},
+		wantErr: true,
+	}} {
+		got, err := createBuildArgs(tc.config)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("createBuildArgs(%+v) = %v, wanted error", tc.config, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("createBuildArgs(%+v) = %v", tc.config, err)
+		} else if !cmp.Equal(got, tc.want, cmpopts.EquateEmpty()) {
+			t.Errorf("createBuildArgs(%+v) = %v, want %v", tc.config, got, tc.want)
+		}
+	}
+}
+
+func TestBuildPIE(t *testing.T) {
+	if runtime.GOOS != "linux" || runtime.GOARCH != "amd64" {
+		t.Skip("PIE builds are only checked on linux/amd64")
+	}
+	platform := v1.Platform{OS: "linux", Architecture: "amd64"}
+	file, err := build(context.Background(), "github.com/google/ko/test", ".", platform, Config{Buildmode: "pie"})
+	if err != nil {
+		t.Fatalf("build() = %v", err)
+	}
+	defer os.RemoveAll(filepath.Dir(file))
+
+	f, err := elf.Open(file)
+	if err != nil {
+		t.Fatalf("elf.Open() = %v", err)
+	}
+	defer f.Close()
+	if f.Type != elf.ET_DYN {
+		t.Errorf("ELF type = %v, want %v", f.Type, elf.ET_DYN)
+	}
+}
+
+func nilGetBase(context.Context, string) (name.Reference, Result, error) {
+	return nil, nil, nil
+}
+
+const wantSBOM = "This is our fake SBOM"
+
+// A helper method we use to substitute for the default "build" method.
+func fauxSBOM(context.Context, string, string, oci.SignedEntity) ([]byte, types.MediaType, error) {
+	return []byte(wantSBOM), "application/vnd.garbage", nil
+}
+
+// A helper method we use to substitute for the default "build" method.
+func writeTempFile(_ context.Context, s string, _ string, _ v1.Platform, _ Config) (string, error) {
+	tmpDir, err := ioutil.TempDir("", "ko")
+	if err != nil {
+		return "", err
+	}
+
+	file, err := ioutil.TempFile(tmpDir, "out")
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	if _, err := file.WriteString(filepath.ToSlash(s)); err != nil {
+		return "", err
+	}
+	return file.Name(), nil
+}
+
+func TestGoBuildTinygoSBOM(t *testing.T) {
+	base, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	importpath := "github.com/google/ko"
+
+	ng, err := NewGo(
+		context.Background(),
+		"",
+		WithBaseImages(func(context.Context, string) (name.Reference, Result, error) { return baseRef, base, nil }),
+		WithConfig(map[string]Config{importpath: {Builder: "tinygo"}}),
+		withBuilder(writeTempFile),
+		// Like go version -m, fail for binaries without Go build info.
+		withSBOMber(func(context.Context, string, string, oci.SignedEntity) ([]byte, types.MediaType, error) {
+			return nil, "", errors.New("could not read Go build info")
+		}),
+	)
+	if err != nil {
+		t.Fatalf("NewGo() = %v", err)
+	}
+	result, err := ng.Build(context.Background(), StrictScheme+importpath)
+	if err != nil {
+		t.Fatalf("Build() = %v", err)
+	}
+	if _, err := result.(oci.SignedImage).Attachment("sbom"); err == nil {
+		t.Error("Attachment(sbom) = nil, wanted no SBOM for a tinygo build")
+	}
+}
+
+func TestBuildConfigTinygoRace(t *testing.T) {
+	i, err := NewGo(context.Background(), "",
+		WithBaseImages(nilGetBase),
+		WithConfig(map[string]Config{"example.com/tiny": {Builder: "tinygo"}}),
+		WithRace(),
+	)
+	if err != nil {
+		t.Fatalf("NewGo() = %v", err)
+	}
+	if _, err := i.(*gobuild).configForImportPath("example.com/tiny", v1.Platform{}); err == nil {
+		t.Error("configForImportPath() with the race detector and tinygo = nil, wanted error")
+	}
+}
+
+func TestGoBuildNoKoData(t *testing.T) {
+	baseLayers := int64(3)
+	base, err := random.Image(1024, baseLayers)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	importpath := "github.com/google/ko"
+
+	creationTime := v1.Time{Time: time.Unix(5000, 0)}
+	ng, err := NewGo(
+		context.Background(),
+		"",
+		WithCreationTime(creationTime),
+		WithBaseImages(func(context.Context, string) (name.Reference, Result, error) { return baseRef, base, nil }),
+		withBuilder(writeTempFile),
+		withSBOMber(fauxSBOM),
+	)
+	if err != nil {
+		t.Fatalf("NewGo() = %v", err)
+	}
+
+	result, err := ng.Build(context.Background(), StrictScheme+importpath)
+	if err != nil {
+		t.Fatalf("Build() = %v", err)
+	}
+
+	img, ok := result.(v1.Image)
+	if !ok {
+		t.Fatalf("Build() not an Image: %T", result)
+	}
+
+	ls, err := img.Layers()
+	if err != nil {
+		t.Fatalf("Layers() = %v", err)
 	}
 
 	// Check that we have the expected number of layers.
@@ -774,149 +1398,1830 @@ func TestGoBuild(t *testing.T) {
 	})
 }
 
-func TestGoBuildWithKOCACHE(t *testing.T) {
-	now := time.Now() // current local time
-	sec := now.Unix()
-	tmpDir := t.TempDir()
-	koCacheDir := filepath.Join(tmpDir, strconv.FormatInt(sec, 10))
-
-	t.Setenv("KOCACHE", koCacheDir)
-	baseLayers := int64(3)
-	base, err := random.Image(1024, baseLayers)
+func TestGoBuildMergesBaseLabels(t *testing.T) {
+	base, err := random.Image(1024, 1)
 	if err != nil {
 		t.Fatalf("random.Image() = %v", err)
 	}
-	importpath := "github.com/google/ko"
+	base, err = mutate.Config(base, v1.Config{
+		Labels: map[string]string{
+			"base":  "label",
+			"hello": "base",
+		},
+	})
+	if err != nil {
+		t.Fatalf("mutate.Config() = %v", err)
+	}
 
-	creationTime := v1.Time{Time: time.Unix(5000, 0)}
 	ng, err := NewGo(
 		context.Background(),
 		"",
-		WithCreationTime(creationTime),
 		WithBaseImages(func(context.Context, string) (name.Reference, Result, error) { return baseRef, base, nil }),
+		withBuilder(writeTempFile),
+		withSBOMber(fauxSBOM),
+		WithLabel("foo", "bar"),
+		WithLabel("hello", "world"),
+		WithLabel("org.opencontainers.image.revision", "deadbeef"),
 	)
 	if err != nil {
 		t.Fatalf("NewGo() = %v", err)
 	}
 
-	_, err = ng.Build(context.Background(), StrictScheme+filepath.Join(importpath, "test"))
+	result, err := ng.Build(context.Background(), StrictScheme+"github.com/google/ko/test")
 	if err != nil {
 		t.Fatalf("Build() = %v", err)
 	}
+	img, ok := result.(v1.Image)
+	if !ok {
+		t.Fatalf("Build() not an Image: %T", result)
+	}
+	cfg, err := img.ConfigFile()
+	if err != nil {
+		t.Fatalf("ConfigFile() = %v", err)
+	}
 
-	t.Run("check KOCACHE exists", func(t *testing.T) {
-		_, err := os.Stat(koCacheDir)
-		if os.IsNotExist(err) {
-			t.Fatalf("KOCACHE directory %s should be exists= %v", koCacheDir, err)
-		}
-	})
+	want := map[string]string{
+		"base":                              "label",
+		"foo":                               "bar",
+		"hello":                             "world",
+		"org.opencontainers.image.revision": "deadbeef",
+	}
+	if d := cmp.Diff(cfg.Config.Labels, want); d != "" {
+		t.Errorf("Labels diff (-got,+want): %s", d)
+	}
+
+	// The base image's config must not be modified.
+	bcfg, err := base.ConfigFile()
+	if err != nil {
+		t.Fatalf("ConfigFile() = %v", err)
+	}
+	if got := bcfg.Config.Labels["hello"]; got != "base" {
+		t.Errorf("base label hello = %q, want %q", got, "base")
+	}
 }
 
-func TestGoBuildWithoutSBOM(t *testing.T) {
+func TestGoBuildMultipleBinaries(t *testing.T) {
 	baseLayers := int64(3)
 	base, err := random.Image(1024, baseLayers)
 	if err != nil {
 		t.Fatalf("random.Image() = %v", err)
 	}
-	importpath := "github.com/google/ko"
+	importpath := "github.com/google/ko/test"
 
-	creationTime := v1.Time{Time: time.Unix(5000, 0)}
 	ng, err := NewGo(
 		context.Background(),
 		"",
-		WithCreationTime(creationTime),
 		WithBaseImages(func(context.Context, string) (name.Reference, Result, error) { return baseRef, base, nil }),
 		withBuilder(writeTempFile),
 		withSBOMber(fauxSBOM),
-		WithLabel("foo", "bar"),
-		WithLabel("hello", "world"),
-		WithDisabledSBOM(),
+		WithConfig(map[string]Config{
+			importpath: {
+				Binaries:   []string{"github.com/google/ko/cmd/help", "github.com/google/ko"},
+				Entrypoint: "help",
+			},
+		}),
 	)
 	if err != nil {
 		t.Fatalf("NewGo() = %v", err)
 	}
 
-	result, err := ng.Build(context.Background(), StrictScheme+filepath.Join(importpath, "test"))
+	result, err := ng.Build(context.Background(), StrictScheme+importpath)
 	if err != nil {
 		t.Fatalf("Build() = %v", err)
 	}
-
-	img, ok := result.(oci.SignedImage)
+	img, ok := result.(v1.Image)
 	if !ok {
-		t.Fatalf("Build() not a SignedImage: %T", result)
+		t.Fatalf("Build() not an Image: %T", result)
 	}
 
-	validateImage(t, img, baseLayers, creationTime, true, false)
+	t.Run("check app layer contents", func(t *testing.T) {
+		ls, err := img.Layers()
+		if err != nil {
+			t.Fatalf("Layers() = %v", err)
+		}
+		if got, want := int64(len(ls)), baseLayers+2; got != want {
+			t.Fatalf("len(Layers()) = %v, want %v", got, want)
+		}
+		r, err := ls[baseLayers+1].Uncompressed()
+		if err != nil {
+			t.Fatalf("Uncompressed() = %v", err)
+		}
+		defer r.Close()
+		var got []string
+		tr := tar.NewReader(r)
+		for {
+			header, err := tr.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			} else if err != nil {
+				t.Fatalf("Next() = %v", err)
+			}
+			if header.Typeflag == tar.TypeReg {
+				got = append(got, header.Name)
+			}
+		}
+		want := []string{"/ko-app/test", "/ko-app/help", "/ko-app/ko"}
+		if d := cmp.Diff(got, want); d != "" {
+			t.Errorf("binaries diff (-got,+want): %s", d)
+		}
+	})
+
+	t.Run("check entrypoint", func(t *testing.T) {
+		cfg, err := img.ConfigFile()
+		if err != nil {
+			t.Fatalf("ConfigFile() = %v", err)
+		}
+		if d := cmp.Diff(cfg.Config.Entrypoint, []string{"/ko-app/help"}); d != "" {
+			t.Errorf("entrypoint diff (-got,+want): %s", d)
+		}
+	})
+
+	t.Run("check unknown entrypoint", func(t *testing.T) {
+		ng, err := NewGo(
+			context.Background(),
+			"",
+			WithBaseImages(func(context.Context, string) (name.Reference, Result, error) { return baseRef, base, nil }),
+			withBuilder(writeTempFile),
+			WithConfig(map[string]Config{
+				importpath: {
+					Binaries:   []string{"github.com/google/ko/cmd/help"},
+					Entrypoint: "nope",
+				},
+			}),
+		)
+		if err != nil {
+			t.Fatalf("NewGo() = %v", err)
+		}
+		if _, err := ng.Build(context.Background(), StrictScheme+importpath); err == nil {
+			t.Error("Build() = nil, want error for unknown entrypoint")
+		}
+	})
 }
 
-func TestGoBuildIndex(t *testing.T) {
-	baseLayers := int64(3)
-	images := int64(2)
-	base, err := random.Index(1024, baseLayers, images)
+func TestGoBuildImageEnv(t *testing.T) {
+	base, err := random.Image(1024, 1)
 	if err != nil {
 		t.Fatalf("random.Image() = %v", err)
 	}
-	importpath := "github.com/google/ko"
+	base, err = mutate.Config(base, v1.Config{
+		Env: []string{"PATH=/usr/bin", "APP_MODE=base", "TZ=UTC"},
+	})
+	if err != nil {
+		t.Fatalf("mutate.Config() = %v", err)
+	}
+	importpath := "github.com/google/ko/test"
 
-	creationTime := v1.Time{Time: time.Unix(5000, 0)}
 	ng, err := NewGo(
 		context.Background(),
 		"",
-		WithCreationTime(creationTime),
 		WithBaseImages(func(context.Context, string) (name.Reference, Result, error) { return baseRef, base, nil }),
-		WithPlatforms("all"),
 		withBuilder(writeTempFile),
 		withSBOMber(fauxSBOM),
+		WithConfig(map[string]Config{importpath: {
+			ImageEnv: []string{"APP_MODE=production", "GREETING=hello=world"},
+		}}),
 	)
 	if err != nil {
 		t.Fatalf("NewGo() = %v", err)
 	}
-
-	result, err := ng.Build(context.Background(), StrictScheme+filepath.Join(importpath, "test"))
+	result, err := ng.Build(context.Background(), StrictScheme+importpath)
 	if err != nil {
 		t.Fatalf("Build() = %v", err)
 	}
-
-	idx, ok := result.(oci.SignedImageIndex)
+	img, ok := result.(v1.Image)
 	if !ok {
-		t.Fatalf("Build() not a SignedImageIndex: %T", result)
+		t.Fatalf("Build() not an Image: %T", result)
+	}
+	cfg, err := img.ConfigFile()
+	if err != nil {
+		t.Fatalf("ConfigFile() = %v", err)
+	}
+
+	// The base image's variables come first, then KO_DATA_PATH, then ours,
+	// which replace any of the same name.
+	want := []string{
+		"PATH=/usr/bin:/ko-app",
+		"TZ=UTC",
+		"KO_DATA_PATH=/var/run/ko",
+		"APP_MODE=production",
+		"GREETING=hello=world",
+	}
+	if d := cmp.Diff(want, cfg.Config.Env); d != "" {
+		t.Errorf("Env diff (-want,+got): %s", d)
+	}
+}
+
+func TestGoBuildDefaultArgs(t *testing.T) {
+	base, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	base, err = mutate.Config(base, v1.Config{
+		Cmd: []string{"/bin/sh"},
+	})
+	if err != nil {
+		t.Fatalf("mutate.Config() = %v", err)
+	}
+	importpath := "github.com/google/ko/test"
+
+	for _, tc := range []struct {
+		description string
+		args        []string
+		want        []string
+	}{{
+		description: "no default args",
+	}, {
+		description: "default args",
+		args:        []string{"--serve", "--port=8080"},
+		want:        []string{"--serve", "--port=8080"},
+	}} {
+		t.Run(tc.description, func(t *testing.T) {
+			ng, err := NewGo(
+				context.Background(),
+				"",
+				WithBaseImages(func(context.Context, string) (name.Reference, Result, error) { return baseRef, base, nil }),
+				withBuilder(writeTempFile),
+				withSBOMber(fauxSBOM),
+				WithConfig(map[string]Config{importpath: {DefaultArgs: tc.args}}),
+			)
+			if err != nil {
+				t.Fatalf("NewGo() = %v", err)
+			}
+			result, err := ng.Build(context.Background(), StrictScheme+importpath)
+			if err != nil {
+				t.Fatalf("Build() = %v", err)
+			}
+			img, ok := result.(v1.Image)
+			if !ok {
+				t.Fatalf("Build() not an Image: %T", result)
+			}
+			cfg, err := img.ConfigFile()
+			if err != nil {
+				t.Fatalf("ConfigFile() = %v", err)
+			}
+			if got, want := cfg.Config.Entrypoint, []string{"/ko-app/test"}; !cmp.Equal(got, want) {
+				t.Errorf("Entrypoint = %v, want %v", got, want)
+			}
+			if !cmp.Equal(cfg.Config.Cmd, tc.want, cmpopts.EquateEmpty()) {
+				t.Errorf("Cmd = %v, want %v", cfg.Config.Cmd, tc.want)
+			}
+		})
+	}
+}
+
+func TestGoBuildKeepBaseEntrypoint(t *testing.T) {
+	plain, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	shim, err := mutate.Config(plain, v1.Config{
+		Entrypoint: []string{"/sbin/tini", "--"},
+		Cmd:        []string{"/bin/sh"},
+	})
+	if err != nil {
+		t.Fatalf("mutate.Config() = %v", err)
+	}
+	importpath := "github.com/google/ko/test"
+
+	for _, tc := range []struct {
+		description    string
+		base           v1.Image
+		args           []string
+		wantEntrypoint []string
+		wantCmd        []string
+		wantErr        bool
+	}{{
+		description:    "binary is passed to the base entrypoint",
+		base:           shim,
+		wantEntrypoint: []string{"/sbin/tini", "--"},
+		wantCmd:        []string{"/ko-app/test"},
+	}, {
+		description:    "default args follow the binary",
+		base:           shim,
+		args:           []string{"--serve"},
+		wantEntrypoint: []string{"/sbin/tini", "--"},
+		wantCmd:        []string{"/ko-app/test", "--serve"},
+	}, {
+		description: "base without entrypoint",
+		base:        plain,
+		wantErr:     true,
+	}} {
+		t.Run(tc.description, func(t *testing.T) {
+			ng, err := NewGo(
+				context.Background(),
+				"",
+				WithBaseImages(func(context.Context, string) (name.Reference, Result, error) { return baseRef, tc.base, nil }),
+				withBuilder(writeTempFile),
+				withSBOMber(fauxSBOM),
+				WithConfig(map[string]Config{importpath: {DefaultArgs: tc.args}}),
+				WithKeepBaseEntrypoint(true),
+			)
+			if err != nil {
+				t.Fatalf("NewGo() = %v", err)
+			}
+			result, err := ng.Build(context.Background(), StrictScheme+importpath)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("Build() = nil, wanted an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Build() = %v", err)
+			}
+			img, ok := result.(v1.Image)
+			if !ok {
+				t.Fatalf("Build() not an Image: %T", result)
+			}
+			cfg, err := img.ConfigFile()
+			if err != nil {
+				t.Fatalf("ConfigFile() = %v", err)
+			}
+			if got := cfg.Config.Entrypoint; !cmp.Equal(got, tc.wantEntrypoint) {
+				t.Errorf("Entrypoint = %v, want %v", got, tc.wantEntrypoint)
+			}
+			if got := cfg.Config.Cmd; !cmp.Equal(got, tc.wantCmd) {
+				t.Errorf("Cmd = %v, want %v", got, tc.wantCmd)
+			}
+		})
+	}
+}
+
+func TestGoBuildWithUser(t *testing.T) {
+	base, err := random.Image(1024, 3)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	importpath := "github.com/google/ko"
+
+	for _, user := range []string{"65532", "65532:65532", "nonroot", "nonroot:nonroot"} {
+		t.Run(user, func(t *testing.T) {
+			ng, err := NewGo(
+				context.Background(),
+				"",
+				WithBaseImages(func(context.Context, string) (name.Reference, Result, error) { return baseRef, base, nil }),
+				withBuilder(writeTempFile),
+				withSBOMber(fauxSBOM),
+				WithUser(user),
+			)
+			if err != nil {
+				t.Fatalf("NewGo() = %v", err)
+			}
+			result, err := ng.Build(context.Background(), StrictScheme+filepath.Join(importpath, "test"))
+			if err != nil {
+				t.Fatalf("Build() = %v", err)
+			}
+			img, ok := result.(v1.Image)
+			if !ok {
+				t.Fatalf("Build() not an Image: %T", result)
+			}
+			cfg, err := img.ConfigFile()
+			if err != nil {
+				t.Fatalf("ConfigFile() = %v", err)
+			}
+			if got := cfg.Config.User; got != user {
+				t.Errorf("User = %q, want %q", got, user)
+			}
+		})
+	}
+
+	for _, user := range []string{"1:2:3", ":65532", "65532:", "non root"} {
+		if _, err := NewGo(context.Background(), "", WithUser(user)); err == nil {
+			t.Errorf("NewGo() = nil, want error for user %q", user)
+		}
+	}
+}
+
+func TestGoBuildBinaryPath(t *testing.T) {
+	baseLayers := int64(3)
+	base, err := random.Image(1024, baseLayers)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	importpath := "github.com/google/ko/test"
+
+	newGo := func(config Config) Interface {
+		ng, err := NewGo(
+			context.Background(),
+			"",
+			WithBaseImages(func(context.Context, string) (name.Reference, Result, error) { return baseRef, base, nil }),
+			withBuilder(writeTempFile),
+			withSBOMber(fauxSBOM),
+			WithConfig(map[string]Config{importpath: config}),
+		)
+		if err != nil {
+			t.Fatalf("NewGo() = %v", err)
+		}
+		return ng
+	}
+
+	result, err := newGo(Config{BinaryPath: "/usr/local/bin/app"}).Build(context.Background(), StrictScheme+importpath)
+	if err != nil {
+		t.Fatalf("Build() = %v", err)
+	}
+	img, ok := result.(v1.Image)
+	if !ok {
+		t.Fatalf("Build() not an Image: %T", result)
+	}
+
+	t.Run("check binary layer contents", func(t *testing.T) {
+		ls, err := img.Layers()
+		if err != nil {
+			t.Fatalf("Layers() = %v", err)
+		}
+		r, err := ls[baseLayers+1].Uncompressed()
+		if err != nil {
+			t.Fatalf("Uncompressed() = %v", err)
+		}
+		defer r.Close()
+		var got []string
+		tr := tar.NewReader(r)
+		for {
+			header, err := tr.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			} else if err != nil {
+				t.Fatalf("Next() = %v", err)
+			}
+			got = append(got, header.Name)
+		}
+		want := []string{"usr", "usr/local", "usr/local/bin", "/usr/local/bin/app"}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("layer contents (-want +got) = %s", diff)
+		}
+	})
+
+	t.Run("check entrypoint and PATH", func(t *testing.T) {
+		cfg, err := img.ConfigFile()
+		if err != nil {
+			t.Fatalf("ConfigFile() = %v", err)
+		}
+		if diff := cmp.Diff([]string{"/usr/local/bin/app"}, cfg.Config.Entrypoint); diff != "" {
+			t.Errorf("Entrypoint (-want +got) = %s", diff)
+		}
+		found := false
+		for _, env := range cfg.Config.Env {
+			if strings.HasPrefix(env, "PATH=") && strings.HasSuffix(env, "/usr/local/bin") {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("Env = %v, want PATH to include /usr/local/bin", cfg.Config.Env)
+		}
+	})
+
+	t.Run("relative binaryPath", func(t *testing.T) {
+		if _, err := newGo(Config{BinaryPath: "bin/app"}).Build(context.Background(), StrictScheme+importpath); err == nil {
+			t.Error("Build() = nil, want error for relative binaryPath")
+		}
+	})
+}
+
+func TestGoBuildDataPath(t *testing.T) {
+	baseLayers := int64(3)
+	base, err := random.Image(1024, baseLayers)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	importpath := "github.com/google/ko/test"
+
+	newGo := func(config Config) Interface {
+		ng, err := NewGo(
+			context.Background(),
+			"",
+			WithBaseImages(func(context.Context, string) (name.Reference, Result, error) { return baseRef, base, nil }),
+			withBuilder(writeTempFile),
+			withSBOMber(fauxSBOM),
+			WithConfig(map[string]Config{importpath: config}),
+		)
+		if err != nil {
+			t.Fatalf("NewGo() = %v", err)
+		}
+		return ng
+	}
+
+	result, err := newGo(Config{DataPath: "/var/run/app-data"}).Build(context.Background(), StrictScheme+importpath)
+	if err != nil {
+		t.Fatalf("Build() = %v", err)
+	}
+	img, ok := result.(v1.Image)
+	if !ok {
+		t.Fatalf("Build() not an Image: %T", result)
+	}
+
+	t.Run("check kodata layer contents", func(t *testing.T) {
+		ls, err := img.Layers()
+		if err != nil {
+			t.Fatalf("Layers() = %v", err)
+		}
+		r, err := ls[baseLayers].Uncompressed()
+		if err != nil {
+			t.Fatalf("Uncompressed() = %v", err)
+		}
+		defer r.Close()
+		got := map[string]bool{}
+		tr := tar.NewReader(r)
+		for {
+			header, err := tr.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			} else if err != nil {
+				t.Fatalf("Next() = %v", err)
+			}
+			got[header.Name] = true
+		}
+		for _, want := range []string{"/var", "/var/run", "/var/run/app-data", "/var/run/app-data/kenobi"} {
+			if !got[want] {
+				t.Errorf("kodata layer missing %q, got %v", want, got)
+			}
+		}
+	})
+
+	t.Run("check KO_DATA_PATH env var", func(t *testing.T) {
+		cfg, err := img.ConfigFile()
+		if err != nil {
+			t.Fatalf("ConfigFile() = %v", err)
+		}
+		found := false
+		for _, entry := range cfg.Config.Env {
+			if entry == "KO_DATA_PATH=/var/run/app-data" {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("Env = %v, want KO_DATA_PATH=/var/run/app-data", cfg.Config.Env)
+		}
+	})
+
+	t.Run("relative dataPath", func(t *testing.T) {
+		if _, err := newGo(Config{DataPath: "app-data"}).Build(context.Background(), StrictScheme+importpath); err == nil {
+			t.Error("Build() = nil, want error for relative dataPath")
+		}
+	})
+}
+
+func TestGoBuildLayerCompression(t *testing.T) {
+	baseLayers := int64(3)
+	base, err := random.Image(1024, baseLayers)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	importpath := "github.com/google/ko"
+
+	buildImage := func(t *testing.T, opts ...Option) v1.Image {
+		opts = append([]Option{
+			WithBaseImages(func(context.Context, string) (name.Reference, Result, error) { return baseRef, base, nil }),
+			withBuilder(writeTempFile),
+			withSBOMber(fauxSBOM),
+		}, opts...)
+		ng, err := NewGo(context.Background(), "", opts...)
+		if err != nil {
+			t.Fatalf("NewGo() = %v", err)
+		}
+		result, err := ng.Build(context.Background(), StrictScheme+filepath.Join(importpath, "test"))
+		if err != nil {
+			t.Fatalf("Build() = %v", err)
+		}
+		img, ok := result.(v1.Image)
+		if !ok {
+			t.Fatalf("Build() not an Image: %T", result)
+		}
+		return img
+	}
+
+	for _, tc := range []struct {
+		algorithm string
+		want      types.MediaType
+	}{
+		{algorithm: "", want: types.DockerLayer},
+		{algorithm: GzipCompression, want: types.DockerLayer},
+		{algorithm: ZstdCompression, want: OCILayerZstd},
+	} {
+		t.Run(tc.algorithm, func(t *testing.T) {
+			img := buildImage(t, WithLayerCompression(tc.algorithm))
+			ls, err := img.Layers()
+			if err != nil {
+				t.Fatalf("Layers() = %v", err)
+			}
+			for _, l := range ls[baseLayers:] {
+				mt, err := l.MediaType()
+				if err != nil {
+					t.Fatalf("MediaType() = %v", err)
+				}
+				if mt != tc.want {
+					t.Errorf("MediaType() = %v, want %v", mt, tc.want)
+				}
+			}
+
+			// The layers must be reproducible so that they can be cached.
+			d1, err := img.Digest()
+			if err != nil {
+				t.Fatalf("Digest() = %v", err)
+			}
+			d2, err := buildImage(t, WithLayerCompression(tc.algorithm)).Digest()
+			if err != nil {
+				t.Fatalf("Digest() = %v", err)
+			}
+			if d1 != d2 {
+				t.Errorf("Digest() = %v, then %v; want stable digest", d1, d2)
+			}
+		})
+	}
+
+	t.Run("zstd layers round trip", func(t *testing.T) {
+		ls, err := buildImage(t, WithLayerCompression(ZstdCompression)).Layers()
+		if err != nil {
+			t.Fatalf("Layers() = %v", err)
+		}
+		l := ls[baseLayers+1]
+		rc, err := l.Compressed()
+		if err != nil {
+			t.Fatalf("Compressed() = %v", err)
+		}
+		defer rc.Close()
+		dec, err := zstd.NewReader(rc)
+		if err != nil {
+			t.Fatalf("zstd.NewReader() = %v", err)
+		}
+		defer dec.Close()
+		got, _, err := v1.SHA256(dec)
+		if err != nil {
+			t.Fatalf("SHA256() = %v", err)
+		}
+		want, err := l.DiffID()
+		if err != nil {
+			t.Fatalf("DiffID() = %v", err)
+		}
+		if got != want {
+			t.Errorf("decompressed digest = %v, want DiffID() %v", got, want)
+		}
+	})
+
+	t.Run("unsupported algorithm", func(t *testing.T) {
+		if _, err := NewGo(context.Background(), "", WithLayerCompression("lz4")); err == nil {
+			t.Error("NewGo() = nil, want error for unsupported compression")
+		}
+	})
+
+	t.Run("compression level", func(t *testing.T) {
+		digest := func(opts ...Option) v1.Hash {
+			ls, err := buildImage(t, opts...).Layers()
+			if err != nil {
+				t.Fatalf("Layers() = %v", err)
+			}
+			d, err := ls[baseLayers+1].Digest()
+			if err != nil {
+				t.Fatalf("Digest() = %v", err)
+			}
+			return d
+		}
+		def := digest()
+		if got := digest(WithLayerCompressionLevel(0)); got != def {
+			t.Errorf("level 0 digest = %v, want default %v", got, def)
+		}
+		fast, best := digest(WithLayerCompressionLevel(1)), digest(WithLayerCompressionLevel(9))
+		if fast == best {
+			t.Errorf("levels 1 and 9 both have digest %v, want different digests", fast)
+		}
+		if got := digest(WithLayerCompressionLevel(1)); got != fast {
+			t.Errorf("level 1 digest = %v, then %v; want stable digest", fast, got)
+		}
+
+		for _, level := range []int{-1, 10} {
+			if _, err := NewGo(context.Background(), "", WithLayerCompressionLevel(level)); err == nil {
+				t.Errorf("NewGo() = nil, want error for level %d", level)
+			}
+		}
+	})
+}
+
+func TestKoDataPermissionsAndSymlinks(t *testing.T) {
+	root := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(root, "helper.sh"), []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Join(root, "helper.sh"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(root, "conf"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "conf", "app.yaml"), []byte("a: b\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Join(root, "conf", "app.yaml"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Private files must still be readable by non-root users of the image.
+	if err := ioutil.WriteFile(filepath.Join(root, "conf", "secret.txt"), []byte("s3cr3t\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Join(root, "conf", "secret.txt"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("conf/app.yaml", filepath.Join(root, "config.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	outside := filepath.Join(t.TempDir(), "outside")
+	if err := ioutil.WriteFile(outside, []byte("outside\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "outside")); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	tw := tar.NewWriter(buf)
+	if err := walkRecursive(tw, root, kodataRoot, v1.Time{}, &v1.Platform{OS: "linux", Architecture: "amd64"}, nil); err != nil {
+		t.Fatalf("walkRecursive() = %v", err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	type entry struct {
+		Typeflag byte
+		Mode     int64
+		Linkname string
+	}
+	got := map[string]entry{}
+	tr := tar.NewReader(buf)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			t.Fatalf("Next() = %v", err)
+		}
+		got[header.Name] = entry{Typeflag: header.Typeflag, Mode: header.Mode, Linkname: header.Linkname}
+	}
+	want := map[string]entry{
+		path.Join(kodataRoot, "helper.sh"):       {Typeflag: tar.TypeReg, Mode: 0555},
+		path.Join(kodataRoot, "conf/app.yaml"):   {Typeflag: tar.TypeReg, Mode: 0444},
+		path.Join(kodataRoot, "conf/secret.txt"): {Typeflag: tar.TypeReg, Mode: 0444},
+		path.Join(kodataRoot, "config.yaml"):     {Typeflag: tar.TypeSymlink, Mode: 0777, Linkname: "conf/app.yaml"},
+		// Symlinks that leave kodata are dereferenced.
+		path.Join(kodataRoot, "outside"): {Typeflag: tar.TypeReg, Mode: 0444},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("tar entries (-want +got) = %s", diff)
+	}
+}
+
+func TestGoBuildWithKOCACHE(t *testing.T) {
+	now := time.Now() // current local time
+	sec := now.Unix()
+	tmpDir := t.TempDir()
+	koCacheDir := filepath.Join(tmpDir, strconv.FormatInt(sec, 10))
+
+	t.Setenv("KOCACHE", koCacheDir)
+	baseLayers := int64(3)
+	base, err := random.Image(1024, baseLayers)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	importpath := "github.com/google/ko"
+
+	creationTime := v1.Time{Time: time.Unix(5000, 0)}
+	ng, err := NewGo(
+		context.Background(),
+		"",
+		WithCreationTime(creationTime),
+		WithBaseImages(func(context.Context, string) (name.Reference, Result, error) { return baseRef, base, nil }),
+	)
+	if err != nil {
+		t.Fatalf("NewGo() = %v", err)
+	}
+
+	_, err = ng.Build(context.Background(), StrictScheme+filepath.Join(importpath, "test"))
+	if err != nil {
+		t.Fatalf("Build() = %v", err)
+	}
+
+	t.Run("check KOCACHE exists", func(t *testing.T) {
+		_, err := os.Stat(koCacheDir)
+		if os.IsNotExist(err) {
+			t.Fatalf("KOCACHE directory %s should be exists= %v", koCacheDir, err)
+		}
+	})
+}
+
+func TestGoBuildWithBuildCache(t *testing.T) {
+	base, err := random.Image(1024, 3)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	importpath := "github.com/google/ko"
+	cacheDir := t.TempDir()
+
+	builds := 0
+	countingBuilder := func(ctx context.Context, ip, dir string, platform v1.Platform, config Config) (string, error) {
+		builds++
+		return writeTempFile(ctx, ip, dir, platform, config)
+	}
+
+	buildDigest := func(t *testing.T, config Config) v1.Hash {
+		ng, err := NewGo(
+			context.Background(),
+			"",
+			WithBaseImages(func(context.Context, string) (name.Reference, Result, error) { return baseRef, base, nil }),
+			withBuilder(countingBuilder),
+			withSBOMber(fauxSBOM),
+			WithBuildCacheDir(cacheDir),
+			WithConfig(map[string]Config{importpath + "/test": config}),
+		)
+		if err != nil {
+			t.Fatalf("NewGo() = %v", err)
+		}
+		result, err := ng.Build(context.Background(), StrictScheme+filepath.Join(importpath, "test"))
+		if err != nil {
+			t.Fatalf("Build() = %v", err)
+		}
+		d, err := result.Digest()
+		if err != nil {
+			t.Fatalf("Digest() = %v", err)
+		}
+		return d
+	}
+
+	first := buildDigest(t, Config{})
+	second := buildDigest(t, Config{})
+	if first != second {
+		t.Errorf("Digest() = %v, then %v; want identical digests", first, second)
+	}
+	if builds != 1 {
+		t.Errorf("built %d times, want 1 (second build should read from cache)", builds)
+	}
+
+	// Changing the build flags must miss the cache.
+	buildDigest(t, Config{Ldflags: []string{"-s", "-w"}})
+	if builds != 2 {
+		t.Errorf("built %d times, want 2 (changed ldflags should miss the cache)", builds)
+	}
+}
+
+func TestBuildCacheConcurrentWrites(t *testing.T) {
+	cacheDir := t.TempDir()
+	build := cachingBuilder(cacheDir, writeTempFile)
+
+	// Builds of the same key at once must not write over each other.
+	var wg sync.WaitGroup
+	errs := make([]error, 8)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = build(context.Background(), "github.com/google/ko/test", "", v1.Platform{OS: "linux", Architecture: "amd64"}, Config{})
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Errorf("build() = %v", err)
+		}
+	}
+
+	entries, err := ioutil.ReadDir(cacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || strings.HasSuffix(entries[0].Name(), ".tmp") {
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		t.Errorf("build cache = %v, want just the cached binary", names)
+	}
+}
+
+func TestBuildCacheVCSState(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	if got := vcsState(context.Background(), dir); got != "" {
+		t.Errorf("vcsState() = %q outside a checkout, want empty", got)
+	}
+
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-c", "user.name=ko", "-c", "user.email=ko@example.com"}, args...)...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v = %v: %s", args, err, out)
+		}
+	}
+	git("init", "-q")
+	if err := ioutil.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	git("add", "main.go")
+	git("commit", "-q", "-m", "first")
+	clean := vcsState(context.Background(), dir)
+	if !strings.HasSuffix(clean, " modified=false") {
+		t.Errorf("vcsState() = %q, want a clean checkout", clean)
+	}
+
+	// Committing changes the stamped revision, and so the cache key.
+	git("commit", "-q", "--allow-empty", "-m", "second")
+	if got := vcsState(context.Background(), dir); got == clean {
+		t.Errorf("vcsState() = %q after a commit, want a new revision", got)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "main.go"), []byte("package main // dirty\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := vcsState(context.Background(), dir); !strings.HasSuffix(got, " modified=true") {
+		t.Errorf("vcsState() = %q, want a modified checkout", got)
+	}
+}
+
+func TestGoBuildWorkspace(t *testing.T) {
+	// Workspaces can't be combined with -mod=vendor.
+	t.Setenv("GOFLAGS", "")
+	t.Setenv("GOWORK", "")
+	base, err := random.Image(1024, 3)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+
+	tests := []struct {
+		description string
+		dir         string
+		importpath  string
+		want        string
+	}{{
+		description: "local import path from the workspace root",
+		dir:         "testdata/workspace",
+		importpath:  "./tools/cmd/tool",
+		want:        "ko://example.com/tools/cmd/tool",
+	}, {
+		description: "import path in a sibling module",
+		dir:         "testdata/workspace/app",
+		importpath:  "example.com/tools/cmd/tool",
+		want:        "ko://example.com/tools/cmd/tool",
+	}, {
+		description: "local import path in a sibling module",
+		dir:         "testdata/workspace/app",
+		importpath:  "../tools/cmd/tool",
+		want:        "ko://example.com/tools/cmd/tool",
+	}}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			ng, err := NewGo(
+				context.Background(),
+				test.dir,
+				WithBaseImages(func(context.Context, string) (name.Reference, Result, error) { return baseRef, base, nil }),
+				WithBuildCacheDir(t.TempDir()),
+				WithDisabledSBOM(),
+			)
+			if err != nil {
+				t.Fatalf("NewGo() = %v", err)
+			}
+			got, err := ng.QualifyImport(test.importpath)
+			if err != nil {
+				t.Fatalf("QualifyImport(%q) = %v", test.importpath, err)
+			}
+			if got != test.want {
+				t.Errorf("QualifyImport(%q) = %q, want %q", test.importpath, got, test.want)
+			}
+			if err := ng.IsSupportedReference(got); err != nil {
+				t.Fatalf("IsSupportedReference(%q) = %v", got, err)
+			}
+			if _, err := ng.Build(context.Background(), got); err != nil {
+				t.Fatalf("Build(%q) = %v", got, err)
+			}
+		})
+	}
+}
+
+func TestGoBuildWithoutSBOM(t *testing.T) {
+	baseLayers := int64(3)
+	base, err := random.Image(1024, baseLayers)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	importpath := "github.com/google/ko"
+
+	creationTime := v1.Time{Time: time.Unix(5000, 0)}
+	ng, err := NewGo(
+		context.Background(),
+		"",
+		WithCreationTime(creationTime),
+		WithBaseImages(func(context.Context, string) (name.Reference, Result, error) { return baseRef, base, nil }),
+		withBuilder(writeTempFile),
+		withSBOMber(fauxSBOM),
+		WithLabel("foo", "bar"),
+		WithLabel("hello", "world"),
+		WithDisabledSBOM(),
+	)
+	if err != nil {
+		t.Fatalf("NewGo() = %v", err)
+	}
+
+	result, err := ng.Build(context.Background(), StrictScheme+filepath.Join(importpath, "test"))
+	if err != nil {
+		t.Fatalf("Build() = %v", err)
+	}
+
+	img, ok := result.(oci.SignedImage)
+	if !ok {
+		t.Fatalf("Build() not a SignedImage: %T", result)
+	}
+
+	validateImage(t, img, baseLayers, creationTime, true, false)
+}
+
+func TestGoBuildSourceURL(t *testing.T) {
+	base, err := random.Index(1024, 1, 2)
+	if err != nil {
+		t.Fatalf("random.Index() = %v", err)
+	}
+	const url = "https://github.com/google/ko"
+
+	ng, err := NewGo(
+		context.Background(),
+		"",
+		WithBaseImages(func(context.Context, string) (name.Reference, Result, error) { return baseRef, base, nil }),
+		WithPlatforms("all"),
+		withBuilder(writeTempFile),
+		withSBOMber(fauxSBOM),
+		WithSourceURL(url),
+	)
+	if err != nil {
+		t.Fatalf("NewGo() = %v", err)
+	}
+
+	result, err := ng.Build(context.Background(), StrictScheme+"github.com/google/ko/test")
+	if err != nil {
+		t.Fatalf("Build() = %v", err)
+	}
+	idx, ok := result.(v1.ImageIndex)
+	if !ok {
+		t.Fatalf("Build() not an ImageIndex: %T", result)
+	}
+	im, err := idx.IndexManifest()
+	if err != nil {
+		t.Fatalf("IndexManifest() = %v", err)
+	}
+	if got := im.Annotations[specsv1.AnnotationSource]; got != url {
+		t.Errorf("index annotation %s = %q, want %q", specsv1.AnnotationSource, got, url)
+	}
+	for _, desc := range im.Manifests {
+		img, err := idx.Image(desc.Digest)
+		if err != nil {
+			t.Fatalf("Image(%s) = %v", desc.Digest, err)
+		}
+		cfg, err := img.ConfigFile()
+		if err != nil {
+			t.Fatalf("ConfigFile() = %v", err)
+		}
+		if got := cfg.Config.Labels[specsv1.AnnotationSource]; got != url {
+			t.Errorf("label %s of %s = %q, want %q", specsv1.AnnotationSource, desc.Digest, got, url)
+		}
+	}
+
+	// The base index's annotations must not be modified.
+	bm, err := base.IndexManifest()
+	if err != nil {
+		t.Fatalf("IndexManifest() = %v", err)
+	}
+	if _, ok := bm.Annotations[specsv1.AnnotationSource]; ok {
+		t.Errorf("base index was annotated with %s", specsv1.AnnotationSource)
+	}
+}
+
+func TestGoBuildIndex(t *testing.T) {
+	baseLayers := int64(3)
+	images := int64(2)
+	base, err := random.Index(1024, baseLayers, images)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	importpath := "github.com/google/ko"
+
+	creationTime := v1.Time{Time: time.Unix(5000, 0)}
+	ng, err := NewGo(
+		context.Background(),
+		"",
+		WithCreationTime(creationTime),
+		WithBaseImages(func(context.Context, string) (name.Reference, Result, error) { return baseRef, base, nil }),
+		WithPlatforms("all"),
+		withBuilder(writeTempFile),
+		withSBOMber(fauxSBOM),
+	)
+	if err != nil {
+		t.Fatalf("NewGo() = %v", err)
+	}
+
+	result, err := ng.Build(context.Background(), StrictScheme+filepath.Join(importpath, "test"))
+	if err != nil {
+		t.Fatalf("Build() = %v", err)
+	}
+
+	idx, ok := result.(oci.SignedImageIndex)
+	if !ok {
+		t.Fatalf("Build() not a SignedImageIndex: %T", result)
+	}
+
+	im, err := idx.IndexManifest()
+	if err != nil {
+		t.Fatalf("IndexManifest() = %v", err)
+	}
+
+	for _, desc := range im.Manifests {
+		img, err := idx.SignedImage(desc.Digest)
+		if err != nil {
+			t.Fatalf("idx.Image(%s) = %v", desc.Digest, err)
+		}
+		validateImage(t, img, baseLayers, creationTime, false, true)
+	}
+
+	if want, got := images, int64(len(im.Manifests)); want != got {
+		t.Fatalf("len(Manifests()) = %v, want %v", got, want)
+	}
+
+	// Check that rebuilding the image again results in the same image digest.
+	t.Run("check determinism", func(t *testing.T) {
+		result2, err := ng.Build(context.Background(), StrictScheme+filepath.Join(importpath, "test"))
+		if err != nil {
+			t.Fatalf("Build() = %v", err)
+		}
+
+		d1, err := result.Digest()
+		if err != nil {
+			t.Fatalf("Digest() = %v", err)
+		}
+		d2, err := result2.Digest()
+		if err != nil {
+			t.Fatalf("Digest() = %v", err)
+		}
+
+		if d1 != d2 {
+			t.Errorf("Digest mismatch: %s != %s", d1, d2)
+		}
+	})
+}
+
+func TestGoBuildIndexConcurrency(t *testing.T) {
+	var adds []mutate.IndexAddendum
+	for _, arch := range []string{"amd64", "arm64", "ppc64le", "s390x"} {
+		img, err := random.Image(1024, 1)
+		if err != nil {
+			t.Fatalf("random.Image() = %v", err)
+		}
+		adds = append(adds, mutate.IndexAddendum{
+			Add: img,
+			Descriptor: v1.Descriptor{
+				Platform: &v1.Platform{OS: "linux", Architecture: arch},
+			},
+		})
+	}
+	base := mutate.AppendManifests(empty.Index, adds...)
+	importpath := "github.com/google/ko/test"
+
+	newGo := func(jobs int, b builder) Interface {
+		ng, err := NewGo(
+			context.Background(),
+			"",
+			WithBaseImages(func(context.Context, string) (name.Reference, Result, error) { return baseRef, base, nil }),
+			WithPlatforms("all"),
+			WithJobs(jobs),
+			withBuilder(b),
+			withSBOMber(fauxSBOM),
+		)
+		if err != nil {
+			t.Fatalf("NewGo() = %v", err)
+		}
+		return ng
+	}
+
+	t.Run("bounded by jobs", func(t *testing.T) {
+		var mu sync.Mutex
+		running, peak := 0, 0
+		b := func(ctx context.Context, ip string, dir string, platform v1.Platform, config Config) (string, error) {
+			mu.Lock()
+			running++
+			if running > peak {
+				peak = running
+			}
+			mu.Unlock()
+			time.Sleep(50 * time.Millisecond)
+			mu.Lock()
+			running--
+			mu.Unlock()
+			return writeTempFile(ctx, ip, dir, platform, config)
+		}
+
+		parallel, err := newGo(2, b).Build(context.Background(), StrictScheme+importpath)
+		if err != nil {
+			t.Fatalf("Build() = %v", err)
+		}
+		if peak != 2 {
+			t.Errorf("peak concurrent builds = %d, want 2", peak)
+		}
+
+		serial, err := newGo(1, writeTempFile).Build(context.Background(), StrictScheme+importpath)
+		if err != nil {
+			t.Fatalf("Build() = %v", err)
+		}
+		d1, err := parallel.Digest()
+		if err != nil {
+			t.Fatalf("Digest() = %v", err)
+		}
+		d2, err := serial.Digest()
+		if err != nil {
+			t.Fatalf("Digest() = %v", err)
+		}
+		if d1 != d2 {
+			t.Errorf("parallel digest %s != serial digest %s", d1, d2)
+		}
+	})
+
+	t.Run("failure cancels other platforms", func(t *testing.T) {
+		b := func(ctx context.Context, ip string, dir string, platform v1.Platform, config Config) (string, error) {
+			if platform.Architecture == "ppc64le" {
+				return "", errors.New("compiler exploded")
+			}
+			// The other builds only finish once they are cancelled.
+			<-ctx.Done()
+			return "", ctx.Err()
+		}
+
+		_, err := newGo(4, b).Build(context.Background(), StrictScheme+importpath)
+		if err == nil {
+			t.Fatal("Build() = nil, wanted error")
+		}
+		if !strings.Contains(err.Error(), "linux/ppc64le") || !strings.Contains(err.Error(), "compiler exploded") {
+			t.Errorf("Build() = %v, wanted error naming linux/ppc64le", err)
+		}
+	})
+}
+
+func TestGoBuildTimeout(t *testing.T) {
+	var adds []mutate.IndexAddendum
+	for _, arch := range []string{"amd64", "arm64", "s390x"} {
+		img, err := random.Image(1024, 1)
+		if err != nil {
+			t.Fatalf("random.Image() = %v", err)
+		}
+		adds = append(adds, mutate.IndexAddendum{
+			Add: img,
+			Descriptor: v1.Descriptor{
+				Platform: &v1.Platform{OS: "linux", Architecture: arch},
+			},
+		})
+	}
+	base := mutate.AppendManifests(empty.Index, adds...)
+	importpath := "github.com/google/ko/test"
+
+	newGo := func(b builder) Interface {
+		ng, err := NewGo(
+			context.Background(),
+			"",
+			WithBaseImages(func(context.Context, string) (name.Reference, Result, error) { return baseRef, base, nil }),
+			WithPlatforms("all"),
+			WithJobs(1),
+			WithBuildTimeout(500*time.Millisecond),
+			withBuilder(b),
+			withSBOMber(fauxSBOM),
+		)
+		if err != nil {
+			t.Fatalf("NewGo() = %v", err)
+		}
+		return ng
+	}
+
+	t.Run("applies per platform", func(t *testing.T) {
+		// Together the builds take longer than the timeout, but each
+		// platform finishes within it.
+		b := func(ctx context.Context, ip string, dir string, platform v1.Platform, config Config) (string, error) {
+			select {
+			case <-time.After(200 * time.Millisecond):
+			case <-ctx.Done():
+				return "", ctx.Err()
+			}
+			return writeTempFile(ctx, ip, dir, platform, config)
+		}
+		if _, err := newGo(b).Build(context.Background(), StrictScheme+importpath); err != nil {
+			t.Fatalf("Build() = %v", err)
+		}
+	})
+
+	t.Run("kills hung builds", func(t *testing.T) {
+		b := func(ctx context.Context, ip string, dir string, platform v1.Platform, config Config) (string, error) {
+			if platform.Architecture != "arm64" {
+				return writeTempFile(ctx, ip, dir, platform, config)
+			}
+			<-ctx.Done()
+			return "", ctx.Err()
+		}
+		_, err := newGo(b).Build(context.Background(), StrictScheme+importpath)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("Build() = %v, want %v", err, context.DeadlineExceeded)
+		}
+		if !strings.Contains(err.Error(), importpath) || !strings.Contains(err.Error(), "linux/arm64") || !strings.Contains(err.Error(), "timed out") {
+			t.Errorf("Build() = %v, wanted timeout error naming %s and linux/arm64", err, importpath)
+		}
+	})
+
+	if _, err := NewGo(context.Background(), "", WithBaseImages(nilGetBase), WithBuildTimeout(-time.Second)); err == nil {
+		t.Error("NewGo() = nil, wanted error for negative timeout")
+	}
+}
+
+func TestGoBuildRiscv64(t *testing.T) {
+	var adds []mutate.IndexAddendum
+	for _, arch := range []string{"amd64", "arm64", "riscv64"} {
+		img, err := random.Image(1024, 1)
+		if err != nil {
+			t.Fatalf("random.Image() = %v", err)
+		}
+		adds = append(adds, mutate.IndexAddendum{
+			Add: img,
+			Descriptor: v1.Descriptor{
+				Platform: &v1.Platform{OS: "linux", Architecture: arch},
+			},
+		})
+	}
+	multiArch := mutate.AppendManifests(empty.Index, adds[0], adds[2])
+	noRiscv := mutate.AppendManifests(empty.Index, adds[0], adds[1])
+	importpath := "github.com/google/ko/test"
+
+	newGo := func(base v1.ImageIndex, b builder, platforms ...string) Interface {
+		ng, err := NewGo(
+			context.Background(),
+			"",
+			WithBaseImages(func(context.Context, string) (name.Reference, Result, error) { return baseRef, base, nil }),
+			WithPlatforms(platforms...),
+			withBuilder(b),
+			withSBOMber(fauxSBOM),
+		)
+		if err != nil {
+			t.Fatalf("NewGo() = %v", err)
+		}
+		return ng
+	}
+
+	t.Run("builds riscv64", func(t *testing.T) {
+		var arches []string
+		var mu sync.Mutex
+		b := func(ctx context.Context, ip string, dir string, platform v1.Platform, config Config) (string, error) {
+			mu.Lock()
+			arches = append(arches, platform.Architecture)
+			mu.Unlock()
+			return writeTempFile(ctx, ip, dir, platform, config)
+		}
+		result, err := newGo(multiArch, b, "linux/amd64", "linux/riscv64").Build(context.Background(), StrictScheme+importpath)
+		if err != nil {
+			t.Fatalf("Build() = %v", err)
+		}
+		idx, ok := result.(v1.ImageIndex)
+		if !ok {
+			t.Fatalf("Build() not an ImageIndex: %T", result)
+		}
+		im, err := idx.IndexManifest()
+		if err != nil {
+			t.Fatalf("IndexManifest() = %v", err)
+		}
+		var got []string
+		for _, desc := range im.Manifests {
+			got = append(got, desc.Platform.String())
+		}
+		if want := []string{"linux/amd64", "linux/riscv64"}; !cmp.Equal(got, want) {
+			t.Errorf("index platforms = %v, want %v", got, want)
+		}
+		sort.Strings(arches)
+		if want := []string{"amd64", "riscv64"}; !cmp.Equal(arches, want) {
+			t.Errorf("built GOARCHes = %v, want %v", arches, want)
+		}
+	})
+
+	t.Run("base lacks riscv64", func(t *testing.T) {
+		_, err := newGo(noRiscv, writeTempFile, "linux/riscv64").Build(context.Background(), StrictScheme+importpath)
+		if err == nil {
+			t.Fatal("Build() = nil, wanted error")
+		}
+		if !strings.Contains(err.Error(), "linux/riscv64") {
+			t.Errorf("Build() = %v, wanted error naming linux/riscv64", err)
+		}
+	})
+}
+
+func TestGoBuildMissingBasePlatforms(t *testing.T) {
+	platformImage := func(arch string) v1.Image {
+		img, err := random.Image(1024, 1)
+		if err != nil {
+			t.Fatalf("random.Image() = %v", err)
+		}
+		cf, err := img.ConfigFile()
+		if err != nil {
+			t.Fatalf("ConfigFile() = %v", err)
+		}
+		cf = cf.DeepCopy()
+		cf.OS, cf.Architecture = "linux", arch
+		img, err = mutate.ConfigFile(img, cf)
+		if err != nil {
+			t.Fatalf("mutate.ConfigFile() = %v", err)
+		}
+		return img
+	}
+	amd64 := platformImage("amd64")
+	index := mutate.AppendManifests(empty.Index,
+		mutate.IndexAddendum{Add: amd64, Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "linux", Architecture: "amd64"}}},
+		mutate.IndexAddendum{Add: platformImage("s390x"), Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "linux", Architecture: "s390x"}}},
+	)
+	importpath := "github.com/google/ko/test"
+
+	for _, tc := range []struct {
+		desc      string
+		base      Result
+		platforms []string
+		missing   string
+	}{{
+		desc:      "index lacks one of the platforms",
+		base:      index,
+		platforms: []string{"linux/amd64", "linux/arm64"},
+		missing:   "linux/arm64",
+	}, {
+		desc:      "index lacks all of the platforms",
+		base:      index,
+		platforms: []string{"linux/arm64", "linux/ppc64le"},
+		missing:   "linux/arm64,linux/ppc64le",
+	}, {
+		// The default platform doesn't apply to single-platform bases,
+		// which are built for their own platform.
+		desc:      "image has another platform than the default",
+		base:      platformImage("arm64"),
+		platforms: []string{"linux/amd64"},
+	}, {
+		desc:      "index has the platforms",
+		base:      index,
+		platforms: []string{"linux/amd64", "linux/s390x"},
+	}, {
+		desc:      "image has the platform",
+		base:      amd64,
+		platforms: []string{"linux/amd64"},
+	}, {
+		desc:      "all platforms",
+		base:      amd64,
+		platforms: []string{"all"},
+	}} {
+		t.Run(tc.desc, func(t *testing.T) {
+			built := false
+			b := func(ctx context.Context, ip string, dir string, platform v1.Platform, config Config) (string, error) {
+				built = true
+				return writeTempFile(ctx, ip, dir, platform, config)
+			}
+			ng, err := NewGo(
+				context.Background(),
+				"",
+				WithBaseImages(func(context.Context, string) (name.Reference, Result, error) { return baseRef, tc.base, nil }),
+				WithPlatforms(tc.platforms...),
+				withBuilder(b),
+				withSBOMber(fauxSBOM),
+			)
+			if err != nil {
+				t.Fatalf("NewGo() = %v", err)
+			}
+			_, err = ng.Build(context.Background(), StrictScheme+importpath)
+			if tc.missing == "" {
+				if err != nil {
+					t.Fatalf("Build() = %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("Build() = nil, wanted an error")
+			}
+			if want := "requested platforms " + tc.missing + ","; !strings.Contains(err.Error(), want) {
+				t.Errorf("Build() = %v, wanted it to name missing %s", err, tc.missing)
+			}
+			if built {
+				t.Error("Build() built a binary before failing")
+			}
+		})
+	}
+}
+
+func TestGoBuildArmVariants(t *testing.T) {
+	armImage := func(variant string) v1.Image {
+		img, err := random.Image(1024, 1)
+		if err != nil {
+			t.Fatalf("random.Image() = %v", err)
+		}
+		cf, err := img.ConfigFile()
+		if err != nil {
+			t.Fatalf("ConfigFile() = %v", err)
+		}
+		cf = cf.DeepCopy()
+		cf.OS, cf.Architecture, cf.Variant = "linux", "arm", variant
+		img, err = mutate.ConfigFile(img, cf)
+		if err != nil {
+			t.Fatalf("mutate.ConfigFile() = %v", err)
+		}
+		return img
 	}
+	importpath := "github.com/google/ko/test"
 
-	im, err := idx.IndexManifest()
+	newGo := func(base Result, built *[]v1.Platform, platforms ...string) Interface {
+		var mu sync.Mutex
+		b := func(ctx context.Context, ip string, dir string, platform v1.Platform, config Config) (string, error) {
+			mu.Lock()
+			*built = append(*built, platform)
+			mu.Unlock()
+			return writeTempFile(ctx, ip, dir, platform, config)
+		}
+		ng, err := NewGo(
+			context.Background(),
+			"",
+			WithBaseImages(func(context.Context, string) (name.Reference, Result, error) { return baseRef, base, nil }),
+			WithPlatforms(platforms...),
+			withBuilder(b),
+			withSBOMber(fauxSBOM),
+		)
+		if err != nil {
+			t.Fatalf("NewGo() = %v", err)
+		}
+		return ng
+	}
+
+	t.Run("index", func(t *testing.T) {
+		// The base only records the variants in its index.
+		var adds []mutate.IndexAddendum
+		for _, variant := range []string{"v6", "v7"} {
+			adds = append(adds, mutate.IndexAddendum{
+				Add: armImage(""),
+				Descriptor: v1.Descriptor{
+					Platform: &v1.Platform{OS: "linux", Architecture: "arm", Variant: variant},
+				},
+			})
+		}
+		base := mutate.AppendManifests(empty.Index, adds...)
+
+		var built []v1.Platform
+		result, err := newGo(base, &built, "linux/arm/v6", "linux/arm/v7").Build(context.Background(), StrictScheme+importpath)
+		if err != nil {
+			t.Fatalf("Build() = %v", err)
+		}
+		idx, ok := result.(v1.ImageIndex)
+		if !ok {
+			t.Fatalf("Build() not an ImageIndex: %T", result)
+		}
+		im, err := idx.IndexManifest()
+		if err != nil {
+			t.Fatalf("IndexManifest() = %v", err)
+		}
+		if len(im.Manifests) != 2 {
+			t.Fatalf("len(Manifests) = %d, want 2", len(im.Manifests))
+		}
+		for i, want := range []string{"v6", "v7"} {
+			desc := im.Manifests[i]
+			if desc.Platform == nil || desc.Platform.Variant != want {
+				t.Errorf("Manifests[%d].Platform = %v, want variant %s", i, desc.Platform, want)
+			}
+			img, err := idx.Image(desc.Digest)
+			if err != nil {
+				t.Fatalf("Image() = %v", err)
+			}
+			cf, err := img.ConfigFile()
+			if err != nil {
+				t.Fatalf("ConfigFile() = %v", err)
+			}
+			if cf.Variant != want {
+				t.Errorf("config variant = %q, want %q", cf.Variant, want)
+			}
+		}
+		if im.Manifests[0].Digest == im.Manifests[1].Digest {
+			t.Errorf("v6 and v7 images have the same digest %s", im.Manifests[0].Digest)
+		}
+
+		var goarms []string
+		for _, p := range built {
+			env, err := buildEnv(p, nil, nil)
+			if err != nil {
+				t.Fatalf("buildEnv() = %v", err)
+			}
+			for _, kv := range env {
+				if strings.HasPrefix(kv, "GOARM=") {
+					goarms = append(goarms, kv)
+				}
+			}
+		}
+		sort.Strings(goarms)
+		if want := []string{"GOARM=6", "GOARM=7"}; !cmp.Equal(goarms, want) {
+			t.Errorf("built with %v, want %v", goarms, want)
+		}
+	})
+
+	t.Run("single image", func(t *testing.T) {
+		var built []v1.Platform
+		if _, err := newGo(armImage("v7"), &built, "linux/arm/v7").Build(context.Background(), StrictScheme+importpath); err != nil {
+			t.Fatalf("Build() = %v", err)
+		}
+		if len(built) != 1 || built[0].Variant != "v7" {
+			t.Errorf("built platforms = %v, want linux/arm/v7", built)
+		}
+	})
+}
+
+func TestGoBuildWindows(t *testing.T) {
+	base, err := random.Image(1024, 1)
 	if err != nil {
-		t.Fatalf("IndexManifest() = %v", err)
+		t.Fatalf("random.Image() = %v", err)
+	}
+	foreign, err := random.Layer(1024, types.DockerForeignLayer)
+	if err != nil {
+		t.Fatalf("random.Layer() = %v", err)
 	}
+	foreignURL := "https://mcr.microsoft.com/v2/windows/nanoserver/blobs/sha256:deadbeef"
+	base, err = mutate.Append(base, mutate.Addendum{
+		Layer:     foreign,
+		URLs:      []string{foreignURL},
+		MediaType: types.DockerForeignLayer,
+	})
+	if err != nil {
+		t.Fatalf("mutate.Append() = %v", err)
+	}
+	cf, err := base.ConfigFile()
+	if err != nil {
+		t.Fatalf("ConfigFile() = %v", err)
+	}
+	cf = cf.DeepCopy()
+	cf.OS, cf.Architecture, cf.OSVersion = "windows", "amd64", "10.0.17763.1234"
+	base, err = mutate.ConfigFile(base, cf)
+	if err != nil {
+		t.Fatalf("mutate.ConfigFile() = %v", err)
+	}
+	importpath := "github.com/google/ko/test"
 
-	for _, desc := range im.Manifests {
-		img, err := idx.SignedImage(desc.Digest)
+	ng, err := NewGo(
+		context.Background(),
+		"",
+		WithBaseImages(func(context.Context, string) (name.Reference, Result, error) { return baseRef, base, nil }),
+		WithPlatforms("windows/amd64"),
+		withBuilder(writeTempFile),
+		withSBOMber(fauxSBOM),
+	)
+	if err != nil {
+		t.Fatalf("NewGo() = %v", err)
+	}
+	result, err := ng.Build(context.Background(), StrictScheme+importpath)
+	if err != nil {
+		t.Fatalf("Build() = %v", err)
+	}
+	img, ok := result.(v1.Image)
+	if !ok {
+		t.Fatalf("Build() not an Image: %T", result)
+	}
+
+	t.Run("check config", func(t *testing.T) {
+		cfg, err := img.ConfigFile()
 		if err != nil {
-			t.Fatalf("idx.Image(%s) = %v", desc.Digest, err)
+			t.Fatalf("ConfigFile() = %v", err)
 		}
-		validateImage(t, img, baseLayers, creationTime, false, true)
+		if got, want := cfg.Config.Entrypoint, []string{`C:\ko-app\test.exe`}; !cmp.Equal(got, want) {
+			t.Errorf("Entrypoint = %v, want %v", got, want)
+		}
+		if cfg.OS != "windows" || cfg.OSVersion != "10.0.17763.1234" {
+			t.Errorf("OS, OSVersion = %q, %q, want windows, 10.0.17763.1234", cfg.OS, cfg.OSVersion)
+		}
+		found := false
+		for _, entry := range cfg.Config.Env {
+			if entry == `KO_DATA_PATH=C:\var\run\ko` {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("Env = %v, want KO_DATA_PATH=C:\\var\\run\\ko", cfg.Config.Env)
+		}
+	})
+
+	t.Run("check binary layer", func(t *testing.T) {
+		ls, err := img.Layers()
+		if err != nil {
+			t.Fatalf("Layers() = %v", err)
+		}
+		r, err := ls[len(ls)-1].Uncompressed()
+		if err != nil {
+			t.Fatalf("Uncompressed() = %v", err)
+		}
+		defer r.Close()
+		got := map[string]bool{}
+		tr := tar.NewReader(r)
+		for {
+			header, err := tr.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			} else if err != nil {
+				t.Fatalf("Next() = %v", err)
+			}
+			got[header.Name] = true
+		}
+		for _, want := range []string{"Hives", "Files", "Files/ko-app", "Files/ko-app/test.exe"} {
+			if !got[want] {
+				t.Errorf("binary layer missing %q, got %v", want, got)
+			}
+		}
+	})
+
+	t.Run("check foreign layer", func(t *testing.T) {
+		m, err := img.Manifest()
+		if err != nil {
+			t.Fatalf("Manifest() = %v", err)
+		}
+		desc := m.Layers[1]
+		if desc.MediaType != types.DockerForeignLayer || !cmp.Equal(desc.URLs, []string{foreignURL}) {
+			t.Errorf("foreign layer descriptor = %+v, want media type %s with URLs [%s]", desc, types.DockerForeignLayer, foreignURL)
+		}
+	})
+}
+
+func TestGoBuildPlatformBaseOverrides(t *testing.T) {
+	var adds []mutate.IndexAddendum
+	for _, arch := range []string{"amd64", "arm64"} {
+		img, err := random.Image(1024, 1)
+		if err != nil {
+			t.Fatalf("random.Image() = %v", err)
+		}
+		adds = append(adds, mutate.IndexAddendum{
+			Add: img,
+			Descriptor: v1.Descriptor{
+				Platform: &v1.Platform{OS: "linux", Architecture: arch},
+			},
+		})
 	}
+	base := mutate.AppendManifests(empty.Index, adds...)
 
-	if want, got := images, int64(len(im.Manifests)); want != got {
-		t.Fatalf("len(Manifests()) = %v, want %v", got, want)
+	debugBase, err := random.Image(1024, 2)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
 	}
+	debugRef := name.MustParseReference("all.your/debug")
+	importpath := "github.com/google/ko"
 
-	// Check that rebuilding the image again results in the same image digest.
-	t.Run("check determinism", func(t *testing.T) {
-		result2, err := ng.Build(context.Background(), StrictScheme+filepath.Join(importpath, "test"))
+	newGo := func(overrides map[string]PlatformBase) Interface {
+		ng, err := NewGo(
+			context.Background(),
+			"",
+			WithBaseImages(func(context.Context, string) (name.Reference, Result, error) { return baseRef, base, nil }),
+			WithPlatformBaseImages(func(context.Context, string) (map[string]PlatformBase, error) { return overrides, nil }),
+			WithPlatforms("linux/amd64", "linux/arm64"),
+			withBuilder(writeTempFile),
+			withSBOMber(fauxSBOM),
+		)
 		if err != nil {
-			t.Fatalf("Build() = %v", err)
+			t.Fatalf("NewGo() = %v", err)
 		}
+		return ng
+	}
 
-		d1, err := result.Digest()
+	ng := newGo(map[string]PlatformBase{
+		"linux/arm64": {Ref: debugRef, Base: debugBase},
+	})
+	result, err := ng.Build(context.Background(), StrictScheme+importpath)
+	if err != nil {
+		t.Fatalf("Build() = %v", err)
+	}
+	idx, ok := result.(v1.ImageIndex)
+	if !ok {
+		t.Fatalf("Build() not an ImageIndex: %T", result)
+	}
+	im, err := idx.IndexManifest()
+	if err != nil {
+		t.Fatalf("IndexManifest() = %v", err)
+	}
+	if got, want := len(im.Manifests), 2; got != want {
+		t.Fatalf("len(Manifests) = %d, want %d", got, want)
+	}
+
+	for _, desc := range im.Manifests {
+		img, err := idx.Image(desc.Digest)
 		if err != nil {
-			t.Fatalf("Digest() = %v", err)
+			t.Fatalf("Image(%s) = %v", desc.Digest, err)
 		}
-		d2, err := result2.Digest()
+		m, err := img.Manifest()
 		if err != nil {
-			t.Fatalf("Digest() = %v", err)
+			t.Fatalf("Manifest() = %v", err)
 		}
+		wantBase, wantLayers := baseRef.Name(), 1+2
+		if desc.Platform.Architecture == "arm64" {
+			wantBase, wantLayers = debugRef.Name(), 2+2
+		}
+		if got := m.Annotations[specsv1.AnnotationBaseImageName]; got != wantBase {
+			t.Errorf("%s: base image name = %q, want %q", desc.Platform, got, wantBase)
+		}
+		if got := len(m.Layers); got != wantLayers {
+			t.Errorf("%s: len(Layers) = %d, want %d", desc.Platform, got, wantLayers)
+		}
+	}
 
-		if d1 != d2 {
-			t.Errorf("Digest mismatch: %s != %s", d1, d2)
+	t.Run("override for platform not being built", func(t *testing.T) {
+		ng := newGo(map[string]PlatformBase{
+			"linux/s390x": {Ref: debugRef, Base: debugBase},
+		})
+		if _, err := ng.Build(context.Background(), StrictScheme+importpath); err == nil {
+			t.Error("Build() = nil, want error for override of unbuilt platform")
 		}
 	})
 }
@@ -1141,87 +3446,667 @@ func TestMatchesPlatformSpec(t *testing.T) {
 			if err == nil {
 				t.Errorf("parseSpec(%v, %q) expected err", tc.platform, tc.spec)
 			}
-			continue
+			continue
+		}
+		if err != nil {
+			t.Fatalf("parseSpec failed for %v %q: %v", tc.platform, tc.spec, err)
+		}
+		matches := pm.matches(tc.platform)
+		if got, want := matches, tc.result; got != want {
+			t.Errorf("wrong result for %v %q: want %t got %t", tc.platform, tc.spec, want, got)
+		}
+	}
+}
+
+func TestGoBuildConsistentMediaTypes(t *testing.T) {
+	for _, c := range []struct {
+		desc                      string
+		mediaType, layerMediaType types.MediaType
+	}{{
+		desc:           "docker types",
+		mediaType:      types.DockerManifestSchema2,
+		layerMediaType: types.DockerLayer,
+	}, {
+		desc:           "oci types",
+		mediaType:      types.OCIManifestSchema1,
+		layerMediaType: types.OCILayer,
+	}} {
+		t.Run(c.desc, func(t *testing.T) {
+			base := mutate.MediaType(empty.Image, c.mediaType)
+			l, err := random.Layer(10, c.layerMediaType)
+			if err != nil {
+				t.Fatal(err)
+			}
+			base, err = mutate.AppendLayers(base, l)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ng, err := NewGo(
+				context.Background(),
+				"",
+				WithBaseImages(func(context.Context, string) (name.Reference, Result, error) { return baseRef, base, nil }),
+				withBuilder(writeTempFile),
+				withSBOMber(fauxSBOM),
+			)
+			if err != nil {
+				t.Fatalf("NewGo() = %v", err)
+			}
+
+			importpath := "github.com/google/ko"
+
+			result, err := ng.Build(context.Background(), StrictScheme+importpath)
+			if err != nil {
+				t.Fatalf("Build() = %v", err)
+			}
+
+			img, ok := result.(v1.Image)
+			if !ok {
+				t.Fatalf("Build() not an Image: %T", result)
+			}
+
+			ls, err := img.Layers()
+			if err != nil {
+				t.Fatalf("Layers() = %v", err)
+			}
+
+			for i, l := range ls {
+				gotMT, err := l.MediaType()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if gotMT != c.layerMediaType {
+					t.Errorf("layer %d: got mediaType %q, want %q", i, gotMT, c.layerMediaType)
+				}
+			}
+
+			gotMT, err := img.MediaType()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if gotMT != c.mediaType {
+				t.Errorf("got image mediaType %q, want %q", gotMT, c.layerMediaType)
+			}
+		})
+	}
+}
+
+const fakeGoVersionM = `out: go1.18
+	path	github.com/google/ko
+	mod	github.com/google/ko	(devel)	
+	dep	github.com/google/go-containerregistry	v0.11.0	
+`
+
+const baseSPDX = `{
+  "SPDXID": "SPDXRef-DOCUMENT",
+  "name": "distroless",
+  "spdxVersion": "SPDX-2.2",
+  "documentDescribes": ["SPDXRef-Package-base-files"],
+  "packages": [{
+    "SPDXID": "SPDXRef-Package-base-files",
+    "name": "base-files",
+    "versionInfo": "11.1"
+  }, {
+    "SPDXID": "SPDXRef-Package-libc6",
+    "name": "libc6",
+    "versionInfo": "2.31-13"
+  }],
+  "relationships": [{
+    "spdxElementId": "SPDXRef-Package-base-files",
+    "relationshipType": "DEPENDS_ON",
+    "relatedSpdxElement": "SPDXRef-Package-libc6"
+  }, {
+    "spdxElementId": "SPDXRef-Package-libc6",
+    "relationshipType": "CONTAINS",
+    "relatedSpdxElement": "SPDXRef-File-libc.so"
+  }]
+}`
+
+func TestGoBuildMergesBaseSBOM(t *testing.T) {
+	base, err := random.Image(1024, 3)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	baseDigest, err := base.Digest()
+	if err != nil {
+		t.Fatalf("Digest() = %v", err)
+	}
+	spdxSBOM := func(ctx context.Context, file, appPath string, se oci.SignedEntity) ([]byte, types.MediaType, error) {
+		b, err := sbom.GenerateImageSPDX("test", []byte(fakeGoVersionM), se.(oci.SignedImage))
+		return b, ctypes.SPDXJSONMediaType, err
+	}
+
+	for _, tc := range []struct {
+		name     string
+		baseSBOM []byte
+		want     []string
+	}{{
+		name: "without base SBOM",
+	}, {
+		name:     "with base SBOM",
+		baseSBOM: []byte(baseSPDX),
+		want:     []string{"SPDXRef-Base-Package-base-files", "SPDXRef-Base-Package-libc6"},
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			var gotRef name.Digest
+			ng, err := NewGo(
+				context.Background(),
+				"",
+				WithBaseImages(func(context.Context, string) (name.Reference, Result, error) { return baseRef, base, nil }),
+				withBuilder(writeTempFile),
+				withSBOMber(spdxSBOM),
+				WithBaseSBOM(func(_ context.Context, ref name.Digest) ([]byte, types.MediaType, error) {
+					gotRef = ref
+					if tc.baseSBOM == nil {
+						return nil, "", nil
+					}
+					return tc.baseSBOM, ctypes.SPDXJSONMediaType, nil
+				}),
+			)
+			if err != nil {
+				t.Fatalf("NewGo() = %v", err)
+			}
+			result, err := ng.Build(context.Background(), StrictScheme+"github.com/google/ko")
+			if err != nil {
+				t.Fatalf("Build() = %v", err)
+			}
+			if want := baseRef.Context().Digest(baseDigest.String()); gotRef != want {
+				t.Errorf("got base SBOM of %v, wanted %v", gotRef, want)
+			}
+
+			f, err := result.(oci.SignedImage).Attachment("sbom")
+			if err != nil {
+				t.Fatalf("Attachment() = %v", err)
+			}
+			b, err := f.Payload()
+			if err != nil {
+				t.Fatalf("Payload() = %v", err)
+			}
+			var doc sbom.Document
+			if err := json.Unmarshal(b, &doc); err != nil {
+				t.Fatalf("Unmarshal() = %v", err)
+			}
+			var got []string
+			for _, p := range doc.Packages {
+				if strings.HasPrefix(p.ID, "SPDXRef-Base-") {
+					got = append(got, p.ID)
+				}
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("base packages (-want +got) = %s", diff)
+			}
+			if tc.baseSBOM == nil {
+				return
+			}
+
+			// The base image contains what the base SBOM describes, and the
+			// relationships between its packages are kept.
+			baseID := fmt.Sprintf("SPDXRef-Package-%s-%s", baseDigest.Algorithm, baseDigest.Hex)
+			wantRels := []sbom.Relationship{{
+				Element: baseID,
+				Type:    "CONTAINS",
+				Related: "SPDXRef-Base-Package-base-files",
+			}, {
+				Element: "SPDXRef-Base-Package-base-files",
+				Type:    "DEPENDS_ON",
+				Related: "SPDXRef-Base-Package-libc6",
+			}}
+			var gotRels []sbom.Relationship
+			for _, r := range doc.Relationships {
+				if strings.HasPrefix(r.Related, "SPDXRef-Base-") {
+					gotRels = append(gotRels, r)
+				}
+			}
+			if diff := cmp.Diff(wantRels, gotRels); diff != "" {
+				t.Errorf("base relationships (-want +got) = %s", diff)
+			}
+		})
+	}
+}
+
+func TestGoBuildLayerOrder(t *testing.T) {
+	baseLayers := int64(3)
+	base, err := random.Image(1024, baseLayers)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	importpath := "github.com/google/ko/test"
+
+	// Build the same input twice, with separate builders, as on different
+	// machines.
+	var digests []v1.Hash
+	for i := 0; i < 2; i++ {
+		ng, err := NewGo(
+			context.Background(),
+			"",
+			WithCreationTime(v1.Time{Time: time.Unix(5000, 0)}),
+			WithBaseImages(func(context.Context, string) (name.Reference, Result, error) { return baseRef, base, nil }),
+			withBuilder(writeTempFile),
+			withSBOMber(fauxSBOM),
+		)
+		if err != nil {
+			t.Fatalf("NewGo() = %v", err)
 		}
+		result, err := ng.Build(context.Background(), StrictScheme+importpath)
 		if err != nil {
-			t.Fatalf("parseSpec failed for %v %q: %v", tc.platform, tc.spec, err)
+			t.Fatalf("Build() = %v", err)
 		}
-		matches := pm.matches(tc.platform)
-		if got, want := matches, tc.result; got != want {
-			t.Errorf("wrong result for %v %q: want %t got %t", tc.platform, tc.spec, want, got)
+		img, ok := result.(v1.Image)
+		if !ok {
+			t.Fatalf("Build() not an Image: %T", result)
+		}
+		d, err := img.Digest()
+		if err != nil {
+			t.Fatalf("Digest() = %v", err)
+		}
+		digests = append(digests, d)
+
+		cfg, err := img.ConfigFile()
+		if err != nil {
+			t.Fatalf("ConfigFile() = %v", err)
+		}
+		var comments []string
+		for _, h := range cfg.History[baseLayers:] {
+			comments = append(comments, h.Comment)
+		}
+		want := []string{"kodata contents, at $KO_DATA_PATH", "go build output, at /ko-app/test"}
+		if diff := cmp.Diff(want, comments); diff != "" {
+			t.Errorf("layers after the base's (-want +got) = %s", diff)
 		}
 	}
+	if digests[0] != digests[1] {
+		t.Errorf("Digest mismatch: %s != %s", digests[0], digests[1])
+	}
 }
 
-func TestGoBuildConsistentMediaTypes(t *testing.T) {
-	for _, c := range []struct {
-		desc                      string
-		mediaType, layerMediaType types.MediaType
+func TestWalkRecursiveOrder(t *testing.T) {
+	files := []string{"z", "a.txt", filepath.Join("a", "b"), filepath.Join("a", "a"), "m"}
+	tarball := func(order []string) []byte {
+		root := t.TempDir()
+		for _, f := range order {
+			if err := os.MkdirAll(filepath.Join(root, filepath.Dir(f)), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(filepath.Join(root, f), []byte(f), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		if err := walkRecursive(tw, root, "/var/run/ko", v1.Time{}, &v1.Platform{OS: "linux"}, nil); err != nil {
+			t.Fatalf("walkRecursive() = %v", err)
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+
+	b := tarball(files)
+	reversed := make([]string, len(files))
+	for i, f := range files {
+		reversed[len(files)-1-i] = f
+	}
+	if !bytes.Equal(b, tarball(reversed)) {
+		t.Error("tarball depends on the order the files were created in")
+	}
+
+	var got []string
+	tr := tar.NewReader(bytes.NewReader(b))
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			t.Fatalf("Next() = %v", err)
+		}
+		got = append(got, header.Name)
+	}
+	want := []string{"/var/run/ko/a/a", "/var/run/ko/a/b", "/var/run/ko/a.txt", "/var/run/ko/m", "/var/run/ko/z"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("tarball entries (-want +got) = %s", diff)
+	}
+}
+
+func TestGoBuildMediaTypes(t *testing.T) {
+	baseLayers := int64(3)
+	dockerBase, err := random.Image(1024, baseLayers)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	ociBase, err := convertMediaTypes(dockerBase, OCIMediaTypes)
+	if err != nil {
+		t.Fatalf("convertMediaTypes() = %v", err)
+	}
+	importpath := "github.com/google/ko"
+
+	for _, tc := range []struct {
+		desc         string
+		base         v1.Image
+		mediaTypes   string
+		wantManifest types.MediaType
+		wantConfig   types.MediaType
+		wantLayer    types.MediaType
 	}{{
-		desc:           "docker types",
-		mediaType:      types.DockerManifestSchema2,
-		layerMediaType: types.DockerLayer,
+		desc:         "docker base to oci",
+		base:         dockerBase,
+		mediaTypes:   OCIMediaTypes,
+		wantManifest: types.OCIManifestSchema1,
+		wantConfig:   types.OCIConfigJSON,
+		wantLayer:    types.OCILayer,
 	}, {
-		desc:           "oci types",
-		mediaType:      types.OCIManifestSchema1,
-		layerMediaType: types.OCILayer,
+		desc:         "oci base to docker",
+		base:         ociBase,
+		mediaTypes:   DockerMediaTypes,
+		wantManifest: types.DockerManifestSchema2,
+		wantConfig:   types.DockerConfigJSON,
+		wantLayer:    types.DockerLayer,
+	}, {
+		desc:         "base's by default",
+		base:         ociBase,
+		wantManifest: types.OCIManifestSchema1,
+		wantConfig:   types.OCIConfigJSON,
+		wantLayer:    types.OCILayer,
 	}} {
-		t.Run(c.desc, func(t *testing.T) {
-			base := mutate.MediaType(empty.Image, c.mediaType)
-			l, err := random.Layer(10, c.layerMediaType)
+		t.Run(tc.desc, func(t *testing.T) {
+			base := tc.base
+			ng, err := NewGo(
+				context.Background(),
+				"",
+				WithBaseImages(func(context.Context, string) (name.Reference, Result, error) { return baseRef, base, nil }),
+				withBuilder(writeTempFile),
+				withSBOMber(fauxSBOM),
+				WithMediaTypes(tc.mediaTypes),
+			)
 			if err != nil {
-				t.Fatal(err)
+				t.Fatalf("NewGo() = %v", err)
 			}
-			base, err = mutate.AppendLayers(base, l)
+			result, err := ng.Build(context.Background(), StrictScheme+filepath.Join(importpath, "test"))
 			if err != nil {
-				t.Fatal(err)
+				t.Fatalf("Build() = %v", err)
+			}
+			img, ok := result.(v1.Image)
+			if !ok {
+				t.Fatalf("Build() not an Image: %T", result)
+			}
+
+			m, err := img.Manifest()
+			if err != nil {
+				t.Fatalf("Manifest() = %v", err)
+			}
+			if m.MediaType != tc.wantManifest {
+				t.Errorf("manifest media type = %s, want %s", m.MediaType, tc.wantManifest)
+			}
+			if m.Config.MediaType != tc.wantConfig {
+				t.Errorf("config media type = %s, want %s", m.Config.MediaType, tc.wantConfig)
+			}
+			for i, l := range m.Layers {
+				if l.MediaType != tc.wantLayer {
+					t.Errorf("layer %d media type = %s, want %s", i, l.MediaType, tc.wantLayer)
+				}
+			}
+
+			// The base layers' contents are unchanged.
+			bm, err := dockerBase.Manifest()
+			if err != nil {
+				t.Fatalf("Manifest() = %v", err)
+			}
+			for i, l := range bm.Layers {
+				if m.Layers[i].Digest != l.Digest {
+					t.Errorf("base layer %d digest = %s, want %s", i, m.Layers[i].Digest, l.Digest)
+				}
+			}
+			cfg, err := img.ConfigFile()
+			if err != nil {
+				t.Fatalf("ConfigFile() = %v", err)
+			}
+			if got, want := len(cfg.RootFS.DiffIDs), len(m.Layers); got != want {
+				t.Errorf("%d diff IDs, want %d", got, want)
+			}
+		})
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		if _, err := NewGo(context.Background(), "", WithMediaTypes("v2")); err == nil {
+			t.Error("NewGo() = nil, want error for unsupported media types")
+		}
+		if _, err := NewGo(context.Background(), "",
+			WithBaseImages(func(context.Context, string) (name.Reference, Result, error) { return baseRef, dockerBase, nil }),
+			WithMediaTypes(DockerMediaTypes),
+			WithLayerCompression(ZstdCompression),
+		); err == nil {
+			t.Error("NewGo() = nil, want error for zstd with docker media types")
+		}
+	})
+}
+
+func TestGoBuildBinaryLayerChanges(t *testing.T) {
+	baseLayers := int64(3)
+	base, err := random.Image(1024, baseLayers)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	importpath := "github.com/google/ko/test"
+
+	// buildVersion builds the binary as if its code was at version v.
+	buildVersion := func(v string) []v1.Descriptor {
+		ng, err := NewGo(
+			context.Background(),
+			"",
+			WithBaseImages(func(context.Context, string) (name.Reference, Result, error) { return baseRef, base, nil }),
+			withBuilder(func(ctx context.Context, ip, dir string, platform v1.Platform, config Config) (string, error) {
+				return writeTempFile(ctx, ip+"@"+v, dir, platform, config)
+			}),
+			withSBOMber(fauxSBOM),
+		)
+		if err != nil {
+			t.Fatalf("NewGo() = %v", err)
+		}
+		result, err := ng.Build(context.Background(), StrictScheme+importpath)
+		if err != nil {
+			t.Fatalf("Build() = %v", err)
+		}
+		img, ok := result.(v1.Image)
+		if !ok {
+			t.Fatalf("Build() not an Image: %T", result)
+		}
+		m, err := img.Manifest()
+		if err != nil {
+			t.Fatalf("Manifest() = %v", err)
+		}
+
+		// The topmost layer only has the binary.
+		ls, err := img.Layers()
+		if err != nil {
+			t.Fatalf("Layers() = %v", err)
+		}
+		r, err := ls[len(ls)-1].Uncompressed()
+		if err != nil {
+			t.Fatalf("Uncompressed() = %v", err)
+		}
+		defer r.Close()
+		tr := tar.NewReader(r)
+		for {
+			header, err := tr.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			} else if err != nil {
+				t.Fatalf("Next() = %v", err)
 			}
+			if header.Typeflag != tar.TypeDir && header.Name != "/ko-app/test" {
+				t.Errorf("binary layer has %s, want only /ko-app/test", header.Name)
+			}
+		}
+		return m.Layers
+	}
+
+	v1Layers, v2Layers := buildVersion("v1"), buildVersion("v2")
+	if len(v1Layers) != int(baseLayers)+2 || len(v2Layers) != len(v1Layers) {
+		t.Fatalf("got %d and %d layers, want %d", len(v1Layers), len(v2Layers), baseLayers+2)
+	}
+	// The base and kodata layers are the same, and only the binary's changes.
+	for i := range v1Layers[:len(v1Layers)-1] {
+		if v1Layers[i].Digest != v2Layers[i].Digest {
+			t.Errorf("layer %d digest changed: %s != %s", i, v1Layers[i].Digest, v2Layers[i].Digest)
+		}
+	}
+	last := len(v1Layers) - 1
+	if v1Layers[last].Digest == v2Layers[last].Digest {
+		t.Errorf("binary layer digest = %s for both versions, want it to change", v1Layers[last].Digest)
+	}
+}
+
+func TestGoBuildOmitEmptyKoData(t *testing.T) {
+	baseLayers := int64(3)
+	base, err := random.Image(1024, baseLayers)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
 
+	for _, tc := range []struct {
+		desc       string
+		importpath string
+		omit       bool
+		wantLayers int64
+	}{{
+		desc:       "absent kodata",
+		importpath: "github.com/google/ko",
+		omit:       true,
+		wantLayers: baseLayers + 1,
+	}, {
+		desc:       "present kodata",
+		importpath: "github.com/google/ko/test",
+		omit:       true,
+		wantLayers: baseLayers + 2,
+	}, {
+		desc:       "absent kodata by default",
+		importpath: "github.com/google/ko",
+		wantLayers: baseLayers + 2,
+	}} {
+		t.Run(tc.desc, func(t *testing.T) {
 			ng, err := NewGo(
 				context.Background(),
 				"",
 				WithBaseImages(func(context.Context, string) (name.Reference, Result, error) { return baseRef, base, nil }),
 				withBuilder(writeTempFile),
 				withSBOMber(fauxSBOM),
+				WithOmitEmptyKoData(tc.omit),
 			)
 			if err != nil {
 				t.Fatalf("NewGo() = %v", err)
 			}
-
-			importpath := "github.com/google/ko"
-
-			result, err := ng.Build(context.Background(), StrictScheme+importpath)
+			result, err := ng.Build(context.Background(), StrictScheme+tc.importpath)
 			if err != nil {
 				t.Fatalf("Build() = %v", err)
 			}
-
 			img, ok := result.(v1.Image)
 			if !ok {
 				t.Fatalf("Build() not an Image: %T", result)
 			}
-
 			ls, err := img.Layers()
 			if err != nil {
 				t.Fatalf("Layers() = %v", err)
 			}
-
-			for i, l := range ls {
-				gotMT, err := l.MediaType()
-				if err != nil {
-					t.Fatal(err)
-				}
-				if gotMT != c.layerMediaType {
-					t.Errorf("layer %d: got mediaType %q, want %q", i, gotMT, c.layerMediaType)
-				}
+			if got := int64(len(ls)); got != tc.wantLayers {
+				t.Errorf("got %d layers, want %d", got, tc.wantLayers)
 			}
-
-			gotMT, err := img.MediaType()
+			cfg, err := img.ConfigFile()
 			if err != nil {
-				t.Fatal(err)
+				t.Fatalf("ConfigFile() = %v", err)
 			}
-			if gotMT != c.mediaType {
-				t.Errorf("got image mediaType %q, want %q", gotMT, c.layerMediaType)
+			if got := int64(len(cfg.History)); got != tc.wantLayers {
+				t.Errorf("got %d history entries, want %d", got, tc.wantLayers)
 			}
 		})
 	}
 }
+
+func TestGoBuildAddedFiles(t *testing.T) {
+	baseLayers := int64(3)
+	base, err := random.Image(1024, baseLayers)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	src := filepath.Join(t.TempDir(), "ca.pem")
+	if err := ioutil.WriteFile(src, []byte("certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	ng, err := NewGo(
+		context.Background(),
+		"",
+		WithBaseImages(func(context.Context, string) (name.Reference, Result, error) { return baseRef, base, nil }),
+		withBuilder(writeTempFile),
+		withSBOMber(fauxSBOM),
+		WithAddedFiles(AddedFile{Source: src, Destination: "/etc/ssl/custom-ca.pem"}),
+	)
+	if err != nil {
+		t.Fatalf("NewGo() = %v", err)
+	}
+	result, err := ng.Build(context.Background(), StrictScheme+"github.com/google/ko/test")
+	if err != nil {
+		t.Fatalf("Build() = %v", err)
+	}
+	img, ok := result.(v1.Image)
+	if !ok {
+		t.Fatalf("Build() not an Image: %T", result)
+	}
+	ls, err := img.Layers()
+	if err != nil {
+		t.Fatalf("Layers() = %v", err)
+	}
+	// The base's, kodata, the added files, then the binary.
+	if got, want := int64(len(ls)), baseLayers+3; got != want {
+		t.Fatalf("got %d layers, want %d", got, want)
+	}
+	r, err := ls[baseLayers+1].Uncompressed()
+	if err != nil {
+		t.Fatalf("Uncompressed() = %v", err)
+	}
+	defer r.Close()
+	tr := tar.NewReader(r)
+	header, err := tr.Next()
+	if err != nil {
+		t.Fatalf("Next() = %v", err)
+	}
+	if header.Name != "/etc/ssl/custom-ca.pem" || header.Mode != 0644 {
+		t.Errorf("got %s with mode %o, want /etc/ssl/custom-ca.pem with mode 644", header.Name, header.Mode)
+	}
+	if b, err := ioutil.ReadAll(tr); err != nil || string(b) != "certificate" {
+		t.Errorf("got contents %q (%v), want %q", b, err, "certificate")
+	}
+	if _, err := tr.Next(); !errors.Is(err, io.EOF) {
+		t.Errorf("Next() = %v, want only the added file", err)
+	}
+
+	if _, err := NewGo(context.Background(), "", WithBaseImages(func(context.Context, string) (name.Reference, Result, error) { return baseRef, base, nil }),
+		WithAddedFiles(AddedFile{Source: src, Destination: "etc/ca.pem"})); err == nil {
+		t.Error("NewGo() with a relative destination = nil, want error")
+	}
+}
+
+func TestIsEmptyKoData(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "empty", "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "nested", "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "nested", "sub", "file"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]bool{
+		"missing": true,
+		"empty":   true,
+		"nested":  false,
+	} {
+		got, err := isEmptyKoData(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("isEmptyKoData(%s) = %v", name, err)
+		}
+		if got != want {
+			t.Errorf("isEmptyKoData(%s) = %t, want %t", name, got, want)
+		}
+	}
+}
@@ -83,11 +83,19 @@ func (g *gobuilds) QualifyImport(importpath string) (string, error) {
 
 // IsSupportedReference implements build.Interface
 func (g *gobuilds) IsSupportedReference(importpath string) error {
+	importpath, err := qualifyLocalRef(g, importpath)
+	if err != nil {
+		return err
+	}
 	return g.builder(importpath).builder.IsSupportedReference(importpath)
 }
 
 // Build implements build.Interface
 func (g *gobuilds) Build(ctx context.Context, importpath string) (Result, error) {
+	importpath, err := qualifyLocalRef(g, importpath)
+	if err != nil {
+		return nil, err
+	}
 	return g.builder(importpath).builder.Build(ctx, importpath)
 }
 
@@ -0,0 +1,155 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// koIgnoreFile is the name of the file, at the root of a kodata directory,
+// listing the files to leave out of the kodata layer, with gitignore syntax.
+const koIgnoreFile = ".ko-ignore"
+
+// ignorePattern is a pattern of a .ko-ignore file.
+type ignorePattern struct {
+	// segments are the path segments to match, where "**" matches any
+	// number of segments.
+	segments []string
+	// negate re-includes what the pattern matches.
+	negate bool
+	// dirOnly only matches directories.
+	dirOnly bool
+}
+
+// koIgnore are the patterns of a .ko-ignore file, in order.
+type koIgnore []ignorePattern
+
+// readKoIgnore reads the .ko-ignore file, if there is one.
+func readKoIgnore(file string) (koIgnore, error) {
+	f, err := os.Open(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var ki koIgnore
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if p, ok := parseIgnorePattern(s.Text()); ok {
+			ki = append(ki, p)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", file, err)
+	}
+	return ki, nil
+}
+
+// parseIgnorePattern parses a line of a .ko-ignore file, returning false for
+// blank lines and comments.
+func parseIgnorePattern(line string) (ignorePattern, bool) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignorePattern{}, false
+	}
+	var p ignorePattern
+	if strings.HasPrefix(line, "!") {
+		p.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\#`) || strings.HasPrefix(line, `\!`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return ignorePattern{}, false
+	}
+
+	// A pattern with a slash (other than a trailing one) is relative to the
+	// kodata directory; otherwise, it matches at any depth.
+	if strings.Contains(line, "/") {
+		p.segments = strings.Split(strings.TrimPrefix(line, "/"), "/")
+	} else {
+		p.segments = []string{"**", line}
+	}
+	// A trailing "/**" matches everything inside, but not the directory.
+	if n := len(p.segments); n > 1 && p.segments[n-1] == "**" {
+		p.segments = append(p.segments[:n-1], "*", "**")
+	}
+	return p, true
+}
+
+// koDataIgnored returns a function for walkRecursive to leave out the files
+// in the kodata directory root, placed at chroot, that match the patterns of
+// its .ko-ignore file, and the file itself.
+func koDataIgnored(root, chroot string) (func(name string, isDir bool) bool, error) {
+	ki, err := readKoIgnore(filepath.Join(root, koIgnoreFile))
+	if err != nil {
+		return nil, err
+	}
+	return func(name string, isDir bool) bool {
+		rel := strings.TrimPrefix(name, chroot+"/")
+		return rel == koIgnoreFile || ki.ignored(rel, isDir)
+	}, nil
+}
+
+// ignored returns whether name, a slash-separated path relative to the
+// kodata directory, is ignored. The last pattern matching it wins.
+func (ki koIgnore) ignored(name string, isDir bool) bool {
+	segments := strings.Split(name, "/")
+	ignored := false
+	for _, p := range ki {
+		if p.dirOnly && !isDir {
+			continue
+		}
+		if matchSegments(p.segments, segments) {
+			ignored = !p.negate
+		}
+	}
+	return ignored
+}
+
+// matchSegments returns whether the path segments name match the pattern
+// segments pattern.
+func matchSegments(pattern, name []string) bool {
+	if len(pattern) == 0 {
+		return len(name) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(name); i++ {
+			if matchSegments(pattern[1:], name[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(name) == 0 {
+		return false
+	}
+	if ok, err := path.Match(pattern[0], name[0]); err != nil || !ok {
+		return false
+	}
+	return matchSegments(pattern[1:], name[1:])
+}
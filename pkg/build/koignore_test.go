@@ -0,0 +1,133 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+const testKoIgnore = `# Test fixtures are too big.
+fixtures/
+!fixtures/small.json
+
+# Anywhere.
+*.tmp
+!keep.tmp
+
+/root-only.txt
+docs/**/*.md
+cache/**
+`
+
+func TestKoIgnore(t *testing.T) {
+	var ki koIgnore
+	for _, line := range strings.Split(testKoIgnore, "\n") {
+		if p, ok := parseIgnorePattern(line); ok {
+			ki = append(ki, p)
+		}
+	}
+
+	for _, tc := range []struct {
+		name  string
+		isDir bool
+		want  bool
+	}{
+		{name: "fixtures", isDir: true, want: true},
+		// A file named like a directory-only pattern isn't ignored.
+		{name: "fixtures", want: false},
+		{name: "sub/fixtures", isDir: true, want: true},
+		{name: "a.tmp", want: true},
+		{name: "deep/down/b.tmp", want: true},
+		{name: "deep/keep.tmp", want: false},
+		{name: "root-only.txt", want: true},
+		{name: "sub/root-only.txt", want: false},
+		{name: "docs/a.md", want: true},
+		{name: "docs/x/y/a.md", want: true},
+		{name: "docs/a.txt", want: false},
+		{name: "cache", isDir: true, want: false},
+		{name: "cache/a", want: true},
+		{name: "cache/a/b", want: true},
+		{name: "index.html", want: false},
+	} {
+		if got := ki.ignored(tc.name, tc.isDir); got != tc.want {
+			t.Errorf("ignored(%q, %t) = %t, want %t", tc.name, tc.isDir, got, tc.want)
+		}
+	}
+}
+
+func TestWalkRecursiveKoIgnore(t *testing.T) {
+	root := t.TempDir()
+	for name, content := range map[string]string{
+		koIgnoreFile:             testKoIgnore,
+		"index.html":             "<html/>",
+		"a.tmp":                  "",
+		"keep.tmp":               "",
+		"fixtures/big.json":      "{}",
+		"fixtures/small.json":    "{}",
+		"docs/guide/intro.md":    "",
+		"docs/guide/diagram.png": "",
+		"cache/entry":            "",
+	} {
+		file := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ignored, err := koDataIgnored(root, kodataRoot)
+	if err != nil {
+		t.Fatalf("koDataIgnored() = %v", err)
+	}
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := walkRecursive(tw, root, kodataRoot, v1.Time{}, &v1.Platform{OS: "linux"}, ignored); err != nil {
+		t.Fatalf("walkRecursive() = %v", err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	tr := tar.NewReader(&buf)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			t.Fatalf("Next() = %v", err)
+		}
+		got = append(got, strings.TrimPrefix(header.Name, kodataRoot+"/"))
+	}
+	// fixtures/small.json can't be re-included, since its directory is
+	// ignored, like with git.
+	want := []string{"docs/guide/diagram.png", "index.html", "keep.tmp"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("kodata (-want +got) = %s", diff)
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"fmt"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+const (
+	// DockerMediaTypes produces images with Docker schema 2 media types.
+	DockerMediaTypes = "docker"
+	// OCIMediaTypes produces images with OCI media types.
+	OCIMediaTypes = "oci"
+)
+
+// dockerToOCI maps the Docker media types of layers to their OCI ones.
+var dockerToOCI = map[types.MediaType]types.MediaType{
+	types.DockerLayer:             types.OCILayer,
+	types.DockerUncompressedLayer: types.OCIUncompressedLayer,
+	types.DockerForeignLayer:      types.OCIRestrictedLayer,
+}
+
+// manifestMediaTypes returns the media types of the manifest, config and
+// index of images with the kind of media types mts.
+func manifestMediaTypes(mts string) (manifest, config, index types.MediaType) {
+	if mts == DockerMediaTypes {
+		return types.DockerManifestSchema2, types.DockerConfigJSON, types.DockerManifestList
+	}
+	return types.OCIManifestSchema1, types.OCIConfigJSON, types.OCIImageIndex
+}
+
+// convertLayerMediaType returns the media type of the kind mts equivalent to the
+// layer media type mt.
+func convertLayerMediaType(mt types.MediaType, mts string) (types.MediaType, error) {
+	for docker, oci := range dockerToOCI {
+		switch {
+		case mts == OCIMediaTypes && mt == docker:
+			return oci, nil
+		case mts == DockerMediaTypes && mt == oci:
+			return docker, nil
+		}
+	}
+	if (mts == DockerMediaTypes) == strings.HasPrefix(string(mt), "application/vnd.docker.") {
+		return mt, nil
+	}
+	return "", fmt.Errorf("layer media type %q has no %s equivalent", mt, mts)
+}
+
+// convertMediaTypes returns img with the kind of media types mts, for its
+// manifest, config and layers. The contents of its blobs, and so their
+// digests, are unchanged.
+func convertMediaTypes(img v1.Image, mts string) (v1.Image, error) {
+	manifestType, configType, _ := manifestMediaTypes(mts)
+	mt, err := img.MediaType()
+	if err != nil {
+		return nil, err
+	}
+	if mt == manifestType {
+		return img, nil
+	}
+
+	m, err := img.Manifest()
+	if err != nil {
+		return nil, err
+	}
+	cf, err := img.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	layers, err := img.Layers()
+	if err != nil {
+		return nil, err
+	}
+	adds := make([]mutate.Addendum, 0, len(layers))
+	for i, l := range layers {
+		desc := m.Layers[i]
+		lmt, err := convertLayerMediaType(desc.MediaType, mts)
+		if err != nil {
+			return nil, err
+		}
+		adds = append(adds, mutate.Addendum{
+			Layer:       l,
+			MediaType:   lmt,
+			URLs:        desc.URLs,
+			Annotations: desc.Annotations,
+		})
+	}
+
+	out := mutate.ConfigMediaType(mutate.MediaType(empty.Image, manifestType), configType)
+	if out, err = mutate.Append(out, adds...); err != nil {
+		return nil, err
+	}
+	// Keep the base's config, including its history.
+	return mutate.ConfigFile(out, cf.DeepCopy())
+}
@@ -15,7 +15,11 @@
 package build
 
 import (
+	"fmt"
+	"path"
 	"strings"
+	"sync"
+	"time"
 
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 )
@@ -29,6 +33,15 @@ func WithBaseImages(gb GetBase) Option {
 	}
 }
 
+// WithPlatformBaseImages is a functional option for overriding the base
+// images that are used for specific platforms of different images.
+func WithPlatformBaseImages(gpb GetPlatformBases) Option {
+	return func(gbo *gobuildOpener) error {
+		gbo.getPlatformBases = gpb
+		return nil
+	}
+}
+
 // WithCreationTime is a functional option for overriding the creation
 // time given to images.
 func WithCreationTime(t v1.Time) Option {
@@ -56,6 +69,15 @@ func WithDisabledOptimizations() Option {
 	}
 }
 
+// WithRace is a functional option for building binaries with the race
+// detector enabled.
+func WithRace() Option {
+	return func(gbo *gobuildOpener) error {
+		gbo.race = true
+		return nil
+	}
+}
+
 // WithDisabledSBOM is a functional option for disabling SBOM generation.
 func WithDisabledSBOM() Option {
 	return func(gbo *gobuildOpener) error {
@@ -73,6 +95,34 @@ func WithTrimpath(v bool) Option {
 	}
 }
 
+// WithStripSymbols is a functional option that controls whether binaries are
+// stripped of their symbol table and DWARF debugging information, by adding
+// `-s -w` to their ldflags. This changes the digests of images.
+func WithStripSymbols(v bool) Option {
+	return func(gbo *gobuildOpener) error {
+		gbo.stripSymbols = v
+		return nil
+	}
+}
+
+// WithGitState is a functional option for providing the git state to the
+// templates in flags and ldflags. state is called at most once, the first
+// time a template refers to the git state.
+func WithGitState(state func() (GitState, error)) Option {
+	return func(gbo *gobuildOpener) error {
+		var once sync.Once
+		var s GitState
+		var err error
+		gbo.gitState = func() (GitState, error) {
+			once.Do(func() {
+				s, err = state()
+			})
+			return s, err
+		}
+		return nil
+	}
+}
+
 // WithConfig is a functional option for providing GoReleaser Build influenced
 // build settings for importpaths.
 //
@@ -117,6 +167,35 @@ func WithLabel(k, v string) Option {
 	}
 }
 
+// WithSourceURL is a functional option for recording the URL of the source
+// code of built images, in their org.opencontainers.image.source label, and
+// in the annotation of the same name of multi-platform indexes. Labels set
+// with WithLabel take precedence.
+func WithSourceURL(url string) Option {
+	return func(gbo *gobuildOpener) error {
+		gbo.sourceURL = url
+		return nil
+	}
+}
+
+// WithUser is a functional option for setting the user (uid, uid:gid, or a
+// name, optionally with a group) that built images run as.
+func WithUser(user string) Option {
+	return func(gbo *gobuildOpener) error {
+		parts := strings.Split(user, ":")
+		if len(parts) > 2 || strings.ContainsAny(user, " \t\n") {
+			return fmt.Errorf("invalid user %q, must be <user>[:<group>]", user)
+		}
+		for _, part := range parts {
+			if part == "" {
+				return fmt.Errorf("invalid user %q, must be <user>[:<group>]", user)
+			}
+		}
+		gbo.user = user
+		return nil
+	}
+}
+
 // withBuilder is a functional option for overriding the way go binaries
 // are built.
 func withBuilder(b builder) Option {
@@ -153,6 +232,16 @@ func WithCycloneDX() Option {
 	}
 }
 
+// WithBaseSBOM is a functional option for merging the SBOMs of base
+// images, as returned by gbs, into the SBOMs generated for the images built
+// on them.
+func WithBaseSBOM(gbs GetBaseSBOM) Option {
+	return func(gbo *gobuildOpener) error {
+		gbo.getBaseSBOM = gbs
+		return nil
+	}
+}
+
 // withSBOMber is a functional option for overriding the way SBOMs
 // are generated.
 func withSBOMber(sbom sbomber) Option {
@@ -169,3 +258,116 @@ func WithJobs(jobs int) Option {
 		return nil
 	}
 }
+
+// WithLayerCompression is a functional option for choosing the algorithm
+// (gzip or zstd) used to compress the layers ko produces.
+func WithLayerCompression(algorithm string) Option {
+	return func(gbo *gobuildOpener) error {
+		switch algorithm {
+		case "", GzipCompression, ZstdCompression:
+		default:
+			return fmt.Errorf("unsupported layer compression %q, must be %q or %q", algorithm, GzipCompression, ZstdCompression)
+		}
+		gbo.compression.algorithm = algorithm
+		return nil
+	}
+}
+
+// WithMediaTypes is a functional option for choosing whether the images
+// ko produces have Docker or OCI media types, converting the base image's.
+// By default, they have the base image's.
+func WithMediaTypes(mts string) Option {
+	return func(gbo *gobuildOpener) error {
+		switch mts {
+		case "", DockerMediaTypes, OCIMediaTypes:
+		default:
+			return fmt.Errorf("unsupported media types %q, must be %q or %q", mts, DockerMediaTypes, OCIMediaTypes)
+		}
+		gbo.mediaTypes = mts
+		return nil
+	}
+}
+
+// WithLayerCompressionLevel is a functional option for overriding the
+// compression level (1-9) of the layers ko produces. Changing the level
+// changes the digests of those layers.
+func WithLayerCompressionLevel(level int) Option {
+	return func(gbo *gobuildOpener) error {
+		if level < 0 || level > 9 {
+			return fmt.Errorf("layer compression level %d is out of range, must be between 1 and 9", level)
+		}
+		gbo.compression.level = level
+		return nil
+	}
+}
+
+// WithOmitEmptyKoData is a functional option for leaving out the kodata
+// layer of images whose kodata directory is absent or empty, rather than
+// adding a layer with no files. This changes the digests of those images.
+func WithOmitEmptyKoData(omit bool) Option {
+	return func(gbo *gobuildOpener) error {
+		gbo.omitEmptyKoData = omit
+		return nil
+	}
+}
+
+// WithAddedFiles is a functional option for adding files from the host to
+// every image, in a layer of their own between kodata and the binaries.
+// Files without a Mode get 0644.
+func WithAddedFiles(files ...AddedFile) Option {
+	return func(gbo *gobuildOpener) error {
+		for _, f := range files {
+			if !path.IsAbs(f.Destination) {
+				return fmt.Errorf("destination of added file %s must be an absolute path, got %q", f.Source, f.Destination)
+			}
+			if f.Mode == 0 {
+				f.Mode = 0644
+			}
+			gbo.addedFiles = append(gbo.addedFiles, f)
+		}
+		return nil
+	}
+}
+
+// WithBuildCommandRecorder is a functional option for calling record with
+// the command line and environment of each binary that is built. It may be
+// called concurrently, for the platforms of a multi-platform image.
+func WithBuildCommandRecorder(record func(BuildCommand)) Option {
+	return func(gbo *gobuildOpener) error {
+		gbo.recordCommand = record
+		return nil
+	}
+}
+
+// WithKeepBaseEntrypoint is a functional option for keeping the entrypoint of
+// the base image, like an init shim, and setting the binary as the first
+// element of the image's Cmd, ahead of any default args, instead of as its
+// entrypoint. Building fails if the base image has no entrypoint.
+func WithKeepBaseEntrypoint(keep bool) Option {
+	return func(gbo *gobuildOpener) error {
+		gbo.keepBaseEntrypoint = keep
+		return nil
+	}
+}
+
+// WithBuildTimeout is a functional option for limiting how long each build
+// of a binary for a single platform may take. The build is killed if it runs
+// longer.
+func WithBuildTimeout(timeout time.Duration) Option {
+	return func(gbo *gobuildOpener) error {
+		if timeout < 0 {
+			return fmt.Errorf("build timeout must not be negative, got %s", timeout)
+		}
+		gbo.buildTimeout = timeout
+		return nil
+	}
+}
+
+// WithBuildCacheDir is a functional option for caching built binaries in dir,
+// keyed on their inputs, so that rebuilding unchanged sources skips compiling.
+func WithBuildCacheDir(dir string) Option {
+	return func(gbo *gobuildOpener) error {
+		gbo.buildCacheDir = dir
+		return nil
+	}
+}
@@ -14,7 +14,10 @@
 
 package build
 
-import "strings"
+import (
+	gb "go/build"
+	"strings"
+)
 
 // StrictScheme is a prefix that can be placed on import paths that users
 // think MUST be supported references.
@@ -46,3 +49,14 @@ func (r reference) String() string {
 	}
 	return r.Path()
 }
+
+// qualifyLocalRef turns a strict reference to a local import path, like
+// ko://./cmd/app, into one to the complete import path with b. Other
+// references are returned unchanged.
+func qualifyLocalRef(b Interface, s string) (string, error) {
+	ref := newRef(s)
+	if !ref.IsStrict() || !gb.IsLocalImport(ref.Path()) {
+		return s, nil
+	}
+	return b.QualifyImport(ref.Path())
+}
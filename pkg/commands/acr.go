@@ -0,0 +1,145 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+)
+
+// acrHostRegexp matches the hosts of Azure Container Registries, in each of
+// the Azure clouds.
+var acrHostRegexp = regexp.MustCompile(`^[a-z0-9]+\.azurecr\.(io|cn|de|us)$`)
+
+// acrUsername is the username to authenticate to ACR with a refresh token.
+const acrUsername = "00000000-0000-0000-0000-000000000000"
+
+// acrKeychain authenticates to Azure Container Registry by exchanging an
+// Azure AD access token for an ACR refresh token. The AD token is obtained
+// with the federated credential in AZURE_FEDERATED_TOKEN_FILE, for the
+// AZURE_CLIENT_ID in AZURE_TENANT_ID, as set up by Azure Workload Identity and
+// azure/login in GitHub Actions.
+//
+// Service principal secrets and managed identities are left to azureKeychain.
+type acrKeychain struct {
+	client *http.Client
+
+	m sync.Mutex
+	// tokens are the refresh tokens of each host.
+	tokens map[string]string
+}
+
+// Resolve implements authn.Keychain.
+func (k *acrKeychain) Resolve(target authn.Resource) (authn.Authenticator, error) {
+	host := target.RegistryStr()
+	if !acrHostRegexp.MatchString(host) {
+		return authn.Anonymous, nil
+	}
+	tokenFile, clientID, tenantID := os.Getenv("AZURE_FEDERATED_TOKEN_FILE"), os.Getenv("AZURE_CLIENT_ID"), os.Getenv("AZURE_TENANT_ID")
+	if tokenFile == "" || clientID == "" || tenantID == "" {
+		return authn.Anonymous, nil
+	}
+
+	k.m.Lock()
+	defer k.m.Unlock()
+	if k.tokens == nil {
+		k.tokens = make(map[string]string)
+	}
+	if _, ok := k.tokens[host]; !ok {
+		assertion, err := ioutil.ReadFile(tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading the Azure federated token: %w", err)
+		}
+		aad, err := k.aadToken(tenantID, clientID, strings.TrimSpace(string(assertion)))
+		if err != nil {
+			return nil, err
+		}
+		refresh, err := k.exchange(host, tenantID, aad)
+		if err != nil {
+			return nil, err
+		}
+		k.tokens[host] = refresh
+	}
+	return authn.FromConfig(authn.AuthConfig{
+		Username:      acrUsername,
+		IdentityToken: k.tokens[host],
+	}), nil
+}
+
+// aadToken returns an Azure AD access token for Azure Resource Manager,
+// for the client in the tenant identified by the federated assertion.
+func (k *acrKeychain) aadToken(tenantID, clientID, assertion string) (string, error) {
+	authority := os.Getenv("AZURE_AUTHORITY_HOST")
+	if authority == "" {
+		authority = "https://login.microsoftonline.com/"
+	}
+	var resp struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := k.postForm(strings.TrimSuffix(authority, "/")+"/"+tenantID+"/oauth2/v2.0/token", url.Values{
+		"grant_type":            {"client_credentials"},
+		"client_id":             {clientID},
+		"client_assertion_type": {"urn:ietf:params:oauth:client-assertion-type:jwt-bearer"},
+		"client_assertion":      {assertion},
+		"scope":                 {"https://management.azure.com/.default"},
+	}, &resp); err != nil {
+		return "", fmt.Errorf("getting an Azure AD token: %w", err)
+	}
+	return resp.AccessToken, nil
+}
+
+// exchange exchanges the Azure AD access token aad for a refresh token of
+// the registry at host.
+func (k *acrKeychain) exchange(host, tenantID, aad string) (string, error) {
+	var resp struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := k.postForm("https://"+host+"/oauth2/exchange", url.Values{
+		"grant_type":   {"access_token"},
+		"service":      {host},
+		"tenant":       {tenantID},
+		"access_token": {aad},
+	}, &resp); err != nil {
+		return "", fmt.Errorf("exchanging the Azure AD token for a refresh token of %s: %w", host, err)
+	}
+	return resp.RefreshToken, nil
+}
+
+// postForm posts form to u, and decodes the JSON response into v.
+func (k *acrKeychain) postForm(u string, form url.Values, v interface{}) error {
+	client := k.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.PostForm(u, form)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("POST %s: %s: %s", u, resp.Status, strings.TrimSpace(string(b)))
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,136 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// handlerTransport serves every request with a handler.
+type handlerTransport struct {
+	h http.Handler
+}
+
+func (t handlerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rec := httptest.NewRecorder()
+	t.h.ServeHTTP(rec, req)
+	return rec.Result(), nil
+}
+
+func TestACRHost(t *testing.T) {
+	for host, want := range map[string]bool{
+		"myregistry.azurecr.io":      true,
+		"myregistry.azurecr.cn":      true,
+		"myregistry.azurecr.us":      true,
+		"azurecr.io":                 false,
+		"myregistry.azurecr.io.evil": false,
+		"gcr.io":                     false,
+	} {
+		if got := acrHostRegexp.MatchString(host); got != want {
+			t.Errorf("acrHostRegexp.MatchString(%q) = %t, want %t", host, got, want)
+		}
+	}
+}
+
+func TestACRKeychain(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := ioutil.WriteFile(tokenFile, []byte("federated-jwt\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("AZURE_FEDERATED_TOKEN_FILE", tokenFile)
+	t.Setenv("AZURE_CLIENT_ID", "client")
+	t.Setenv("AZURE_TENANT_ID", "tenant")
+	t.Setenv("AZURE_AUTHORITY_HOST", "https://login.example.com/")
+
+	var requests []string
+	forms := make(map[string]url.Values)
+	k := &acrKeychain{client: &http.Client{Transport: handlerTransport{http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Error(err)
+		}
+		u := r.Method + " " + r.URL.String()
+		requests = append(requests, u)
+		forms[u] = r.PostForm
+		switch u {
+		case "POST https://login.example.com/tenant/oauth2/v2.0/token":
+			w.Write([]byte(`{"access_token": "aad-token"}`))
+		case "POST https://myregistry.azurecr.io/oauth2/exchange":
+			w.Write([]byte(`{"refresh_token": "acr-token"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	})}}}
+
+	repo, err := name.NewRepository("myregistry.azurecr.io/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		auth, err := k.Resolve(repo)
+		if err != nil {
+			t.Fatalf("Resolve() = %v", err)
+		}
+		got, err := auth.Authorization()
+		if err != nil {
+			t.Fatalf("Authorization() = %v", err)
+		}
+		want := authn.AuthConfig{Username: acrUsername, IdentityToken: "acr-token"}
+		if diff := cmp.Diff(want, *got); diff != "" {
+			t.Errorf("Authorization() (-want +got) = %s", diff)
+		}
+	}
+
+	// The tokens are exchanged once.
+	wantRequests := []string{
+		"POST https://login.example.com/tenant/oauth2/v2.0/token",
+		"POST https://myregistry.azurecr.io/oauth2/exchange",
+	}
+	if diff := cmp.Diff(wantRequests, requests); diff != "" {
+		t.Errorf("requests (-want +got) = %s", diff)
+	}
+	wantForms := map[string]url.Values{
+		wantRequests[0]: {
+			"grant_type":            {"client_credentials"},
+			"client_id":             {"client"},
+			"client_assertion_type": {"urn:ietf:params:oauth:client-assertion-type:jwt-bearer"},
+			"client_assertion":      {"federated-jwt"},
+			"scope":                 {"https://management.azure.com/.default"},
+		},
+		wantRequests[1]: {
+			"grant_type":   {"access_token"},
+			"service":      {"myregistry.azurecr.io"},
+			"tenant":       {"tenant"},
+			"access_token": {"aad-token"},
+		},
+	}
+	if diff := cmp.Diff(wantForms, forms); diff != "" {
+		t.Errorf("forms (-want +got) = %s", diff)
+	}
+
+	// Other registries are left to the rest of the chain.
+	other, _ := name.NewRepository("gcr.io/project/app")
+	if auth, err := k.Resolve(other); err != nil || auth != authn.Anonymous {
+		t.Errorf("Resolve(%s) = %v, %v, want anonymous", other, auth, err)
+	}
+}
@@ -15,10 +15,13 @@
 package commands
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"time"
 
 	"github.com/google/ko/pkg/commands/options"
 	"github.com/spf13/cobra"
@@ -31,6 +34,10 @@ func addApply(topLevel *cobra.Command) {
 	fo := &options.FilenameOptions{}
 	so := &options.SelectorOptions{}
 	bo := &options.BuildOptions{}
+	ko := &options.KubectlOptions{}
+	var dryRun string
+	var wait bool
+	var waitTimeout time.Duration
 	apply := &cobra.Command{
 		Use:   "apply -f FILENAME",
 		Short: "Apply the input files with image references resolved to built/pushed image digests.",
@@ -59,13 +66,34 @@ func addApply(topLevel *cobra.Command) {
   # Apply from stdin:
   cat config.yaml | ko apply -f -
 
+  # Build, publish and apply again whenever the sources of the
+  # referenced import paths (or their kodata) change, until interrupted:
+  ko apply --watch -f config/
+
+  # Build and publish, then show what "kubectl apply" would
+  # change, as validated by the API server, without changing it:
+  ko apply --dry-run=server -f config/
+
+  # Apply, then wait up to 10 minutes for the rollouts of the
+  # Deployments, StatefulSets and DaemonSets applied to complete:
+  ko apply --wait --wait-timeout=10m -f config/
+
+  # Use another kubeconfig context and namespace:
+  ko apply --context=staging --namespace=foo -f config/
+
   # Any flags passed after '--' are passed to 'kubectl apply' directly:
-  ko apply -f config -- --namespace=foo --kubeconfig=cfg.yaml
+  ko apply -f config -- --kubeconfig=cfg.yaml
 `,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if err := options.Validate(po, bo); err != nil {
 				return fmt.Errorf("validating options: %w", err)
 			}
+			if dryRun != "" && dryRun != "server" && dryRun != "client" {
+				return fmt.Errorf("invalid --dry-run %q: must be \"server\" or \"client\"", dryRun)
+			}
+			if wait && (fo.Watch || dryRun != "") {
+				return errors.New("--wait can't be used with --watch or --dry-run")
+			}
 
 			if !isKubectlAvailable() {
 				return errors.New("error: kubectl is not available. kubectl must be installed to use ko apply")
@@ -77,7 +105,7 @@ func addApply(topLevel *cobra.Command) {
 			if err != nil {
 				return fmt.Errorf("error creating builder: %w", err)
 			}
-			publisher, err := makePublisher(po)
+			publisher, err := makePublisher(po, bo.WorkingDirectory)
 			if err != nil {
 				return fmt.Errorf("error creating publisher: %w", err)
 			}
@@ -86,7 +114,10 @@ func addApply(topLevel *cobra.Command) {
 			// Issue a "kubectl apply" command reading from stdin,
 			// to which we will pipe the resolved files, and any
 			// remaining flags passed after '--'.
-			kubectlCmd := exec.CommandContext(ctx, "kubectl", append([]string{"apply", "-f", "-"}, args...)...)
+			if dryRun != "" {
+				args = append([]string{"--dry-run=" + dryRun}, args...)
+			}
+			kubectlCmd := exec.CommandContext(ctx, "kubectl", kubectlArgs("apply", ko, args...)...)
 
 			// Pass through our environment
 			kubectlCmd.Env = os.Environ()
@@ -100,6 +131,13 @@ func addApply(topLevel *cobra.Command) {
 				return fmt.Errorf("error piping to 'kubectl apply': %w", err)
 			}
 
+			// Keep what's applied, to wait for its rollouts.
+			var applied bytes.Buffer
+			var out io.WriteCloser = stdin
+			if wait {
+				out = teeWriteCloser{Writer: io.MultiWriter(stdin, &applied), Closer: stdin}
+			}
+
 			// Make sure builds are cancelled if kubectl apply fails.
 			g, ctx := errgroup.WithContext(ctx)
 			g.Go(func() error {
@@ -114,7 +152,7 @@ func addApply(topLevel *cobra.Command) {
 					stdin.Write([]byte("---\n"))
 				}
 				// Once primed kick things off.
-				return resolveFilesToWriter(ctx, builder, publisher, fo, so, stdin)
+				return resolveFilesToWriter(ctx, builder, publisher, fo, so, bo, out)
 			})
 
 			g.Go(func() error {
@@ -125,13 +163,31 @@ func addApply(topLevel *cobra.Command) {
 				return nil
 			})
 
-			return g.Wait()
+			if err := g.Wait(); err != nil {
+				// Interrupting is how a watch is meant to end.
+				if fo.Watch && cmd.Context().Err() != nil {
+					return nil
+				}
+				return err
+			}
+			if wait {
+				return waitForRollouts(cmd.Context(), applied.Bytes(), ko, waitTimeout)
+			}
+			return nil
 		},
 	}
 	options.AddPublishArg(apply, po)
 	options.AddFileArg(apply, fo)
+	options.AddWatchArg(apply, fo)
 	options.AddSelectorArg(apply, so)
 	options.AddBuildOptions(apply, bo)
+	options.AddKubectlArgs(apply, ko)
+	apply.Flags().BoolVar(&wait, "wait", false,
+		"Wait for the rollouts of the Deployments, StatefulSets and DaemonSets applied to complete, and fail if they don't.")
+	apply.Flags().DurationVar(&waitTimeout, "wait-timeout", 5*time.Minute,
+		"How long --wait waits for all the rollouts to complete.")
+	apply.Flags().StringVar(&dryRun, "dry-run", "",
+		`If "server" or "client", build and publish the images as usual, but only pass the resolved files to "kubectl apply --dry-run" to print what would change.`)
 
 	topLevel.AddCommand(apply)
 }
@@ -23,8 +23,11 @@ import (
 
 // addBuild augments our CLI surface with build.
 func addBuild(topLevel *cobra.Command) {
-	po := &options.PublishOptions{}
+	// ko build prints image references to stderr instead when the tarball
+	// is streamed to stdout.
+	po := &options.PublishOptions{AllowTarballToStdout: true}
 	bo := &options.BuildOptions{}
+	var output string
 
 	build := &cobra.Command{
 		Use:     "build IMPORTPATH...",
@@ -54,11 +57,18 @@ func addBuild(topLevel *cobra.Command) {
   # Build and publish import path references to a Docker daemon as:
   #   ko.local/<import path>
   # This always preserves import paths.
-  ko build --local github.com/foo/bar/cmd/baz github.com/foo/bar/cmd/blah`,
+  ko build --local github.com/foo/bar/cmd/baz github.com/foo/bar/cmd/blah
+
+  # Print the import path, reference, digest and size of each published
+  # image as JSON.
+  ko build --output=json ./cmd/blah`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if err := options.Validate(po, bo); err != nil {
 				return fmt.Errorf("validating options: %w", err)
 			}
+			if output != "" && output != "json" {
+				return fmt.Errorf("invalid --output %q, must be \"json\"", output)
+			}
 
 			if len(args) == 0 {
 				// Build the current directory by default.
@@ -72,21 +82,31 @@ func addBuild(topLevel *cobra.Command) {
 			if err != nil {
 				return fmt.Errorf("error creating builder: %w", err)
 			}
-			publisher, err := makePublisher(po)
+			publisher, err := makePublisher(po, bo.WorkingDirectory)
 			if err != nil {
 				return fmt.Errorf("error creating publisher: %w", err)
 			}
 			defer publisher.Close()
-			images, err := publishImages(ctx, args, publisher, builder)
+			images, err := publishImages(ctx, withStrictScheme(args, bo.Scheme), publisher, builder, po.PushConcurrency)
 			if err != nil {
 				return fmt.Errorf("failed to publish images: %w", err)
 			}
+			// Keep stdout for the tarball, if it's streamed there.
+			out := cmd.OutOrStdout()
+			if po.TarballToStdout() {
+				out = cmd.ErrOrStderr()
+			}
+			if output == "json" {
+				return writeBuildOutput(ctx, out, builder, images)
+			}
 			for _, img := range images {
-				fmt.Println(img)
+				fmt.Fprintln(out, img)
 			}
 			return nil
 		},
 	}
+	build.Flags().StringVar(&output, "output", "",
+		"Output format. If set to json, print a JSON array describing each published image's import path, reference, digest and size instead of one reference per line.")
 	options.AddPublishArg(build, po)
 	options.AddBuildOptions(build, bo)
 	topLevel.AddCommand(build)
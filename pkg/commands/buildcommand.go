@@ -0,0 +1,187 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/sigstore/cosign/pkg/oci"
+
+	"github.com/google/ko/pkg/build"
+	"github.com/google/ko/pkg/publish"
+)
+
+const koBuildCommandType = "https://github.com/google/ko/build-command@v1"
+
+// buildCommandStatement is an in-toto statement with the commands that built
+// the binaries of an image.
+type buildCommandStatement struct {
+	Type          string                `json:"_type"`
+	PredicateType string                `json:"predicateType"`
+	Subject       []subject             `json:"subject"`
+	Predicate     buildCommandPredicate `json:"predicate"`
+}
+
+type buildCommandPredicate struct {
+	Commands []buildCommandEntry `json:"commands"`
+}
+
+type buildCommandEntry struct {
+	ImportPath string   `json:"importPath"`
+	Platform   string   `json:"platform"`
+	GoVersion  string   `json:"goVersion"`
+	Args       []string `json:"args"`
+	Env        []string `json:"env"`
+}
+
+// buildCommandStore keeps the latest command each binary of each image was
+// built with, for each platform.
+type buildCommandStore struct {
+	mu   sync.Mutex
+	cmds map[string]map[string]build.BuildCommand
+}
+
+// buildCommands is where the builders made by makeBuilder record their
+// commands, for --attest-build-command.
+var buildCommands = &buildCommandStore{}
+
+// record implements the recorder of build.WithBuildCommandRecorder.
+func (s *buildCommandStore) record(c build.BuildCommand) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cmds == nil {
+		s.cmds = map[string]map[string]build.BuildCommand{}
+	}
+	if s.cmds[c.Image] == nil {
+		s.cmds[c.Image] = map[string]build.BuildCommand{}
+	}
+	s.cmds[c.Image][c.Platform.String()+" "+c.ImportPath] = c
+}
+
+// get returns the commands of image, sorted by platform and import path.
+func (s *buildCommandStore) get(image string) []build.BuildCommand {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0, len(s.cmds[image]))
+	for k := range s.cmds[image] {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	cmds := make([]build.BuildCommand, 0, len(keys))
+	for _, k := range keys {
+		cmds = append(cmds, s.cmds[image][k])
+	}
+	return cmds
+}
+
+// buildCommandAttester returns a publish.Attester for the commands recorded
+// in s that built each image. The attestations aren't signed.
+func buildCommandAttester(s *buildCommandStore) publish.Attester {
+	return func(ctx context.Context, importpath string, dig name.Digest) (oci.Signature, error) {
+		st, err := buildCommandAttestation(ctx, s.get(importpath), dig)
+		if err != nil {
+			return nil, fmt.Errorf("attesting the build command of %s: %w", importpath, err)
+		}
+		return unsignedAttestation(st)
+	}
+}
+
+// buildCommandAttestation returns the statement that the image dig was built
+// with cmds, with secrets redacted from their environments.
+func buildCommandAttestation(ctx context.Context, cmds []build.BuildCommand, dig name.Digest) (*buildCommandStatement, error) {
+	if len(cmds) == 0 {
+		return nil, fmt.Errorf("no build command was recorded for %s", dig)
+	}
+	h, err := v1.NewHash(dig.DigestStr())
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]buildCommandEntry, 0, len(cmds))
+	for _, c := range cmds {
+		version, err := c.GoVersion(ctx)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, buildCommandEntry{
+			ImportPath: c.ImportPath,
+			Platform:   c.Platform.String(),
+			GoVersion:  version,
+			Args:       c.Args,
+			Env:        redactEnv(c.Env),
+		})
+	}
+	return &buildCommandStatement{
+		Type:          inTotoStatementType,
+		PredicateType: koBuildCommandType,
+		Subject: []subject{{
+			Name:   dig.Context().Name(),
+			Digest: map[string]string{h.Algorithm: h.Hex},
+		}},
+		Predicate: buildCommandPredicate{Commands: entries},
+	}, nil
+}
+
+// secretEnvPattern matches the names of environment variables that likely
+// hold secrets.
+var secretEnvPattern = regexp.MustCompile(`(?i)(SECRET|TOKEN|PASSWORD|PASSWD|CREDENTIAL|AUTH|API_?KEY|PRIVATE_?KEY|ACCESS_?KEY)`)
+
+const redacted = "REDACTED"
+
+// redactEnv returns env with the values of variables whose names look like
+// secrets replaced, and passwords in URLs, like in GOPROXY, removed.
+func redactEnv(env []string) []string {
+	out := make([]string, 0, len(env))
+	for _, kv := range env {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			out = append(out, kv)
+			continue
+		}
+		k, v := parts[0], parts[1]
+		if secretEnvPattern.MatchString(k) {
+			v = redacted
+		} else {
+			v = redactURLs(v)
+		}
+		out = append(out, k+"="+v)
+	}
+	return out
+}
+
+// redactURLs replaces the user info of the URLs in the comma or pipe
+// separated list v, like GOPROXY and GOPRIVATE values.
+func redactURLs(v string) string {
+	if !strings.Contains(v, "@") {
+		return v
+	}
+	fields := strings.FieldsFunc(v, func(r rune) bool { return r == ',' || r == '|' })
+	for _, f := range fields {
+		u, err := url.Parse(f)
+		if err != nil || u.User == nil {
+			continue
+		}
+		u.User = url.User(redacted)
+		v = strings.Replace(v, f, u.String(), 1)
+	}
+	return v
+}
@@ -0,0 +1,141 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/ko/pkg/build"
+)
+
+// buildOutput describes one published image for `ko build --output=json`.
+type buildOutput struct {
+	ImportPath string `json:"importPath"`
+	Reference  string `json:"reference"`
+	Digest     string `json:"digest"`
+	// Size is the size of the config and compressed layers, summed over
+	// every platform for multi-platform images.
+	Size      int64            `json:"size"`
+	Platforms []platformOutput `json:"platforms,omitempty"`
+}
+
+// platformOutput describes a single platform of a multi-platform image.
+type platformOutput struct {
+	Platform string `json:"platform"`
+	Digest   string `json:"digest"`
+	Size     int64  `json:"size"`
+}
+
+// writeBuildOutput writes images, the result of publishImages, to w as a JSON
+// array sorted by import path. The build results are looked up again through
+// b, which is expected to be caching so nothing is rebuilt.
+func writeBuildOutput(ctx context.Context, w io.Writer, b build.Interface, images map[string]name.Reference) error {
+	importpaths := make([]string, 0, len(images))
+	for ip := range images {
+		importpaths = append(importpaths, ip)
+	}
+	sort.Strings(importpaths)
+
+	out := make([]buildOutput, 0, len(importpaths))
+	for _, ip := range importpaths {
+		res, err := b.Build(ctx, ip)
+		if err != nil {
+			return fmt.Errorf("error building %q: %w", ip, err)
+		}
+		o, err := describeResult(res)
+		if err != nil {
+			return fmt.Errorf("describing %q: %w", ip, err)
+		}
+		o.ImportPath = strings.TrimPrefix(ip, build.StrictScheme)
+		o.Reference = images[ip].String()
+		out = append(out, o)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+// describeResult fills in the digest, size and platforms of a build result.
+func describeResult(res build.Result) (buildOutput, error) {
+	d, err := res.Digest()
+	if err != nil {
+		return buildOutput{}, err
+	}
+	o := buildOutput{Digest: d.String()}
+
+	switch r := res.(type) {
+	case v1.ImageIndex:
+		im, err := r.IndexManifest()
+		if err != nil {
+			return buildOutput{}, err
+		}
+		for _, desc := range im.Manifests {
+			if !desc.MediaType.IsImage() {
+				continue
+			}
+			img, err := r.Image(desc.Digest)
+			if err != nil {
+				return buildOutput{}, err
+			}
+			size, err := imageSize(img)
+			if err != nil {
+				return buildOutput{}, err
+			}
+			o.Size += size
+			o.Platforms = append(o.Platforms, platformOutput{
+				Platform: platformName(desc.Platform),
+				Digest:   desc.Digest.String(),
+				Size:     size,
+			})
+		}
+	case v1.Image:
+		if o.Size, err = imageSize(r); err != nil {
+			return buildOutput{}, err
+		}
+	default:
+		return buildOutput{}, fmt.Errorf("result type %T is not supported", res)
+	}
+	return o, nil
+}
+
+// imageSize returns the size of img's config and compressed layers, which is
+// roughly what a registry stores and a client pulls.
+func imageSize(img v1.Image) (int64, error) {
+	m, err := img.Manifest()
+	if err != nil {
+		return 0, err
+	}
+	size := m.Config.Size
+	for _, l := range m.Layers {
+		size += l.Size
+	}
+	return size, nil
+}
+
+// platformName formats p as os/arch[/variant].
+func platformName(p *v1.Platform) string {
+	if p == nil {
+		return ""
+	}
+	return p.String()
+}
@@ -0,0 +1,136 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/ko/pkg/build"
+)
+
+// resultBuilder returns fixed results for each import path.
+type resultBuilder map[string]build.Result
+
+func (b resultBuilder) QualifyImport(ip string) (string, error) { return ip, nil }
+
+func (b resultBuilder) IsSupportedReference(string) error { return nil }
+
+func (b resultBuilder) Build(_ context.Context, ip string) (build.Result, error) {
+	res, ok := b[ip]
+	if !ok {
+		return nil, fmt.Errorf("unexpected import path %q", ip)
+	}
+	return res, nil
+}
+
+func TestWriteBuildOutput(t *testing.T) {
+	img, err := random.Image(1024, 2)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	idx, err := random.Index(512, 1, 2)
+	if err != nil {
+		t.Fatalf("random.Index() = %v", err)
+	}
+
+	b := resultBuilder{
+		"ko://example.com/single": img,
+		"ko://example.com/multi":  idx,
+	}
+	images := map[string]name.Reference{}
+	for ip, res := range b {
+		d, err := res.Digest()
+		if err != nil {
+			t.Fatalf("Digest() = %v", err)
+		}
+		images[ip], err = name.NewDigest("registry.example.com/app@" + d.String())
+		if err != nil {
+			t.Fatalf("name.NewDigest() = %v", err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := writeBuildOutput(context.Background(), &buf, b, images); err != nil {
+		t.Fatalf("writeBuildOutput() = %v", err)
+	}
+	var got []buildOutput
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("json.Unmarshal() = %v\n%s", err, buf.String())
+	}
+	if len(got) != 2 {
+		t.Fatalf("got %d entries, want 2:\n%s", len(got), buf.String())
+	}
+
+	// Entries are sorted by import path, without the ko:// scheme.
+	multi, single := got[0], got[1]
+	if multi.ImportPath != "example.com/multi" || single.ImportPath != "example.com/single" {
+		t.Errorf("import paths = %q, %q", multi.ImportPath, single.ImportPath)
+	}
+
+	wantSize, err := imageSize(img)
+	if err != nil {
+		t.Fatalf("imageSize() = %v", err)
+	}
+	d, _ := img.Digest()
+	if single.Digest != d.String() || single.Size != wantSize || len(single.Platforms) != 0 {
+		t.Errorf("single = %+v, want digest %s and size %d", single, d, wantSize)
+	}
+	if single.Reference != images["ko://example.com/single"].String() {
+		t.Errorf("single reference = %s", single.Reference)
+	}
+
+	im, err := idx.IndexManifest()
+	if err != nil {
+		t.Fatalf("IndexManifest() = %v", err)
+	}
+	if len(multi.Platforms) != len(im.Manifests) {
+		t.Fatalf("got %d platforms, want %d", len(multi.Platforms), len(im.Manifests))
+	}
+	var total int64
+	for i, p := range multi.Platforms {
+		child, err := idx.Image(im.Manifests[i].Digest)
+		if err != nil {
+			t.Fatalf("Image() = %v", err)
+		}
+		size, err := imageSize(child)
+		if err != nil {
+			t.Fatalf("imageSize() = %v", err)
+		}
+		if p.Digest != im.Manifests[i].Digest.String() || p.Size != size {
+			t.Errorf("platform %d = %+v, want digest %s and size %d", i, p, im.Manifests[i].Digest, size)
+		}
+		total += size
+	}
+	if multi.Size != total {
+		t.Errorf("multi size = %d, want sum of platforms %d", multi.Size, total)
+	}
+}
+
+func TestPlatformName(t *testing.T) {
+	if got := platformName(nil); got != "" {
+		t.Errorf("platformName(nil) = %q", got)
+	}
+	p := &v1.Platform{OS: "linux", Architecture: "arm", Variant: "v7"}
+	if got, want := platformName(p), "linux/arm/v7"; got != want {
+		t.Errorf("platformName() = %q, want %q", got, want)
+	}
+}
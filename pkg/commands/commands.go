@@ -32,6 +32,7 @@ func AddKubeCommands(topLevel *cobra.Command) {
 	addBuild(topLevel)
 	addRun(topLevel)
 	addDeps(topLevel)
+	addDiff(topLevel)
 }
 
 // check if kubectl is installed
@@ -18,10 +18,15 @@ package commands
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net/http"
 	"os"
+	"path"
+	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
 	"sync"
@@ -35,7 +40,12 @@ import (
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/daemon"
 	"github.com/google/go-containerregistry/pkg/v1/google"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	ociremote "github.com/sigstore/cosign/pkg/oci/remote"
 
 	"github.com/google/ko/pkg/build"
 	"github.com/google/ko/pkg/commands/options"
@@ -47,84 +57,426 @@ var (
 	azureKeychain  authn.Keychain = authn.NewKeychainFromHelper(credhelper.NewACRCredentialsHelper())
 	keychain                      = authn.NewMultiKeychain(
 		amazonKeychain,
+		&externalAccountKeychain{},
+		credHelperKeychain{},
 		authn.DefaultKeychain,
 		google.Keychain,
 		github.Keychain,
+		&acrKeychain{},
 		azureKeychain,
 	)
 )
 
 // getBaseImage returns a function that determines the base image for a given import path.
 func getBaseImage(bo *options.BuildOptions) build.GetBase {
+	fetch := baseImageFetcher(bo)
+	return func(ctx context.Context, s string) (name.Reference, build.Result, error) {
+		s = strings.TrimPrefix(s, build.StrictScheme)
+		// Viper configuration file keys are case insensitive, and are
+		// returned as all lowercase.  This means that import paths with
+		// uppercase must be normalized for matching here, e.g.
+		//    github.com/GoogleCloudPlatform/foo/cmd/bar
+		// comes through as:
+		//    github.com/googlecloudplatform/foo/cmd/bar
+		baseImage, ok := bo.BaseImageOverrides[strings.ToLower(s)]
+		if !ok || baseImage == "" {
+			baseImage = bo.BaseImage
+		}
+		if bo.Debug {
+			var err error
+			if baseImage, err = debugBaseImage(bo, baseImage); err != nil {
+				return nil, nil, err
+			}
+		}
+		return fetch(ctx, s, baseImage)
+	}
+}
+
+// getPlatformBaseImages returns a function that determines the per-platform
+// base image overrides for a given import path.
+func getPlatformBaseImages(bo *options.BuildOptions) build.GetPlatformBases {
+	fetch := baseImageFetcher(bo)
+	return func(ctx context.Context, s string) (map[string]build.PlatformBase, error) {
+		s = strings.TrimPrefix(s, build.StrictScheme)
+		// See getBaseImage for why we lowercase here.
+		overrides := bo.PlatformBaseImageOverrides[strings.ToLower(s)]
+		if len(overrides) == 0 {
+			return nil, nil
+		}
+		bases := make(map[string]build.PlatformBase, len(overrides))
+		for platform, baseImage := range overrides {
+			if bo.Debug {
+				var err error
+				if baseImage, err = debugBaseImage(bo, baseImage); err != nil {
+					return nil, err
+				}
+			}
+			ref, result, err := fetch(ctx, s, baseImage)
+			if err != nil {
+				return nil, err
+			}
+			bases[platform] = build.PlatformBase{Ref: ref, Base: result}
+		}
+		return bases, nil
+	}
+}
+
+// debugBaseImage returns the base image to use in place of baseImage with
+// --debug.
+func debugBaseImage(bo *options.BuildOptions, baseImage string) (string, error) {
+	if bo.DebugBaseImage != "" {
+		return bo.DebugBaseImage, nil
+	}
+	return options.DebugBase(baseImage)
+}
+
+// baseImageFetcher returns a function that fetches (and caches) the given
+// base image for an import path.
+func baseImageFetcher(bo *options.BuildOptions) func(context.Context, string, string) (name.Reference, build.Result, error) {
 	var cache sync.Map
+	var nameOpts []name.Option
+	if bo.InsecureRegistry {
+		nameOpts = append(nameOpts, name.Insecure)
+	}
+	// These have already been validated by gobuildOptions.
+	mirrors, _ := parseBaseImageMirrors(bo.BaseImageMirrors)
 	fetch := func(ctx context.Context, ref name.Reference) (build.Result, error) {
 		// For ko.local, look in the daemon.
 		if ref.Context().RegistryStr() == publish.LocalDomain {
 			return daemon.Image(ref)
 		}
 
-		userAgent := ua()
-		if bo.UserAgent != "" {
-			userAgent = bo.UserAgent
+		ropt := baseRemoteOptions(ctx, bo)
+		desc, err := getFromMirror(ref, mirrors, nameOpts, ropt)
+		if desc == nil && err == nil {
+			desc, err = remote.Get(ref, ropt...)
 		}
-		ropt := []remote.Option{
-			remote.WithAuthFromKeychain(keychain),
-			remote.WithUserAgent(userAgent),
-			remote.WithContext(ctx),
-		}
-
-		desc, err := remote.Get(ref, ropt...)
 		if err != nil {
 			return nil, err
 		}
 		if desc.MediaType.IsIndex() {
-			return desc.ImageIndex()
+			idx, err := desc.ImageIndex()
+			if err != nil {
+				return nil, err
+			}
+			return &cachingIndex{imageIndex: idx}, nil
 		}
 		return desc.Image()
 	}
-	return func(ctx context.Context, s string) (name.Reference, build.Result, error) {
-		s = strings.TrimPrefix(s, build.StrictScheme)
-		// Viper configuration file keys are case insensitive, and are
-		// returned as all lowercase.  This means that import paths with
-		// uppercase must be normalized for matching here, e.g.
-		//    github.com/GoogleCloudPlatform/foo/cmd/bar
-		// comes through as:
-		//    github.com/googlecloudplatform/foo/cmd/bar
-		baseImage, ok := bo.BaseImageOverrides[strings.ToLower(s)]
-		if !ok || baseImage == "" {
-			baseImage = bo.BaseImage
-		}
-		var nameOpts []name.Option
-		if bo.InsecureRegistry {
-			nameOpts = append(nameOpts, name.Insecure)
+	// load fetches the base at most once for key, however many import paths
+	// (and concurrent builds) share it.
+	load := func(key string, f func() (name.Reference, build.Result, error)) (name.Reference, build.Result, error) {
+		v, _ := cache.LoadOrStore(key, &baseEntry{})
+		e := v.(*baseEntry)
+		e.once.Do(func() {
+			e.ref, e.result, e.err = f()
+		})
+		return e.ref, e.result, e.err
+	}
+	return func(ctx context.Context, s string, baseImage string) (name.Reference, build.Result, error) {
+		if strings.HasPrefix(baseImage, options.TarballBaseScheme) || strings.HasPrefix(baseImage, options.LayoutBaseScheme) {
+			return load(baseImage, func() (name.Reference, build.Result, error) {
+				ref, result, err := loadLocalBase(baseImage, bo.WorkingDirectory)
+				if err != nil {
+					return nil, nil, fmt.Errorf("loading base image %s: %w", baseImage, err)
+				}
+				dig, err := result.Digest()
+				if err != nil {
+					return nil, nil, err
+				}
+				log.Printf("Using base %s@%s for %s", baseImage, dig, s)
+				return ref, result, nil
+			})
 		}
+
 		ref, err := name.ParseReference(baseImage, nameOpts...)
 		if err != nil {
 			return nil, nil, fmt.Errorf("parsing base image (%q): %w", baseImage, err)
 		}
 
-		if v, ok := cache.Load(ref.String()); ok {
-			return ref, v.(build.Result), nil
+		return load(ref.String(), func() (name.Reference, build.Result, error) {
+			result, err := fetch(ctx, ref)
+			if err != nil {
+				return ref, result, err
+			}
+
+			if _, ok := ref.(name.Digest); ok {
+				log.Printf("Using base %s for %s", ref, s)
+			} else {
+				dig, err := result.Digest()
+				if err != nil {
+					return ref, result, err
+				}
+				log.Printf("Using base %s@%s for %s", ref, dig, s)
+			}
+			return ref, result, nil
+		})
+	}
+}
+
+// baseEntry is a base image that's being, or has been, fetched.
+type baseEntry struct {
+	once   sync.Once
+	ref    name.Reference
+	result build.Result
+	err    error
+}
+
+// cachingIndex is a base index that resolves the image for each of its
+// platforms once, so that every import path built on it shares the image's
+// manifest and config rather than fetching them again.
+type cachingIndex struct {
+	imageIndex
+	images sync.Map // v1.Hash -> *imageEntry
+}
+
+// imageIndex lets cachingIndex embed v1.ImageIndex without the field's name
+// hiding the ImageIndex method.
+type imageIndex = v1.ImageIndex
+
+var _ v1.ImageIndex = (*cachingIndex)(nil)
+
+// imageEntry is an image from a cachingIndex.
+type imageEntry struct {
+	once sync.Once
+	img  v1.Image
+	err  error
+}
+
+// Image implements v1.ImageIndex.
+func (c *cachingIndex) Image(h v1.Hash) (v1.Image, error) {
+	v, _ := c.images.LoadOrStore(h, &imageEntry{})
+	e := v.(*imageEntry)
+	e.once.Do(func() {
+		e.img, e.err = c.imageIndex.Image(h)
+	})
+	return e.img, e.err
+}
+
+const (
+	// tarballBaseDomain and layoutBaseDomain are sentinel "registries" for
+	// the references to base images read from disk, which have no registry
+	// to fetch SBOMs from.
+	tarballBaseDomain = "tarball.local"
+	layoutBaseDomain  = "oci.local"
+)
+
+// invalidRepoChars matches the runs of characters that can't be in a
+// repository name.
+var invalidRepoChars = regexp.MustCompile(`[^a-z0-9._-]+`)
+
+// loadLocalBase reads the base image from the tarball or OCI layout named by
+// baseImage, relative to dir if it's set, and returns it with a reference
+// named after the file.
+func loadLocalBase(baseImage, dir string) (name.Reference, build.Result, error) {
+	domain, path := tarballBaseDomain, strings.TrimPrefix(baseImage, options.TarballBaseScheme)
+	if strings.HasPrefix(baseImage, options.LayoutBaseScheme) {
+		domain, path = layoutBaseDomain, strings.TrimPrefix(baseImage, options.LayoutBaseScheme)
+	}
+	if dir != "" && !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+
+	var result build.Result
+	var err error
+	if domain == layoutBaseDomain {
+		result, err = loadLayoutBase(path)
+	} else {
+		result, err = tarball.ImageFromPath(path, nil)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	repo := strings.Trim(invalidRepoChars.ReplaceAllString(strings.ToLower(filepath.Base(path)), "-"), "._-")
+	if repo == "" {
+		repo = "base"
+	}
+	ref, err := name.ParseReference(domain + "/" + repo)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ref, result, nil
+}
+
+// loadLayoutBase reads the base image from the OCI layout at path. Layouts
+// written by tools like `crane pull --format=oci` hold a single index or
+// image, which is unwrapped; otherwise the layout's own index is the base,
+// with one image per platform.
+func loadLayoutBase(path string) (build.Result, error) {
+	idx, err := layout.ImageIndexFromPath(path)
+	if err != nil {
+		return nil, err
+	}
+	im, err := idx.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+	if len(im.Manifests) == 0 {
+		return nil, fmt.Errorf("no images in OCI layout %s", path)
+	}
+	if len(im.Manifests) > 1 {
+		return idx, nil
+	}
+	desc := im.Manifests[0]
+	switch {
+	case desc.MediaType.IsIndex():
+		return idx.ImageIndex(desc.Digest)
+	case desc.MediaType.IsImage():
+		return idx.Image(desc.Digest)
+	default:
+		return nil, fmt.Errorf("unexpected media type %s in OCI layout %s", desc.MediaType, path)
+	}
+}
+
+// baseRemoteOptions returns the options for pulling base images and their
+// SBOMs.
+func baseRemoteOptions(ctx context.Context, bo *options.BuildOptions) []remote.Option {
+	userAgent := ua()
+	if bo.UserAgent != "" {
+		userAgent = bo.UserAgent
+	}
+	return []remote.Option{
+		remote.WithAuthFromKeychain(keychain),
+		remote.WithUserAgent(userAgent),
+		remote.WithContext(ctx),
+	}
+}
+
+// getBaseSBOM returns a function that fetches the SBOM attached to a base
+// image, the way ko and cosign attach them, with a tag derived from the
+// digest of the image.
+func getBaseSBOM(bo *options.BuildOptions) build.GetBaseSBOM {
+	var nameOpts []name.Option
+	if bo.InsecureRegistry {
+		nameOpts = append(nameOpts, name.Insecure)
+	}
+	return func(ctx context.Context, dig name.Digest) ([]byte, types.MediaType, error) {
+		switch dig.Context().RegistryStr() {
+		case publish.LocalDomain, tarballBaseDomain, layoutBaseDomain:
+			return nil, "", nil
+		}
+		dig, err := name.NewDigest(dig.String(), nameOpts...)
+		if err != nil {
+			return nil, "", err
+		}
+		tag, err := ociremote.SBOMTag(dig)
+		if err != nil {
+			return nil, "", err
+		}
+		img, err := remote.Image(tag, baseRemoteOptions(ctx, bo)...)
+		var terr *transport.Error
+		if errors.As(err, &terr) && terr.StatusCode == http.StatusNotFound {
+			return nil, "", nil
+		}
+		if err != nil {
+			return nil, "", err
 		}
+		layers, err := img.Layers()
+		if err != nil {
+			return nil, "", err
+		}
+		if len(layers) != 1 {
+			return nil, "", fmt.Errorf("expected the SBOM %s to have one layer, got %d", tag, len(layers))
+		}
+		mt, err := layers[0].MediaType()
+		if err != nil {
+			return nil, "", err
+		}
+		rc, err := layers[0].Uncompressed()
+		if err != nil {
+			return nil, "", err
+		}
+		defer rc.Close()
+		b, err := ioutil.ReadAll(rc)
+		if err != nil {
+			return nil, "", err
+		}
+		log.Printf("Merging the SBOM of base image %s", dig)
+		return b, mt, nil
+	}
+}
 
-		result, err := fetch(ctx, ref)
+// parseBaseImageMirrors parses --base-image-mirror values, which are either
+// <registry>=<mirror> or just <mirror> to mirror Docker Hub, into a map from
+// registry to mirror. A mirror may include a path under which the mirrored
+// repositories are found, e.g. harbor.example.com/dockerhub-proxy.
+func parseBaseImageMirrors(specs []string) (map[string]string, error) {
+	mirrors := make(map[string]string, len(specs))
+	for _, spec := range specs {
+		registry, mirror := name.DefaultRegistry, spec
+		if parts := strings.SplitN(spec, "=", 2); len(parts) == 2 {
+			registry, mirror = parts[0], parts[1]
+		}
+		reg, err := name.NewRegistry(registry)
 		if err != nil {
-			return ref, result, err
+			return nil, fmt.Errorf("invalid --base-image-mirror %q: %w", spec, err)
+		}
+		mirror = strings.TrimSuffix(mirror, "/")
+		if _, err := name.NewRepository(mirror + "/mirrored"); err != nil {
+			return nil, fmt.Errorf("invalid --base-image-mirror %q: %w", spec, err)
 		}
+		mirrors[reg.RegistryStr()] = mirror
+	}
+	return mirrors, nil
+}
 
-		if _, ok := ref.(name.Digest); ok {
-			log.Printf("Using base %s for %s", ref, s)
-		} else {
-			dig, err := result.Digest()
-			if err != nil {
-				return ref, result, err
+// parseAddedFiles parses --add-file values, <src>:<dst>[:<mode>] with an
+// octal mode, into the files to add to images. Relative sources are relative
+// to dir.
+func parseAddedFiles(specs []string, dir string) ([]build.AddedFile, error) {
+	files := make([]build.AddedFile, 0, len(specs))
+	for _, spec := range specs {
+		parts := strings.Split(spec, ":")
+		if len(parts) < 2 || len(parts) > 3 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid --add-file %q, must be <src>:<dst>[:<mode>]", spec)
+		}
+		f := build.AddedFile{Source: parts[0], Destination: parts[1]}
+		if !filepath.IsAbs(f.Source) {
+			f.Source = filepath.Join(dir, f.Source)
+		}
+		if !path.IsAbs(f.Destination) {
+			return nil, fmt.Errorf("invalid --add-file %q, the destination must be an absolute path", spec)
+		}
+		if len(parts) == 3 {
+			mode, err := strconv.ParseUint(parts[2], 8, 32)
+			if err != nil || mode > 0777 {
+				return nil, fmt.Errorf("invalid --add-file %q, the mode must be octal permissions, like 0644", spec)
 			}
-			log.Printf("Using base %s@%s for %s", ref, dig, s)
+			f.Mode = int64(mode)
 		}
+		files = append(files, f)
+	}
+	return files, nil
+}
 
-		cache.Store(ref.String(), result)
-		return ref, result, nil
+// getFromMirror gets ref from its registry's mirror, if it has one. It
+// returns nil without an error if there is no mirror or the mirror doesn't
+// have ref, so that ref is fetched from its own registry instead.
+func getFromMirror(ref name.Reference, mirrors map[string]string, nameOpts []name.Option, ropt []remote.Option) (*remote.Descriptor, error) {
+	mirror, ok := mirrors[ref.Context().RegistryStr()]
+	if !ok {
+		return nil, nil
+	}
+	sep := ":"
+	if _, ok := ref.(name.Digest); ok {
+		sep = "@"
+	}
+	mref, err := name.ParseReference(mirror+"/"+ref.Context().RepositoryStr()+sep+ref.Identifier(), nameOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("mirroring %s: %w", ref, err)
 	}
+	desc, err := remote.Get(mref, ropt...)
+	var terr *transport.Error
+	if errors.As(err, &terr) && terr.StatusCode == http.StatusNotFound {
+		log.Printf("Base image %s not found in mirror %s, pulling it from %s", ref, mirror, ref.Context().Registry)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("pulling %s from mirror: %w", ref, err)
+	}
+	log.Printf("Pulling base image %s from mirror %s", ref, mref)
+	return desc, nil
 }
 
 func getTimeFromEnv(env string) (*v1.Time, error) {
@@ -140,8 +492,20 @@ func getTimeFromEnv(env string) (*v1.Time, error) {
 	return &v1.Time{Time: time.Unix(seconds, 0)}, nil
 }
 
-func getCreationTime() (*v1.Time, error) {
-	return getTimeFromEnv("SOURCE_DATE_EPOCH")
+// getCreationTime returns the time to stamp images with, if any. The
+// --timestamp flag takes precedence over SOURCE_DATE_EPOCH.
+func getCreationTime(bo *options.BuildOptions) (*v1.Time, error) {
+	if bo.Timestamp == "" {
+		return getTimeFromEnv("SOURCE_DATE_EPOCH")
+	}
+	if seconds, err := strconv.ParseInt(bo.Timestamp, 10, 64); err == nil {
+		return &v1.Time{Time: time.Unix(seconds, 0)}, nil
+	}
+	t, err := time.Parse(time.RFC3339, bo.Timestamp)
+	if err != nil {
+		return nil, fmt.Errorf("--timestamp should be the number of seconds since January 1st 1970, 00:00 UTC, or an RFC 3339 time, got %q", bo.Timestamp)
+	}
+	return &v1.Time{Time: t}, nil
 }
 
 func getKoDataCreationTime() (*v1.Time, error) {
@@ -19,10 +19,30 @@ package commands
 import (
 	"context"
 	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	ociremote "github.com/sigstore/cosign/pkg/oci/remote"
+	"github.com/sigstore/cosign/pkg/oci/static"
+	ctypes "github.com/sigstore/cosign/pkg/types"
 
+	"github.com/google/ko/pkg/build"
 	"github.com/google/ko/pkg/commands/options"
 )
 
@@ -59,3 +79,519 @@ func TestOverrideDefaultBaseImageUsingBuildOption(t *testing.T) {
 		t.Errorf("got digest %s, wanted %s", gotDigest, wantDigest)
 	}
 }
+
+func TestDebugBaseImage(t *testing.T) {
+	s := httptest.NewServer(registry.New(registry.Logger(log.New(ioutil.Discard, "", 0))))
+	defer s.Close()
+	host := s.Listener.Addr().String()
+	push := func(ref string) v1.Hash {
+		img, err := random.Image(1024, 1)
+		if err != nil {
+			t.Fatalf("random.Image() = %v", err)
+		}
+		if err := crane.Push(img, ref); err != nil {
+			t.Fatalf("crane.Push(%s) = %v", ref, err)
+		}
+		dig, err := img.Digest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return dig
+	}
+	push(host + "/static:nonroot")
+	debug := push(host + "/static:debug-nonroot")
+	busybox := push(host + "/busybox:latest")
+
+	for _, tc := range []struct {
+		desc string
+		bo   *options.BuildOptions
+		want v1.Hash
+	}{{
+		desc: "debug variant",
+		bo:   &options.BuildOptions{BaseImage: host + "/static:nonroot", Debug: true},
+		want: debug,
+	}, {
+		desc: "--debug-base",
+		bo:   &options.BuildOptions{BaseImage: host + "/static:nonroot", Debug: true, DebugBaseImage: host + "/busybox"},
+		want: busybox,
+	}} {
+		t.Run(tc.desc, func(t *testing.T) {
+			_, res, err := getBaseImage(tc.bo)(context.Background(), "ko://example.com/helloworld")
+			if err != nil {
+				t.Fatalf("getBaseImage() = %v", err)
+			}
+			got, err := res.Digest()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.want {
+				t.Errorf("got base %s, wanted %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestTarballBaseImage(t *testing.T) {
+	dir := t.TempDir()
+	write := func(file string) v1.Image {
+		img, err := random.Image(1024, 2)
+		if err != nil {
+			t.Fatalf("random.Image() = %v", err)
+		}
+		tag, err := name.NewTag("gcr.io/distroless/static:nonroot")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tarball.WriteToFile(filepath.Join(dir, file), tag, img); err != nil {
+			t.Fatalf("tarball.WriteToFile() = %v", err)
+		}
+		return img
+	}
+	defaultBase := write("Default Base.tar")
+	overrideBase := write("override.tar")
+
+	bo := &options.BuildOptions{
+		// Relative paths are relative to the working directory.
+		WorkingDirectory:   dir,
+		BaseImage:          options.TarballBaseScheme + filepath.Join(dir, "Default Base.tar"),
+		BaseImageOverrides: map[string]string{"example.com/override": options.TarballBaseScheme + "override.tar"},
+	}
+	baseFn := getBaseImage(bo)
+	for _, tc := range []struct {
+		importpath string
+		want       v1.Image
+		wantRef    string
+	}{
+		{"ko://example.com/helloworld", defaultBase, "tarball.local/default-base.tar:latest"},
+		{"ko://example.com/override", overrideBase, "tarball.local/override.tar:latest"},
+	} {
+		ref, res, err := baseFn(context.Background(), tc.importpath)
+		if err != nil {
+			t.Fatalf("getBaseImage(%s) = %v", tc.importpath, err)
+		}
+		if ref.Name() != tc.wantRef {
+			t.Errorf("getBaseImage(%s) ref = %s, want %s", tc.importpath, ref.Name(), tc.wantRef)
+		}
+		got, err := res.Digest()
+		if err != nil {
+			t.Fatalf("Digest() = %v", err)
+		}
+		want, _ := tc.want.Digest()
+		if got != want {
+			t.Errorf("getBaseImage(%s) digest = %s, want %s", tc.importpath, got, want)
+		}
+
+		// There's no registry to look for the SBOM of the base in.
+		sbom, _, err := getBaseSBOM(bo)(context.Background(), ref.Context().Digest(got.String()))
+		if err != nil || sbom != nil {
+			t.Errorf("getBaseSBOM(%s) = %s, %v, want nothing", ref, sbom, err)
+		}
+	}
+
+	bo.BaseImage = options.TarballBaseScheme + filepath.Join(dir, "missing.tar")
+	if _, _, err := getBaseImage(bo)(context.Background(), "ko://example.com/helloworld"); err == nil {
+		t.Error("getBaseImage() for a missing tarball = nil, want error")
+	}
+}
+
+func TestLayoutBaseImage(t *testing.T) {
+	dir := t.TempDir()
+	idx, err := random.Index(1024, 1, 2)
+	if err != nil {
+		t.Fatalf("random.Index() = %v", err)
+	}
+	img, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+
+	// A layout holding a single index, like `crane pull --format=oci` writes.
+	lp, err := layout.Write(filepath.Join(dir, "index"), empty.Index)
+	if err != nil {
+		t.Fatalf("layout.Write() = %v", err)
+	}
+	if err := lp.AppendIndex(idx); err != nil {
+		t.Fatalf("AppendIndex() = %v", err)
+	}
+	// A layout holding a single image.
+	lp, err = layout.Write(filepath.Join(dir, "image"), empty.Index)
+	if err != nil {
+		t.Fatalf("layout.Write() = %v", err)
+	}
+	if err := lp.AppendImage(img); err != nil {
+		t.Fatalf("AppendImage() = %v", err)
+	}
+	// An empty layout.
+	if _, err := layout.Write(filepath.Join(dir, "empty"), empty.Index); err != nil {
+		t.Fatalf("layout.Write() = %v", err)
+	}
+
+	bo := &options.BuildOptions{
+		WorkingDirectory: dir,
+		BaseImage:        options.LayoutBaseScheme + "index",
+		BaseImageOverrides: map[string]string{
+			"example.com/image": options.LayoutBaseScheme + filepath.Join(dir, "image"),
+			"example.com/empty": options.LayoutBaseScheme + "empty",
+		},
+	}
+	baseFn := getBaseImage(bo)
+	for _, tc := range []struct {
+		importpath string
+		want       build.Result
+		wantRef    string
+	}{
+		{"ko://example.com/helloworld", idx, "oci.local/index:latest"},
+		{"ko://example.com/image", img, "oci.local/image:latest"},
+	} {
+		ref, res, err := baseFn(context.Background(), tc.importpath)
+		if err != nil {
+			t.Fatalf("getBaseImage(%s) = %v", tc.importpath, err)
+		}
+		if ref.Name() != tc.wantRef {
+			t.Errorf("getBaseImage(%s) ref = %s, want %s", tc.importpath, ref.Name(), tc.wantRef)
+		}
+		got, err := res.Digest()
+		if err != nil {
+			t.Fatalf("Digest() = %v", err)
+		}
+		want, _ := tc.want.Digest()
+		if got != want {
+			t.Errorf("getBaseImage(%s) digest = %s, want %s", tc.importpath, got, want)
+		}
+	}
+
+	if _, _, err := baseFn(context.Background(), "ko://example.com/empty"); err == nil {
+		t.Error("getBaseImage() for an empty layout = nil, want error")
+	}
+}
+
+func TestBaseImageFetchedOnce(t *testing.T) {
+	var mu sync.Mutex
+	gets := map[string]int{}
+	reg := registry.New(registry.Logger(log.New(ioutil.Discard, "", 0)))
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && r.URL.Path != "/v2/" {
+			mu.Lock()
+			gets[r.URL.Path]++
+			mu.Unlock()
+		}
+		reg.ServeHTTP(w, r)
+	}))
+	defer s.Close()
+
+	baseImage := fmt.Sprintf("%s/base", s.Listener.Addr().String())
+	idx, err := random.Index(1024, 1, 2)
+	if err != nil {
+		t.Fatalf("random.Index() = %v", err)
+	}
+	ref, err := name.ParseReference(baseImage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.WriteIndex(ref, idx); err != nil {
+		t.Fatalf("remote.WriteIndex() = %v", err)
+	}
+	im, err := idx.IndexManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	gets = map[string]int{}
+	mu.Unlock()
+
+	// Resolve the base and each platform's config for a bunch of import
+	// paths at once, the way concurrent builds do.
+	baseFn := getBaseImage(&options.BuildOptions{BaseImage: baseImage})
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, res, err := baseFn(context.Background(), fmt.Sprintf("ko://example.com/cmd%d", i))
+			if err != nil {
+				errs <- err
+				return
+			}
+			base, ok := res.(v1.ImageIndex)
+			if !ok {
+				errs <- fmt.Errorf("base is a %T, want index", res)
+				return
+			}
+			for _, desc := range im.Manifests {
+				img, err := base.Image(desc.Digest)
+				if err != nil {
+					errs <- err
+					return
+				}
+				if _, err := img.ConfigFile(); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	// The index, each image and each config.
+	if want := 1 + 2*len(im.Manifests); len(gets) != want {
+		t.Errorf("fetched %d distinct paths, want %d: %v", len(gets), want, gets)
+	}
+	for path, n := range gets {
+		if n != 1 {
+			t.Errorf("fetched %s %d times, want once", path, n)
+		}
+	}
+}
+
+func TestBaseImageMirror(t *testing.T) {
+	canonical, err := registryServerWithImage("base")
+	if err != nil {
+		t.Fatalf("could not create test registry server: %v", err)
+	}
+	defer canonical.Close()
+	mirror, err := registryServerWithImage("proxy/base")
+	if err != nil {
+		t.Fatalf("could not create test registry server: %v", err)
+	}
+	defer mirror.Close()
+	canonicalHost, mirrorHost := canonical.Listener.Addr().String(), mirror.Listener.Addr().String()
+
+	// Only the canonical registry has this one.
+	other, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("random.Image(): %v", err)
+	}
+	if err := crane.Push(other, canonicalHost+"/other"); err != nil {
+		t.Fatalf("crane.Push(): %v", err)
+	}
+
+	bo := &options.BuildOptions{
+		BaseImageMirrors: []string{canonicalHost + "=" + mirrorHost + "/proxy"},
+		Platforms:        []string{"all"},
+	}
+	for _, tc := range []struct {
+		baseImage  string
+		wantDigest string
+	}{{
+		baseImage:  canonicalHost + "/base",
+		wantDigest: mirrorHost + "/proxy/base",
+	}, {
+		baseImage:  canonicalHost + "/other",
+		wantDigest: canonicalHost + "/other",
+	}} {
+		t.Run(tc.baseImage, func(t *testing.T) {
+			want, err := crane.Digest(tc.wantDigest)
+			if err != nil {
+				t.Fatalf("crane.Digest(%s): %v", tc.wantDigest, err)
+			}
+			bo.BaseImage = tc.baseImage
+			ref, res, err := getBaseImage(bo)(context.Background(), "ko://example.com/helloworld")
+			if err != nil {
+				t.Fatalf("getBaseImage(): %v", err)
+			}
+			// The base image keeps its own name, wherever it was pulled from.
+			if got := ref.Context().String(); got != tc.baseImage {
+				t.Errorf("getBaseImage() ref = %s, want %s", got, tc.baseImage)
+			}
+			got, err := res.Digest()
+			if err != nil {
+				t.Fatalf("res.Digest(): %v", err)
+			}
+			if got.String() != want {
+				t.Errorf("got digest %s, wanted %s", got, want)
+			}
+		})
+	}
+}
+
+func TestGetBaseSBOM(t *testing.T) {
+	server, err := registryServerWithImage("base")
+	if err != nil {
+		t.Fatalf("could not create test registry server: %v", err)
+	}
+	defer server.Close()
+	host := server.Listener.Addr().String()
+
+	withSBOM, err := crane.Digest(host + "/base")
+	if err != nil {
+		t.Fatalf("crane.Digest(): %v", err)
+	}
+	dig, err := name.NewDigest(host + "/base@" + withSBOM)
+	if err != nil {
+		t.Fatalf("name.NewDigest(): %v", err)
+	}
+	f, err := static.NewFile([]byte(`{"spdxVersion": "SPDX-2.2"}`), static.WithLayerMediaType(ctypes.SPDXJSONMediaType))
+	if err != nil {
+		t.Fatalf("static.NewFile(): %v", err)
+	}
+	tag, err := ociremote.SBOMTag(dig)
+	if err != nil {
+		t.Fatalf("ociremote.SBOMTag(): %v", err)
+	}
+	if err := remote.Write(tag, f); err != nil {
+		t.Fatalf("remote.Write(): %v", err)
+	}
+
+	other, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("random.Image(): %v", err)
+	}
+	if err := crane.Push(other, host+"/other"); err != nil {
+		t.Fatalf("crane.Push(): %v", err)
+	}
+	withoutSBOM, err := crane.Digest(host + "/other")
+	if err != nil {
+		t.Fatalf("crane.Digest(): %v", err)
+	}
+
+	get := getBaseSBOM(&options.BuildOptions{})
+	b, mt, err := get(context.Background(), dig)
+	if err != nil {
+		t.Fatalf("getBaseSBOM(): %v", err)
+	}
+	if got, want := string(b), `{"spdxVersion": "SPDX-2.2"}`; got != want {
+		t.Errorf("getBaseSBOM() = %s, want %s", got, want)
+	}
+	if mt != ctypes.SPDXJSONMediaType {
+		t.Errorf("getBaseSBOM() media type = %s, want %s", mt, ctypes.SPDXJSONMediaType)
+	}
+
+	otherDig, err := name.NewDigest(host + "/other@" + withoutSBOM)
+	if err != nil {
+		t.Fatalf("name.NewDigest(): %v", err)
+	}
+	b, _, err = get(context.Background(), otherDig)
+	if err != nil {
+		t.Fatalf("getBaseSBOM(): %v", err)
+	}
+	if b != nil {
+		t.Errorf("getBaseSBOM() of an image without an SBOM = %s, want nil", b)
+	}
+}
+
+func TestParseBaseImageMirrors(t *testing.T) {
+	for _, tc := range []struct {
+		specs   []string
+		want    map[string]string
+		wantErr bool
+	}{{
+		specs: []string{"mirror.gcr.io"},
+		want:  map[string]string{"index.docker.io": "mirror.gcr.io"},
+	}, {
+		specs: []string{"docker.io=mirror.gcr.io", "ghcr.io=harbor.example.com/ghcr-proxy/"},
+		want: map[string]string{
+			"index.docker.io": "mirror.gcr.io",
+			"ghcr.io":         "harbor.example.com/ghcr-proxy",
+		},
+	}, {
+		specs:   []string{"ghcr.io=Harbor.example.com/UPPER"},
+		wantErr: true,
+	}, {
+		specs:   []string{"ghcr.io/foo=mirror.gcr.io"},
+		wantErr: true,
+	}} {
+		t.Run(fmt.Sprint(tc.specs), func(t *testing.T) {
+			got, err := parseBaseImageMirrors(tc.specs)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("parseBaseImageMirrors() = %v, wantErr %v", err, tc.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("parseBaseImageMirrors() (-want +got) = %s", diff)
+			}
+		})
+	}
+}
+
+func TestParseAddedFiles(t *testing.T) {
+	for _, tc := range []struct {
+		specs   []string
+		want    []build.AddedFile
+		wantErr bool
+	}{{
+		specs: []string{"certs/ca.pem:/etc/ssl/custom-ca.pem"},
+		want:  []build.AddedFile{{Source: "wd/certs/ca.pem", Destination: "/etc/ssl/custom-ca.pem"}},
+	}, {
+		specs: []string{"/tmp/run.sh:/usr/local/bin/run.sh:0755"},
+		want:  []build.AddedFile{{Source: "/tmp/run.sh", Destination: "/usr/local/bin/run.sh", Mode: 0755}},
+	}, {
+		specs:   []string{"ca.pem:etc/ssl/ca.pem"},
+		wantErr: true,
+	}, {
+		specs:   []string{"ca.pem"},
+		wantErr: true,
+	}, {
+		specs:   []string{"ca.pem:/etc/ca.pem:rw"},
+		wantErr: true,
+	}, {
+		specs:   []string{"ca.pem:/etc/ca.pem:1777"},
+		wantErr: true,
+	}} {
+		t.Run(fmt.Sprint(tc.specs), func(t *testing.T) {
+			got, err := parseAddedFiles(tc.specs, "wd")
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("parseAddedFiles() = %v, wantErr %v", err, tc.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("parseAddedFiles() (-want +got) = %s", diff)
+			}
+		})
+	}
+}
+
+func TestGetCreationTime(t *testing.T) {
+	tests := []struct {
+		description string
+		env         string
+		timestamp   string
+		want        *v1.Time
+		wantErr     bool
+	}{{
+		description: "neither set",
+	}, {
+		description: "SOURCE_DATE_EPOCH",
+		env:         "1234567890",
+		want:        &v1.Time{Time: time.Unix(1234567890, 0)},
+	}, {
+		description: "--timestamp as seconds",
+		timestamp:   "1600000000",
+		want:        &v1.Time{Time: time.Unix(1600000000, 0)},
+	}, {
+		description: "--timestamp as RFC 3339",
+		timestamp:   "2020-09-13T12:26:40Z",
+		want:        &v1.Time{Time: time.Unix(1600000000, 0)},
+	}, {
+		description: "--timestamp takes precedence",
+		env:         "1234567890",
+		timestamp:   "1600000000",
+		want:        &v1.Time{Time: time.Unix(1600000000, 0)},
+	}, {
+		description: "invalid --timestamp",
+		timestamp:   "yesterday",
+		wantErr:     true,
+	}}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			t.Setenv("SOURCE_DATE_EPOCH", test.env)
+			got, err := getCreationTime(&options.BuildOptions{Timestamp: test.timestamp})
+			if (err != nil) != test.wantErr {
+				t.Fatalf("getCreationTime() = %v, wantErr %v", err, test.wantErr)
+			}
+			switch {
+			case got == nil && test.want == nil:
+			case got == nil || test.want == nil || !got.Time.Equal(test.want.Time):
+				t.Errorf("getCreationTime() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
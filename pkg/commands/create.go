@@ -32,6 +32,7 @@ func addCreate(topLevel *cobra.Command) {
 	fo := &options.FilenameOptions{}
 	so := &options.SelectorOptions{}
 	bo := &options.BuildOptions{}
+	ko := &options.KubectlOptions{}
 	create := &cobra.Command{
 		Use:   "create -f FILENAME",
 		Short: "Create the input files with image references resolved to built/pushed image digests.",
@@ -60,8 +61,11 @@ func addCreate(topLevel *cobra.Command) {
   # Create from stdin:
   cat config.yaml | ko create -f -
 
-  # Any flags passed after '--' are passed to 'kubectl apply' directly:
-  ko apply -f config -- --namespace=foo --kubeconfig=cfg.yaml
+  # Use another kubeconfig context and namespace:
+  ko create --context=staging --namespace=foo -f config/
+
+  # Any flags passed after '--' are passed to 'kubectl create' directly:
+  ko create -f config -- --kubeconfig=cfg.yaml
 `,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if err := options.Validate(po, bo); err != nil {
@@ -78,7 +82,7 @@ func addCreate(topLevel *cobra.Command) {
 			if err != nil {
 				return fmt.Errorf("error creating builder: %w", err)
 			}
-			publisher, err := makePublisher(po)
+			publisher, err := makePublisher(po, bo.WorkingDirectory)
 			if err != nil {
 				return fmt.Errorf("error creating publisher: %w", err)
 			}
@@ -87,7 +91,7 @@ func addCreate(topLevel *cobra.Command) {
 			// Issue a "kubectl create" command reading from stdin,
 			// to which we will pipe the resolved files, and any
 			// remaining flags passed after '--'.
-			kubectlCmd := exec.CommandContext(ctx, "kubectl", append([]string{"create", "-f", "-"}, args...)...)
+			kubectlCmd := exec.CommandContext(ctx, "kubectl", kubectlArgs("create", ko, args...)...)
 
 			// Pass through our environment
 			kubectlCmd.Env = os.Environ()
@@ -115,7 +119,7 @@ func addCreate(topLevel *cobra.Command) {
 					stdin.Write([]byte("---\n"))
 				}
 				// Once primed kick things off.
-				return resolveFilesToWriter(ctx, builder, publisher, fo, so, stdin)
+				return resolveFilesToWriter(ctx, builder, publisher, fo, so, bo, stdin)
 			})
 
 			g.Go(func() error {
@@ -133,6 +137,7 @@ func addCreate(topLevel *cobra.Command) {
 	options.AddFileArg(create, fo)
 	options.AddSelectorArg(create, so)
 	options.AddBuildOptions(create, bo)
+	options.AddKubectlArgs(create, ko)
 
 	topLevel.AddCommand(create)
 }
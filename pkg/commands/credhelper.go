@@ -0,0 +1,66 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/docker/cli/cli/config"
+	"github.com/docker/docker-credential-helpers/client"
+	"github.com/docker/docker-credential-helpers/credentials"
+	"github.com/google/go-containerregistry/pkg/authn"
+)
+
+// credHelperKeychain resolves the credentials of a registry by executing the
+// docker-credential-<helper> configured for its host in the credHelpers of
+// the Docker config, or its credsStore.
+//
+// authn.DefaultKeychain also executes them, but asks the credsStore for the
+// repository before the credHelpers for its host, so a credsStore that fails
+// (e.g. a desktop helper that isn't installed in CI) hides them.
+type credHelperKeychain struct{}
+
+// Resolve implements authn.Keychain.
+func (credHelperKeychain) Resolve(target authn.Resource) (authn.Authenticator, error) {
+	cf, err := config.Load(os.Getenv("DOCKER_CONFIG"))
+	if err != nil {
+		return nil, err
+	}
+	host := target.RegistryStr()
+	helper, explicit := cf.CredentialHelpers[host]
+	if !explicit {
+		helper = cf.CredentialsStore
+	}
+	if helper == "" {
+		return authn.Anonymous, nil
+	}
+
+	creds, err := client.Get(client.NewShellProgramFunc("docker-credential-"+helper), host)
+	if credentials.IsErrCredentialsNotFound(err) {
+		return authn.Anonymous, nil
+	} else if err != nil {
+		if !explicit {
+			// Leave it to the rest of the chain.
+			return authn.Anonymous, nil
+		}
+		return nil, fmt.Errorf("getting credentials for %s from docker-credential-%s: %w", host, helper, err)
+	}
+	// See https://docs.docker.com/engine/reference/commandline/login/#credential-helper-protocol
+	if creds.Username == "<token>" {
+		return authn.FromConfig(authn.AuthConfig{Username: creds.Username, IdentityToken: creds.Secret}), nil
+	}
+	return authn.FromConfig(authn.AuthConfig{Username: creds.Username, Password: creds.Secret}), nil
+}
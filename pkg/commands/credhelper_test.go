@@ -0,0 +1,113 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// fakeHelper is a docker-credential-foo returning a token for
+// registry.example.com, and no credentials for other hosts.
+const fakeHelper = `#!/bin/sh
+read host
+if [ "$1" = get ] && [ "$host" = registry.example.com ]; then
+  echo '{"ServerURL":"registry.example.com","Username":"<token>","Secret":"s3cr3t"}'
+  exit 0
+fi
+echo "credentials not found in native keychain"
+exit 1
+`
+
+// brokenHelper is a docker-credential-broken that always fails.
+const brokenHelper = `#!/bin/sh
+echo "something went wrong"
+exit 1
+`
+
+func TestCredHelperKeychain(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake credential helpers are shell scripts")
+	}
+	bin := t.TempDir()
+	for helper, script := range map[string]string{"foo": fakeHelper, "broken": brokenHelper} {
+		if err := ioutil.WriteFile(filepath.Join(bin, "docker-credential-"+helper), []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	dockerConfig := t.TempDir()
+	t.Setenv("DOCKER_CONFIG", dockerConfig)
+	if err := ioutil.WriteFile(filepath.Join(dockerConfig, "config.json"), []byte(`{
+  "credsStore": "broken",
+  "credHelpers": {
+    "registry.example.com": "foo",
+    "other.example.com": "foo"
+  }
+}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		repo    string
+		want    authn.AuthConfig
+		wantErr bool
+	}{{
+		repo: "registry.example.com/team/app",
+		want: authn.AuthConfig{Username: "<token>", IdentityToken: "s3cr3t"},
+	}, {
+		// The helper has no credentials for the host.
+		repo: "other.example.com/team/app",
+	}, {
+		// The credsStore fails, which is left to the rest of the chain.
+		repo: "unknown.example.com/team/app",
+	}} {
+		t.Run(tc.repo, func(t *testing.T) {
+			repo, err := name.NewRepository(tc.repo)
+			if err != nil {
+				t.Fatal(err)
+			}
+			auth, err := credHelperKeychain{}.Resolve(repo)
+			if err != nil {
+				t.Fatalf("Resolve() = %v", err)
+			}
+			got, err := auth.Authorization()
+			if err != nil {
+				t.Fatalf("Authorization() = %v", err)
+			}
+			if diff := cmp.Diff(tc.want, *got); diff != "" {
+				t.Errorf("Authorization() (-want +got) = %s", diff)
+			}
+		})
+	}
+
+	// The keychain ko pushes with uses the helper too.
+	repo, _ := name.NewRepository("registry.example.com/team/app")
+	auth, err := keychain.Resolve(repo)
+	if err != nil {
+		t.Fatalf("keychain.Resolve() = %v", err)
+	}
+	if got, err := auth.Authorization(); err != nil || got.IdentityToken != "s3cr3t" {
+		t.Errorf("keychain.Resolve().Authorization() = %+v, %v, want the identity token", got, err)
+	}
+}
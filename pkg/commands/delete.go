@@ -19,6 +19,7 @@ import (
 	"os"
 	"os/exec"
 
+	"github.com/google/ko/pkg/commands/options"
 	"github.com/spf13/cobra"
 )
 
@@ -53,7 +54,8 @@ func passthru(command string) runCmd {
 
 // addDelete augments our CLI surface with publish.
 func addDelete(topLevel *cobra.Command) {
-	topLevel.AddCommand(&cobra.Command{
+	ko := &options.KubectlOptions{}
+	del := &cobra.Command{
 		Use:   "delete",
 		Short: `See "kubectl help delete" for detailed usage.`,
 		RunE:  passthru("kubectl"),
@@ -62,5 +64,10 @@ func addDelete(topLevel *cobra.Command) {
 		FParseErrWhitelist: cobra.FParseErrWhitelist{
 			UnknownFlags: true,
 		},
-	})
+	}
+	// These are passed through with the rest of our arguments, but are
+	// registered for consistency with apply and create.
+	options.AddKubectlArgs(del, ko)
+
+	topLevel.AddCommand(del)
 }
@@ -17,6 +17,8 @@ package commands
 import (
 	"archive/tar"
 	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -25,9 +27,10 @@ import (
 	"os/exec"
 	"path"
 	"path/filepath"
+	"strings"
 
 	"github.com/google/go-containerregistry/pkg/name"
-	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
 	"github.com/google/ko/internal/sbom"
 	"github.com/sigstore/cosign/pkg/oci/signed"
@@ -37,24 +40,35 @@ import (
 // addDeps augments our CLI surface with deps.
 func addDeps(topLevel *cobra.Command) {
 	var sbomType string
+	var jsonOutput bool
 	deps := &cobra.Command{
 		Use:   "deps IMAGE",
 		Short: "Print Go module dependency information about the ko-built binary in the image",
-		Long: `This sub-command finds and extracts the executable binary in the image, assuming it was built by ko, and prints information about the Go module dependencies of that executable, as reported by "go version -m".
+		Long: `This sub-command finds and extracts the executable binary in the image, assuming it was built by ko, and prints the Go modules it was built from, as reported by "go version -m", or an SBOM of them.
+
+Only the layers down to the one with the executable are downloaded.
 
 If the image was not built using ko, or if it was built without embedding dependency information, this command will fail.`,
 		Example: `
   # Fetch and extract Go dependency information from an image:
-  ko deps docker.io/my-user/my-image:v3`,
-		Args:       cobra.ExactArgs(1),
-		Deprecated: "SBOMs are generated and uploaded by default; this command will be removed in a future release.",
+  ko deps docker.io/my-user/my-image:v3
+
+  # Print it as JSON, for other tools to consume:
+  ko deps docker.io/my-user/my-image:v3 --json
+
+  # Print an SPDX SBOM of the image:
+  ko deps docker.io/my-user/my-image:v3 --sbom=spdx`,
+		Args: cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			ctx := cmd.Context()
 
 			switch sbomType {
-			case "cyclonedx", "spdx", "go.version-m":
+			case "", "cyclonedx", "spdx", "spdx-json", "go.version-m":
 			default:
-				return fmt.Errorf("invalid sbom type %q: must be spdx, cyclonedx or go.version-m", sbomType)
+				return fmt.Errorf("invalid sbom type %q: must be spdx, spdx-json, cyclonedx or go.version-m", sbomType)
+			}
+			if sbomType != "" && jsonOutput {
+				return errors.New("--json and --sbom cannot be used together")
 			}
 
 			ref, err := name.ParseReference(args[0])
@@ -70,91 +84,206 @@ If the image was not built using ko, or if it was built without embedding depend
 				return err
 			}
 
-			cfg, err := img.ConfigFile()
+			mod, err := goVersionM(ctx, img)
 			if err != nil {
 				return err
 			}
-			ep := cfg.Config.Entrypoint
-			if len(ep) != 1 {
-				return fmt.Errorf("unexpected entrypoint: %s", ep)
-			}
-			bin := ep[0]
-
-			rc := mutate.Extract(img)
-			defer rc.Close()
-			tr := tar.NewReader(rc)
-			for {
-				// Stop reading if the context is cancelled.
-				select {
-				case <-ctx.Done():
-					return ctx.Err()
-				default:
-					// keep reading.
-				}
-				h, err := tr.Next()
-				if errors.Is(err, io.EOF) {
-					return fmt.Errorf("no ko-built executable named %q found", bin)
-				}
+			switch sbomType {
+			case "spdx", "spdx-json":
+				b, err := sbom.GenerateImageSPDX(Version, mod, signed.Image(img))
 				if err != nil {
 					return err
 				}
-
-				if h.Typeflag != tar.TypeReg {
-					continue
-				}
-				if h.Name != bin {
-					continue
-				}
-
-				tmp, err := ioutil.TempFile("", filepath.Base(filepath.Clean(h.Name)))
+				io.Copy(os.Stdout, bytes.NewReader(b))
+			case "cyclonedx":
+				b, err := sbom.GenerateImageCycloneDX(mod, signed.Image(img))
 				if err != nil {
 					return err
 				}
-				n := tmp.Name()
-				defer os.RemoveAll(n) // best effort: remove tmp file afterwards.
-				defer tmp.Close()     // close it first.
-				// io.LimitReader to appease gosec...
-				if _, err := io.Copy(tmp, io.LimitReader(tr, h.Size)); err != nil {
-					return err
-				}
-				if err := os.Chmod(n, os.FileMode(h.Mode)); err != nil {
-					return err
-				}
-				cmd := exec.CommandContext(ctx, "go", "version", "-m", n)
-				var buf bytes.Buffer
-				cmd.Stdout = &buf
-				cmd.Stderr = os.Stderr
-				if err := cmd.Run(); err != nil {
+				io.Copy(os.Stdout, bytes.NewReader(b))
+			case "go.version-m":
+				io.Copy(os.Stdout, bytes.NewReader(mod))
+			default:
+				bi, err := sbom.ParseGoVersionM(mod)
+				if err != nil {
 					return err
 				}
-				// In order to get deterministics SBOMs replace
-				// our randomized file name with the path the
-				// app will get inside of the container.
-				mod := bytes.Replace(buf.Bytes(),
-					[]byte(n),
-					[]byte(path.Join("/ko-app", filepath.Base(filepath.Clean(h.Name)))),
-					1)
-				switch sbomType {
-				case "spdx":
-					b, err := sbom.GenerateImageSPDX(Version, mod, signed.Image(img))
-					if err != nil {
-						return err
-					}
-					io.Copy(os.Stdout, bytes.NewReader(b))
-				case "cyclonedx":
-					b, err := sbom.GenerateImageCycloneDX(mod)
-					if err != nil {
-						return err
-					}
-					io.Copy(os.Stdout, bytes.NewReader(b))
-				case "go.version-m":
-					io.Copy(os.Stdout, bytes.NewReader(mod))
-				}
-				return nil
+				return printModules(os.Stdout, bi, jsonOutput)
 			}
-			// unreachable
+			return nil
 		},
 	}
-	deps.Flags().StringVar(&sbomType, "sbom", "spdx", "Format for SBOM output (supports: spdx, cyclonedx, go.version-m).")
+	deps.Flags().StringVar(&sbomType, "sbom", "", "Print an SBOM of the image instead of the modules (supports: spdx or spdx-json for SPDX 2.3 JSON, cyclonedx, go.version-m).")
+	deps.Flags().BoolVar(&jsonOutput, "json", false, "Print the modules as JSON.")
 	topLevel.AddCommand(deps)
 }
+
+// goVersionM returns the output of `go version -m` for the ko-built binary
+// in img, the entrypoint.
+func goVersionM(ctx context.Context, img v1.Image) ([]byte, error) {
+	cfg, err := img.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	ep := cfg.Config.Entrypoint
+	if len(ep) != 1 {
+		return nil, fmt.Errorf("unexpected entrypoint: %s", ep)
+	}
+	bin := ep[0]
+
+	tmp, err := ioutil.TempFile("", filepath.Base(filepath.Clean(bin)))
+	if err != nil {
+		return nil, err
+	}
+	n := tmp.Name()
+	defer os.RemoveAll(n) // best effort: remove tmp file afterwards.
+	defer tmp.Close()     // close it first.
+	mode, err := extractFile(ctx, img, bin, tmp)
+	if err != nil {
+		return nil, err
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(n, mode); err != nil {
+		return nil, err
+	}
+
+	cmd := exec.CommandContext(ctx, "go", "version", "-m", n)
+	var buf bytes.Buffer
+	cmd.Stdout = &buf
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, err
+	}
+	// In order to get deterministics SBOMs replace
+	// our randomized file name with the path the
+	// app will get inside of the container.
+	return bytes.Replace(buf.Bytes(),
+		[]byte(n),
+		[]byte(path.Join("/ko-app", filepath.Base(filepath.Clean(bin)))),
+		1), nil
+}
+
+// extractFile copies the regular file at file in img to w, and returns its
+// mode. It reads the layers from the top down, and stops at the one with the
+// file, so the ones below it aren't fetched.
+func extractFile(ctx context.Context, img v1.Image, file string, w io.Writer) (os.FileMode, error) {
+	layers, err := img.Layers()
+	if err != nil {
+		return 0, err
+	}
+	file = path.Clean("/" + file)
+	for i := len(layers) - 1; i >= 0; i-- {
+		found, mode, hidden, err := extractFromLayer(ctx, layers[i], file, w)
+		if err != nil {
+			return 0, err
+		}
+		if found {
+			return mode, nil
+		}
+		if hidden {
+			break
+		}
+	}
+	return 0, fmt.Errorf("no ko-built executable named %q found", file)
+}
+
+// extractFromLayer copies the regular file at file in layer to w, if it's
+// there. Otherwise, hidden is whether the layer deletes file or a directory
+// it's in, so it's not in the image even if it's in a layer below.
+func extractFromLayer(ctx context.Context, layer v1.Layer, file string, w io.Writer) (found bool, mode os.FileMode, hidden bool, err error) {
+	rc, err := layer.Uncompressed()
+	if err != nil {
+		return false, 0, false, err
+	}
+	defer rc.Close()
+	tr := tar.NewReader(rc)
+	for {
+		// Stop reading if the context is cancelled.
+		select {
+		case <-ctx.Done():
+			return false, 0, false, ctx.Err()
+		default:
+			// keep reading.
+		}
+		h, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return false, 0, hidden, nil
+		}
+		if err != nil {
+			return false, 0, false, err
+		}
+
+		name := path.Clean("/" + h.Name)
+		dir, base := path.Split(name)
+		switch {
+		case base == ".wh..wh..opq":
+			// An opaque whiteout hides the lower layers' files in dir.
+			if strings.HasPrefix(file, dir) {
+				hidden = true
+			}
+		case strings.HasPrefix(base, ".wh."):
+			deleted := path.Join(dir, strings.TrimPrefix(base, ".wh."))
+			if file == deleted || strings.HasPrefix(file, deleted+"/") {
+				hidden = true
+			}
+		case name == file && h.Typeflag == tar.TypeReg:
+			// io.LimitReader to appease gosec...
+			if _, err := io.Copy(w, io.LimitReader(tr, h.Size)); err != nil {
+				return false, 0, false, err
+			}
+			return true, os.FileMode(h.Mode), false, nil
+		}
+	}
+}
+
+// depsModule is a module in the output of ko deps --json.
+type depsModule struct {
+	Path    string      `json:"path"`
+	Version string      `json:"version,omitempty"`
+	Sum     string      `json:"sum,omitempty"`
+	Replace *depsModule `json:"replace,omitempty"`
+}
+
+// depsOutput is the output of ko deps --json.
+type depsOutput struct {
+	GoVersion string       `json:"goVersion"`
+	Path      string       `json:"path"`
+	Main      depsModule   `json:"main"`
+	Deps      []depsModule `json:"deps"`
+}
+
+// printModules prints the main module and dependencies in bi to w, one per
+// line, or as JSON.
+func printModules(w io.Writer, bi *sbom.BuildInfo, jsonOutput bool) error {
+	out := depsOutput{
+		GoVersion: bi.GoVersion,
+		Path:      bi.Path,
+		Main:      depsModule{Path: bi.Main.Path, Version: bi.Main.Version, Sum: bi.Main.Sum},
+		Deps:      []depsModule{},
+	}
+	for _, dep := range bi.Deps {
+		m := depsModule{Path: dep.Path, Version: dep.Version, Sum: dep.Sum}
+		if dep.Replace != nil {
+			m.Replace = &depsModule{Path: dep.Replace.Path, Version: dep.Replace.Version, Sum: dep.Replace.Sum}
+		}
+		out.Deps = append(out.Deps, m)
+	}
+
+	if jsonOutput {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(out)
+	}
+	for _, m := range append([]depsModule{out.Main}, out.Deps...) {
+		line := strings.TrimSpace(m.Path + " " + m.Version)
+		if m.Replace != nil {
+			line += " => " + strings.TrimSpace(m.Replace.Path+" "+m.Replace.Version)
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
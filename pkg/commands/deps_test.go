@@ -0,0 +1,153 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/google/ko/internal/sbom"
+)
+
+// unreadableLayer is a layer that fails to be read, to show that it isn't.
+type unreadableLayer struct {
+	v1.Layer
+}
+
+func (unreadableLayer) Uncompressed() (io.ReadCloser, error) {
+	return nil, errors.New("read a layer below the binary")
+}
+
+func tarLayer(t *testing.T, files map[string]string) v1.Layer {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, contents := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0755, Size: int64(len(contents))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(contents)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	layer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(buf.Bytes())), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return layer
+}
+
+func TestExtractFile(t *testing.T) {
+	binary := tarLayer(t, map[string]string{"/ko-app/foo": "binary"})
+
+	for _, test := range []struct {
+		desc    string
+		layers  []v1.Layer
+		wantErr bool
+	}{{
+		desc:   "top layer",
+		layers: []v1.Layer{unreadableLayer{binary}, binary},
+	}, {
+		desc:   "below another layer",
+		layers: []v1.Layer{unreadableLayer{binary}, binary, tarLayer(t, map[string]string{"/ko-app/bar": "other"})},
+	}, {
+		desc:    "deleted",
+		layers:  []v1.Layer{binary, tarLayer(t, map[string]string{"/ko-app/.wh.foo": ""})},
+		wantErr: true,
+	}, {
+		desc:    "directory deleted",
+		layers:  []v1.Layer{binary, tarLayer(t, map[string]string{"/.wh.ko-app": ""})},
+		wantErr: true,
+	}, {
+		desc:    "directory made opaque",
+		layers:  []v1.Layer{binary, tarLayer(t, map[string]string{"/ko-app/.wh..wh..opq": ""})},
+		wantErr: true,
+	}, {
+		desc:    "missing",
+		layers:  []v1.Layer{tarLayer(t, map[string]string{"/ko-app/bar": "other"})},
+		wantErr: true,
+	}} {
+		t.Run(test.desc, func(t *testing.T) {
+			img, err := mutate.AppendLayers(empty.Image, test.layers...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var buf bytes.Buffer
+			mode, err := extractFile(context.Background(), img, "/ko-app/foo", &buf)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("extractFile() = %v, wanted error: %t", err, test.wantErr)
+			}
+			if err == nil && (buf.String() != "binary" || mode != 0755) {
+				t.Errorf("extractFile() = %q with mode %v, wanted %q with mode 0755", buf.String(), mode, "binary")
+			}
+		})
+	}
+}
+
+func TestPrintModules(t *testing.T) {
+	bi, err := sbom.ParseGoVersionM([]byte(`/ko-app/ko: go1.19.1
+	path	github.com/google/ko
+	mod	github.com/google/ko	(devel)	
+	dep	github.com/google/go-containerregistry	v0.11.0	h1:6rOrSOiWvSzTNc3qPvhV3nkzRLasYv0WPs1Cr1zWx+w=
+	dep	golang.org/x/sync	v0.1.0
+	=>	../sync	(devel)	
+`))
+	if err != nil {
+		t.Fatalf("ParseGoVersionM() = %v", err)
+	}
+
+	var text bytes.Buffer
+	if err := printModules(&text, bi, false); err != nil {
+		t.Fatalf("printModules() = %v", err)
+	}
+	want := `github.com/google/ko (devel)
+github.com/google/go-containerregistry v0.11.0
+golang.org/x/sync v0.1.0 => ../sync (devel)
+`
+	if diff := cmp.Diff(want, text.String()); diff != "" {
+		t.Errorf("printModules(); (-want +got) = %v", diff)
+	}
+
+	var j bytes.Buffer
+	if err := printModules(&j, bi, true); err != nil {
+		t.Fatalf("printModules() = %v", err)
+	}
+	for _, field := range []string{
+		`"goVersion": "go1.19.1"`,
+		`"path": "github.com/google/ko"`,
+		`"sum": "h1:6rOrSOiWvSzTNc3qPvhV3nkzRLasYv0WPs1Cr1zWx+w="`,
+		`"replace": {`,
+	} {
+		if !strings.Contains(j.String(), field) {
+			t.Errorf("printModules() = %s, wanted it to contain %s", j.String(), field)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/google/ko/internal/diff"
+	"github.com/google/ko/pkg/build"
+	"github.com/google/ko/pkg/commands/options"
+	"github.com/google/ko/pkg/publish"
+	"github.com/spf13/cobra"
+	"golang.org/x/sync/errgroup"
+)
+
+// addDiff augments our CLI surface with diff.
+func addDiff(topLevel *cobra.Command) {
+	po := &options.PublishOptions{}
+	fo := &options.FilenameOptions{}
+	so := &options.SelectorOptions{}
+	bo := &options.BuildOptions{}
+
+	diffCmd := &cobra.Command{
+		Use:   "diff -f FILENAME",
+		Short: "Print how resolving the image references would change the input files.",
+		Long: `This sub-command finds import path references within the provided files, builds and publishes them like ko resolve, and prints a unified diff between the files and the resolved yaml.
+
+It fails if resolving would change any of the files, so it can be used to check that they are up to date.`,
+		Example: `
+  # Print the references that resolving config/ would change.
+  ko diff -f config/
+
+  # Check that the image references in release.yaml are up to date,
+  # without pushing the images.
+  ko diff --push=false -f release.yaml`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := options.Validate(po, bo); err != nil {
+				return fmt.Errorf("validating options: %w", err)
+			}
+
+			ctx := cmd.Context()
+
+			bo.InsecureRegistry = po.InsecureRegistry
+			builder, err := makeBuilder(ctx, bo)
+			if err != nil {
+				return fmt.Errorf("error creating builder: %w", err)
+			}
+			publisher, err := makePublisher(po, bo.WorkingDirectory)
+			if err != nil {
+				return fmt.Errorf("error creating publisher: %w", err)
+			}
+			defer publisher.Close()
+			changed, err := diffFiles(ctx, builder, publisher, fo, so, bo.Scheme, os.Stdout)
+			if err != nil {
+				return err
+			}
+			if changed > 0 {
+				return fmt.Errorf("resolving would change %d file(s)", changed)
+			}
+			return nil
+		},
+	}
+	options.AddPublishArg(diffCmd, po)
+	options.AddFileArg(diffCmd, fo)
+	options.AddSelectorArg(diffCmd, so)
+	options.AddBuildOptions(diffCmd, bo)
+	topLevel.AddCommand(diffCmd)
+}
+
+// diffFiles resolves the files in fo like resolveFilesToWriter, and writes
+// the diffs between them and the results to out, in order. It returns how
+// many files have differences.
+func diffFiles(
+	ctx context.Context,
+	builder build.Interface,
+	publisher publish.Interface,
+	fo *options.FilenameOptions,
+	so *options.SelectorOptions,
+	scheme string,
+	out io.Writer) (int, error) {
+	var files []string
+	for f := range options.EnumerateFiles(fo) {
+		files = append(files, f)
+	}
+
+	diffs := make([][]byte, len(files))
+	errs, ctx := errgroup.WithContext(ctx)
+	for i, f := range files {
+		i, f := i, f
+		errs.Go(func() error {
+			d, err := diffFile(ctx, f, builder, publisher, so, scheme)
+			if err != nil {
+				return fmt.Errorf("error processing import paths in %q: %w", f, err)
+			}
+			diffs[i] = d
+			return nil
+		})
+	}
+	if err := errs.Wait(); err != nil {
+		return 0, err
+	}
+
+	changed := 0
+	for _, d := range diffs {
+		if d == nil {
+			continue
+		}
+		changed++
+		if _, err := out.Write(d); err != nil {
+			return 0, err
+		}
+	}
+	return changed, nil
+}
+
+// diffFile returns the unified diff between the file f and the result of
+// resolving it, or nil if there is no difference.
+func diffFile(
+	ctx context.Context,
+	f string,
+	builder build.Interface,
+	pub publish.Interface,
+	so *options.SelectorOptions,
+	scheme string) ([]byte, error) {
+	selector, err := parseSelector(so)
+	if err != nil {
+		return nil, err
+	}
+	b, err := readFile(f)
+	if err != nil {
+		return nil, err
+	}
+	resolved, err := resolveBytes(ctx, f, b, builder, pub, selector, resolveOptions(so, scheme)...)
+	if err != nil {
+		return nil, err
+	}
+
+	// The documents that don't match the selector aren't resolved, so
+	// compare with the ones that do, encoded the same way.
+	before := b
+	if selector != nil {
+		docs, err := decodeDocuments(b, selector)
+		if err != nil {
+			return nil, err
+		}
+		if before, err = encodeDocuments(f, b, docs); err != nil {
+			return nil, err
+		}
+	}
+	return diff.Unified(f, f+" (resolved)", before, resolved), nil
+}
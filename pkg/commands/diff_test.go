@@ -0,0 +1,101 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/ko/pkg/build"
+	"github.com/google/ko/pkg/commands/options"
+	kotesting "github.com/google/ko/pkg/internal/testing"
+)
+
+func TestDiffFiles(t *testing.T) {
+	base := mustRepository("gcr.io/multi-pass")
+	changed := yamlToTmpFile(t, []byte(fmt.Sprintf(`# foo
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  labels:
+    app: foo
+image: %s
+---
+apiVersion: v1
+kind: Service
+metadata:
+  labels:
+    app: bar
+image: %s
+`, build.StrictScheme+fooRef, build.StrictScheme+barRef)))
+	unchanged := yamlToTmpFile(t, []byte("apiVersion: v1\nkind: Pod\nimage: gcr.io/foo\n"))
+
+	for _, test := range []struct {
+		desc     string
+		selector string
+		want     string
+	}{{
+		desc: "all",
+		want: fmt.Sprintf(`--- %[1]s
++++ %[1]s (resolved)
+@@ -4,11 +4,11 @@
+ metadata:
+   labels:
+     app: foo
+-image: %[2]s
++image: %[3]s
+ ---
+ apiVersion: v1
+ kind: Service
+ metadata:
+   labels:
+     app: bar
+-image: %[4]s
++image: %[5]s
+`, changed, build.StrictScheme+fooRef, kotesting.ComputeDigest(base, fooRef, fooHash), build.StrictScheme+barRef, kotesting.ComputeDigest(base, barRef, barHash)),
+	}, {
+		desc:     "selector",
+		selector: "app=bar",
+		want: fmt.Sprintf(`--- %[1]s
++++ %[1]s (resolved)
+@@ -3,4 +3,4 @@
+ metadata:
+   labels:
+     app: bar
+-image: %[2]s
++image: %[3]s
+`, changed, build.StrictScheme+barRef, kotesting.ComputeDigest(base, barRef, barHash)),
+	}} {
+		t.Run(test.desc, func(t *testing.T) {
+			var out bytes.Buffer
+			n, err := diffFiles(context.Background(), testBuilder, kotesting.NewFixedPublish(base, testHashes),
+				&options.FilenameOptions{Filenames: []string{changed, unchanged}},
+				&options.SelectorOptions{Selector: test.selector},
+				build.StrictScheme, &out)
+			if err != nil {
+				t.Fatalf("diffFiles() = %v", err)
+			}
+			if n != 1 {
+				t.Errorf("diffFiles() = %d, wanted 1 changed file", n)
+			}
+			if diff := cmp.Diff(test.want, out.String()); diff != "" {
+				t.Errorf("diffFiles(); (-want +got) = %v", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+// cloudPlatformScope is the scope of the access tokens to push with.
+const cloudPlatformScope = "https://www.googleapis.com/auth/cloud-platform"
+
+// externalAccountKeychain authenticates to Google Container Registry and
+// Artifact Registry with the external account credentials (e.g. Workload
+// Identity Federation from GitHub Actions) that GOOGLE_APPLICATION_CREDENTIALS
+// points to, if it does.
+//
+// google.Keychain falls back to anonymous, only logging why at debug level,
+// when these credentials can't be used, and comes after the Docker config,
+// so it's consulted first and its errors are returned instead.
+type externalAccountKeychain struct {
+	once sync.Once
+	auth authn.Authenticator
+	err  error
+}
+
+// Resolve implements authn.Keychain.
+func (k *externalAccountKeychain) Resolve(target authn.Resource) (authn.Authenticator, error) {
+	if !isGoogleRegistry(target.RegistryStr()) {
+		return authn.Anonymous, nil
+	}
+	k.once.Do(func() {
+		k.auth, k.err = externalAccountAuth(context.Background(), os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"))
+	})
+	return k.auth, k.err
+}
+
+// isGoogleRegistry returns whether host is Google Container Registry or
+// Artifact Registry.
+func isGoogleRegistry(host string) bool {
+	return host == "gcr.io" || strings.HasSuffix(host, ".gcr.io") || strings.HasSuffix(host, ".pkg.dev")
+}
+
+// externalAccountAuth returns an authenticator with access tokens for the
+// external account credentials in the file at path, or authn.Anonymous if
+// there's no such file or it has credentials of another type.
+func externalAccountAuth(ctx context.Context, path string) (authn.Authenticator, error) {
+	if path == "" {
+		return authn.Anonymous, nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return authn.Anonymous, nil
+	}
+	var f struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(b, &f); err != nil || f.Type != "external_account" {
+		return authn.Anonymous, nil
+	}
+
+	creds, err := google.CredentialsFromJSON(ctx, b, cloudPlatformScope)
+	if err != nil {
+		return nil, fmt.Errorf("reading external account credentials from %s: %w", path, err)
+	}
+	return tokenSourceAuth{creds.TokenSource}, nil
+}
+
+// tokenSourceAuth authenticates with access tokens from a token source.
+type tokenSourceAuth struct {
+	oauth2.TokenSource
+}
+
+// Authorization implements authn.Authenticator.
+func (a tokenSourceAuth) Authorization() (*authn.AuthConfig, error) {
+	t, err := a.Token()
+	if err != nil {
+		return nil, fmt.Errorf("getting an access token with external account credentials: %w", err)
+	}
+	return &authn.AuthConfig{
+		Username: "_token",
+		Password: t.AccessToken,
+	}, nil
+}
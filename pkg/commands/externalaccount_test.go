@@ -0,0 +1,103 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// externalAccount returns the external account credentials GitHub Actions
+// would have, exchanging a token in a file at tokenURL.
+func externalAccount(dir, tokenURL string) string {
+	return `{
+  "type": "external_account",
+  "audience": "//iam.googleapis.com/projects/123/locations/global/workloadIdentityPools/github/providers/github",
+  "subject_token_type": "urn:ietf:params:oauth:token-type:jwt",
+  "token_url": "` + tokenURL + `",
+  "credential_source": {
+    "file": "` + filepath.Join(dir, "token") + `"
+  }
+}`
+}
+
+func TestExternalAccountKeychain(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	wif := write("wif.json", externalAccount(dir, "https://sts.googleapis.com/v1/token"))
+	invalid := write("invalid.json", externalAccount(dir, "https://sts.example.com/v1/token"))
+	sa := write("sa.json", `{"type": "service_account"}`)
+
+	for _, tc := range []struct {
+		desc, creds, repo string
+		wantAnonymous     bool
+		wantErr           bool
+	}{{
+		desc:  "external account",
+		creds: wif,
+		repo:  "us-docker.pkg.dev/project/repo/app",
+	}, {
+		desc:  "external account for gcr.io",
+		creds: wif,
+		repo:  "gcr.io/project/app",
+	}, {
+		desc:          "not a Google registry",
+		creds:         wif,
+		repo:          "registry.example.com/app",
+		wantAnonymous: true,
+	}, {
+		desc:          "not configured",
+		repo:          "us-docker.pkg.dev/project/repo/app",
+		wantAnonymous: true,
+	}, {
+		desc:          "service account",
+		creds:         sa,
+		repo:          "us-docker.pkg.dev/project/repo/app",
+		wantAnonymous: true,
+	}, {
+		desc:    "invalid token URL",
+		creds:   invalid,
+		repo:    "us-docker.pkg.dev/project/repo/app",
+		wantErr: true,
+	}} {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", tc.creds)
+			repo, err := name.NewRepository(tc.repo)
+			if err != nil {
+				t.Fatal(err)
+			}
+			auth, err := (&externalAccountKeychain{}).Resolve(repo)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("Resolve() = %v, wantErr %t", err, tc.wantErr)
+			}
+			if tc.wantErr {
+				return
+			}
+			if got := auth == authn.Anonymous; got != tc.wantAnonymous {
+				t.Errorf("Resolve() = %v, want anonymous %t", auth, tc.wantAnonymous)
+			}
+		})
+	}
+}
@@ -0,0 +1,189 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/ko/pkg/commands/options"
+)
+
+const (
+	revisionLabel = "org.opencontainers.image.revision"
+	createdLabel  = "org.opencontainers.image.created"
+)
+
+// gitLabels returns the OCI annotation labels describing the commit checked
+// out in dir. The creation time is the commit time, so that rebuilding the
+// same commit produces the same labels.
+func gitLabels(dir string) (map[string]string, error) {
+	revision, err := git(dir, "rev-parse", "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	ct, err := git(dir, "log", "-1", "--format=%ct", "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	secs, err := strconv.ParseInt(ct, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("parsing commit time %q: %w", ct, err)
+	}
+	return map[string]string{
+		revisionLabel: revision,
+		createdLabel:  time.Unix(secs, 0).UTC().Format(time.RFC3339),
+	}, nil
+}
+
+// gitTagData returns the state of the git repository in dir, for templates
+// in --tags.
+func gitTagData(dir string) (options.TagData, error) {
+	var td options.TagData
+	var err error
+	if td.GitCommit, err = git(dir, "rev-parse", "HEAD"); err != nil {
+		return td, err
+	}
+	if td.GitCommitShort, err = git(dir, "rev-parse", "--short", "HEAD"); err != nil {
+		return td, err
+	}
+	status, err := git(dir, "status", "--porcelain")
+	if err != nil {
+		return td, err
+	}
+	td.GitTreeState = "clean"
+	if status != "" {
+		td.GitTreeState = "dirty"
+	}
+	// Not every repository has tags, so leave GitTag empty if this fails.
+	td.GitTag, _ = git(dir, "describe", "--tags", "--abbrev=0")
+	return td, nil
+}
+
+// deriveSourceURL returns the URL of the source code in dir, for
+// --source-url: the web URL of the origin git remote, or else that of the
+// module path of the go.mod governing dir, or "" if neither is a URL.
+func deriveSourceURL(dir string) string {
+	if remote, err := git(dir, "remote", "get-url", "origin"); err == nil {
+		if u := remoteURL(remote); u != "" {
+			return u
+		}
+	}
+	mod, err := modulePath(dir)
+	if err != nil {
+		return ""
+	}
+	return moduleURL(mod)
+}
+
+// scpLikeRemote matches git remotes like git@github.com:google/ko.git.
+var scpLikeRemote = regexp.MustCompile(`^(?:[^@/]+@)?([^:/]+):(.+)$`)
+
+// remoteURL returns the https URL of a git remote, without any credentials
+// or .git suffix, or "" if it isn't on a host.
+func remoteURL(remote string) string {
+	var host, p string
+	if strings.Contains(remote, "://") {
+		u, err := url.Parse(remote)
+		if err != nil {
+			return ""
+		}
+		switch u.Scheme {
+		case "http", "https", "ssh", "git", "git+ssh":
+		default:
+			return ""
+		}
+		host, p = u.Hostname(), u.Path
+		if u.Scheme == "http" || u.Scheme == "https" {
+			// Other ports are those of the git server, not of the web.
+			host = u.Host
+		}
+	} else if m := scpLikeRemote.FindStringSubmatch(remote); m != nil {
+		host, p = m[1], m[2]
+	}
+	p = strings.TrimSuffix(strings.Trim(p, "/"), ".git")
+	if host == "" || p == "" {
+		return ""
+	}
+	return "https://" + host + "/" + p
+}
+
+// majorVersionSuffix matches the /vN suffix of module paths of major
+// versions 2 and up.
+var majorVersionSuffix = regexp.MustCompile(`/v([2-9]|[1-9][0-9]+)$`)
+
+// moduleURL returns the https URL of a module path, like
+// https://github.com/google/ko for github.com/google/ko/v2, or "" if its
+// first element isn't a host name.
+func moduleURL(mod string) string {
+	if i := strings.Index(mod, "/"); i < 0 || !strings.Contains(mod[:i], ".") {
+		return ""
+	}
+	return "https://" + majorVersionSuffix.ReplaceAllString(mod, "")
+}
+
+// modulePath returns the module path of the go.mod in dir or its closest
+// parent directory that has one.
+func modulePath(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	for {
+		f, err := os.Open(filepath.Join(dir, "go.mod"))
+		if err == nil {
+			defer f.Close()
+			s := bufio.NewScanner(f)
+			for s.Scan() {
+				fields := strings.Fields(s.Text())
+				if len(fields) >= 2 && fields[0] == "module" {
+					return strings.Trim(fields[1], `"`+"`"), nil
+				}
+			}
+			if err := s.Err(); err != nil {
+				return "", err
+			}
+			return "", fmt.Errorf("%s has no module directive", f.Name())
+		} else if !os.IsNotExist(err) {
+			return "", err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", fmt.Errorf("no go.mod in %s or its parents", dir)
+		}
+		dir = parent
+	}
+}
+
+func git(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("running git %s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
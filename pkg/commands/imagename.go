@@ -0,0 +1,43 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/ko/pkg/build"
+	"github.com/google/ko/pkg/commands/options"
+	"github.com/google/ko/pkg/publish"
+)
+
+// checkedNamePublisher wraps a publisher whose images are named with an
+// --image-name-template, to fail to publish those whose name is invalid,
+// which the publish.Namer of the template can't.
+type checkedNamePublisher struct {
+	publish.Interface
+	namer *options.TemplateNamer
+}
+
+// Publish implements publish.Interface
+func (c *checkedNamePublisher) Publish(ctx context.Context, br build.Result, s string) (name.Reference, error) {
+	// Publishers name images by their lowercased import path.
+	importpath := strings.ToLower(strings.TrimPrefix(s, build.StrictScheme))
+	if _, err := c.namer.Path(importpath); err != nil {
+		return nil, err
+	}
+	return c.Interface.Publish(ctx, br, s)
+}
@@ -0,0 +1,62 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/ko/pkg/build"
+	"github.com/google/ko/pkg/commands/options"
+)
+
+func TestPublisherImageNameTemplate(t *testing.T) {
+	img, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	publishOptions := func(template string) *options.PublishOptions {
+		return &options.PublishOptions{
+			DockerRepo:        "registry.example.com/repo",
+			TarballFile:       filepath.Join(t.TempDir(), "images.tar"),
+			ImageNameTemplate: template,
+		}
+	}
+
+	publisher, err := NewPublisher(publishOptions("services/{{.Name}}"))
+	if err != nil {
+		t.Fatalf("NewPublisher() = %v", err)
+	}
+	ref, err := publisher.Publish(context.Background(), img, build.StrictScheme+"github.com/My-Org/repo/cmd/Service")
+	if err != nil {
+		t.Fatalf("Publish() = %v", err)
+	}
+	if got, want := ref.Context().Name(), "registry.example.com/repo/services/service"; got != want {
+		t.Errorf("Publish() = %v, wanted repository %s", ref, want)
+	}
+
+	publisher, err = NewPublisher(publishOptions("{{.Name}}@latest"))
+	if err != nil {
+		t.Fatalf("NewPublisher() = %v", err)
+	}
+	if _, err := publisher.Publish(context.Background(), img, build.StrictScheme+"github.com/my-org/repo/cmd/service"); err == nil {
+		t.Error("Publish() with an invalid image name = nil, wanted error")
+	} else if !strings.Contains(err.Error(), "service@latest") {
+		t.Errorf("Publish() = %v, wanted error naming service@latest", err)
+	}
+}
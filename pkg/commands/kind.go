@@ -0,0 +1,75 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// kindRegistry returns the host of the local registry of the kind cluster,
+// which the cluster advertises in the local-registry-hosting ConfigMap in
+// kube-public, as set up by https://kind.sigs.k8s.io/docs/user/local-registry/.
+// It returns "" if the cluster has no local registry.
+func kindRegistry(cluster string) (string, error) {
+	if !isKubectlAvailable() {
+		return "", errors.New("kubectl must be installed to find the local registry of --kind-cluster")
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("kubectl", "get", "configmap", "--output=json", "--ignore-not-found",
+		"--context=kind-"+cluster, "--namespace=kube-public", "local-registry-hosting")
+	cmd.Env = os.Environ()
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("reading the local registry of kind cluster %s: %w: %s", cluster, err, strings.TrimSpace(stderr.String()))
+	}
+	host, err := parseLocalRegistryHosting(stdout.Bytes())
+	if err != nil {
+		return "", fmt.Errorf("parsing the local registry of kind cluster %s: %w", cluster, err)
+	}
+	return host, nil
+}
+
+// parseLocalRegistryHosting returns the host of the local-registry-hosting
+// ConfigMap b, as defined by KEP-1755, or "" if b is empty.
+func parseLocalRegistryHosting(b []byte) (string, error) {
+	if len(bytes.TrimSpace(b)) == 0 {
+		return "", nil
+	}
+	var cm struct {
+		Data map[string]string `json:"data"`
+	}
+	if err := json.Unmarshal(b, &cm); err != nil {
+		return "", err
+	}
+	v1, ok := cm.Data["localRegistryHosting.v1"]
+	if !ok {
+		return "", nil
+	}
+	var hosting struct {
+		Host string `yaml:"host"`
+	}
+	if err := yaml.Unmarshal([]byte(v1), &hosting); err != nil {
+		return "", err
+	}
+	return hosting.Host, nil
+}
@@ -0,0 +1,107 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+const localRegistryHosting = `{
+  "apiVersion": "v1",
+  "kind": "ConfigMap",
+  "metadata": {"name": "local-registry-hosting", "namespace": "kube-public"},
+  "data": {"localRegistryHosting.v1": "host: \"localhost:5001\"\nhelp: \"https://kind.sigs.k8s.io/docs/user/local-registry/\"\n"}
+}`
+
+func TestParseLocalRegistryHosting(t *testing.T) {
+	for _, tc := range []struct {
+		desc    string
+		in      string
+		want    string
+		wantErr bool
+	}{{
+		desc: "registry",
+		in:   localRegistryHosting,
+		want: "localhost:5001",
+	}, {
+		desc: "not found",
+		in:   "",
+	}, {
+		desc: "no v1 data",
+		in:   `{"data": {"localRegistryHosting.v2": "host: localhost:5001"}}`,
+	}, {
+		desc:    "not json",
+		in:      "local-registry-hosting",
+		wantErr: true,
+	}} {
+		t.Run(tc.desc, func(t *testing.T) {
+			got, err := parseLocalRegistryHosting([]byte(tc.in))
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("parseLocalRegistryHosting() = %v, wantErr %t", err, tc.wantErr)
+			}
+			if got != tc.want {
+				t.Errorf("parseLocalRegistryHosting() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestKindRegistry(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake kubectl is a shell script")
+	}
+	bin := t.TempDir()
+	cm := filepath.Join(bin, "configmap.json")
+	if err := ioutil.WriteFile(cm, []byte(localRegistryHosting), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// The fake kubectl has a registry only in the context of kind cluster
+	// dev, knows no ConfigMap in kind-bare, and fails for other contexts.
+	kubectl := `#!/bin/sh
+case "$*" in
+"get configmap --output=json --ignore-not-found --context=kind-dev --namespace=kube-public local-registry-hosting")
+  cat ` + cm + `;;
+"get configmap --output=json --ignore-not-found --context=kind-bare --namespace=kube-public local-registry-hosting")
+  ;;
+*)
+  echo "error: context was not found" >&2
+  exit 1;;
+esac
+`
+	if err := ioutil.WriteFile(filepath.Join(bin, "kubectl"), []byte(kubectl), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	for cluster, want := range map[string]string{
+		"dev":  "localhost:5001",
+		"bare": "",
+	} {
+		got, err := kindRegistry(cluster)
+		if err != nil {
+			t.Fatalf("kindRegistry(%s) = %v", cluster, err)
+		}
+		if got != want {
+			t.Errorf("kindRegistry(%s) = %q, want %q", cluster, got, want)
+		}
+	}
+	if _, err := kindRegistry("missing"); err == nil {
+		t.Error("kindRegistry(missing) = nil, want error")
+	}
+}
@@ -0,0 +1,32 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"github.com/google/ko/pkg/commands/options"
+)
+
+// kubectlArgs returns the arguments of a "kubectl <verb>" reading the
+// resolved files from stdin, with the flags in ko forwarded ahead of args.
+func kubectlArgs(verb string, ko *options.KubectlOptions, args ...string) []string {
+	kargs := []string{verb, "-f", "-"}
+	if ko.Context != "" {
+		kargs = append(kargs, "--context="+ko.Context)
+	}
+	if ko.Namespace != "" {
+		kargs = append(kargs, "--namespace="+ko.Namespace)
+	}
+	return append(kargs, args...)
+}
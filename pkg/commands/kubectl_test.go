@@ -0,0 +1,76 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/ko/pkg/commands/options"
+	"github.com/spf13/cobra"
+)
+
+func TestKubectlArgs(t *testing.T) {
+	for _, tc := range []struct {
+		desc string
+		verb string
+		ko   options.KubectlOptions
+		args []string
+		want []string
+	}{{
+		desc: "no flags",
+		verb: "apply",
+		want: []string{"apply", "-f", "-"},
+	}, {
+		desc: "context and namespace",
+		verb: "apply",
+		ko:   options.KubectlOptions{Context: "staging", Namespace: "foo"},
+		want: []string{"apply", "-f", "-", "--context=staging", "--namespace=foo"},
+	}, {
+		desc: "with args after --",
+		verb: "create",
+		ko:   options.KubectlOptions{Namespace: "foo"},
+		args: []string{"--dry-run=server", "--kubeconfig=cfg.yaml"},
+		want: []string{"create", "-f", "-", "--namespace=foo", "--dry-run=server", "--kubeconfig=cfg.yaml"},
+	}} {
+		t.Run(tc.desc, func(t *testing.T) {
+			got := kubectlArgs(tc.verb, &tc.ko, tc.args...)
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("kubectlArgs() (-want +got) = %s", diff)
+			}
+		})
+	}
+}
+
+func TestKubectlFlags(t *testing.T) {
+	root := &cobra.Command{Use: "ko"}
+	addApply(root)
+	addCreate(root)
+	addDelete(root)
+	for _, name := range []string{"apply", "create", "delete"} {
+		cmd, _, err := root.Find([]string{name})
+		if err != nil {
+			t.Fatalf("Find(%s) = %v", name, err)
+		}
+		if err := cmd.ParseFlags([]string{"--context=staging", "-n", "foo"}); err != nil {
+			t.Fatalf("%s: ParseFlags() = %v", name, err)
+		}
+		for flag, want := range map[string]string{"context": "staging", "namespace": "foo"} {
+			if got := cmd.Flags().Lookup(flag).Value.String(); got != want {
+				t.Errorf("%s --%s = %q, want %q", name, flag, got, want)
+			}
+		}
+	}
+}
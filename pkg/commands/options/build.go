@@ -17,15 +17,22 @@ limitations under the License.
 package options
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
 
 	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"golang.org/x/tools/go/packages"
+	"gopkg.in/yaml.v3"
 
 	"github.com/google/ko/pkg/build"
 )
@@ -35,6 +42,17 @@ const (
 	configDefaultBaseImage = "ghcr.io/distroless/static:latest"
 )
 
+// envRegexp matches the references to environment variables in the config
+// file, $NAME, ${NAME} or ${NAME:-default}, as well as $$, an escaped $.
+var envRegexp = regexp.MustCompile(`\$\$|\$\{([^}]*)\}|\$([a-zA-Z_][a-zA-Z0-9_]*)`)
+
+// envNameRegexp matches the names of environment variables.
+var envNameRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// schemeRegexp matches the reference schemes that may be set with --scheme,
+// per RFC 3986, followed by "://".
+var schemeRegexp = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9+.-]*://$`)
+
 // BuildOptions represents options for the ko builder.
 type BuildOptions struct {
 	// BaseImage enables setting the default base image programmatically.
@@ -44,18 +62,87 @@ type BuildOptions struct {
 	// BaseImageOverrides stores base image overrides for import paths.
 	BaseImageOverrides map[string]string
 
+	// PlatformBaseImageOverrides stores base image overrides for specific
+	// platforms (<os>/<arch>[/<variant>]) of import paths.
+	PlatformBaseImageOverrides map[string]map[string]string
+
+	// Debug swaps every base image for a debug variant with a shell:
+	// DebugBaseImage, or else the variant named by DebugBase.
+	Debug bool
+
+	// DebugBaseImage is the base image to use with Debug.
+	DebugBaseImage string
+
 	// WorkingDirectory allows for setting the working directory for invocations of the `go` tool.
 	// Empty string means the current working directory.
 	WorkingDirectory string
 
+	// ModuleRoot is the directory of the Go module to build, relative to
+	// WorkingDirectory. If set, LoadConfig makes it the WorkingDirectory,
+	// so that ko works as if it was run there: `.ko.yaml` is read from it
+	// and local import paths like ./cmd/app are relative to it.
+	ModuleRoot string
+
 	ConcurrentBuilds     int
 	DisableOptimizations bool
+	Race                 bool
 	SBOM                 string
 	Platforms            []string
 	Labels               []string
+	// GitLabels adds the org.opencontainers.image.revision and
+	// org.opencontainers.image.created labels, from the commit checked out
+	// in WorkingDirectory.
+	GitLabels bool
+	// SourceURL is the URL of the source code of the images, for their
+	// org.opencontainers.image.source label and annotation. If empty, it is
+	// derived from the git remote or module path of WorkingDirectory, and
+	// SourceURLNone leaves it unset.
+	SourceURL string
+	// LayerCompression is the algorithm (gzip or zstd) used to compress the
+	// layers ko produces.
+	LayerCompression string
+	// LayerCompressionLevel is the compression level (1-9) of the layers ko
+	// produces, or 0 for the default. Changing it changes the layer digests.
+	LayerCompressionLevel int
+	// MediaTypes is whether the images ko produces have Docker or OCI media
+	// types. Empty keeps the base image's.
+	MediaTypes string
+	// OmitEmptyKoData leaves out the kodata layer of images whose kodata
+	// directory is absent or empty.
+	OmitEmptyKoData bool
+	// KeepBaseEntrypoint keeps the base image's entrypoint, and sets the
+	// binary as the first element of Cmd instead.
+	KeepBaseEntrypoint bool
+	// User is the user (uid, uid:gid, or a name) that built images run as.
+	// Empty leaves the base image's user in place.
+	User string
+	// Timestamp is the time to use as the image creation time, as seconds
+	// since the Unix epoch or an RFC 3339 time. If non-empty, this takes
+	// precedence over SOURCE_DATE_EPOCH.
+	Timestamp string
+	// BuildCacheDir is a directory in which to cache built binaries across
+	// invocations, keyed on their inputs. Empty disables the cache.
+	BuildCacheDir string
+	// BuildTimeout limits how long each `go build` may run, per platform.
+	// Zero means no limit.
+	BuildTimeout time.Duration
 	// UserAgent enables overriding the default value of the `User-Agent` HTTP
 	// request header used when retrieving the base image.
 	UserAgent string
+	// BaseImageMirrors are mirrors to try pulling base images from before
+	// their own registry, as <registry>=<mirror>, or just <mirror> to mirror
+	// Docker Hub.
+	BaseImageMirrors []string
+	// AddedFiles are host files to add to images, outside of kodata, as
+	// <src>:<dst>[:<mode>].
+	AddedFiles []string
+	// ConfigFiles are the config files, or URLs, to read instead of
+	// `.ko.yaml`, each merged over the ones before it (see mergeConfig).
+	ConfigFiles []string
+	// Scheme is the prefix of the image references that ko resolves, e.g.
+	// image://. If empty, it is read from `.ko.yaml`, defaulting to
+	// build.StrictScheme.
+	Scheme string
 
 	InsecureRegistry bool
 
@@ -65,6 +152,10 @@ type BuildOptions struct {
 	// `AddBuildOptions()` defaults this field to `true`.
 	Trimpath bool
 
+	// DebugSymbols keeps the symbol table and DWARF debugging information
+	// in binaries, which are otherwise stripped with `-ldflags=-s -w`.
+	DebugSymbols bool
+
 	// BuildConfigs stores the per-image build config from `.ko.yaml`.
 	BuildConfigs map[string]build.Config
 }
@@ -74,12 +165,52 @@ func AddBuildOptions(cmd *cobra.Command, bo *BuildOptions) {
 		"The maximum number of concurrent builds (default GOMAXPROCS)")
 	cmd.Flags().BoolVar(&bo.DisableOptimizations, "disable-optimizations", bo.DisableOptimizations,
 		"Disable optimizations when building Go code. Useful when you want to interactively debug the created container.")
-	cmd.Flags().StringVar(&bo.SBOM, "sbom", "spdx",
-		"The SBOM media type to use (none will disable SBOM synthesis and upload, also supports: spdx, cyclonedx, go.version-m).")
+	cmd.Flags().BoolVar(&bo.Debug, "debug", false,
+		"Build on the debug variant of the base image, with a shell, and suffix the tags with -debug. The variant is --debug-base, or else the distroless convention: the base's :latest tag becomes :debug, and any other tag :debug-<tag>.")
+	cmd.Flags().StringVar(&bo.DebugBaseImage, "debug-base", "",
+		"The base image to build on with --debug, for every import path, instead of each base's debug variant.")
+	cmd.Flags().BoolVar(&bo.DebugSymbols, "debug-symbols", false,
+		"Keep the symbol table and DWARF debugging information in binaries, instead of stripping them with -ldflags=-s -w. They are always kept with --disable-optimizations.")
+	cmd.Flags().BoolVar(&bo.Race, "race", bo.Race,
+		"Build binaries with the race detector enabled. This requires cgo, and a C cross-compiler (set with CC in the build config's env) when building for another platform.")
+	cmd.Flags().StringVar(&bo.SBOM, "sbom", "",
+		"The SBOM media type to use (none will disable SBOM synthesis and upload, also supports: spdx or spdx-json for SPDX 2.3 JSON, cyclonedx, go.version-m). Defaults to none when images aren't pushed to a registry, and spdx otherwise.")
 	cmd.Flags().StringSliceVar(&bo.Platforms, "platform", []string{},
 		"Which platform to use when pulling a multi-platform base. Format: all | <os>[/<arch>[/<variant>]][,platform]*")
 	cmd.Flags().StringSliceVar(&bo.Labels, "image-label", []string{},
 		"Which labels (key=value) to add to the image.")
+	cmd.Flags().BoolVar(&bo.GitLabels, "git-labels", false,
+		"Whether to add the org.opencontainers.image.revision and org.opencontainers.image.created labels from the current git commit. Labels set with --image-label take precedence.")
+	cmd.Flags().StringVar(&bo.SourceURL, "source-url", "",
+		"The URL of the source code of the images, to set as their org.opencontainers.image.source label and as the annotation of multi-platform indexes. If unset, it is derived from the origin git remote or else the Go module path, and none leaves it unset. Labels set with --image-label and annotations set with --image-annotation take precedence.")
+	cmd.Flags().StringVar(&bo.LayerCompression, "layer-compression", build.GzipCompression,
+		"The compression algorithm to use for the layers ko produces (gzip, zstd). zstd layers are pushed as gzip if the registry rejects them.")
+	cmd.Flags().IntVar(&bo.LayerCompressionLevel, "layer-compression-level", 0,
+		"The compression level (1-9) to use for the layers ko produces, trading build time for size. Changing it changes the layer digests. (default: the algorithm's default level)")
+	cmd.Flags().StringVar(&bo.MediaTypes, "media-type", "",
+		"Whether the images ko produces, including their base image layers, have Docker (docker) or OCI (oci) media types, e.g. for registries that only accept Docker ones. (default: the base image's)")
+	cmd.Flags().BoolVar(&bo.OmitEmptyKoData, "omit-empty-kodata", false,
+		"Whether to leave out the kodata layer of images whose kodata directory is absent or empty, instead of adding a layer with no files. This changes the digests of those images.")
+	cmd.Flags().BoolVar(&bo.KeepBaseEntrypoint, "keep-base-entrypoint", false,
+		"Whether to keep the base image's entrypoint, like an init shim, and pass it the binary as its first argument, instead of replacing the entrypoint with the binary. Fails if the base image has no entrypoint.")
+	cmd.Flags().StringVar(&bo.User, "user", "",
+		"The user the image runs as, e.g. 65532:65532. Format: <uid>[:<gid>] | <name>[:<group>]")
+	cmd.Flags().StringVar(&bo.Timestamp, "timestamp", "",
+		"The image creation time, as seconds since the Unix epoch or an RFC 3339 time. Overrides SOURCE_DATE_EPOCH. (default: 1970-01-01T00:00:00Z, for reproducibility)")
+	cmd.Flags().StringVar(&bo.BuildCacheDir, "build-cache-dir", "",
+		"Directory in which to cache built binaries, keyed on the import path, Go version, build flags and source contents. Unchanged binaries are reused instead of rebuilt.")
+	cmd.Flags().DurationVar(&bo.BuildTimeout, "build-timeout", 0,
+		"How long to let each build of a binary run, per platform, before killing it, e.g. 10m. (default: no timeout)")
+	cmd.Flags().StringSliceVar(&bo.BaseImageMirrors, "base-image-mirror", []string{},
+		"Mirror to pull base images from, falling back to their own registry if the mirror doesn't have them. Format: [<registry>=]<mirror>[/<path>], where the registry defaults to Docker Hub, e.g. mirror.gcr.io or ghcr.io=ghcr-mirror.example.com")
+	cmd.Flags().StringSliceVar(&bo.AddedFiles, "add-file", []string{},
+		"File to add to the image, in a layer of its own, outside of kodata. Format: <src>:<dst>[:<mode>], where dst is an absolute path and mode is octal (default: 0644), e.g. certs/ca.pem:/etc/ssl/custom-ca.pem. Relative sources are relative to the working directory.")
+	cmd.Flags().StringSliceVar(&bo.ConfigFiles, "config", []string{},
+		"Config file, or http(s):// URL, to read instead of .ko.yaml. Can be repeated, to merge each file over the ones before it: maps and builds with the same id are merged, other values are replaced.")
+	cmd.Flags().StringVar(&bo.ModuleRoot, "module-root", "",
+		"The directory of the Go module to build, with its go.mod, to work from as if ko was run there: .ko.yaml is read from it and local import paths like ./cmd/app are relative to it. (default: the current directory)")
+	cmd.Flags().StringVar(&bo.Scheme, "scheme", "",
+		"The prefix of the import paths to build and resolve, e.g. image://. Can also be set with scheme in .ko.yaml. (default: ko://)")
 	bo.Trimpath = true
 }
 
@@ -89,8 +220,24 @@ func (bo *BuildOptions) LoadConfig() error {
 	if bo.WorkingDirectory == "" {
 		bo.WorkingDirectory = "."
 	}
+	if bo.ModuleRoot != "" {
+		root := bo.ModuleRoot
+		if !filepath.IsAbs(root) {
+			root = filepath.Join(bo.WorkingDirectory, root)
+		}
+		if _, err := os.Stat(filepath.Join(root, "go.mod")); err != nil {
+			return fmt.Errorf("module root %s has no go.mod: %w", bo.ModuleRoot, err)
+		}
+		// Keep it absolute, so loading the config again finds it too.
+		root, err := filepath.Abs(root)
+		if err != nil {
+			return err
+		}
+		bo.ModuleRoot, bo.WorkingDirectory = root, root
+	}
 	// If omitted, use this base image.
 	v.SetDefault("defaultBaseImage", configDefaultBaseImage)
+	v.SetDefault("scheme", build.StrictScheme)
 	const configName = ".ko"
 
 	v.SetConfigName(configName) // .yaml is implicit
@@ -120,30 +267,75 @@ func (bo *BuildOptions) LoadConfig() error {
 	}
 	v.AddConfigPath(bo.WorkingDirectory)
 
-	if err := v.ReadInConfig(); err != nil {
-		if !errors.As(err, &viper.ConfigFileNotFoundError{}) {
-			return fmt.Errorf("error reading config file: %w", err)
+	configs := bo.ConfigFiles
+	if len(configs) == 0 {
+		if err := v.ReadInConfig(); err != nil {
+			if !errors.As(err, &viper.ConfigFileNotFoundError{}) {
+				return fmt.Errorf("error reading config file: %w", err)
+			}
+		} else {
+			configs = []string{v.ConfigFileUsed()}
+		}
+	}
+	// The config at KO_CONFIG_URL, if any, is the base for the others.
+	if url := os.Getenv("KO_CONFIG_URL"); url != "" {
+		configs = append([]string{url}, configs...)
+	}
+	if len(configs) > 0 {
+		if err := readConfigFiles(v, configs); err != nil {
+			return err
 		}
 	}
 
 	if bo.BaseImage == "" {
 		ref := v.GetString("defaultBaseImage")
-		if _, err := name.ParseReference(ref); err != nil {
+		if err := validateBaseImage(ref); err != nil {
 			return fmt.Errorf("'defaultBaseImage': error parsing %q as image reference: %w", ref, err)
 		}
 		bo.BaseImage = ref
 	}
 
-	if len(bo.BaseImageOverrides) == 0 {
+	if bo.Scheme == "" {
+		bo.Scheme = v.GetString("scheme")
+	}
+	if !schemeRegexp.MatchString(bo.Scheme) {
+		return fmt.Errorf("'scheme': %q is not a valid scheme, e.g. image://", bo.Scheme)
+	}
+
+	if len(bo.BaseImageOverrides) == 0 && len(bo.PlatformBaseImageOverrides) == 0 {
 		baseImageOverrides := map[string]string{}
-		overrides := v.GetStringMapString("baseImageOverrides")
+		platformBaseImageOverrides := map[string]map[string]string{}
+		overrides := v.GetStringMap("baseImageOverrides")
 		for key, value := range overrides {
-			if _, err := name.ParseReference(value); err != nil {
-				return fmt.Errorf("'baseImageOverrides': error parsing %q as image reference: %w", value, err)
+			switch value := value.(type) {
+			case string:
+				if err := validateBaseImage(value); err != nil {
+					return fmt.Errorf("'baseImageOverrides': error parsing %q as image reference: %w", value, err)
+				}
+				baseImageOverrides[key] = value
+			case map[string]interface{}:
+				// Overrides keyed by platform, e.g. linux/arm64: <image>
+				platforms := map[string]string{}
+				for platform, ref := range value {
+					if _, err := v1.ParsePlatform(platform); err != nil {
+						return fmt.Errorf("'baseImageOverrides': error parsing %q as platform for %s: %w", platform, key, err)
+					}
+					s, ok := ref.(string)
+					if !ok {
+						return fmt.Errorf("'baseImageOverrides': expected image reference for platform %q of %s, got %v", platform, key, ref)
+					}
+					if err := validateBaseImage(s); err != nil {
+						return fmt.Errorf("'baseImageOverrides': error parsing %q as image reference: %w", s, err)
+					}
+					platforms[platform] = s
+				}
+				platformBaseImageOverrides[key] = platforms
+			default:
+				return fmt.Errorf("'baseImageOverrides': expected image reference or map of platforms for %s, got %v", key, value)
 			}
-			baseImageOverrides[key] = value
 		}
 		bo.BaseImageOverrides = baseImageOverrides
+		bo.PlatformBaseImageOverrides = platformBaseImageOverrides
 	}
 
 	if len(bo.BuildConfigs) == 0 {
@@ -212,8 +404,258 @@ func createBuildConfigMap(workingDirectory string, configs []build.Config) (map[
 			return nil, fmt.Errorf("'builds': entry #%d results in %d local packages, only 1 is expected", i, len(pkgs))
 		}
 		importPath := pkgs[0].PkgPath
+
+		// Image paths are always slash-separated, regardless of the host.
+		if config.BinaryPath != "" && (!strings.HasPrefix(config.BinaryPath, "/") || strings.HasSuffix(config.BinaryPath, "/")) {
+			return nil, fmt.Errorf("'builds': entry #%d binaryPath %q must be an absolute path to a file", i, config.BinaryPath)
+		}
+		if config.DataPath != "" && !strings.HasPrefix(config.DataPath, "/") {
+			return nil, fmt.Errorf("'builds': entry #%d dataPath %q must be an absolute path", i, config.DataPath)
+		}
+		if config.WorkingDir != "" && !strings.HasPrefix(config.WorkingDir, "/") {
+			return nil, fmt.Errorf("'builds': entry #%d workingDir %q must be an absolute path", i, config.WorkingDir)
+		}
+		for _, kv := range config.ImageEnv {
+			if !strings.Contains(kv, "=") || strings.HasPrefix(kv, "=") {
+				return nil, fmt.Errorf("'builds': entry #%d imageEnv has %q, expected KEY=VALUE", i, kv)
+			}
+		}
+		if err := build.ValidateTemplates(config); err != nil {
+			return nil, fmt.Errorf("'builds': entry #%d has an invalid template: %w", i, err)
+		}
+		for spec := range config.PlatformFlags {
+			if _, err := v1.ParsePlatform(spec); err != nil {
+				return nil, fmt.Errorf("'builds': entry #%d platformFlags has invalid platform %q: %w", i, spec, err)
+			}
+		}
+		for spec := range config.PlatformLdflags {
+			if _, err := v1.ParsePlatform(spec); err != nil {
+				return nil, fmt.Errorf("'builds': entry #%d platformLdflags has invalid platform %q: %w", i, spec, err)
+			}
+		}
+		for spec, env := range config.PlatformEnv {
+			if _, err := v1.ParsePlatform(spec); err != nil {
+				return nil, fmt.Errorf("'builds': entry #%d platformEnv has invalid platform %q: %w", i, spec, err)
+			}
+			for _, kv := range env {
+				if !strings.Contains(kv, "=") {
+					return nil, fmt.Errorf("'builds': entry #%d platformEnv for %q has %q, expected key=value", i, spec, kv)
+				}
+			}
+		}
+
+		// Qualify any additional binaries the same way, so they can be
+		// built from any directory.
+		binaries := make([]string, 0, len(config.Binaries))
+		for _, bin := range config.Binaries {
+			localBinPath := bin
+			if !strings.HasPrefix(bin, ".") {
+				localBinPath = fmt.Sprint(".", string(filepath.Separator), bin)
+			}
+			pkgs, err := packages.Load(&packages.Config{Mode: packages.NeedName, Dir: dir}, localBinPath)
+			if err != nil {
+				return nil, fmt.Errorf("'builds': entry #%d binary %q is not a valid local import path for directory (%s): %w", i, bin, baseDir, err)
+			}
+			if len(pkgs) != 1 || pkgs[0].Name != "main" {
+				return nil, fmt.Errorf("'builds': entry #%d binary %q must be a single main package", i, bin)
+			}
+			binaries = append(binaries, pkgs[0].PkgPath)
+		}
+		if len(binaries) > 0 {
+			config.Binaries = binaries
+		}
+
 		buildConfigsByImportPath[importPath] = config
 	}
 
 	return buildConfigsByImportPath, nil
 }
+
+// readConfigFiles reads the config files at paths, or URLs, into v, merging
+// each of them over the ones before it.
+func readConfigFiles(v *viper.Viper, paths []string) error {
+	merged := map[string]interface{}{}
+	for _, path := range paths {
+		var b []byte
+		var err error
+		if isConfigURL(path) {
+			b, err = fetchConfig(path)
+		} else {
+			b, err = ioutil.ReadFile(path)
+		}
+		if err != nil {
+			return fmt.Errorf("error reading config file: %w", err)
+		}
+		expanded, err := expandEnv(string(b))
+		if err != nil {
+			return fmt.Errorf("error expanding environment variables in %s: %w", path, err)
+		}
+		var config map[string]interface{}
+		if err := yaml.Unmarshal([]byte(expanded), &config); err != nil {
+			return fmt.Errorf("error parsing config file %s: %w", path, err)
+		}
+		mergeConfig(merged, config)
+	}
+
+	b, err := yaml.Marshal(merged)
+	if err != nil {
+		return fmt.Errorf("error merging config files: %w", err)
+	}
+	v.SetConfigType("yaml")
+	if err := v.ReadConfig(bytes.NewReader(b)); err != nil {
+		return fmt.Errorf("error reading config file: %w", err)
+	}
+	return nil
+}
+
+// mergeConfig merges the config src over dst: maps are merged recursively,
+// the entries of builds with the same id are merged, and other builds and
+// values replace the ones in dst.
+func mergeConfig(dst, src map[string]interface{}) {
+	builds := mergeBuilds(dst["builds"], src["builds"])
+	mergeMaps(dst, src)
+	if builds != nil {
+		dst["builds"] = builds
+	}
+}
+
+func mergeMaps(dst, src map[string]interface{}) {
+	for k, sv := range src {
+		dm, dok := dst[k].(map[string]interface{})
+		sm, sok := sv.(map[string]interface{})
+		if dok && sok {
+			mergeMaps(dm, sm)
+			continue
+		}
+		dst[k] = sv
+	}
+}
+
+// mergeBuilds returns the builds dst with the ones in src merged over them
+// by id, or nil if either isn't a list.
+func mergeBuilds(dst, src interface{}) []interface{} {
+	dl, dok := dst.([]interface{})
+	sl, sok := src.([]interface{})
+	if !dok || !sok {
+		return nil
+	}
+	merged := append([]interface{}{}, dl...)
+	for _, sb := range sl {
+		sm, ok := sb.(map[string]interface{})
+		if id, hasID := sm["id"]; ok && hasID {
+			if i := indexOfBuild(merged, id); i >= 0 {
+				mergeMaps(merged[i].(map[string]interface{}), sm)
+				continue
+			}
+		}
+		merged = append(merged, sb)
+	}
+	return merged
+}
+
+// indexOfBuild returns the index of the build with the given id in builds,
+// or -1.
+func indexOfBuild(builds []interface{}, id interface{}) int {
+	for i, b := range builds {
+		if m, ok := b.(map[string]interface{}); ok && m["id"] == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// expandEnv replaces the references to environment variables in s with their
+// values. It is an error to reference a variable that isn't set, unless the
+// reference has a default, like ${NAME:-default}, which is also used if the
+// variable is empty.
+func expandEnv(s string) (string, error) {
+	var err error
+	expanded := envRegexp.ReplaceAllStringFunc(s, func(ref string) string {
+		if ref == "$$" {
+			return "$"
+		}
+		m := envRegexp.FindStringSubmatch(ref)
+		name := m[2]
+		var def *string
+		if name == "" {
+			name = m[1]
+			if i := strings.Index(name, ":-"); i >= 0 {
+				d := name[i+len(":-"):]
+				name, def = name[:i], &d
+			}
+		}
+		if !envNameRegexp.MatchString(name) {
+			if err == nil {
+				err = fmt.Errorf("%q is not a valid environment variable reference", ref)
+			}
+			return ref
+		}
+		value, ok := os.LookupEnv(name)
+		if def != nil && value == "" {
+			return *def
+		}
+		if !ok && err == nil {
+			err = fmt.Errorf("environment variable %s is not set", name)
+		}
+		return value
+	})
+	if err != nil {
+		return "", err
+	}
+	return expanded, nil
+}
+
+// TarballBaseScheme is the prefix of base images that are read from a tarball,
+// like one written by `docker save` or `crane pull`, rather than pulled.
+const TarballBaseScheme = "tarball://"
+
+// LayoutBaseScheme is the prefix of base images that are read from an OCI
+// image layout directory rather than pulled.
+const LayoutBaseScheme = "oci://"
+
+// SourceURLNone is the --source-url that leaves the source URL of images
+// unset, rather than deriving it.
+const SourceURLNone = "none"
+
+// DebugBase returns the debug variant of the base image ref, per the
+// convention of distroless images: :latest becomes :debug, and any other tag,
+// like :nonroot, :debug-nonroot. Tags that already start with debug are kept.
+func DebugBase(ref string) (string, error) {
+	if strings.HasPrefix(ref, TarballBaseScheme) || strings.HasPrefix(ref, LayoutBaseScheme) {
+		return "", fmt.Errorf("base image %s has no debug variant, set one with --debug-base", ref)
+	}
+	r, err := name.ParseReference(ref)
+	if err != nil {
+		return "", err
+	}
+	tag, ok := r.(name.Tag)
+	if !ok {
+		return "", fmt.Errorf("base image %s is pinned by digest, so it has no debug variant, set one with --debug-base", ref)
+	}
+	switch t := tag.TagStr(); {
+	case strings.HasPrefix(t, "debug"):
+		return ref, nil
+	case t == "latest":
+		return tag.Context().Tag("debug").String(), nil
+	default:
+		return tag.Context().Tag("debug-" + t).String(), nil
+	}
+}
+
+// validateBaseImage checks that ref is an image reference or the path to a
+// tarball or OCI layout.
+func validateBaseImage(ref string) error {
+	for scheme, kind := range map[string]string{
+		TarballBaseScheme: "a tarball",
+		LayoutBaseScheme:  "an OCI layout",
+	} {
+		if strings.HasPrefix(ref, scheme) {
+			if strings.TrimPrefix(ref, scheme) == "" {
+				return fmt.Errorf("%s needs the path of %s", scheme, kind)
+			}
+			return nil
+		}
+	}
+	_, err := name.ParseReference(ref)
+	return err
+}
@@ -16,6 +16,8 @@ package options
 
 import (
 	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 
@@ -38,6 +40,79 @@ func TestDefaultBaseImage(t *testing.T) {
 	}
 }
 
+func TestScheme(t *testing.T) {
+	bo := &BuildOptions{
+		WorkingDirectory: "testdata/config",
+	}
+	if err := bo.LoadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if bo.Scheme != build.StrictScheme {
+		t.Errorf("wanted Scheme %s, got %s", build.StrictScheme, bo.Scheme)
+	}
+
+	for _, scheme := range []string{"image", "image:/", "1mage://", "ko:///"} {
+		bo := &BuildOptions{
+			WorkingDirectory: "testdata/config",
+			Scheme:           scheme,
+		}
+		if err := bo.LoadConfig(); err == nil {
+			t.Errorf("LoadConfig() with scheme %q = nil, wanted an error", scheme)
+		}
+	}
+}
+
+func TestDebugBase(t *testing.T) {
+	for _, tc := range []struct {
+		base, want string
+	}{
+		{"gcr.io/distroless/static", "gcr.io/distroless/static:debug"},
+		{"gcr.io/distroless/static:latest", "gcr.io/distroless/static:debug"},
+		{"gcr.io/distroless/static:nonroot", "gcr.io/distroless/static:debug-nonroot"},
+		{"gcr.io/distroless/static:debug-nonroot", "gcr.io/distroless/static:debug-nonroot"},
+	} {
+		got, err := DebugBase(tc.base)
+		if err != nil {
+			t.Errorf("DebugBase(%q) = %v", tc.base, err)
+		} else if got != tc.want {
+			t.Errorf("DebugBase(%q) = %q, want %q", tc.base, got, tc.want)
+		}
+	}
+
+	for _, base := range []string{
+		"gcr.io/distroless/static@sha256:" + strings.Repeat("a", 64),
+		TarballBaseScheme + "base.tar",
+	} {
+		if _, err := DebugBase(base); err == nil {
+			t.Errorf("DebugBase(%q) = nil error, want one", base)
+		}
+	}
+}
+
+func TestModuleRoot(t *testing.T) {
+	bo := &BuildOptions{
+		WorkingDirectory: "testdata",
+		ModuleRoot:       "paths/app",
+	}
+	if err := bo.LoadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	want, err := filepath.Abs("testdata/paths/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bo.WorkingDirectory != want {
+		t.Errorf("WorkingDirectory = %s, wanted %s", bo.WorkingDirectory, want)
+	}
+
+	bo = &BuildOptions{
+		ModuleRoot: "testdata/config",
+	}
+	if err := bo.LoadConfig(); err == nil {
+		t.Error("LoadConfig() with module root without go.mod = nil, wanted an error")
+	}
+}
+
 func TestBuildConfigWithWorkingDirectoryAndDirAndMain(t *testing.T) {
 	bo := &BuildOptions{
 		WorkingDirectory: "testdata/paths",
@@ -51,9 +126,71 @@ func TestBuildConfigWithWorkingDirectoryAndDirAndMain(t *testing.T) {
 		t.Fatalf("expected 1 build config, got %d", len(bo.BuildConfigs))
 	}
 	expectedImportPath := "example.com/testapp/cmd/foo" // module from app/go.mod + `main` from .ko.yaml
-	if _, exists := bo.BuildConfigs[expectedImportPath]; !exists {
+	config, exists := bo.BuildConfigs[expectedImportPath]
+	if !exists {
 		t.Fatalf("expected build config for import path [%s], got %+v", expectedImportPath, bo.BuildConfigs)
 	}
+	if config.Trimpath == nil || *config.Trimpath {
+		t.Errorf("expected trimpath: false from .ko.yaml, got %v", config.Trimpath)
+	}
+	if got, want := config.PlatformFlags["linux/arm64"], (build.FlagArray{"-tags=arm"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("PlatformFlags[linux/arm64] = %v, want %v", got, want)
+	}
+	if got, want := config.PlatformLdflags["linux/amd64"], (build.StringArray{"-s -w"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("PlatformLdflags[linux/amd64] = %v, want %v", got, want)
+	}
+}
+
+func TestCreateBuildConfigsWithImageEnv(t *testing.T) {
+	buildConfigMap, err := createBuildConfigMap("../../..", []build.Config{{
+		Main:     "test",
+		ImageEnv: []string{"APP_MODE=production", "EMPTY="},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buildConfigMap["github.com/google/ko/test"].ImageEnv, []string{"APP_MODE=production", "EMPTY="}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ImageEnv = %v, want %v", got, want)
+	}
+
+	for _, kv := range []string{"APP_MODE", "=production"} {
+		if _, err := createBuildConfigMap("../../..", []build.Config{{
+			Main:     "test",
+			ImageEnv: []string{kv},
+		}}); err == nil {
+			t.Errorf("createBuildConfigMap() = nil, want error for imageEnv %q", kv)
+		}
+	}
+}
+
+func TestCreateBuildConfigsWithInvalidPlatformSettings(t *testing.T) {
+	if _, err := createBuildConfigMap("../../..", []build.Config{{
+		Main:          "test",
+		PlatformFlags: map[string]build.FlagArray{"linux/arm/v7/extra": {"-v"}},
+	}}); err == nil {
+		t.Error("createBuildConfigMap() = nil, want error for invalid platform")
+	}
+	if _, err := createBuildConfigMap("../../..", []build.Config{{
+		Main:        "test",
+		PlatformEnv: map[string][]string{"linux/arm64": {"CC"}},
+	}}); err == nil {
+		t.Error("createBuildConfigMap() = nil, want error for platformEnv entry without a value")
+	}
+}
+
+func TestCreateBuildConfigsWithInvalidTemplate(t *testing.T) {
+	if _, err := createBuildConfigMap("../../..", []build.Config{{
+		Main:    "test",
+		Ldflags: build.StringArray{"-X main.version={{.GitTag}}"},
+	}}); err != nil {
+		t.Errorf("createBuildConfigMap() = %v", err)
+	}
+	if _, err := createBuildConfigMap("../../..", []build.Config{{
+		Main:    "test",
+		Ldflags: build.StringArray{"-X main.version={{.Version}}"},
+	}}); err == nil {
+		t.Error("createBuildConfigMap() = nil, want error for a template referring to an unknown field")
+	}
 }
 
 func TestCreateBuildConfigs(t *testing.T) {
@@ -91,6 +228,120 @@ func TestCreateBuildConfigs(t *testing.T) {
 	}
 }
 
+func TestCreateBuildConfigsWithBinaries(t *testing.T) {
+	buildConfigMap, err := createBuildConfigMap("../../..", []build.Config{{
+		ID:       "multi",
+		Main:     "test",
+		Binaries: []string{"cmd/help", "."},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, ok := buildConfigMap["github.com/google/ko/test"]
+	if !ok {
+		t.Fatalf("expected build config for github.com/google/ko/test, got %+v", buildConfigMap)
+	}
+	want := []string{"github.com/google/ko/cmd/help", "github.com/google/ko"}
+	if strings.Join(cfg.Binaries, ",") != strings.Join(want, ",") {
+		t.Errorf("Binaries = %v, want %v", cfg.Binaries, want)
+	}
+
+	if _, err := createBuildConfigMap("../../..", []build.Config{{
+		Main:     "test",
+		Binaries: []string{"pkg/build"},
+	}}); err == nil {
+		t.Error("createBuildConfigMap() = nil, want error for non-main binary")
+	}
+}
+
+func TestCreateBuildConfigsWithBinaryPath(t *testing.T) {
+	buildConfigMap, err := createBuildConfigMap("../../..", []build.Config{{
+		Main:       "test",
+		BinaryPath: "/usr/local/bin/app",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buildConfigMap["github.com/google/ko/test"].BinaryPath, "/usr/local/bin/app"; got != want {
+		t.Errorf("BinaryPath = %q, want %q", got, want)
+	}
+
+	for _, binaryPath := range []string{"usr/local/bin/app", "/usr/local/bin/"} {
+		if _, err := createBuildConfigMap("../../..", []build.Config{{
+			Main:       "test",
+			BinaryPath: binaryPath,
+		}}); err == nil {
+			t.Errorf("createBuildConfigMap() = nil, want error for binaryPath %q", binaryPath)
+		}
+	}
+}
+
+func TestCreateBuildConfigsWithWorkingDir(t *testing.T) {
+	buildConfigMap, err := createBuildConfigMap("../../..", []build.Config{{
+		Main:       "test",
+		WorkingDir: "/ko-app",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buildConfigMap["github.com/google/ko/test"].WorkingDir, "/ko-app"; got != want {
+		t.Errorf("WorkingDir = %q, want %q", got, want)
+	}
+
+	if _, err := createBuildConfigMap("../../..", []build.Config{{
+		Main:       "test",
+		WorkingDir: "ko-app",
+	}}); err == nil {
+		t.Error("createBuildConfigMap() = nil, want error for relative workingDir")
+	}
+}
+
+func TestCreateBuildConfigsWithDataPath(t *testing.T) {
+	buildConfigMap, err := createBuildConfigMap("../../..", []build.Config{{
+		Main:     "test",
+		DataPath: "/var/run/app-data",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buildConfigMap["github.com/google/ko/test"].DataPath, "/var/run/app-data"; got != want {
+		t.Errorf("DataPath = %q, want %q", got, want)
+	}
+
+	if _, err := createBuildConfigMap("../../..", []build.Config{{
+		Main:     "test",
+		DataPath: "app-data",
+	}}); err == nil {
+		t.Error("createBuildConfigMap() = nil, want error for relative dataPath")
+	}
+}
+
+func TestPlatformBaseImageOverrides(t *testing.T) {
+	bo := &BuildOptions{
+		WorkingDirectory: "testdata/platform-overrides",
+	}
+	if err := bo.LoadConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := bo.BaseImageOverrides["example.com/app"], "gcr.io/distroless/static"; got != want {
+		t.Errorf("BaseImageOverrides[example.com/app] = %q, want %q", got, want)
+	}
+	want := map[string]string{
+		"linux/amd64": "gcr.io/distroless/static",
+		"linux/arm64": "gcr.io/distroless/base:debug",
+	}
+	got := bo.PlatformBaseImageOverrides["example.com/debug"]
+	if len(got) != len(want) {
+		t.Fatalf("PlatformBaseImageOverrides[example.com/debug] = %v, want %v", got, want)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("PlatformBaseImageOverrides[example.com/debug][%s] = %q, want %q", k, got[k], v)
+		}
+	}
+}
+
 func TestAddBuildOptionsSetsDefaultsForNonFlagOptions(t *testing.T) {
 	cmd := &cobra.Command{}
 	bo := &BuildOptions{}
@@ -143,3 +394,125 @@ func TestOverrideConfigPath(t *testing.T) {
 		})
 	}
 }
+
+func TestExpandEnv(t *testing.T) {
+	t.Setenv("KO_TEST_VERSION", "v1.2.3")
+	t.Setenv("KO_TEST_EMPTY", "")
+
+	for _, test := range []struct {
+		in, want string
+		wantErr  bool
+	}{
+		{in: "-X main.version=${KO_TEST_VERSION}", want: "-X main.version=v1.2.3"},
+		{in: "-X main.version=$KO_TEST_VERSION", want: "-X main.version=v1.2.3"},
+		{in: "${KO_TEST_VERSION:-dev}", want: "v1.2.3"},
+		{in: "${KO_TEST_UNSET:-dev}", want: "dev"},
+		{in: "${KO_TEST_EMPTY:-dev}", want: "dev"},
+		{in: "${KO_TEST_EMPTY}", want: ""},
+		{in: "${KO_TEST_UNSET:-}", want: ""},
+		{in: "costs $$5 or $5", want: "costs $5 or $5"},
+		{in: "${KO_TEST_UNSET}", wantErr: true},
+		{in: "$KO_TEST_UNSET", wantErr: true},
+		{in: "${not valid}", wantErr: true},
+	} {
+		got, err := expandEnv(test.in)
+		if (err != nil) != test.wantErr {
+			t.Errorf("expandEnv(%q) = %v, wanted error: %t", test.in, err, test.wantErr)
+		}
+		if err == nil && got != test.want {
+			t.Errorf("expandEnv(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestLoadConfigExpandsEnv(t *testing.T) {
+	dir := "testdata/env"
+	t.Setenv("KO_TEST_VERSION", "v1.2.3")
+	bo := &BuildOptions{WorkingDirectory: dir}
+	if err := bo.LoadConfig(); err != nil {
+		t.Fatalf("LoadConfig() = %v", err)
+	}
+	if bo.BaseImage != "alpine" {
+		t.Errorf("wanted BaseImage alpine, got %s", bo.BaseImage)
+	}
+	var ldflags []string
+	for _, bc := range bo.BuildConfigs {
+		ldflags = append(ldflags, bc.Ldflags...)
+	}
+	if want := []string{"-X main.version=v1.2.3", "-X main.price=$5"}; !reflect.DeepEqual(ldflags, want) {
+		t.Errorf("wanted ldflags %v, got %v", want, ldflags)
+	}
+
+	os.Unsetenv("KO_TEST_VERSION")
+	bo = &BuildOptions{WorkingDirectory: dir}
+	if err := bo.LoadConfig(); err == nil || !strings.Contains(err.Error(), "KO_TEST_VERSION") {
+		t.Errorf("LoadConfig() = %v, wanted an error about KO_TEST_VERSION", err)
+	}
+}
+
+func TestLoadConfigMergesConfigFiles(t *testing.T) {
+	bo := &BuildOptions{
+		WorkingDirectory: "testdata/merge",
+		ConfigFiles:      []string{"testdata/merge/base.yaml", "testdata/merge/service.yaml"},
+	}
+	if err := bo.LoadConfig(); err != nil {
+		t.Fatalf("LoadConfig() = %v", err)
+	}
+
+	if bo.BaseImage != "alpine" {
+		t.Errorf("wanted BaseImage alpine, got %s", bo.BaseImage)
+	}
+	wantOverrides := map[string]string{
+		"example.com/a": "gcr.io/base/a",
+		"example.com/b": "gcr.io/service/b",
+	}
+	if !reflect.DeepEqual(bo.BaseImageOverrides, wantOverrides) {
+		t.Errorf("wanted BaseImageOverrides %v, got %v", wantOverrides, bo.BaseImageOverrides)
+	}
+
+	if len(bo.BuildConfigs) != 1 {
+		t.Fatalf("wanted 1 build config, got %v", bo.BuildConfigs)
+	}
+	for _, bc := range bo.BuildConfigs {
+		if bc.Main != "./cmd/foo" {
+			t.Errorf("wanted main ./cmd/foo from base.yaml, got %s", bc.Main)
+		}
+		if want := []string{"GOPRIVATE=example.com"}; !reflect.DeepEqual(bc.Env, want) {
+			t.Errorf("wanted env %v from base.yaml, got %v", want, bc.Env)
+		}
+		if want := (build.StringArray{"-X main.service=foo"}); !reflect.DeepEqual(bc.Ldflags, want) {
+			t.Errorf("wanted ldflags %v from service.yaml, got %v", want, bc.Ldflags)
+		}
+	}
+}
+
+func TestMergeConfig(t *testing.T) {
+	dst := map[string]interface{}{
+		"a": map[string]interface{}{"b": 1, "c": 2},
+		"d": []interface{}{1},
+		"builds": []interface{}{
+			map[string]interface{}{"id": "x", "main": "./x"},
+			map[string]interface{}{"id": "y", "main": "./y"},
+		},
+	}
+	mergeConfig(dst, map[string]interface{}{
+		"a": map[string]interface{}{"c": 3},
+		"d": []interface{}{2},
+		"builds": []interface{}{
+			map[string]interface{}{"id": "y", "flags": []interface{}{"-v"}},
+			map[string]interface{}{"id": "z", "main": "./z"},
+		},
+	})
+	want := map[string]interface{}{
+		"a": map[string]interface{}{"b": 1, "c": 3},
+		"d": []interface{}{2},
+		"builds": []interface{}{
+			map[string]interface{}{"id": "x", "main": "./x"},
+			map[string]interface{}{"id": "y", "main": "./y", "flags": []interface{}{"-v"}},
+			map[string]interface{}{"id": "z", "main": "./z"},
+		},
+	}
+	if !reflect.DeepEqual(dst, want) {
+		t.Errorf("mergeConfig() = %v, want %v", dst, want)
+	}
+}
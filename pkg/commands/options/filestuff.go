@@ -26,6 +26,9 @@ import (
 type FilenameOptions struct {
 	Filenames []string
 	Recursive bool
+	// Watch keeps resolving the files as they, or the sources of the images
+	// they reference, change.
+	Watch bool
 }
 
 func AddFileArg(cmd *cobra.Command, fo *FilenameOptions) {
@@ -36,6 +39,12 @@ func AddFileArg(cmd *cobra.Command, fo *FilenameOptions) {
 		"Process the directory used in -f, --filename recursively. Useful when you want to manage related manifests organized within the same directory.")
 }
 
+// AddWatchArg adds the --watch flag, for commands that support it.
+func AddWatchArg(cmd *cobra.Command, fo *FilenameOptions) {
+	cmd.Flags().BoolVarP(&fo.Watch, "watch", "W", fo.Watch,
+		"Continuously monitor the files, and the sources of the images they reference, for changes, and rebuild and reapply the affected files.")
+}
+
 // Based heavily on pkg/kubectl
 func EnumerateFiles(fo *FilenameOptions) chan string {
 	files := make(chan string)
@@ -0,0 +1,128 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package options
+
+import (
+	"bytes"
+	"crypto/md5" // nolint: gosec // No strong cryptography needed.
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/ko/pkg/publish"
+)
+
+// ImageNameData is the data available to Go templates in
+// --image-name-template, for an image built from ImportPath.
+type ImageNameData struct {
+	// TagData is the git state of the current directory, as for --tags.
+	TagData
+	// ImportPath is the import path of the image's main package, like
+	// github.com/my-org/my-repo/cmd/service.
+	ImportPath string
+	// Name is the last element of ImportPath, like service.
+	Name string
+	// Elems are the elements of ImportPath, like github.com, my-org,
+	// my-repo, cmd and service.
+	Elems []string
+	// MD5 is the hex MD5 hash of ImportPath, as the default names end with.
+	MD5 string
+}
+
+// TemplateNamer names images by executing an --image-name-template for
+// their import path, to get the path of their repository under
+// KO_DOCKER_REPO.
+type TemplateNamer struct {
+	text string
+	tmpl *template.Template
+
+	data    func() (TagData, error)
+	once    sync.Once
+	td      TagData
+	dataErr error
+}
+
+// NewTemplateNamer parses text as an image name template. data is called
+// for the git state the first time an image is named, if text refers to it.
+func NewTemplateNamer(text string, data func() (TagData, error)) (*TemplateNamer, error) {
+	tmpl, err := template.New("image name").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid image name template %q: %w", text, err)
+	}
+	return &TemplateNamer{text: text, tmpl: tmpl, data: data}, nil
+}
+
+// Path returns the path of the repository of the image built from
+// importpath, relative to KO_DOCKER_REPO, checking that it's a valid
+// repository path.
+func (n *TemplateNamer) Path(importpath string) (string, error) {
+	d := ImageNameData{
+		ImportPath: importpath,
+		Name:       path.Base(importpath),
+		Elems:      strings.Split(importpath, "/"),
+	}
+	hasher := md5.New() // nolint: gosec // No strong cryptography needed.
+	hasher.Write([]byte(importpath))
+	d.MD5 = hex.EncodeToString(hasher.Sum(nil))
+	if strings.Contains(n.text, "Git") {
+		n.once.Do(func() { n.td, n.dataErr = n.data() })
+		if n.dataErr != nil {
+			return "", fmt.Errorf("reading git state for image name template %q: %w", n.text, n.dataErr)
+		}
+		d.TagData = n.td
+	}
+
+	var buf bytes.Buffer
+	if err := n.tmpl.Execute(&buf, d); err != nil {
+		return "", fmt.Errorf("invalid image name template %q for %s: %w", n.text, importpath, err)
+	}
+	p := strings.Trim(buf.String(), "/")
+	if p == "" {
+		return "", nil
+	}
+	// Check the path alone, as KO_DOCKER_REPO is checked on its own.
+	if _, err := name.NewRepository("example.com/"+p, name.StrictValidation); err != nil {
+		return "", fmt.Errorf("image name template %q expanded to invalid repository path %q for %s: %w", n.text, p, importpath, err)
+	}
+	return p, nil
+}
+
+// Namer returns a publish.Namer that names images with Path. Since a Namer
+// can't fail, the import paths it's called with must have been checked with
+// Path first.
+func (n *TemplateNamer) Namer() publish.Namer {
+	return func(base, importpath string) string {
+		p, _ := n.Path(importpath)
+		return path.Join(base, p)
+	}
+}
+
+// validateImageNameTemplate checks that the --image-name-template parses,
+// without executing it.
+func validateImageNameTemplate(po *PublishOptions) error {
+	if po.ImageNameTemplate == "" {
+		return nil
+	}
+	if po.PreserveImportPaths || po.BaseImportPaths || po.Bare {
+		return errors.New("--image-name-template cannot be used with --preserve-import-paths, --base-import-paths or --bare")
+	}
+	_, err := NewTemplateNamer(po.ImageNameTemplate, nil)
+	return err
+}
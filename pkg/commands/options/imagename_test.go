@@ -0,0 +1,145 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package options_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/ko/pkg/commands/options"
+)
+
+func TestTemplateNamer(t *testing.T) {
+	const importpath = "github.com/my-org/my-repo/cmd/service"
+	git := options.TagData{GitTag: "v1.2.3", GitCommitShort: "0123456"}
+	for _, tc := range []struct {
+		name, template string
+		want           string
+		wantErr        bool
+	}{{
+		name:     "name",
+		template: "{{.Name}}",
+		want:     "registry.example.org/foo/service",
+	}, {
+		name:     "prefix",
+		template: "services/{{.Name}}",
+		want:     "registry.example.org/foo/services/service",
+	}, {
+		name:     "elements",
+		template: "{{index .Elems 2}}/{{.Name}}",
+		want:     "registry.example.org/foo/my-repo/service",
+	}, {
+		name:     "git state",
+		template: "{{.Name}}-{{.GitTag}}",
+		want:     "registry.example.org/foo/service-v1.2.3",
+	}, {
+		name:     "md5",
+		template: "{{.Name}}-{{.MD5}}",
+		want:     "registry.example.org/foo/service-7fd06db1583013f85aa7cb462ff85b39",
+	}, {
+		name:     "empty",
+		template: "",
+		want:     "registry.example.org/foo",
+	}, {
+		name:     "invalid repository path",
+		template: "{{.Name}}:latest",
+		wantErr:  true,
+	}, {
+		name:     "uppercase",
+		template: "Services/{{.Name}}",
+		wantErr:  true,
+	}, {
+		name:     "out of range",
+		template: "{{index .Elems 9}}",
+		wantErr:  true,
+	}, {
+		name:     "unknown field",
+		template: "{{.Nope}}",
+		wantErr:  true,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			n, err := options.NewTemplateNamer(tc.template, func() (options.TagData, error) { return git, nil })
+			if err != nil {
+				t.Fatalf("NewTemplateNamer() = %v", err)
+			}
+			if _, err := n.Path(importpath); (err != nil) != tc.wantErr {
+				t.Fatalf("Path() = %v, wantErr %v", err, tc.wantErr)
+			}
+			if tc.wantErr {
+				return
+			}
+			if got := n.Namer()("registry.example.org/foo", importpath); got != tc.want {
+				t.Errorf("Namer() = %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestTemplateNamerGitState(t *testing.T) {
+	calls := 0
+	data := func() (options.TagData, error) {
+		calls++
+		return options.TagData{}, errors.New("not a git repository")
+	}
+
+	n, err := options.NewTemplateNamer("{{.Name}}", data)
+	if err != nil {
+		t.Fatalf("NewTemplateNamer() = %v", err)
+	}
+	if _, err := n.Path("example.com/app"); err != nil {
+		t.Errorf("Path() = %v", err)
+	}
+	if calls != 0 {
+		t.Errorf("git state was read %d times for a template that doesn't use it", calls)
+	}
+
+	n, err = options.NewTemplateNamer("{{.Name}}-{{.GitCommitShort}}", data)
+	if err != nil {
+		t.Fatalf("NewTemplateNamer() = %v", err)
+	}
+	if _, err := n.Path("example.com/app"); err == nil {
+		t.Error("Path() without git state = nil, wanted error")
+	}
+}
+
+func TestValidateImageNameTemplate(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		po      options.PublishOptions
+		wantErr bool
+	}{{
+		name: "valid",
+		po:   options.PublishOptions{ImageNameTemplate: "{{.Name}}"},
+	}, {
+		name:    "unparseable",
+		po:      options.PublishOptions{ImageNameTemplate: "{{.Name"},
+		wantErr: true,
+	}, {
+		name:    "with --bare",
+		po:      options.PublishOptions{ImageNameTemplate: "{{.Name}}", Bare: true},
+		wantErr: true,
+	}, {
+		name:    "with --preserve-import-paths",
+		po:      options.PublishOptions{ImageNameTemplate: "{{.Name}}", PreserveImportPaths: true},
+		wantErr: true,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := options.Validate(&tc.po, &options.BuildOptions{})
+			if (err != nil) != tc.wantErr {
+				t.Errorf("Validate() = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,32 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package options
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// KubectlOptions are the flags forwarded to the kubectl invocation.
+type KubectlOptions struct {
+	Context   string
+	Namespace string
+}
+
+func AddKubectlArgs(cmd *cobra.Command, ko *KubectlOptions) {
+	cmd.Flags().StringVar(&ko.Context, "context", "",
+		"The name of the kubeconfig context for kubectl to use.")
+	cmd.Flags().StringVarP(&ko.Namespace, "namespace", "n", "",
+		"The namespace for kubectl to use, if the input files don't set one.")
+}
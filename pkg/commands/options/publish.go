@@ -21,18 +21,27 @@ import (
 	"encoding/hex"
 	"os"
 	"path"
+	"time"
 
 	"github.com/google/go-containerregistry/pkg/v1/daemon"
+	"github.com/google/ko/internal/sign"
 	"github.com/google/ko/pkg/publish"
 	"github.com/spf13/cobra"
 )
 
+// SignKeyless is the --sign mode for sigstore keyless signing.
+const SignKeyless = "keyless"
+
 // PublishOptions encapsulates options when publishing.
 type PublishOptions struct {
 	// DockerRepo configures the destination image repository.
 	// In normal ko usage, this is populated with the value of $KO_DOCKER_REPO.
 	DockerRepo string
 
+	// PrimaryRepo selects which of several comma-separated repositories in
+	// DockerRepo references are resolved to. Defaults to the first one.
+	PrimaryRepo string
+
 	// LocalDomain overrides the default domain for images loaded into the local Docker daemon. Use with Local=true.
 	LocalDomain string
 
@@ -46,18 +55,80 @@ type PublishOptions struct {
 	DockerClient daemon.Client
 
 	Tags []string
+	// ImageAnnotations are key=value annotations to add to published
+	// multi-platform image indexes.
+	ImageAnnotations []string
 	// TagOnly resolves images into tag-only references.
 	TagOnly bool
+	// NoTag pushes images by digest only, without any tags.
+	NoTag bool
 
 	// Push publishes images to a registry.
 	Push bool
+	// PushRetries is how many times to retry pushes to a registry that fail
+	// with transient errors, waiting PushRetryDelay before the first retry
+	// and backing off exponentially after that.
+	PushRetries    int
+	PushRetryDelay time.Duration
+	// PushConcurrency bounds how many requests to the registry are made in
+	// parallel while pushing an image or index.
+	PushConcurrency int
+	// FailIfTagExists refuses to push an image if one of its tags already
+	// points at a different image.
+	FailIfTagExists bool
+	// MountBlobs mounts the layers shared by images pushed to different
+	// repositories on the same registry, instead of uploading them to each.
+	MountBlobs bool
+	// SBOMAttach is how SBOMs are attached to the images pushed to a
+	// registry: "tag" or "referrer".
+	SBOMAttach string
+	// Sign is how images pushed to a registry are signed: "" to not sign
+	// them, or "keyless" to sign them with sigstore, using the Fulcio and
+	// Rekor instances at FulcioURL and RekorURL.
+	Sign      string
+	FulcioURL string
+	RekorURL  string
+	// Provenance attaches an SLSA provenance attestation to the images
+	// pushed to a registry.
+	Provenance bool
+	// AttestBuildCommand attaches an attestation with the command, build
+	// flags, environment and Go version each binary was built with.
+	AttestBuildCommand bool
+	// ECRCreateRepo creates repositories in Amazon ECR that don't exist yet
+	// when pushing to them.
+	ECRCreateRepo bool
+	// PullSecret is a dockerconfigjson Secret, as "namespace/name", whose
+	// credentials are used to push ahead of the ambient ones.
+	PullSecret string
+	// KindCluster is a kind cluster to load the images into, after pushing
+	// them to its local registry if it has one.
+	KindCluster string
 
 	// Local publishes images to a local docker daemon.
 	Local            bool
 	InsecureRegistry bool
+	// InsecureRegistries are patterns of registry hosts to treat as
+	// insecure, when InsecureRegistry is not set.
+	InsecureRegistries []string
+
+	// LoadPodman publishes images to a local podman, like Local does to docker.
+	LoadPodman bool
+
+	// LoadKind loads images into the nodes of the named kind cluster, like
+	// KO_DOCKER_REPO=kind.local does for $KIND_CLUSTER_NAME.
+	LoadKind string
+
+	// Containerd publishes images to containerd, using the socket at
+	// ContainerdAddress and the namespace ContainerdNamespace.
+	Containerd          bool
+	ContainerdAddress   string
+	ContainerdNamespace string
 
 	OCILayoutPath string
 	TarballFile   string
+	// AllowTarballToStdout is set by commands that can stream the tarball
+	// to stdout with --tarball=-, because they print nothing else there.
+	AllowTarballToStdout bool
 
 	ImageRefsFile string
 
@@ -67,6 +138,10 @@ type PublishOptions struct {
 	BaseImportPaths bool
 	// Bare uses a tag on the KO_DOCKER_REPO without anything additional.
 	Bare bool
+	// ImageNameTemplate is a Go template of the path after KO_DOCKER_REPO,
+	// executed against ImageNameData. When given, PreserveImportPaths,
+	// BaseImportPaths and Bare can't be.
+	ImageNameTemplate string
 	// ImageNamer can be used to pass a custom image name function. When given
 	// PreserveImportPaths, BaseImportPaths, Bare has no effect.
 	ImageNamer publish.Namer
@@ -79,21 +154,73 @@ func AddPublishArg(cmd *cobra.Command, po *PublishOptions) {
 		po.DockerRepo = dockerRepo
 	}
 
+	cmd.Flags().StringVar(&po.PrimaryRepo, "primary-repo", "",
+		"When KO_DOCKER_REPO is a comma-separated list of repositories to push images to, which of them to resolve image references to. Defaults to the first one.")
+
 	cmd.Flags().StringSliceVarP(&po.Tags, "tags", "t", []string{"latest"},
 		"Which tags to use for the produced image instead of the default 'latest' tag "+
-			"(may not work properly with --base-import-paths or --bare).")
+			"(may not work properly with --base-import-paths or --bare). "+
+			"Tags may be Go templates using the git state of the current directory: "+
+			"{{.GitTag}}, {{.GitCommit}}, {{.GitCommitShort}} and {{.GitTreeState}}.")
+	cmd.Flags().StringSliceVar(&po.ImageAnnotations, "image-annotation", []string{},
+		"Which annotations (key=value) to add to the image index of multi-platform images, e.g. org.opencontainers.image.source=https://github.com/my-user/my-repo.")
 	cmd.Flags().BoolVar(&po.TagOnly, "tag-only", false,
 		"Include tags but not digests in resolved image references. Useful when digests are not preserved when images are repopulated.")
+	cmd.Flags().BoolVar(&po.NoTag, "no-tag", po.NoTag,
+		"Push images to KO_DOCKER_REPO by digest only, without writing any tags, and resolve them into digest references. Cannot be used with --tags or --tag-only.")
 
 	cmd.Flags().BoolVar(&po.Push, "push", true, "Push images to KO_DOCKER_REPO")
+	cmd.Flags().IntVar(&po.PushRetries, "push-retries", 0,
+		"How many times to retry requests to KO_DOCKER_REPO that fail with transient errors, such as 5xx responses or network errors. Other errors, such as 401 or 403, fail immediately. If 0, a few retries are made with a fixed backoff.")
+	cmd.Flags().DurationVar(&po.PushRetryDelay, "push-retry-delay", time.Second,
+		"How long to wait before the first push retry. Later retries back off exponentially, with jitter.")
+	cmd.Flags().IntVar(&po.PushConcurrency, "push-concurrency", 0,
+		"How many layers and manifests to upload to KO_DOCKER_REPO in parallel, and how many of the import paths passed to ko build to publish at once. If 0, the images of an index are pushed one at a time.")
+	cmd.Flags().BoolVar(&po.FailIfTagExists, "fail-if-tag-exists", po.FailIfTagExists,
+		"Whether to fail, before pushing anything, if a tag given with --tags already points at a different image in KO_DOCKER_REPO. The default latest tag is not checked.")
+	cmd.Flags().BoolVar(&po.MountBlobs, "mount-blobs", po.MountBlobs,
+		"Whether to mount layers already pushed to another repository under KO_DOCKER_REPO, like those of a shared base image, instead of uploading them again. Registries that don't support cross-repository mounts get them uploaded.")
+	cmd.Flags().StringVar(&po.SBOMAttach, "sbom-attach", publish.SBOMAttachTag,
+		"How to attach SBOMs to the images pushed to KO_DOCKER_REPO: tag, to push them with a tag derived from the image digest, or referrer, to push them as OCI 1.1 referrers of the image. If the registry doesn't support the referrers API, SBOMs are attached with a tag.")
+	cmd.Flags().StringVar(&po.Sign, "sign", po.Sign,
+		"How to sign the images pushed to KO_DOCKER_REPO, like cosign sign does. The only mode is keyless, which uses an OIDC identity token from $SIGSTORE_ID_TOKEN or GitHub Actions. Has no effect with --push=false.")
+	cmd.Flags().StringVar(&po.FulcioURL, "fulcio-url", sign.DefaultFulcioURL,
+		"URL of the Fulcio instance to get signing certificates from, with --sign=keyless.")
+	cmd.Flags().StringVar(&po.RekorURL, "rekor-url", sign.DefaultRekorURL,
+		"URL of the Rekor instance to record signatures in, with --sign=keyless.")
+	cmd.Flags().BoolVar(&po.Provenance, "provenance", po.Provenance,
+		"Whether to attach an SLSA provenance attestation, naming the module and git commit each image was built from, to the images pushed to KO_DOCKER_REPO, like cosign attest does. Has no effect with --push=false.")
+	cmd.Flags().BoolVar(&po.AttestBuildCommand, "attest-build-command", po.AttestBuildCommand,
+		"Whether to attach an in-toto attestation with the go build command line, environment and Go version of each binary to the images pushed to KO_DOCKER_REPO, like cosign attest does. Values of environment variables that look like secrets are redacted. Has no effect with --push=false.")
+	cmd.Flags().BoolVar(&po.ECRCreateRepo, "ecr-create-repo", po.ECRCreateRepo,
+		"Whether to create Amazon ECR repositories that don't exist yet when pushing to them, using the usual AWS credential chain. Has no effect on other registries.")
+	cmd.Flags().StringVar(&po.PullSecret, "pull-secret", "",
+		"A Kubernetes Secret of type kubernetes.io/dockerconfigjson, as namespace/name, to read with kubectl and push with the credentials of, ahead of the ones in the Docker config and environment for the registries it has.")
+
+	cmd.Flags().StringVar(&po.KindCluster, "kind-cluster", "",
+		"A kind cluster to load the images into. If the cluster has a local registry, advertised in the local-registry-hosting ConfigMap in kube-public, the images are also pushed there, instead of to KO_DOCKER_REPO.")
 
 	cmd.Flags().BoolVarP(&po.Local, "local", "L", po.Local,
 		"Load into images to local docker daemon.")
 	cmd.Flags().BoolVar(&po.InsecureRegistry, "insecure-registry", po.InsecureRegistry,
 		"Whether to skip TLS verification on the registry")
+	cmd.Flags().StringSliceVar(&po.InsecureRegistries, "insecure-registries", po.InsecureRegistries,
+		"Host patterns, like registry.internal:5000 or *.corp.example.com, of registries to reach over plain HTTP or without TLS verification. Unlike --insecure-registry, other registries are still verified.")
+	cmd.Flags().BoolVar(&po.LoadPodman, "load-podman", po.LoadPodman,
+		"Load images into podman, through its API socket. The socket is $CONTAINER_HOST if set, or the default rootless or rootful socket.")
+	cmd.Flags().StringVar(&po.LoadKind, "load-kind", "",
+		"Load images into the nodes of the named kind cluster, without a Docker daemon or registry. Equivalent to KO_DOCKER_REPO=kind.local with KIND_CLUSTER_NAME set to the cluster.")
+	cmd.Flags().BoolVar(&po.Containerd, "containerd", po.Containerd,
+		"Load images into containerd with ctr, instead of pushing them. Equivalent to KO_DOCKER_REPO=containerd.local.")
+	cmd.Flags().StringVar(&po.ContainerdAddress, "containerd-address", po.ContainerdAddress,
+		"Address of the containerd socket to load images into, e.g. /run/k3s/containerd/containerd.sock. Defaults to the ctr default.")
+	cmd.Flags().StringVar(&po.ContainerdNamespace, "containerd-namespace", "k8s.io",
+		"Containerd namespace to load images into.")
 
-	cmd.Flags().StringVar(&po.OCILayoutPath, "oci-layout-path", "", "Path to save the OCI image layout of the built images")
-	cmd.Flags().StringVar(&po.TarballFile, "tarball", "", "File to save images tarballs")
+	cmd.Flags().StringVar(&po.OCILayoutPath, "oci-layout", "",
+		"Directory to save the built images to as an OCI image layout, named by import path with the org.opencontainers.image.ref.name annotation. References resolve to <dir>@<digest>.")
+	cmd.Flags().StringVar(&po.OCILayoutPath, "oci-layout-path", "", "Path to save the OCI image layout of the built images. Same as --oci-layout.")
+	cmd.Flags().StringVar(&po.TarballFile, "tarball", "", "File to save all the images to, as a single tarball that can be loaded with docker load. With ko build, - streams it to stdout, and the image references are printed to stderr.")
 
 	cmd.Flags().StringVar(&po.ImageRefsFile, "image-refs", "",
 		"Path to file where a list of the published image references will be written.")
@@ -104,6 +231,28 @@ func AddPublishArg(cmd *cobra.Command, po *PublishOptions) {
 		"Whether to use the base path without MD5 hash after KO_DOCKER_REPO (may not work properly with --tags).")
 	cmd.Flags().BoolVar(&po.Bare, "bare", po.Bare,
 		"Whether to just use KO_DOCKER_REPO without additional context (may not work properly with --tags).")
+	cmd.Flags().StringVar(&po.ImageNameTemplate, "image-name-template", "",
+		"A Go template of the path after KO_DOCKER_REPO to name images with, like {{.Name}}. It can use {{.ImportPath}}, its last element {{.Name}}, its elements {{.Elems}}, its hash {{.MD5}}, and the git state {{.GitTag}}, {{.GitCommit}}, {{.GitCommitShort}} and {{.GitTreeState}}.")
+}
+
+// TarballToStdout is whether the tarball of the images is streamed to stdout,
+// which then can't be used for anything else.
+func (po *PublishOptions) TarballToStdout() bool {
+	return po.TarballFile == publish.StdoutTarball
+}
+
+// PublishesLocally is whether images are only published locally, to a
+// daemon, a kind cluster, a tarball or an OCI layout, rather than pushed to a
+// registry.
+func (po *PublishOptions) PublishesLocally() bool {
+	if po.Local || po.LoadPodman || po.Containerd || po.LoadKind != "" {
+		return true
+	}
+	switch po.DockerRepo {
+	case publish.LocalDomain, publish.KindDomain, publish.ContainerdDomain:
+		return true
+	}
+	return !po.Push
 }
 
 func packageWithMD5(base, importpath string) string {
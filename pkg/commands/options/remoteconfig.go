@@ -0,0 +1,128 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package options
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// configURLTimeout limits how long fetching a config from a URL may take.
+const configURLTimeout = 30 * time.Second
+
+var (
+	// remoteConfigs caches the configs fetched from URLs for the lifetime of
+	// the process, keyed by URL.
+	remoteConfigs   = map[string][]byte{}
+	remoteConfigsMu sync.Mutex
+)
+
+// isConfigURL returns whether the config path is a URL to fetch, rather than
+// a file.
+func isConfigURL(path string) bool {
+	return strings.HasPrefix(path, "https://") || strings.HasPrefix(path, "http://")
+}
+
+// fetchConfig returns the config at url, fetching it the first time. The
+// request is configured with the environment variables:
+//
+//   - KO_CONFIG_URL_HEADERS: headers to send, as newline-separated
+//     <name>=<value> pairs, e.g. Authorization=Bearer <token>, so that
+//     values can contain commas
+//   - KO_CONFIG_URL_CA_FILE: a PEM file of the CAs to trust instead of the
+//     system's
+//   - KO_CONFIG_URL_CERT_FILE and KO_CONFIG_URL_KEY_FILE: a client
+//     certificate and key to authenticate with
+func fetchConfig(url string) ([]byte, error) {
+	remoteConfigsMu.Lock()
+	defer remoteConfigsMu.Unlock()
+	if b, ok := remoteConfigs[url]; ok {
+		return b, nil
+	}
+
+	client, err := configURLClient()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if headers := os.Getenv("KO_CONFIG_URL_HEADERS"); headers != "" {
+		for _, header := range strings.Split(headers, "\n") {
+			if strings.TrimSpace(header) == "" {
+				continue
+			}
+			parts := strings.SplitN(header, "=", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("KO_CONFIG_URL_HEADERS: expected <name>=<value>, got %q", header)
+			}
+			req.Header.Set(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+		}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", url, resp.Status)
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("fetching %s: %w", url, err)
+	}
+	remoteConfigs[url] = b
+	return b, nil
+}
+
+// configURLClient returns the client to fetch configs from URLs with.
+func configURLClient() (*http.Client, error) {
+	tlsConfig := &tls.Config{}
+	if caFile := os.Getenv("KO_CONFIG_URL_CA_FILE"); caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("KO_CONFIG_URL_CA_FILE: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("KO_CONFIG_URL_CA_FILE: no certificates found in %s", caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	certFile, keyFile := os.Getenv("KO_CONFIG_URL_CERT_FILE"), os.Getenv("KO_CONFIG_URL_KEY_FILE")
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("KO_CONFIG_URL_CERT_FILE and KO_CONFIG_URL_KEY_FILE: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &http.Client{
+		Transport: transport,
+		Timeout:   configURLTimeout,
+	}, nil
+}
@@ -0,0 +1,137 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package options
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// serveConfig serves config over TLS, trusted via KO_CONFIG_URL_CA_FILE, to
+// requests with the Authorization header KO_CONFIG_URL_HEADERS sets, and
+// returns its URL and how many requests it got.
+func serveConfig(t *testing.T, status int, config string) (string, *int) {
+	t.Helper()
+	requests := new(int)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests++
+		if got := r.Header.Get("Authorization"); got != "Bearer token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(config))
+	}))
+	t.Cleanup(server.Close)
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := ioutil.WriteFile(caFile, ca, 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("KO_CONFIG_URL_CA_FILE", caFile)
+	t.Setenv("KO_CONFIG_URL_HEADERS", "Authorization=Bearer token")
+	return server.URL + "/" + t.Name() + "/ko.yaml", requests
+}
+
+func TestConfigURL(t *testing.T) {
+	url, requests := serveConfig(t, http.StatusOK, `defaultBaseImage: gcr.io/remote/base
+baseImageOverrides:
+  example.com/foo: gcr.io/remote/foo
+`)
+
+	for i := 0; i < 2; i++ {
+		bo := &BuildOptions{ConfigFiles: []string{url}}
+		if err := bo.LoadConfig(); err != nil {
+			t.Fatalf("LoadConfig() = %v", err)
+		}
+		if bo.BaseImage != "gcr.io/remote/base" {
+			t.Errorf("wanted BaseImage gcr.io/remote/base, got %s", bo.BaseImage)
+		}
+	}
+	if *requests != 1 {
+		t.Errorf("wanted the config to be fetched once, got %d requests", *requests)
+	}
+
+	// KO_CONFIG_URL is the base for .ko.yaml.
+	t.Setenv("KO_CONFIG_URL", url)
+	bo := &BuildOptions{WorkingDirectory: "testdata/config"}
+	if err := bo.LoadConfig(); err != nil {
+		t.Fatalf("LoadConfig() = %v", err)
+	}
+	if bo.BaseImage != "alpine" {
+		t.Errorf("wanted BaseImage alpine from .ko.yaml, got %s", bo.BaseImage)
+	}
+	if want := map[string]string{"example.com/foo": "gcr.io/remote/foo"}; !reflect.DeepEqual(bo.BaseImageOverrides, want) {
+		t.Errorf("wanted BaseImageOverrides %v from KO_CONFIG_URL, got %v", want, bo.BaseImageOverrides)
+	}
+}
+
+func TestConfigURLErrors(t *testing.T) {
+	for _, test := range []struct {
+		desc    string
+		status  int
+		config  string
+		wantErr string
+	}{{
+		desc:    "not found",
+		status:  http.StatusNotFound,
+		wantErr: "404 Not Found",
+	}, {
+		desc:    "invalid yaml",
+		status:  http.StatusOK,
+		config:  "builds: [",
+		wantErr: "error parsing config file",
+	}} {
+		t.Run(test.desc, func(t *testing.T) {
+			url, _ := serveConfig(t, test.status, test.config)
+			bo := &BuildOptions{ConfigFiles: []string{url}}
+			if err := bo.LoadConfig(); err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("LoadConfig() = %v, wanted an error containing %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestConfigURLHeaders(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		// Values with commas must not be split up.
+		if got := r.Header.Get("Accept"); got != "application/yaml, text/plain" {
+			http.Error(w, "not acceptable: "+got, http.StatusNotAcceptable)
+			return
+		}
+		w.Write([]byte("defaultBaseImage: gcr.io/remote/base\n"))
+	}))
+	defer server.Close()
+	t.Setenv("KO_CONFIG_URL_HEADERS", "Authorization=Bearer token\nAccept=application/yaml, text/plain\n")
+
+	bo := &BuildOptions{ConfigFiles: []string{server.URL + "/ko.yaml"}}
+	if err := bo.LoadConfig(); err != nil {
+		t.Fatalf("LoadConfig() = %v", err)
+	}
+	if bo.BaseImage != "gcr.io/remote/base" {
+		t.Errorf("wanted BaseImage gcr.io/remote/base, got %s", bo.BaseImage)
+	}
+}
@@ -21,9 +21,24 @@ import (
 // SelectorOptions allows selecting objects from the input manifests by label
 type SelectorOptions struct {
 	Selector string
+	// AnnotationSelector selects objects by their annotations, like
+	// Selector does by their labels. Objects have to match both.
+	AnnotationSelector string
+	// Nested are JSON pointers to string values in the input manifests, in
+	// which to resolve the references of the YAML documents they embed.
+	Nested []string
+	// ResolveAll resolves the references in every document, not only in the
+	// ones that look like Kubernetes objects.
+	ResolveAll bool
 }
 
 func AddSelectorArg(cmd *cobra.Command, so *SelectorOptions) {
 	cmd.Flags().StringVarP(&so.Selector, "selector", "l", "",
-		"Selector (label query) to filter on, supports '=', '==', and '!='.(e.g. -l key1=value1,key2=value2)")
+		"Selector (label query) to filter on, supports '=', '==', '!=', 'in', 'notin', and existence ('key', '!key'). Objects without labels never match. (e.g. -l key1=value1,key2=value2 or -l 'env in (prod,staging)')")
+	cmd.Flags().StringVar(&so.AnnotationSelector, "annotation-selector", "",
+		"Selector (annotation query) to filter on, with the same syntax as --selector. Objects have to match both selectors. (e.g. --annotation-selector=example.com/deploy=true)")
+	cmd.Flags().StringSliceVar(&so.Nested, "resolve-nested", []string{},
+		"JSON pointer to string values that embed YAML documents, to resolve the references in them too, where * matches any key or index. (e.g. --resolve-nested=/data/config.yaml)")
+	cmd.Flags().BoolVar(&so.ResolveAll, "resolve-all", false,
+		"Resolve references in every YAML document, not only in the ones that look like Kubernetes objects (with an apiVersion and a kind).")
 }
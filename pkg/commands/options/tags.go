@@ -0,0 +1,109 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package options
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// TagData is the data available to Go templates in --tags.
+type TagData struct {
+	// GitTag is the most recent tag reachable from HEAD, or empty if there
+	// is none.
+	GitTag string
+	// GitCommit is the full SHA of HEAD, and GitCommitShort its abbreviation.
+	GitCommit      string
+	GitCommitShort string
+	// GitTreeState is "clean" if there are no uncommitted changes, and
+	// "dirty" otherwise.
+	GitTreeState string
+}
+
+func isTemplate(tag string) bool {
+	return strings.Contains(tag, "{{")
+}
+
+func parseTag(tag string) (*template.Template, error) {
+	tmpl, err := template.New("tag").Option("missingkey=error").Parse(tag)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tag template %q: %w", tag, err)
+	}
+	return tmpl, nil
+}
+
+func executeTag(tmpl *template.Template, tag string, td TagData) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, td); err != nil {
+		return "", fmt.Errorf("invalid tag template %q: %w", tag, err)
+	}
+	return buf.String(), nil
+}
+
+// validateTags checks that the templates in tags parse and only refer to
+// fields of TagData, without reading the git state.
+func validateTags(tags []string) error {
+	for _, tag := range tags {
+		if !isTemplate(tag) {
+			continue
+		}
+		tmpl, err := parseTag(tag)
+		if err != nil {
+			return err
+		}
+		if _, err := executeTag(tmpl, tag, TagData{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExpandTags returns tags with Go templates, like
+// {{.GitTag}}-g{{.GitCommitShort}}, executed against the TagData returned by
+// data. data is only called if there are templates.
+func ExpandTags(tags []string, data func() (TagData, error)) ([]string, error) {
+	expanded := make([]string, 0, len(tags))
+	var td *TagData
+	for _, tag := range tags {
+		if !isTemplate(tag) {
+			expanded = append(expanded, tag)
+			continue
+		}
+		tmpl, err := parseTag(tag)
+		if err != nil {
+			return nil, err
+		}
+		if td == nil {
+			d, err := data()
+			if err != nil {
+				return nil, fmt.Errorf("reading git state for tag %q: %w", tag, err)
+			}
+			td = &d
+		}
+		t, err := executeTag(tmpl, tag, *td)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := name.NewTag("example.com/repo:"+t, name.StrictValidation); err != nil {
+			return nil, fmt.Errorf("tag template %q expanded to invalid tag %q: %w", tag, t, err)
+		}
+		expanded = append(expanded, t)
+	}
+	return expanded, nil
+}
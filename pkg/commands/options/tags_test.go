@@ -0,0 +1,103 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package options
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestExpandTags(t *testing.T) {
+	data := func() (TagData, error) {
+		return TagData{
+			GitTag:         "v1.2.3",
+			GitCommit:      "0123456789abcdef0123456789abcdef01234567",
+			GitCommitShort: "0123456",
+			GitTreeState:   "dirty",
+		}, nil
+	}
+
+	for _, tc := range []struct {
+		name    string
+		tags    []string
+		want    []string
+		wantErr string
+	}{{
+		name: "plain",
+		tags: []string{"latest", "v1"},
+		want: []string{"latest", "v1"},
+	}, {
+		name: "templates",
+		tags: []string{"latest", "{{.GitTag}}-g{{.GitCommitShort}}", "{{.GitCommit}}", "{{.GitTreeState}}"},
+		want: []string{"latest", "v1.2.3-g0123456", "0123456789abcdef0123456789abcdef01234567", "dirty"},
+	}, {
+		name:    "unknown field",
+		tags:    []string{"{{.GitBranch}}"},
+		wantErr: "GitBranch",
+	}, {
+		name:    "unparseable",
+		tags:    []string{"{{.GitTag"},
+		wantErr: "invalid tag template",
+	}, {
+		name:    "invalid tag",
+		tags:    []string{"{{.GitTag}}+{{.GitTreeState}}"},
+		wantErr: "invalid tag",
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ExpandTags(tc.tags, data)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("ExpandTags() = %v, wanted error containing %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ExpandTags() = %v", err)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("ExpandTags() (-want +got) = %s", diff)
+			}
+		})
+	}
+}
+
+func TestExpandTagsWithoutTemplates(t *testing.T) {
+	data := func() (TagData, error) {
+		return TagData{}, errors.New("not a git repository")
+	}
+
+	// Git is only consulted when there are templates.
+	if _, err := ExpandTags([]string{"latest"}, data); err != nil {
+		t.Errorf("ExpandTags() = %v", err)
+	}
+	if _, err := ExpandTags([]string{"{{.GitTag}}"}, data); err == nil {
+		t.Error("ExpandTags() = nil, wanted error reading git state")
+	}
+}
+
+func TestValidateTags(t *testing.T) {
+	bo := &BuildOptions{}
+	if err := Validate(&PublishOptions{Tags: []string{"latest", "{{.GitTag}}-g{{.GitCommitShort}}"}}, bo); err != nil {
+		t.Errorf("Validate() = %v", err)
+	}
+	for _, tag := range []string{"{{.GitTag", "{{.Nope}}"} {
+		if err := Validate(&PublishOptions{Tags: []string{tag}}, bo); err == nil {
+			t.Errorf("Validate(%q) = nil, wanted error", tag)
+		}
+	}
+}
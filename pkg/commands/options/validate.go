@@ -16,8 +16,11 @@ package options
 
 import (
 	"errors"
+	"fmt"
 	"log"
 	"strings"
+
+	"github.com/google/ko/pkg/publish"
 )
 
 const bareBaseFlagsWarning = `WARNING!
@@ -41,6 +44,10 @@ The --local flag might be deprecated in the future.
 `
 
 func Validate(po *PublishOptions, bo *BuildOptions) error {
+	if po.TarballToStdout() && !po.AllowTarballToStdout {
+		return errors.New("--tarball=- can only be used with ko build")
+	}
+
 	if po.Bare && po.BaseImportPaths {
 		log.Print(bareBaseFlagsWarning)
 		// TODO: return error when we decided to make this an error, for now it is a warning
@@ -50,6 +57,81 @@ func Validate(po *PublishOptions, bo *BuildOptions) error {
 		log.Print(localFlagsWarning)
 	}
 
+	var local []string
+	if po.Local {
+		local = append(local, "--local")
+	}
+	if po.LoadPodman {
+		local = append(local, "--load-podman")
+	}
+	if po.Containerd {
+		local = append(local, "--containerd")
+	}
+	if po.KindCluster != "" {
+		local = append(local, "--kind-cluster")
+	}
+	if po.LoadKind != "" {
+		local = append(local, "--load-kind")
+	}
+	if len(local) > 1 {
+		return fmt.Errorf("%s cannot be used together", strings.Join(local, " and "))
+	}
+
+	switch po.SBOMAttach {
+	case "", publish.SBOMAttachTag, publish.SBOMAttachReferrer:
+	default:
+		return fmt.Errorf("invalid --sbom-attach %q, must be %s or %s", po.SBOMAttach, publish.SBOMAttachTag, publish.SBOMAttachReferrer)
+	}
+
+	// SBOMs aren't worth the time it takes to generate them when nothing
+	// is pushed for them to be attached to.
+	if bo.SBOM == "" && po.PublishesLocally() {
+		bo.SBOM = "none"
+	}
+
+	switch po.Sign {
+	case "", SignKeyless:
+	default:
+		return fmt.Errorf("invalid --sign %q, must be %s", po.Sign, SignKeyless)
+	}
+
+	if bo.DebugBaseImage != "" {
+		if !bo.Debug {
+			return errors.New("--debug-base can only be used with --debug")
+		}
+		if err := validateBaseImage(bo.DebugBaseImage); err != nil {
+			return fmt.Errorf("invalid --debug-base %q: %w", bo.DebugBaseImage, err)
+		}
+	}
+	if err := validateImageNameTemplate(po); err != nil {
+		return err
+	}
+
+	if po.NoTag {
+		if po.TagOnly {
+			return errors.New("--no-tag cannot be used with --tag-only")
+		}
+		if len(po.Tags) > 1 || (len(po.Tags) == 1 && po.Tags[0] != "latest") {
+			return errors.New("--no-tag cannot be used with --tags")
+		}
+		if po.Push && po.PublishesLocally() {
+			return errors.New("--no-tag only applies to images pushed to a registry")
+		}
+	}
+
+	// Debug images are tagged apart, so they don't replace the real ones.
+	if bo.Debug {
+		tags := make([]string, 0, len(po.Tags))
+		for _, tag := range po.Tags {
+			tags = append(tags, tag+"-debug")
+		}
+		po.Tags = tags
+	}
+
+	if err := validateTags(po.Tags); err != nil {
+		return err
+	}
+
 	if len(bo.Platforms) > 1 {
 		for _, platform := range bo.Platforms {
 			if platform == "all" {
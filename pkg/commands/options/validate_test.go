@@ -0,0 +1,112 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package options
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValidateDefaultSBOM(t *testing.T) {
+	for _, tc := range []struct {
+		desc string
+		po   PublishOptions
+		sbom string
+		want string
+	}{{
+		desc: "pushed",
+		po:   PublishOptions{DockerRepo: "registry.example.com/repo", Push: true},
+		want: "",
+	}, {
+		desc: "ko.local",
+		po:   PublishOptions{DockerRepo: "ko.local", Push: true},
+		want: "none",
+	}, {
+		desc: "--local",
+		po:   PublishOptions{DockerRepo: "registry.example.com/repo", Local: true, Push: true},
+		want: "none",
+	}, {
+		desc: "--load-kind",
+		po:   PublishOptions{LoadKind: "dev", Push: true},
+		want: "none",
+	}, {
+		desc: "--tarball without pushing",
+		po:   PublishOptions{DockerRepo: "registry.example.com/repo", TarballFile: "images.tar"},
+		want: "none",
+	}, {
+		desc: "--tarball and pushing",
+		po:   PublishOptions{DockerRepo: "registry.example.com/repo", TarballFile: "images.tar", Push: true},
+		want: "",
+	}, {
+		desc: "explicit --sbom",
+		po:   PublishOptions{DockerRepo: "ko.local", Push: true},
+		sbom: "spdx",
+		want: "spdx",
+	}} {
+		t.Run(tc.desc, func(t *testing.T) {
+			bo := &BuildOptions{SBOM: tc.sbom}
+			if err := Validate(&tc.po, bo); err != nil {
+				t.Fatalf("Validate() = %v", err)
+			}
+			if bo.SBOM != tc.want {
+				t.Errorf("SBOM = %q, want %q", bo.SBOM, tc.want)
+			}
+		})
+	}
+}
+
+func TestValidateDebug(t *testing.T) {
+	po := &PublishOptions{Tags: []string{"latest", "v1"}}
+	if err := Validate(po, &BuildOptions{Debug: true}); err != nil {
+		t.Fatalf("Validate() = %v", err)
+	}
+	if want := []string{"latest-debug", "v1-debug"}; !reflect.DeepEqual(po.Tags, want) {
+		t.Errorf("Tags = %v, want %v", po.Tags, want)
+	}
+
+	if err := Validate(&PublishOptions{}, &BuildOptions{DebugBaseImage: "busybox"}); err == nil {
+		t.Error("Validate() with --debug-base but not --debug = nil, want error")
+	}
+}
+
+func TestValidateNoTag(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		po      PublishOptions
+		wantErr bool
+	}{{
+		name: "default tags",
+		po:   PublishOptions{NoTag: true, Push: true, Tags: []string{"latest"}},
+	}, {
+		name:    "with --tags",
+		po:      PublishOptions{NoTag: true, Push: true, Tags: []string{"v1"}},
+		wantErr: true,
+	}, {
+		name:    "with --tag-only",
+		po:      PublishOptions{NoTag: true, Push: true, Tags: []string{"latest"}, TagOnly: true},
+		wantErr: true,
+	}, {
+		name:    "with --local",
+		po:      PublishOptions{NoTag: true, Push: true, Tags: []string{"latest"}, Local: true},
+		wantErr: true,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := Validate(&tc.po, &BuildOptions{})
+			if (err != nil) != tc.wantErr {
+				t.Errorf("Validate() = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// unsafeFilenameChars matches the characters not to put in the names of the
+// files dirWriter writes, e.g. the colons of system:* ClusterRoles.
+var unsafeFilenameChars = regexp.MustCompile(`[^a-zA-Z0-9._-]`)
+
+// dirWriter writes each document of the YAML stream written to it to its own
+// file in a directory.
+type dirWriter struct {
+	*io.PipeWriter
+	done chan struct{}
+	err  error
+}
+
+// newDirWriter returns a dirWriter writing to dir, creating it if needed.
+// Its Close waits for the files to be written, and returns the first error
+// writing them.
+func newDirWriter(dir string) (*dirWriter, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, fmt.Errorf("creating output directory: %w", err)
+	}
+	pr, pw := io.Pipe()
+	w := &dirWriter{
+		PipeWriter: pw,
+		done:       make(chan struct{}),
+	}
+	go func() {
+		defer close(w.done)
+		w.err = writeDocuments(dir, pr)
+		pr.CloseWithError(w.err)
+	}()
+	return w, nil
+}
+
+// Close implements io.Closer, and may be called more than once.
+func (w *dirWriter) Close() error {
+	w.PipeWriter.Close()
+	<-w.done
+	return w.err
+}
+
+// writeDocuments writes each document read from r to dir, as
+// <namespace>-<kind>-<name>.yaml, leaving out the namespace if it has none,
+// or as document-<index>.yaml if it lacks a kind or name.
+func writeDocuments(dir string, r io.Reader) error {
+	docs := &yamlDocuments{r: bufio.NewReader(r)}
+	written := make(map[string]bool)
+	for i := 0; ; {
+		doc, err := docs.next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		var node yaml.Node
+		if err := yaml.Unmarshal(doc, &node); err != nil {
+			return fmt.Errorf("decoding document %d: %w", i, err)
+		}
+		if len(node.Content) == 0 {
+			// Only comments.
+			continue
+		}
+
+		filename := documentFilename(&node, i)
+		if written[filename] {
+			return fmt.Errorf("document %d: more than one document would be written to %s", i, filename)
+		}
+		written[filename] = true
+		if err := ioutil.WriteFile(filepath.Join(dir, filename), append(bytes.TrimRight(doc, "\n"), '\n'), 0644); err != nil {
+			return fmt.Errorf("writing document %d: %w", i, err)
+		}
+		i++
+	}
+}
+
+// documentFilename returns the name of the file to write the i-th document
+// node to.
+func documentFilename(node *yaml.Node, i int) string {
+	var obj struct {
+		Kind     string `yaml:"kind"`
+		Metadata struct {
+			Name      string `yaml:"name"`
+			Namespace string `yaml:"namespace"`
+		} `yaml:"metadata"`
+	}
+	if err := node.Decode(&obj); err != nil || obj.Kind == "" || obj.Metadata.Name == "" {
+		return fmt.Sprintf("document-%d.yaml", i)
+	}
+	parts := []string{strings.ToLower(obj.Kind), obj.Metadata.Name}
+	if obj.Metadata.Namespace != "" {
+		parts = append([]string{obj.Metadata.Namespace}, parts...)
+	}
+	return unsafeFilenameChars.ReplaceAllString(strings.Join(parts, "-"), "_") + ".yaml"
+}
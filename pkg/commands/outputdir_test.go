@@ -0,0 +1,79 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDirWriter(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "out")
+	w, err := newDirWriter(dir)
+	if err != nil {
+		t.Fatalf("newDirWriter() = %v", err)
+	}
+
+	// This is how resolveFilesToWriter writes each file.
+	for _, b := range []string{
+		"kind: Deployment\nmetadata:\n  name: foo\n  namespace: bar\n",
+		"# leading comment\n---\nkind: ClusterRole\nmetadata:\n  name: system:foo\n---\nkind: Namespace\nmetadata:\n  name: bar\n",
+		"image: gcr.io/foo\n",
+	} {
+		if _, err := w.Write([]byte(b + "\n---\n")); err != nil {
+			t.Fatalf("Write() = %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() = %v", err)
+	}
+
+	want := map[string]string{
+		"bar-deployment-foo.yaml":     "kind: Deployment\nmetadata:\n  name: foo\n  namespace: bar\n",
+		"clusterrole-system_foo.yaml": "kind: ClusterRole\nmetadata:\n  name: system:foo\n",
+		"namespace-bar.yaml":          "kind: Namespace\nmetadata:\n  name: bar\n",
+		"document-3.yaml":             "image: gcr.io/foo\n",
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir() = %v", err)
+	}
+	got := make(map[string]string)
+	for _, f := range files {
+		b, err := ioutil.ReadFile(filepath.Join(dir, f.Name()))
+		if err != nil {
+			t.Fatalf("ReadFile() = %v", err)
+		}
+		got[f.Name()] = string(b)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("files in output directory; (-want +got) = %v", diff)
+	}
+}
+
+func TestDirWriterDuplicate(t *testing.T) {
+	w, err := newDirWriter(t.TempDir())
+	if err != nil {
+		t.Fatalf("newDirWriter() = %v", err)
+	}
+	doc := "kind: Namespace\nmetadata:\n  name: bar\n---\n"
+	w.Write([]byte(doc + doc))
+	if err := w.Close(); err == nil {
+		t.Error("Close() = nil, wanted an error about the duplicate document")
+	}
+}
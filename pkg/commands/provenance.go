@@ -0,0 +1,196 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/sigstore/cosign/pkg/oci"
+	"github.com/sigstore/cosign/pkg/oci/static"
+	ctypes "github.com/sigstore/cosign/pkg/types"
+	"golang.org/x/tools/go/packages"
+
+	"github.com/google/ko/pkg/publish"
+)
+
+const (
+	inTotoStatementType = "https://in-toto.io/Statement/v0.1"
+	slsaProvenanceType  = "https://slsa.dev/provenance/v0.2"
+	koBuildType         = "https://github.com/google/ko/provenance@v1"
+)
+
+// statement is an in-toto statement with an SLSA provenance predicate.
+type statement struct {
+	Type          string     `json:"_type"`
+	PredicateType string     `json:"predicateType"`
+	Subject       []subject  `json:"subject"`
+	Predicate     provenance `json:"predicate"`
+}
+
+type subject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+type provenance struct {
+	Builder    builder            `json:"builder"`
+	BuildType  string             `json:"buildType"`
+	Invocation invocation         `json:"invocation"`
+	Metadata   provenanceMetadata `json:"metadata"`
+	Materials  []material         `json:"materials"`
+}
+
+type builder struct {
+	ID string `json:"id"`
+}
+
+type invocation struct {
+	ConfigSource material `json:"configSource"`
+}
+
+type provenanceMetadata struct {
+	BuildFinishedOn string       `json:"buildFinishedOn"`
+	Completeness    completeness `json:"completeness"`
+	Reproducible    bool         `json:"reproducible"`
+}
+
+type completeness struct {
+	Parameters  bool `json:"parameters"`
+	Environment bool `json:"environment"`
+	Materials   bool `json:"materials"`
+}
+
+type material struct {
+	URI        string            `json:"uri"`
+	Digest     map[string]string `json:"digest,omitempty"`
+	EntryPoint string            `json:"entryPoint,omitempty"`
+}
+
+// envelope is a DSSE envelope, which is how cosign stores attestations.
+type envelope struct {
+	PayloadType string              `json:"payloadType"`
+	Payload     []byte              `json:"payload"`
+	Signatures  []envelopeSignature `json:"signatures"`
+}
+
+type envelopeSignature struct {
+	KeyID string `json:"keyid"`
+	Sig   string `json:"sig"`
+}
+
+// provenanceAttester returns a publish.Attester for SLSA provenance of the
+// images built from the import paths in dir. The attestations aren't signed.
+func provenanceAttester(dir string) publish.Attester {
+	return func(ctx context.Context, importpath string, dig name.Digest) (oci.Signature, error) {
+		st, err := provenanceStatement(dir, importpath, dig)
+		if err != nil {
+			return nil, err
+		}
+		return unsignedAttestation(st)
+	}
+}
+
+// unsignedAttestation wraps the in-toto statement st in a DSSE envelope
+// without signatures, as an attestation cosign can read.
+func unsignedAttestation(st interface{}) (oci.Signature, error) {
+	payload, err := json.Marshal(st)
+	if err != nil {
+		return nil, err
+	}
+	env, err := json.Marshal(envelope{
+		PayloadType: ctypes.IntotoPayloadType,
+		Payload:     payload,
+		Signatures:  []envelopeSignature{},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return static.NewAttestation(env, static.WithLayerMediaType(ctypes.DssePayloadType))
+}
+
+// provenanceStatement returns the provenance of the image dig, built from
+// importpath in dir. Its materials are the module importpath resolved to,
+// and the git commit checked out in it, if it's in a git repository.
+func provenanceStatement(dir, importpath string, dig name.Digest) (*statement, error) {
+	h, err := v1.NewHash(dig.DigestStr())
+	if err != nil {
+		return nil, err
+	}
+	pkgs, err := packages.Load(&packages.Config{Dir: dir, Mode: packages.NeedName | packages.NeedModule}, importpath)
+	if err != nil {
+		return nil, fmt.Errorf("loading package %s: %w", importpath, err)
+	}
+	if len(pkgs) != 1 {
+		return nil, fmt.Errorf("found %d packages for %s, expected 1", len(pkgs), importpath)
+	}
+	mod := pkgs[0].Module
+	if mod == nil {
+		return nil, errors.New("provenance requires building with Go modules")
+	}
+	if mod.Replace != nil {
+		mod = mod.Replace
+	}
+
+	purl := "pkg:golang/" + mod.Path
+	if mod.Version != "" {
+		purl += "@" + mod.Version
+	}
+	source := material{
+		URI:        purl,
+		EntryPoint: importpath,
+	}
+	materials := []material{{URI: purl}}
+	// Modules from the module cache aren't in a git repository.
+	if commit, err := git(mod.Dir, "rev-parse", "HEAD"); err == nil {
+		uri, err := git(mod.Dir, "remote", "get-url", "origin")
+		if err != nil {
+			uri = "https://" + mod.Path
+		}
+		source.URI = "git+" + uri
+		source.Digest = map[string]string{"sha1": commit}
+		materials = append(materials, material{
+			URI:    source.URI,
+			Digest: source.Digest,
+		})
+	}
+
+	id := "https://github.com/google/ko"
+	if v := version(); v != "" {
+		id += "@" + v
+	}
+	return &statement{
+		Type:          inTotoStatementType,
+		PredicateType: slsaProvenanceType,
+		Subject: []subject{{
+			Name:   dig.Context().Name(),
+			Digest: map[string]string{h.Algorithm: h.Hex},
+		}},
+		Predicate: provenance{
+			Builder:    builder{ID: id},
+			BuildType:  koBuildType,
+			Invocation: invocation{ConfigSource: source},
+			Metadata: provenanceMetadata{
+				BuildFinishedOn: time.Now().UTC().Format(time.RFC3339),
+			},
+			Materials: materials,
+		},
+	}, nil
+}
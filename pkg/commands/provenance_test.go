@@ -0,0 +1,75 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	ctypes "github.com/sigstore/cosign/pkg/types"
+)
+
+func TestProvenanceAttester(t *testing.T) {
+	dig, err := name.NewDigest("example.com/ko/test@sha256:deadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeef")
+	if err != nil {
+		t.Fatal(err)
+	}
+	att, err := provenanceAttester("../..")(context.Background(), "github.com/google/ko/test", dig)
+	if err != nil {
+		t.Fatalf("provenanceAttester() = %v", err)
+	}
+	if mt, err := att.MediaType(); err != nil || mt != ctypes.DssePayloadType {
+		t.Errorf("MediaType() = %s, %v, wanted %s", mt, err, ctypes.DssePayloadType)
+	}
+
+	b, err := att.Payload()
+	if err != nil {
+		t.Fatalf("Payload() = %v", err)
+	}
+	var env envelope
+	if err := json.Unmarshal(b, &env); err != nil {
+		t.Fatalf("Unmarshal(envelope) = %v", err)
+	}
+	if env.PayloadType != ctypes.IntotoPayloadType {
+		t.Errorf("payloadType = %s, wanted %s", env.PayloadType, ctypes.IntotoPayloadType)
+	}
+	var st statement
+	if err := json.Unmarshal(env.Payload, &st); err != nil {
+		t.Fatalf("Unmarshal(statement) = %v", err)
+	}
+
+	if st.Type != inTotoStatementType || st.PredicateType != slsaProvenanceType {
+		t.Errorf("statement type = %s, %s, wanted %s, %s", st.Type, st.PredicateType, inTotoStatementType, slsaProvenanceType)
+	}
+	if len(st.Subject) != 1 || st.Subject[0].Name != "example.com/ko/test" ||
+		st.Subject[0].Digest["sha256"] != "deadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeef" {
+		t.Errorf("subject = %v, wanted the image digest", st.Subject)
+	}
+	if got, want := st.Predicate.Invocation.ConfigSource.EntryPoint, "github.com/google/ko/test"; got != want {
+		t.Errorf("entryPoint = %s, wanted %s", got, want)
+	}
+	if len(st.Predicate.Materials) == 0 || st.Predicate.Materials[0].URI != "pkg:golang/github.com/google/ko" {
+		t.Errorf("materials = %v, wanted the module first", st.Predicate.Materials)
+	}
+	// The repository may not be checked out with git, e.g. from a release
+	// tarball.
+	if commit, err := git("../..", "rev-parse", "HEAD"); err == nil {
+		if len(st.Predicate.Materials) != 2 || st.Predicate.Materials[1].Digest["sha1"] != commit {
+			t.Errorf("materials = %v, wanted commit %s", st.Predicate.Materials, commit)
+		}
+	}
+}
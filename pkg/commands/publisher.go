@@ -19,19 +19,43 @@ package commands
 import (
 	"context"
 	"fmt"
+	"strings"
+	"sync"
 
 	"github.com/google/go-containerregistry/pkg/name"
 	"github.com/google/ko/pkg/build"
 	"github.com/google/ko/pkg/publish"
+	"golang.org/x/sync/semaphore"
 )
 
 // PublishImages publishes images
 func PublishImages(ctx context.Context, importpaths []string, pub publish.Interface, b build.Interface) (map[string]name.Reference, error) {
-	return publishImages(ctx, importpaths, pub, b)
+	return publishImages(ctx, importpaths, pub, b, 0)
 }
 
-func publishImages(ctx context.Context, importpaths []string, pub publish.Interface, b build.Interface) (map[string]name.Reference, error) {
-	imgs := make(map[string]name.Reference)
+// withStrictScheme replaces scheme, the prefix configured with --scheme, in
+// importpaths with build.StrictScheme, which the builders expect.
+func withStrictScheme(importpaths []string, scheme string) []string {
+	if scheme == "" || scheme == build.StrictScheme {
+		return importpaths
+	}
+	out := make([]string, 0, len(importpaths))
+	for _, ip := range importpaths {
+		if strings.HasPrefix(ip, scheme) {
+			ip = build.StrictScheme + strings.TrimPrefix(ip, scheme)
+		}
+		out = append(out, ip)
+	}
+	return out
+}
+
+// publishImages builds and publishes importpaths concurrently. The builder
+// bounds how many builds run at once, and, if jobs isn't 0, at most jobs
+// images are published at once. A failure doesn't stop the other import
+// paths, so that all of the failures are reported, one per import path.
+func publishImages(ctx context.Context, importpaths []string, pub publish.Interface, b build.Interface, jobs int) (map[string]name.Reference, error) {
+	// Check all of the import paths before building any of them.
+	qualified := make([]string, 0, len(importpaths))
 	for _, importpath := range importpaths {
 		importpath, err := b.QualifyImport(importpath)
 		if err != nil {
@@ -40,16 +64,71 @@ func publishImages(ctx context.Context, importpaths []string, pub publish.Interf
 		if err := b.IsSupportedReference(importpath); err != nil {
 			return nil, fmt.Errorf("importpath %q is not supported: %w", importpath, err)
 		}
+		qualified = append(qualified, importpath)
+	}
 
-		img, err := b.Build(ctx, importpath)
-		if err != nil {
-			return nil, fmt.Errorf("error building %q: %w", importpath, err)
-		}
-		ref, err := pub.Publish(ctx, img, importpath)
+	var sem *semaphore.Weighted
+	if jobs > 0 {
+		sem = semaphore.NewWeighted(int64(jobs))
+	}
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		imgs = make(map[string]name.Reference, len(qualified))
+		errs = make([]error, len(qualified))
+	)
+	for i, importpath := range qualified {
+		wg.Add(1)
+		go func(i int, importpath string) {
+			defer wg.Done()
+			img, err := b.Build(ctx, importpath)
+			if err != nil {
+				errs[i] = fmt.Errorf("error building %q: %w", importpath, err)
+				return
+			}
+			if sem != nil {
+				if err := sem.Acquire(ctx, 1); err != nil {
+					errs[i] = fmt.Errorf("error publishing %s: %w", importpath, err)
+					return
+				}
+				defer sem.Release(1)
+			}
+			ref, err := pub.Publish(ctx, img, importpath)
+			if err != nil {
+				errs[i] = fmt.Errorf("error publishing %s: %w", importpath, err)
+				return
+			}
+			mu.Lock()
+			imgs[importpath] = ref
+			mu.Unlock()
+		}(i, importpath)
+	}
+	wg.Wait()
+
+	var failed publishErrors
+	for _, err := range errs {
 		if err != nil {
-			return nil, fmt.Errorf("error publishing %s: %w", importpath, err)
+			failed = append(failed, err)
 		}
-		imgs[importpath] = ref
 	}
-	return imgs, nil
+	switch len(failed) {
+	case 0:
+		return imgs, nil
+	case 1:
+		return nil, failed[0]
+	default:
+		return nil, failed
+	}
+}
+
+// publishErrors are the errors of several import paths that failed to be
+// built or published.
+type publishErrors []error
+
+func (e publishErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Sprintf("%d import paths failed:\n%s", len(e), strings.Join(msgs, "\n"))
 }
@@ -22,10 +22,17 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/random"
 	"github.com/google/ko/pkg/build"
 	"github.com/google/ko/pkg/commands/options"
+	kotesting "github.com/google/ko/pkg/internal/testing"
+	"github.com/google/ko/pkg/publish"
 )
 
 func TestPublishImages(t *testing.T) {
@@ -96,6 +103,105 @@ func TestPublishImages(t *testing.T) {
 	}
 }
 
+// concurrentPublisher records how many images are published at once, and
+// holds each one until want of them are, or it gives up.
+type concurrentPublisher struct {
+	publish.Interface
+	want int
+
+	mu            sync.Mutex
+	inFlight, max int
+}
+
+func (p *concurrentPublisher) Publish(ctx context.Context, br build.Result, s string) (name.Reference, error) {
+	p.mu.Lock()
+	p.inFlight++
+	if p.inFlight > p.max {
+		p.max = p.inFlight
+	}
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		p.inFlight--
+		p.mu.Unlock()
+	}()
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		p.mu.Lock()
+		done := p.max >= p.want
+		p.mu.Unlock()
+		if done {
+			break
+		}
+	}
+	return p.Interface.Publish(ctx, br, s)
+}
+
+func TestPublishImagesConcurrently(t *testing.T) {
+	base, err := name.NewRepository("gcr.io/concurrent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := resultBuilder{}
+	hashes := map[string]v1.Hash{}
+	var importpaths []string
+	for _, ip := range []string{"example.com/a", "example.com/b", "example.com/c"} {
+		img, err := random.Image(1024, 1)
+		if err != nil {
+			t.Fatalf("random.Image() = %v", err)
+		}
+		if hashes[ip], err = img.Digest(); err != nil {
+			t.Fatal(err)
+		}
+		b[build.StrictScheme+ip] = img
+		importpaths = append(importpaths, build.StrictScheme+ip)
+	}
+
+	for _, tc := range []struct {
+		jobs, want int
+	}{{
+		jobs: 0,
+		want: len(importpaths),
+	}, {
+		jobs: 2,
+		want: 2,
+	}} {
+		t.Run(fmt.Sprint(tc.jobs), func(t *testing.T) {
+			pub := &concurrentPublisher{Interface: kotesting.NewFixedPublish(base, hashes), want: tc.want}
+			refs, err := publishImages(context.Background(), importpaths, pub, b, tc.jobs)
+			if err != nil {
+				t.Fatalf("publishImages() = %v", err)
+			}
+			if len(refs) != len(importpaths) {
+				t.Errorf("publishImages() = %v, want a reference for each of %v", refs, importpaths)
+			}
+			if pub.max != tc.want {
+				t.Errorf("published %d images at once, want %d", pub.max, tc.want)
+			}
+		})
+	}
+}
+
+func TestPublishImagesErrors(t *testing.T) {
+	img, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	b := resultBuilder{"ko://example.com/ok": img}
+	pub := kotesting.NewFixedPublish(name.MustParseReference("gcr.io/errors").Context(), map[string]v1.Hash{})
+
+	_, err = publishImages(context.Background(), []string{"ko://example.com/ok", "ko://example.com/missing"}, pub, b, 0)
+	if err == nil {
+		t.Fatal("publishImages() = nil, want error")
+	}
+	// The image that was built fails to publish, and the other to build.
+	for _, want := range []string{`error publishing ko://example.com/ok`, `error building "ko://example.com/missing"`} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("publishImages() = %v, want it to contain %q", err, want)
+		}
+	}
+}
+
 func sampleAppRelDir() (string, error) {
 	_, filename, _, ok := runtime.Caller(0)
 	if !ok {
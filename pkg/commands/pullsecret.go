@@ -0,0 +1,118 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// pullSecretKeychain has the credentials of each registry host in a pull
+// secret.
+type pullSecretKeychain map[string]authn.AuthConfig
+
+// Resolve implements authn.Keychain.
+func (k pullSecretKeychain) Resolve(target authn.Resource) (authn.Authenticator, error) {
+	if cfg, ok := k[target.RegistryStr()]; ok {
+		return authn.FromConfig(cfg), nil
+	}
+	return authn.Anonymous, nil
+}
+
+// readPullSecret reads the kubernetes.io/dockerconfigjson or
+// kubernetes.io/dockercfg Secret ref, as "namespace/name" or "name" in the
+// current namespace, with kubectl.
+func readPullSecret(ref string) (pullSecretKeychain, error) {
+	if !isKubectlAvailable() {
+		return nil, errors.New("kubectl must be installed to read --pull-secret")
+	}
+	args := []string{"get", "secret", "--output=json"}
+	if i := strings.Index(ref, "/"); i >= 0 {
+		args = append(args, "--namespace="+ref[:i], ref[i+1:])
+	} else {
+		args = append(args, ref)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("kubectl", args...)
+	cmd.Env = os.Environ()
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("reading pull secret %s: %w: %s", ref, err, strings.TrimSpace(stderr.String()))
+	}
+	k, err := parsePullSecret(stdout.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("parsing pull secret %s: %w", ref, err)
+	}
+	return k, nil
+}
+
+// parsePullSecret parses the docker config embedded in a Secret.
+func parsePullSecret(b []byte) (pullSecretKeychain, error) {
+	var secret struct {
+		Type string `json:"type"`
+		// Data is decoded from base64 by encoding/json.
+		Data map[string][]byte `json:"data"`
+	}
+	if err := json.Unmarshal(b, &secret); err != nil {
+		return nil, err
+	}
+
+	var auths map[string]authn.AuthConfig
+	switch secret.Type {
+	case "kubernetes.io/dockerconfigjson":
+		var cfg struct {
+			Auths map[string]authn.AuthConfig `json:"auths"`
+		}
+		if err := json.Unmarshal(secret.Data[".dockerconfigjson"], &cfg); err != nil {
+			return nil, fmt.Errorf("decoding .dockerconfigjson: %w", err)
+		}
+		auths = cfg.Auths
+	case "kubernetes.io/dockercfg":
+		if err := json.Unmarshal(secret.Data[".dockercfg"], &auths); err != nil {
+			return nil, fmt.Errorf("decoding .dockercfg: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("secret of type %q, want kubernetes.io/dockerconfigjson or kubernetes.io/dockercfg", secret.Type)
+	}
+
+	k := make(pullSecretKeychain, len(auths))
+	for key, cfg := range auths {
+		k[registryHost(key)] = cfg
+	}
+	return k, nil
+}
+
+// registryHost returns the registry host of a key of the auths in a docker
+// config, which may be a URL like https://index.docker.io/v1/.
+func registryHost(key string) string {
+	if u, err := url.Parse(key); err == nil && u.Host != "" {
+		key = u.Host
+	} else {
+		key = strings.SplitN(key, "/", 2)[0]
+	}
+	if reg, err := name.NewRegistry(key); err == nil {
+		return reg.RegistryStr()
+	}
+	return key
+}
@@ -0,0 +1,130 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+func secretJSON(typ, key, data string) string {
+	return `{
+  "apiVersion": "v1",
+  "kind": "Secret",
+  "metadata": {"name": "regcred", "namespace": "ci"},
+  "type": "` + typ + `",
+  "data": {"` + key + `": "` + base64.StdEncoding.EncodeToString([]byte(data)) + `"}
+}`
+}
+
+func TestParsePullSecret(t *testing.T) {
+	for _, tc := range []struct {
+		desc    string
+		secret  string
+		want    pullSecretKeychain
+		wantErr bool
+	}{{
+		desc: "dockerconfigjson",
+		secret: secretJSON("kubernetes.io/dockerconfigjson", ".dockerconfigjson", `{"auths": {
+  "registry.example.com": {"auth": "`+base64.StdEncoding.EncodeToString([]byte("user:pass"))+`"},
+  "https://index.docker.io/v1/": {"username": "hub", "password": "secret"},
+  "https://gcr.io": {"identitytoken": "token"}
+}}`),
+		want: pullSecretKeychain{
+			"registry.example.com": {Username: "user", Password: "pass", Auth: base64.StdEncoding.EncodeToString([]byte("user:pass"))},
+			"index.docker.io":      {Username: "hub", Password: "secret", Auth: base64.StdEncoding.EncodeToString([]byte("hub:secret"))},
+			"gcr.io":               {IdentityToken: "token"},
+		},
+	}, {
+		desc:   "dockercfg",
+		secret: secretJSON("kubernetes.io/dockercfg", ".dockercfg", `{"docker.io": {"username": "hub", "password": "secret"}}`),
+		want: pullSecretKeychain{
+			"index.docker.io": {Username: "hub", Password: "secret", Auth: base64.StdEncoding.EncodeToString([]byte("hub:secret"))},
+		},
+	}, {
+		desc:    "opaque",
+		secret:  secretJSON("Opaque", "password", "hunter2"),
+		wantErr: true,
+	}} {
+		t.Run(tc.desc, func(t *testing.T) {
+			got, err := parsePullSecret([]byte(tc.secret))
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("parsePullSecret() = %v, wantErr %t", err, tc.wantErr)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("parsePullSecret() (-want +got) = %s", diff)
+			}
+		})
+	}
+}
+
+func TestReadPullSecret(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake kubectl is a shell script")
+	}
+	bin := t.TempDir()
+	secret := filepath.Join(bin, "secret.json")
+	if err := ioutil.WriteFile(secret, []byte(secretJSON("kubernetes.io/dockerconfigjson", ".dockerconfigjson",
+		`{"auths": {"registry.example.com": {"username": "user", "password": "pass"}}}`)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// The fake kubectl only knows the secret as "get secret --output=json
+	// --namespace=ci regcred".
+	kubectl := `#!/bin/sh
+if [ "$*" = "get secret --output=json --namespace=ci regcred" ]; then
+  cat ` + secret + `
+  exit 0
+fi
+echo "Error from server (NotFound): secrets not found" >&2
+exit 1
+`
+	if err := ioutil.WriteFile(filepath.Join(bin, "kubectl"), []byte(kubectl), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	if _, err := readPullSecret("ci/missing"); err == nil {
+		t.Error("readPullSecret(ci/missing) = nil, want error")
+	}
+	k, err := readPullSecret("ci/regcred")
+	if err != nil {
+		t.Fatalf("readPullSecret() = %v", err)
+	}
+	for repo, want := range map[string]string{
+		"registry.example.com/app": "user",
+		"other.example.com/app":    "",
+	} {
+		r, _ := name.NewRepository(repo)
+		auth, err := authn.NewMultiKeychain(k).Resolve(r)
+		if err != nil {
+			t.Fatalf("Resolve(%s) = %v", repo, err)
+		}
+		got, err := auth.Authorization()
+		if err != nil {
+			t.Fatalf("Authorization() = %v", err)
+		}
+		if got.Username != want {
+			t.Errorf("Resolve(%s) username = %q, want %q", repo, got.Username, want)
+		}
+	}
+}
@@ -28,6 +28,7 @@ func addResolve(topLevel *cobra.Command) {
 	fo := &options.FilenameOptions{}
 	so := &options.SelectorOptions{}
 	bo := &options.BuildOptions{}
+	var outputDir string
 
 	resolve := &cobra.Command{
 		Use:   "resolve -f FILENAME",
@@ -52,7 +53,11 @@ func addResolve(topLevel *cobra.Command) {
   # daemon as:
   #   ko.local/<import path>
   # This always preserves import paths.
-  ko resolve --local -f config/`,
+  ko resolve --local -f config/
+
+  # Write each resolved document to its own file in out/, named
+  # <namespace>-<kind>-<name>.yaml.
+  ko resolve -f config/ --output-dir=out/`,
 		Args: cobra.NoArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if err := options.Validate(po, bo); err != nil {
@@ -66,17 +71,29 @@ func addResolve(topLevel *cobra.Command) {
 			if err != nil {
 				return fmt.Errorf("error creating builder: %w", err)
 			}
-			publisher, err := makePublisher(po)
+			publisher, err := makePublisher(po, bo.WorkingDirectory)
 			if err != nil {
 				return fmt.Errorf("error creating publisher: %w", err)
 			}
 			defer publisher.Close()
-			return resolveFilesToWriter(ctx, builder, publisher, fo, so, os.Stdout)
+			if outputDir == "" {
+				return resolveFilesToWriter(ctx, builder, publisher, fo, so, bo, os.Stdout)
+			}
+			w, err := newDirWriter(outputDir)
+			if err != nil {
+				return err
+			}
+			if err := resolveFilesToWriter(ctx, builder, publisher, fo, so, bo, w); err != nil {
+				return err
+			}
+			return w.Close()
 		},
 	}
 	options.AddPublishArg(resolve, po)
 	options.AddFileArg(resolve, fo)
 	options.AddSelectorArg(resolve, so)
 	options.AddBuildOptions(resolve, bo)
+	resolve.Flags().StringVar(&outputDir, "output-dir", "",
+		"Directory to write each resolved document to, as its own file named <namespace>-<kind>-<name>.yaml, or document-<index>.yaml if it has no kind or name, instead of printing them.")
 	topLevel.AddCommand(resolve)
 }
@@ -23,16 +23,20 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"log"
 	"os"
 	"path"
+	"path/filepath"
 	"strings"
 	"sync"
 
+	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/name"
 	"golang.org/x/sync/errgroup"
 	"gopkg.in/yaml.v3"
 	"k8s.io/apimachinery/pkg/labels"
 
+	"github.com/google/ko/internal/sign"
 	"github.com/google/ko/pkg/build"
 	"github.com/google/ko/pkg/commands/options"
 	"github.com/google/ko/pkg/publish"
@@ -48,7 +52,7 @@ func ua() string {
 }
 
 func gobuildOptions(bo *options.BuildOptions) ([]build.Option, error) {
-	creationTime, err := getCreationTime()
+	creationTime, err := getCreationTime(bo)
 	if err != nil {
 		return nil, err
 	}
@@ -83,8 +87,13 @@ func gobuildOptions(bo *options.BuildOptions) ([]build.Option, error) {
 		}
 	}
 
+	if _, err := parseBaseImageMirrors(bo.BaseImageMirrors); err != nil {
+		return nil, err
+	}
+
 	opts := []build.Option{
 		build.WithBaseImages(getBaseImage(bo)),
+		build.WithPlatformBaseImages(getPlatformBaseImages(bo)),
 		build.WithPlatforms(bo.Platforms...),
 		build.WithJobs(bo.ConcurrentBuilds),
 	}
@@ -97,17 +106,85 @@ func gobuildOptions(bo *options.BuildOptions) ([]build.Option, error) {
 	if bo.DisableOptimizations {
 		opts = append(opts, build.WithDisabledOptimizations())
 	}
+	if bo.Race {
+		opts = append(opts, build.WithRace())
+	}
 	switch bo.SBOM {
 	case "none":
 		opts = append(opts, build.WithDisabledSBOM())
 	case "go.version-m":
 		opts = append(opts, build.WithGoVersionSBOM())
 	case "cyclonedx":
-		opts = append(opts, build.WithCycloneDX())
-	default: // "spdx"
-		opts = append(opts, build.WithSPDX(version()))
+		opts = append(opts, build.WithCycloneDX(), build.WithBaseSBOM(getBaseSBOM(bo)))
+	default: // "spdx", "spdx-json", or ""
+		opts = append(opts, build.WithSPDX(version()), build.WithBaseSBOM(getBaseSBOM(bo)))
 	}
 	opts = append(opts, build.WithTrimpath(bo.Trimpath))
+	opts = append(opts, build.WithGitState(func() (build.GitState, error) {
+		td, err := gitTagData(bo.WorkingDirectory)
+		if err != nil {
+			return build.GitState{}, fmt.Errorf("reading git state for build templates: %w", err)
+		}
+		return build.GitState{
+			Tag:         td.GitTag,
+			Commit:      td.GitCommit,
+			CommitShort: td.GitCommitShort,
+			TreeState:   td.GitTreeState,
+		}, nil
+	}))
+	// Debuggers need the symbols of unoptimized binaries.
+	opts = append(opts, build.WithStripSymbols(!bo.DebugSymbols && !bo.DisableOptimizations))
+	opts = append(opts, build.WithLayerCompression(bo.LayerCompression))
+	if bo.User != "" {
+		opts = append(opts, build.WithUser(bo.User))
+	}
+	if bo.BuildCacheDir != "" {
+		opts = append(opts, build.WithBuildCacheDir(bo.BuildCacheDir))
+	}
+	if bo.BuildTimeout != 0 {
+		opts = append(opts, build.WithBuildTimeout(bo.BuildTimeout))
+	}
+	if bo.LayerCompressionLevel != 0 {
+		opts = append(opts, build.WithLayerCompressionLevel(bo.LayerCompressionLevel))
+	}
+	if bo.MediaTypes != "" {
+		opts = append(opts, build.WithMediaTypes(bo.MediaTypes))
+	}
+	if bo.OmitEmptyKoData {
+		opts = append(opts, build.WithOmitEmptyKoData(true))
+	}
+	if len(bo.AddedFiles) > 0 {
+		files, err := parseAddedFiles(bo.AddedFiles, bo.WorkingDirectory)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, build.WithAddedFiles(files...))
+	}
+	if bo.KeepBaseEntrypoint {
+		opts = append(opts, build.WithKeepBaseEntrypoint(true))
+	}
+	// Recording is cheap, and makes the commands available to
+	// --attest-build-command, which is a publish option.
+	opts = append(opts, build.WithBuildCommandRecorder(buildCommands.record))
+	sourceURL := bo.SourceURL
+	if sourceURL == "" {
+		// This is best effort, as not every module is in a git
+		// repository, or has a module path that is a URL.
+		sourceURL = deriveSourceURL(bo.WorkingDirectory)
+	}
+	if sourceURL != "" && sourceURL != options.SourceURLNone {
+		opts = append(opts, build.WithSourceURL(sourceURL))
+	}
+	if bo.GitLabels {
+		labels, err := gitLabels(bo.WorkingDirectory)
+		if err != nil {
+			return nil, fmt.Errorf("determining --git-labels: %w", err)
+		}
+		for k, v := range labels {
+			opts = append(opts, build.WithLabel(k, v))
+		}
+	}
+	// Explicit labels take precedence over --git-labels.
 	for _, lf := range bo.Labels {
 		parts := strings.SplitN(lf, "=", 2)
 		if len(parts) != 2 {
@@ -159,38 +236,109 @@ func makeBuilder(ctx context.Context, bo *options.BuildOptions) (*build.Caching,
 
 // NewPublisher creates a ko publisher
 func NewPublisher(po *options.PublishOptions) (publish.Interface, error) {
-	return makePublisher(po)
+	return makePublisher(po, "")
 }
 
-func makePublisher(po *options.PublishOptions) (publish.Interface, error) {
+// makePublisher creates a ko publisher, reading the git state for tag and
+// image name templates from dir.
+func makePublisher(po *options.PublishOptions, dir string) (publish.Interface, error) {
+	// With --kind-cluster, push to the cluster's local registry if it has
+	// one, and otherwise only load the images into its nodes.
+	var kindRegistryHost string
+	if po.KindCluster != "" {
+		host, err := kindRegistry(po.KindCluster)
+		if err != nil {
+			return nil, err
+		}
+		kpo := *po
+		if host == "" {
+			log.Printf("kind cluster %s has no local registry, loading images into its nodes only", po.KindCluster)
+			kpo.DockerRepo = publish.KindDomain
+		} else {
+			kpo.DockerRepo = host
+			kindRegistryHost = host
+		}
+		po = &kpo
+	}
+
+	var templateNamer *options.TemplateNamer
+	if po.ImageNameTemplate != "" && po.ImageNamer == nil {
+		tn, err := options.NewTemplateNamer(po.ImageNameTemplate, func() (options.TagData, error) {
+			return gitTagData(dir)
+		})
+		if err != nil {
+			return nil, err
+		}
+		templateNamer = tn
+	}
+
 	// Create the publish.Interface that we will use to publish image references
 	// to either a docker daemon or a container image registry.
 	innerPublisher, err := func() (publish.Interface, error) {
 		repoName := po.DockerRepo
 		namer := options.MakeNamer(po)
+		if templateNamer != nil {
+			namer = templateNamer.Namer()
+		}
+		tags, err := options.ExpandTags(po.Tags, func() (options.TagData, error) {
+			return gitTagData(dir)
+		})
+		if err != nil {
+			return nil, err
+		}
+		if po.LoadPodman {
+			return publish.NewPodman(namer, tags,
+				publish.WithLocalDomain(po.LocalDomain),
+			)
+		}
 		if repoName == publish.LocalDomain || po.Local {
 			// TODO(jonjohnsonjr): I'm assuming that nobody will
 			// use local with other publishers, but that might
 			// not be true.
-			return publish.NewDaemon(namer, po.Tags,
+			return publish.NewDaemon(namer, tags,
 				publish.WithDockerClient(po.DockerClient),
 				publish.WithLocalDomain(po.LocalDomain),
 			)
 		}
+		if po.LoadKind != "" {
+			return publish.NewKindPublisher(namer, tags, publish.WithKindCluster(po.LoadKind)), nil
+		}
 		if repoName == publish.KindDomain {
-			return publish.NewKindPublisher(namer, po.Tags), nil
+			return publish.NewKindPublisher(namer, tags, publish.WithKindCluster(po.KindCluster)), nil
+		}
+		if repoName == publish.ContainerdDomain || po.Containerd {
+			return publish.NewContainerdPublisher(namer, tags,
+				publish.WithContainerdAddress(po.ContainerdAddress),
+				publish.WithContainerdNamespace(po.ContainerdNamespace),
+			)
 		}
 
 		if repoName == "" && po.Push {
 			return nil, errors.New("KO_DOCKER_REPO environment variable is unset")
 		}
-		if _, err := name.NewRegistry(repoName); err != nil {
-			if _, err := name.NewRepository(repoName); err != nil {
-				return nil, fmt.Errorf("failed to parse %q as repository: %w", repoName, err)
+		// KO_DOCKER_REPO may list several repositories to mirror images to.
+		repoNames, primary, err := dockerRepos(po)
+		if err != nil {
+			return nil, err
+		}
+		repoName = repoNames[primary]
+		for _, repoName := range repoNames {
+			if _, err := name.NewRegistry(repoName); err != nil {
+				if _, err := name.NewRepository(repoName); err != nil {
+					return nil, fmt.Errorf("failed to parse %q as repository: %w", repoName, err)
+				}
 			}
 		}
 
 		publishers := []publish.Interface{}
+		if kindRegistryHost != "" && po.Push {
+			// Load the images into the nodes too, named as in the registry,
+			// so they don't have to pull them. This goes first, as the
+			// reference is that of the last publisher.
+			publishers = append(publishers, publish.NewKindPublisher(namer, tags,
+				publish.WithKindCluster(po.KindCluster),
+				publish.WithKindDomain(kindRegistryHost)))
+		}
 		if po.OCILayoutPath != "" {
 			lp, err := publish.NewLayout(po.OCILayoutPath)
 			if err != nil {
@@ -199,7 +347,7 @@ func makePublisher(po *options.PublishOptions) (publish.Interface, error) {
 			publishers = append(publishers, lp)
 		}
 		if po.TarballFile != "" {
-			tp := publish.NewTarball(po.TarballFile, repoName, namer, po.Tags)
+			tp := publish.NewTarball(po.TarballFile, repoName, namer, tags)
 			publishers = append(publishers, tp)
 		}
 		userAgent := ua()
@@ -207,18 +355,77 @@ func makePublisher(po *options.PublishOptions) (publish.Interface, error) {
 			userAgent = po.UserAgent
 		}
 		if po.Push {
-			dp, err := publish.NewDefault(repoName,
+			kc := keychain
+			if po.PullSecret != "" {
+				// The pull secret takes precedence for the hosts it has.
+				pullSecret, err := readPullSecret(po.PullSecret)
+				if err != nil {
+					return nil, err
+				}
+				kc = authn.NewMultiKeychain(pullSecret, keychain)
+			}
+			opts := []publish.Option{
 				publish.WithUserAgent(userAgent),
-				publish.WithAuthFromKeychain(keychain),
+				publish.WithAuthFromKeychain(kc),
 				publish.WithNamer(namer),
-				publish.WithTags(po.Tags),
+				publish.WithTags(tags),
 				publish.WithTagOnly(po.TagOnly),
 				publish.Insecure(po.InsecureRegistry),
-			)
-			if err != nil {
-				return nil, err
 			}
-			publishers = append(publishers, dp)
+			if po.NoTag {
+				opts = append(opts, publish.WithNoTag())
+			}
+			if po.PushRetries != 0 {
+				opts = append(opts, publish.WithRetries(po.PushRetries, po.PushRetryDelay))
+			}
+			if po.PushConcurrency != 0 {
+				opts = append(opts, publish.WithPushConcurrency(po.PushConcurrency))
+			}
+			if len(po.InsecureRegistries) > 0 {
+				opts = append(opts, publish.WithInsecureRegistries(po.InsecureRegistries))
+			}
+			if po.SBOMAttach != "" {
+				opts = append(opts, publish.WithSBOMAttach(po.SBOMAttach))
+			}
+			if po.FailIfTagExists {
+				opts = append(opts, publish.WithFailIfTagExists())
+			}
+			if po.MountBlobs {
+				opts = append(opts, publish.WithMountBlobs())
+			}
+			if po.ECRCreateRepo {
+				opts = append(opts, publish.WithECRCreateRepo())
+			}
+			if po.Sign == options.SignKeyless {
+				signer := &sign.Keyless{
+					FulcioURL: po.FulcioURL,
+					RekorURL:  po.RekorURL,
+				}
+				opts = append(opts, publish.WithSigner(signer.Sign))
+			}
+			if po.Provenance {
+				opts = append(opts, publish.WithAttester(provenanceAttester("")))
+			}
+			if po.AttestBuildCommand {
+				opts = append(opts, publish.WithAttester(buildCommandAttester(buildCommands)))
+			}
+			dps := make([]publish.Interface, 0, len(repoNames))
+			for _, repoName := range repoNames {
+				dp, err := publish.NewDefault(repoName, opts...)
+				if err != nil {
+					return nil, err
+				}
+				dps = append(dps, dp)
+			}
+			if len(dps) == 1 {
+				publishers = append(publishers, dps[0])
+			} else {
+				mp, err := publish.MirrorPublisher(primary, dps...)
+				if err != nil {
+					return nil, err
+				}
+				publishers = append(publishers, mp)
+			}
 		}
 
 		// If not publishing, at least generate a digest to simulate
@@ -247,10 +454,58 @@ func makePublisher(po *options.PublishOptions) (publish.Interface, error) {
 		}
 	}
 
+	// Annotate indexes before the recorder sees them, so that it records the
+	// digests that were actually published.
+	if len(po.ImageAnnotations) > 0 {
+		annotations := make(map[string]string, len(po.ImageAnnotations))
+		for _, af := range po.ImageAnnotations {
+			parts := strings.SplitN(af, "=", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("invalid annotation flag: %s", af)
+			}
+			annotations[parts[0]] = parts[1]
+		}
+		innerPublisher, err = publish.NewAnnotator(innerPublisher, annotations)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if templateNamer != nil {
+		innerPublisher = &checkedNamePublisher{Interface: innerPublisher, namer: templateNamer}
+	}
+
 	// Wrap publisher in a memoizing publisher implementation.
 	return publish.NewCaching(innerPublisher)
 }
 
+// dockerRepos splits the comma-separated repositories in po.DockerRepo, and
+// returns the index of the one that references should be resolved to.
+func dockerRepos(po *options.PublishOptions) ([]string, int, error) {
+	repoNames := strings.Split(po.DockerRepo, ",")
+	for i, repoName := range repoNames {
+		repoName = strings.TrimSpace(repoName)
+		if len(repoNames) > 1 {
+			switch repoName {
+			case "":
+				return nil, 0, fmt.Errorf("empty repository in KO_DOCKER_REPO %q", po.DockerRepo)
+			case publish.LocalDomain, publish.KindDomain, publish.ContainerdDomain:
+				return nil, 0, fmt.Errorf("cannot publish to %s and other repositories at the same time", repoName)
+			}
+		}
+		repoNames[i] = repoName
+	}
+	if po.PrimaryRepo == "" {
+		return repoNames, 0, nil
+	}
+	for i, repoName := range repoNames {
+		if repoName == po.PrimaryRepo {
+			return repoNames, i, nil
+		}
+	}
+	return nil, 0, fmt.Errorf("primary repository %q is not one of KO_DOCKER_REPO %q", po.PrimaryRepo, po.DockerRepo)
+}
+
 // nopPublisher simulates publishing without actually publishing anything, to
 // provide fallback behavior when the user configures no push destinations.
 type nopPublisher struct {
@@ -269,7 +524,9 @@ func (n nopPublisher) Publish(_ context.Context, br build.Result, s string) (nam
 
 func (n nopPublisher) Close() error { return nil }
 
-// resolvedFuture represents a "future" for the bytes of a resolved file.
+// resolvedFuture represents a "future" for the bytes of a resolved file. It
+// is closed once the file is done, so a streamed file like stdin may send
+// several chunks on it first.
 type resolvedFuture chan []byte
 
 func resolveFilesToWriter(
@@ -278,6 +535,7 @@ func resolveFilesToWriter(
 	publisher publish.Interface,
 	fo *options.FilenameOptions,
 	so *options.SelectorOptions,
+	bo *options.BuildOptions,
 	out io.WriteCloser) error {
 	defer out.Close()
 
@@ -294,6 +552,19 @@ func resolveFilesToWriter(
 	// individual build fails.
 	errs, ctx := errgroup.WithContext(ctx)
 
+	// In watch mode, keep `fs` open to send the files again when their
+	// sources change.
+	var sw *sourceWatcher
+	if fo.Watch {
+		var err error
+		sw, err = newSourceWatcher(builder, bo)
+		if err != nil {
+			return fmt.Errorf("error watching files: %w", err)
+		}
+		defer sw.Close()
+		fs = sw.files(ctx, fs)
+	}
+
 	var futures []resolvedFuture
 	for {
 		// Each iteration, if there is anything in the list of futures,
@@ -335,11 +606,27 @@ func resolveFilesToWriter(
 				recordingBuilder := &build.Recorder{
 					Builder: builder,
 				}
-				b, err := resolveFile(ctx, f, recordingBuilder, publisher, so)
+				if f == "-" {
+					// Stream stdin, one document at a time.
+					if err := resolveStream(ctx, os.Stdin, recordingBuilder, publisher, so, bo.Scheme, ch); err != nil {
+						return fmt.Errorf("error processing import paths in %q: %w", f, err)
+					}
+					sm.Store(f, recordingBuilder.ImportPaths)
+					return nil
+				}
+				b, err := resolveFile(ctx, f, recordingBuilder, publisher, so, bo.Scheme)
+				if sw != nil {
+					sw.record(ctx, f, recordingBuilder.ImportPaths)
+				}
 				if err != nil {
-					// This error is sometimes expected during watch mode, so this
-					// isn't fatal. Just print it and keep the watch open.
-					return fmt.Errorf("error processing import paths in %q: %w", f, err)
+					err = fmt.Errorf("error processing import paths in %q: %w", f, err)
+					if sw != nil && ctx.Err() == nil {
+						// This error is sometimes expected during watch mode, so this
+						// isn't fatal. Just print it and keep the watch open.
+						log.Print(err)
+						return nil
+					}
+					return err
 				}
 				// Associate with this file the collection of binary import paths.
 				sm.Store(f, recordingBuilder.ImportPaths)
@@ -348,17 +635,18 @@ func resolveFilesToWriter(
 			})
 
 		case b, ok := <-bf:
-			// Once the head channel returns something, dequeue it.
+			// Once the head channel is closed, dequeue it.
 			// We listen to the futures in order to be respectful of
 			// the kubectl apply ordering, which matters!
-			futures = futures[1:]
-			if ok {
-				// Write the next body and a trailing delimiter.
-				// We write the delimeter LAST so that when streamed to
-				// kubectl it knows that the resource is complete and may
-				// be applied.
-				out.Write(append(b, []byte("\n---\n")...))
+			if !ok {
+				futures = futures[1:]
+				break
 			}
+			// Write the next body and a trailing delimiter.
+			// We write the delimeter LAST so that when streamed to
+			// kubectl it knows that the resource is complete and may
+			// be applied.
+			out.Write(append(b, []byte("\n---\n")...))
 		}
 	}
 
@@ -372,28 +660,124 @@ func resolveFile(
 	f string,
 	builder build.Interface,
 	pub publish.Interface,
-	so *options.SelectorOptions) (b []byte, err error) {
-	var selector labels.Selector
-	if so.Selector != "" {
-		var err error
-		selector, err = labels.Parse(so.Selector)
+	so *options.SelectorOptions,
+	scheme string) (b []byte, err error) {
+	selector, err := parseSelector(so)
+	if err != nil {
+		return nil, err
+	}
 
+	b, err = readFile(f)
+	if err != nil {
+		return nil, err
+	}
+	return resolveBytes(ctx, f, b, builder, pub, selector, resolveOptions(so, scheme)...)
+}
+
+// readFile returns the contents of the file f, or of stdin if f is "-".
+func readFile(f string) ([]byte, error) {
+	if f == "-" {
+		return ioutil.ReadAll(os.Stdin)
+	}
+	return ioutil.ReadFile(f)
+}
+
+// resolveOptions returns the options to resolve references with the given
+// scheme with, and the nested ones so asks for. Unless so asks for all of
+// them, only the references in Kubernetes objects are resolved.
+func resolveOptions(so *options.SelectorOptions, scheme string) []resolve.Option {
+	opts := []resolve.Option{
+		resolve.WithScheme(scheme),
+		resolve.WithNested(so.Nested...),
+	}
+	if !so.ResolveAll {
+		opts = append(opts, resolve.WithKubernetesObjectsOnly())
+	}
+	return opts
+}
+
+// documentSelector selects documents by their labels and annotations, either
+// of which may be nil to not filter on it.
+type documentSelector struct {
+	labels      labels.Selector
+	annotations labels.Selector
+}
+
+// matches returns whether doc matches both selectors. Lists are mutated to
+// only include the items that do.
+func (s *documentSelector) matches(doc *yaml.Node) (bool, error) {
+	if s.labels != nil {
+		if match, err := resolve.MatchesSelector(doc, s.labels); err != nil || !match {
+			return false, err
+		}
+	}
+	if s.annotations != nil {
+		return resolve.MatchesAnnotationSelector(doc, s.annotations)
+	}
+	return true, nil
+}
+
+// parseSelector returns the selector documents have to match to be resolved,
+// or nil if all of them are.
+func parseSelector(so *options.SelectorOptions) (*documentSelector, error) {
+	if so.Selector == "" && so.AnnotationSelector == "" {
+		return nil, nil
+	}
+	selector := &documentSelector{}
+	if so.Selector != "" {
+		s, err := labels.Parse(so.Selector)
 		if err != nil {
 			return nil, fmt.Errorf("unable to parse selector: %w", err)
 		}
+		selector.labels = s
 	}
-
-	if f == "-" {
-		b, err = ioutil.ReadAll(os.Stdin)
-	} else {
-		b, err = ioutil.ReadFile(f)
+	if so.AnnotationSelector != "" {
+		s, err := labels.Parse(so.AnnotationSelector)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse annotation selector: %w", err)
+		}
+		selector.annotations = s
 	}
+	return selector, nil
+}
+
+// resolveBytes resolves the references in b, the contents of the file f, to
+// the documents that match selector, if it's not nil.
+func resolveBytes(
+	ctx context.Context,
+	f string,
+	b []byte,
+	builder build.Interface,
+	pub publish.Interface,
+	selector *documentSelector,
+	opts ...resolve.Option) ([]byte, error) {
+	docNodes, err := decodeDocuments(b, selector)
 	if err != nil {
 		return nil, err
 	}
 
+	positions := resolve.FindReferences(docNodes, opts...)
+	if err := resolve.ImageReferences(ctx, docNodes, builder, pub, opts...); err != nil {
+		return nil, fmt.Errorf("error resolving image references: %w", err)
+	}
+
+	// Unless some documents were filtered out, only rewrite the references
+	// in the input, so comments and formatting are kept as they were.
+	if selector == nil {
+		if out, ok := positions.Rewrite(b); ok {
+			return out, nil
+		}
+	}
+	return encodeDocuments(f, b, docNodes)
+}
+
+// decodeDocuments decodes the documents in b that match selector, if it's
+// not nil.
+func decodeDocuments(b []byte, selector *documentSelector) ([]*yaml.Node, error) {
 	var docNodes []*yaml.Node
 
+	// JSON is YAML, so JSON files are decoded the same way, and only
+	// encoded differently at the end.
 	// The loop is to support multi-document yaml files.
 	// This is handled by using a yaml.Decoder and reading objects until io.EOF, see:
 	// https://godoc.org/gopkg.in/yaml.v3#Decoder.Decode
@@ -408,7 +792,7 @@ func resolveFile(
 		}
 
 		if selector != nil {
-			if match, err := resolve.MatchesSelector(&doc, selector); err != nil {
+			if match, err := selector.matches(&doc); err != nil {
 				return nil, fmt.Errorf("error evaluating selector: %w", err)
 			} else if !match {
 				continue
@@ -417,12 +801,24 @@ func resolveFile(
 
 		docNodes = append(docNodes, &doc)
 	}
+	return docNodes, nil
+}
 
-	if err := resolve.ImageReferences(ctx, docNodes, builder, pub); err != nil {
-		return nil, fmt.Errorf("error resolving image references: %w", err)
+// encodeDocuments encodes docNodes, decoded from b, the contents of the file
+// f, as YAML, or JSON if f is JSON.
+func encodeDocuments(f string, b []byte, docNodes []*yaml.Node) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if isJSON(f, b) {
+		for _, doc := range docNodes {
+			j, err := resolve.EncodeJSON(doc)
+			if err != nil {
+				return nil, fmt.Errorf("failed to encode output: %w", err)
+			}
+			buf.Write(j)
+		}
+		return buf.Bytes(), nil
 	}
 
-	buf := &bytes.Buffer{}
 	e := yaml.NewEncoder(buf)
 	e.SetIndent(2)
 
@@ -436,3 +832,14 @@ func resolveFile(
 
 	return buf.Bytes(), nil
 }
+
+// isJSON returns whether the file f, with contents b, is JSON rather than
+// YAML: whether it has a .json extension or, e.g. for stdin, starts like a
+// JSON object or array.
+func isJSON(f string, b []byte) bool {
+	if strings.EqualFold(filepath.Ext(f), ".json") {
+		return true
+	}
+	b = bytes.TrimSpace(b)
+	return len(b) > 0 && (b[0] == '{' || b[0] == '[')
+}
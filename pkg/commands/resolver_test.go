@@ -19,6 +19,7 @@ package commands
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -26,8 +27,10 @@ import (
 	"log"
 	"net/http/httptest"
 	"path"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/docker/docker/api/types"
 	"github.com/google/go-cmp/cmp"
@@ -39,9 +42,14 @@ import (
 	"github.com/google/go-containerregistry/pkg/v1/daemon"
 	"github.com/google/go-containerregistry/pkg/v1/empty"
 	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
 	"github.com/google/ko/pkg/build"
 	"github.com/google/ko/pkg/commands/options"
 	kotesting "github.com/google/ko/pkg/internal/testing"
+	"github.com/google/ko/pkg/publish"
+	"github.com/google/ko/pkg/publish/containerd"
+	ociremote "github.com/sigstore/cosign/pkg/oci/remote"
+	"github.com/spf13/cobra"
 	"gopkg.in/yaml.v3"
 )
 
@@ -97,7 +105,8 @@ func TestResolveMultiDocumentYAMLs(t *testing.T) {
 		yamlToTmpFile(t, buf.Bytes()),
 		testBuilder,
 		kotesting.NewFixedPublish(base, testHashes),
-		&options.SelectorOptions{})
+		&options.SelectorOptions{ResolveAll: true},
+		build.StrictScheme)
 
 	if err != nil {
 		t.Fatalf("ImageReferences(%v) = %v", string(inputYAML), err)
@@ -131,6 +140,290 @@ func TestResolveMultiDocumentYAMLs(t *testing.T) {
 	}
 }
 
+func TestResolveMixedDocuments(t *testing.T) {
+	base := mustRepository("gcr.io/multi-pass")
+	object := `apiVersion: v1
+kind: Pod
+spec:
+  containers:
+  - image: %s
+`
+	config := `# Not a Kubernetes object.
+plugins:
+  source: %s
+`
+	input := object + "---\n" + config
+	inputYAML := []byte(fmt.Sprintf(input, build.StrictScheme+fooRef, build.StrictScheme+barRef))
+	resolvedFoo := kotesting.ComputeDigest(base, fooRef, fooHash)
+	resolvedBar := kotesting.ComputeDigest(base, barRef, barHash)
+
+	for _, test := range []struct {
+		desc string
+		so   *options.SelectorOptions
+		want string
+	}{{
+		desc: "kubernetes objects only",
+		so:   &options.SelectorOptions{},
+		want: fmt.Sprintf(input, resolvedFoo, build.StrictScheme+barRef),
+	}, {
+		desc: "--resolve-all",
+		so:   &options.SelectorOptions{ResolveAll: true},
+		want: fmt.Sprintf(input, resolvedFoo, resolvedBar),
+	}} {
+		t.Run(test.desc, func(t *testing.T) {
+			outYAML, err := resolveFile(
+				context.Background(),
+				yamlToTmpFile(t, inputYAML),
+				testBuilder,
+				kotesting.NewFixedPublish(base, testHashes),
+				test.so,
+				build.StrictScheme)
+			if err != nil {
+				t.Fatalf("resolveFile(%v) = %v", string(inputYAML), err)
+			}
+			if diff := cmp.Diff(test.want, string(outYAML)); diff != "" {
+				t.Errorf("resolveFile(%v); (-want +got) = %v", string(inputYAML), diff)
+			}
+		})
+	}
+}
+
+func TestResolveJSON(t *testing.T) {
+	base := mustRepository("gcr.io/multi-pass")
+	inputJSON := []byte(fmt.Sprintf(`{
+  "kind": "List",
+  "items": [%q, %q],
+  "replicas": 1.0,
+  "enabled": true,
+  "note": null
+}`, build.StrictScheme+fooRef, build.StrictScheme+barRef))
+
+	want := fmt.Sprintf(`{
+  "kind": "List",
+  "items": [%q, %q],
+  "replicas": 1.0,
+  "enabled": true,
+  "note": null
+}`, kotesting.ComputeDigest(base, fooRef, fooHash), kotesting.ComputeDigest(base, barRef, barHash))
+
+	jsonFile := filepath.Join(t.TempDir(), "deploy.json")
+	if err := ioutil.WriteFile(jsonFile, inputJSON, 0644); err != nil {
+		t.Fatalf("WriteFile() = %v", err)
+	}
+	for _, f := range []string{
+		jsonFile,
+		// Without the extension, JSON is recognized by its contents.
+		yamlToTmpFile(t, inputJSON),
+	} {
+		outJSON, err := resolveFile(
+			context.Background(),
+			f,
+			testBuilder,
+			kotesting.NewFixedPublish(base, testHashes),
+			&options.SelectorOptions{ResolveAll: true},
+			build.StrictScheme)
+		if err != nil {
+			t.Fatalf("resolveFile(%v) = %v", string(inputJSON), err)
+		}
+		if !json.Valid(outJSON) {
+			t.Errorf("resolveFile(%v) = %v, wanted JSON", string(inputJSON), string(outJSON))
+		}
+		if diff := cmp.Diff(want, string(outJSON)); diff != "" {
+			t.Errorf("resolveFile(%v); (-want +got) = %v", string(inputJSON), diff)
+		}
+	}
+}
+
+func TestResolvePreservesComments(t *testing.T) {
+	base := mustRepository("gcr.io/multi-pass")
+	input := `# The deployment of foo.
+apiVersion: apps/v1
+kind: Deployment
+spec:
+  template:
+    spec:
+      containers:
+      - name: foo   # keep this
+        image: %s # resolved by ko
+        args: ["--flag",   'value']
+---
+# bar, quoted.
+zeta: 1
+alpha: "%s"
+`
+	inputYAML := []byte(fmt.Sprintf(input, build.StrictScheme+fooRef, build.StrictScheme+barRef))
+	want := fmt.Sprintf(input, kotesting.ComputeDigest(base, fooRef, fooHash), kotesting.ComputeDigest(base, barRef, barHash))
+
+	outYAML, err := resolveFile(
+		context.Background(),
+		yamlToTmpFile(t, inputYAML),
+		testBuilder,
+		kotesting.NewFixedPublish(base, testHashes),
+		&options.SelectorOptions{ResolveAll: true},
+		build.StrictScheme)
+	if err != nil {
+		t.Fatalf("resolveFile(%v) = %v", string(inputYAML), err)
+	}
+	if diff := cmp.Diff(want, string(outYAML)); diff != "" {
+		t.Errorf("resolveFile(%v); (-want +got) = %v", string(inputYAML), diff)
+	}
+}
+
+func TestResolveCustomScheme(t *testing.T) {
+	base := mustRepository("gcr.io/multi-pass")
+	input := `image: %s
+other: %s
+`
+	// Only references with the configured scheme are resolved.
+	inputYAML := []byte(fmt.Sprintf(input, "image://"+fooRef, build.StrictScheme+barRef))
+	want := fmt.Sprintf(input, kotesting.ComputeDigest(base, fooRef, fooHash), build.StrictScheme+barRef)
+
+	outYAML, err := resolveFile(
+		context.Background(),
+		yamlToTmpFile(t, inputYAML),
+		testBuilder,
+		kotesting.NewFixedPublish(base, testHashes),
+		&options.SelectorOptions{ResolveAll: true},
+		"image://")
+	if err != nil {
+		t.Fatalf("resolveFile(%v) = %v", string(inputYAML), err)
+	}
+	if diff := cmp.Diff(want, string(outYAML)); diff != "" {
+		t.Errorf("resolveFile(%v); (-want +got) = %v", string(inputYAML), diff)
+	}
+}
+
+func TestResolveNested(t *testing.T) {
+	base := mustRepository("gcr.io/multi-pass")
+	input := `apiVersion: v1
+kind: ConfigMap
+data:
+  config.yaml: |
+    # The image to run.
+    image: %s
+`
+	inputYAML := []byte(fmt.Sprintf(input, build.StrictScheme+fooRef))
+	want := fmt.Sprintf(input, kotesting.ComputeDigest(base, fooRef, fooHash))
+
+	outYAML, err := resolveFile(
+		context.Background(),
+		yamlToTmpFile(t, inputYAML),
+		testBuilder,
+		kotesting.NewFixedPublish(base, testHashes),
+		&options.SelectorOptions{Nested: []string{"/data/config.yaml"}},
+		build.StrictScheme)
+	if err != nil {
+		t.Fatalf("resolveFile(%v) = %v", string(inputYAML), err)
+	}
+	if diff := cmp.Diff(want, string(outYAML)); diff != "" {
+		t.Errorf("resolveFile(%v); (-want +got) = %v", string(inputYAML), diff)
+	}
+}
+
+func TestResolveWithMirrors(t *testing.T) {
+	ghcr := mustRepository("ghcr.io/example")
+	harbor := mustRepository("harbor.example.com/mirror")
+
+	inputYAML, err := yaml.Marshal(build.StrictScheme + fooRef)
+	if err != nil {
+		t.Fatalf("yaml.Marshal() = %v", err)
+	}
+
+	for _, tc := range []struct {
+		primary int
+		want    name.Repository
+	}{{
+		primary: 0,
+		want:    ghcr,
+	}, {
+		primary: 1,
+		want:    harbor,
+	}} {
+		pub, err := publish.MirrorPublisher(tc.primary,
+			kotesting.NewFixedPublish(ghcr, testHashes),
+			kotesting.NewFixedPublish(harbor, testHashes))
+		if err != nil {
+			t.Fatalf("MirrorPublisher() = %v", err)
+		}
+
+		outYAML, err := resolveFile(
+			context.Background(),
+			yamlToTmpFile(t, inputYAML),
+			testBuilder,
+			pub,
+			&options.SelectorOptions{ResolveAll: true},
+			build.StrictScheme)
+		if err != nil {
+			t.Fatalf("resolveFile() = %v", err)
+		}
+
+		var got string
+		if err := yaml.Unmarshal(outYAML, &got); err != nil {
+			t.Fatalf("yaml.Unmarshal(%v) = %v", string(outYAML), err)
+		}
+		if want := kotesting.ComputeDigest(tc.want, fooRef, fooHash); got != want {
+			t.Errorf("resolveFile() = %s, want %s", got, want)
+		}
+	}
+}
+
+func TestDockerRepos(t *testing.T) {
+	for _, tc := range []struct {
+		description string
+		po          *options.PublishOptions
+		want        []string
+		wantPrimary int
+		wantErr     bool
+	}{{
+		description: "single repository",
+		po:          &options.PublishOptions{DockerRepo: "ghcr.io/example"},
+		want:        []string{"ghcr.io/example"},
+	}, {
+		description: "several repositories",
+		po:          &options.PublishOptions{DockerRepo: "ghcr.io/example, harbor.example.com/mirror"},
+		want:        []string{"ghcr.io/example", "harbor.example.com/mirror"},
+	}, {
+		description: "primary repository",
+		po: &options.PublishOptions{
+			DockerRepo:  "ghcr.io/example,harbor.example.com/mirror",
+			PrimaryRepo: "harbor.example.com/mirror",
+		},
+		want:        []string{"ghcr.io/example", "harbor.example.com/mirror"},
+		wantPrimary: 1,
+	}, {
+		description: "unknown primary repository",
+		po: &options.PublishOptions{
+			DockerRepo:  "ghcr.io/example,harbor.example.com/mirror",
+			PrimaryRepo: "quay.io/example",
+		},
+		wantErr: true,
+	}, {
+		description: "empty repository",
+		po:          &options.PublishOptions{DockerRepo: "ghcr.io/example,"},
+		wantErr:     true,
+	}, {
+		description: "local repository",
+		po:          &options.PublishOptions{DockerRepo: "ghcr.io/example,ko.local"},
+		wantErr:     true,
+	}} {
+		t.Run(tc.description, func(t *testing.T) {
+			got, primary, err := dockerRepos(tc.po)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("dockerRepos() = %v, wantErr %v", err, tc.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("dockerRepos() (-want +got) = %s", diff)
+			}
+			if primary != tc.wantPrimary {
+				t.Errorf("dockerRepos() primary = %d, want %d", primary, tc.wantPrimary)
+			}
+		})
+	}
+}
+
 func TestResolveMultiDocumentYAMLsWithSelector(t *testing.T) {
 	passesSelector := `apiVersion: something/v1
 kind: Foo
@@ -152,7 +445,8 @@ kind: Bar
 		kotesting.NewFixedPublish(base, testHashes),
 		&options.SelectorOptions{
 			Selector: "qux=baz",
-		})
+		},
+		build.StrictScheme)
 	if err != nil {
 		t.Fatalf("ImageReferences(%v) = %v", string(inputYAML), err)
 	}
@@ -161,6 +455,118 @@ kind: Bar
 	}
 }
 
+func TestResolveMultiDocumentYAMLsWithAnnotationSelector(t *testing.T) {
+	passesSelector := `apiVersion: something/v1
+kind: Foo
+metadata:
+  annotations:
+    qux: baz
+  labels:
+    app: web
+`
+	failsLabelSelector := `apiVersion: something/v1
+kind: Foo
+metadata:
+  annotations:
+    qux: baz
+  labels:
+    app: db
+`
+	failsSelector := `apiVersion: other/v2
+kind: Bar
+metadata:
+  labels:
+    app: web
+`
+	// Note that this ends in '---', so it in ends in a final null YAML document.
+	inputYAML := []byte(fmt.Sprintf("%s---\n%s---\n%s---", passesSelector, failsLabelSelector, failsSelector))
+	base := mustRepository("gcr.io/multi-pass")
+
+	for _, test := range []struct {
+		desc string
+		so   *options.SelectorOptions
+		want string
+	}{{
+		desc: "annotations",
+		so:   &options.SelectorOptions{AnnotationSelector: "qux=baz"},
+		want: passesSelector + "---\n" + failsLabelSelector,
+	}, {
+		desc: "annotations and labels",
+		so:   &options.SelectorOptions{Selector: "app=web", AnnotationSelector: "qux=baz"},
+		want: passesSelector,
+	}} {
+		t.Run(test.desc, func(t *testing.T) {
+			outputYAML, err := resolveFile(
+				context.Background(),
+				yamlToTmpFile(t, inputYAML),
+				testBuilder,
+				kotesting.NewFixedPublish(base, testHashes),
+				test.so,
+				build.StrictScheme)
+			if err != nil {
+				t.Fatalf("ImageReferences(%v) = %v", string(inputYAML), err)
+			}
+			if diff := cmp.Diff(test.want, string(outputYAML)); diff != "" {
+				t.Errorf("resolveFile (-want +got) = %v", diff)
+			}
+		})
+	}
+}
+
+func TestResolveMultiDocumentYAMLsWithSetSelector(t *testing.T) {
+	prod := `apiVersion: something/v1
+kind: Foo
+metadata:
+  labels:
+    env: prod
+`
+	staging := `apiVersion: something/v1
+kind: Foo
+metadata:
+  labels:
+    env: staging
+`
+	dev := `apiVersion: something/v1
+kind: Foo
+metadata:
+  labels:
+    env: dev
+`
+	unlabeled := `apiVersion: other/v2
+kind: Bar
+`
+	inputYAML := []byte(strings.Join([]string{prod, staging, dev, unlabeled}, "---\n"))
+	base := mustRepository("gcr.io/multi-pass")
+
+	for _, test := range []struct {
+		selector string
+		want     string
+	}{
+		{selector: "env in (prod,staging)", want: prod + "---\n" + staging},
+		{selector: "env notin (prod,staging)", want: dev},
+		{selector: "env", want: prod + "---\n" + staging + "---\n" + dev},
+		{selector: "!env", want: ""},
+	} {
+		t.Run(test.selector, func(t *testing.T) {
+			outputYAML, err := resolveFile(
+				context.Background(),
+				yamlToTmpFile(t, inputYAML),
+				testBuilder,
+				kotesting.NewFixedPublish(base, testHashes),
+				&options.SelectorOptions{
+					Selector: test.selector,
+				},
+				build.StrictScheme)
+			if err != nil {
+				t.Fatalf("ImageReferences(%v) = %v", string(inputYAML), err)
+			}
+			if diff := cmp.Diff(test.want, string(outputYAML)); diff != "" {
+				t.Errorf("resolveFile (-want +got) = %v", diff)
+			}
+		})
+	}
+}
+
 func TestNewBuilder(t *testing.T) {
 	namespace := "base"
 	s, err := registryServerWithImage(namespace)
@@ -205,6 +611,17 @@ func TestNewBuilder(t *testing.T) {
 			wantQualifiedImportpath: "ko://github.com/google/ko/test",
 			shouldBuildError:        true,
 		},
+		{
+			description: "local import path relative to module root",
+			importpath:  "./test",
+			bo: &options.BuildOptions{
+				BaseImage:        baseImage,
+				ConcurrentBuilds: 1,
+				ModuleRoot:       "../..",
+			},
+			wantQualifiedImportpath: "ko://github.com/google/ko/test",
+			shouldBuildError:        false,
+		},
 	}
 	for _, test := range tests {
 		t.Run(test.description, func(t *testing.T) {
@@ -231,6 +648,173 @@ func TestNewBuilder(t *testing.T) {
 	}
 }
 
+func TestNewBuilderTimestamp(t *testing.T) {
+	namespace := "base"
+	s, err := registryServerWithImage(namespace)
+	if err != nil {
+		t.Fatalf("could not create test registry server: %v", err)
+	}
+	defer s.Close()
+	baseImage := fmt.Sprintf("%s/%s", s.Listener.Addr().String(), namespace)
+	ctx := context.Background()
+
+	buildImage := func() v1.Image {
+		builder, err := NewBuilder(ctx, &options.BuildOptions{
+			BaseImage:        baseImage,
+			ConcurrentBuilds: 1,
+			Trimpath:         true,
+			Timestamp:        "1600000000",
+		})
+		if err != nil {
+			t.Fatalf("NewBuilder(): %v", err)
+		}
+		result, err := builder.Build(ctx, "ko://github.com/google/ko/test")
+		if err != nil {
+			t.Fatalf("builder.Build(): %v", err)
+		}
+		img, ok := result.(v1.Image)
+		if !ok {
+			t.Fatalf("builder.Build() not an Image: %T", result)
+		}
+		return img
+	}
+
+	first, second := buildImage(), buildImage()
+	cf, err := first.ConfigFile()
+	if err != nil {
+		t.Fatalf("ConfigFile(): %v", err)
+	}
+	if want := time.Unix(1600000000, 0); !cf.Created.Time.Equal(want) {
+		t.Errorf("Created = %v, want %v", cf.Created.Time, want)
+	}
+	d1, err := first.Digest()
+	if err != nil {
+		t.Fatalf("Digest(): %v", err)
+	}
+	d2, err := second.Digest()
+	if err != nil {
+		t.Fatalf("Digest(): %v", err)
+	}
+	if d1 != d2 {
+		t.Errorf("Digest() = %v, then %v; want identical digests", d1, d2)
+	}
+}
+
+func TestPublishWithWorkingDir(t *testing.T) {
+	namespace := "base"
+	s, err := registryServerWithImage(namespace)
+	if err != nil {
+		t.Fatalf("could not create test registry server: %v", err)
+	}
+	defer s.Close()
+	registryHost := s.Listener.Addr().String()
+	importpath := "github.com/google/ko/test"
+	ctx := context.Background()
+
+	builder, err := NewBuilder(ctx, &options.BuildOptions{
+		BaseImage:        fmt.Sprintf("%s/%s", registryHost, namespace),
+		ConcurrentBuilds: 1,
+		BuildConfigs: map[string]build.Config{
+			importpath: {WorkingDir: "/ko-app"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewBuilder(): %v", err)
+	}
+	publisher, err := NewPublisher(&options.PublishOptions{
+		DockerRepo:          fmt.Sprintf("%s/repo", registryHost),
+		PreserveImportPaths: true,
+		Push:                true,
+		Tags:                []string{"latest"},
+	})
+	if err != nil {
+		t.Fatalf("NewPublisher(): %v", err)
+	}
+	defer publisher.Close()
+
+	result, err := builder.Build(ctx, build.StrictScheme+importpath)
+	if err != nil {
+		t.Fatalf("builder.Build(): %v", err)
+	}
+	ref, err := publisher.Publish(ctx, result, build.StrictScheme+importpath)
+	if err != nil {
+		t.Fatalf("publisher.Publish(): %v", err)
+	}
+
+	img, err := remote.Image(ref)
+	if err != nil {
+		t.Fatalf("remote.Image(%v): %v", ref, err)
+	}
+	cfg, err := img.ConfigFile()
+	if err != nil {
+		t.Fatalf("ConfigFile(): %v", err)
+	}
+	if got, want := cfg.Config.WorkingDir, "/ko-app"; got != want {
+		t.Errorf("WorkingDir = %q, want %q", got, want)
+	}
+}
+
+func TestPublishSBOM(t *testing.T) {
+	namespace := "base"
+	s, err := registryServerWithImage(namespace)
+	if err != nil {
+		t.Fatalf("could not create test registry server: %v", err)
+	}
+	defer s.Close()
+	registryHost := s.Listener.Addr().String()
+	importpath := build.StrictScheme + "github.com/google/ko/test"
+	ctx := context.Background()
+
+	for _, test := range []struct {
+		description string
+		sbom        string
+		wantSBOM    bool
+	}{
+		{description: "default", sbom: "", wantSBOM: true},
+		{description: "none", sbom: "none", wantSBOM: false},
+	} {
+		t.Run(test.description, func(t *testing.T) {
+			builder, err := NewBuilder(ctx, &options.BuildOptions{
+				BaseImage:        fmt.Sprintf("%s/%s", registryHost, namespace),
+				ConcurrentBuilds: 1,
+				SBOM:             test.sbom,
+			})
+			if err != nil {
+				t.Fatalf("NewBuilder(): %v", err)
+			}
+			// The images are identical, so push them to different
+			// repositories to tell their SBOMs apart.
+			publisher, err := NewPublisher(&options.PublishOptions{
+				DockerRepo: fmt.Sprintf("%s/%s", registryHost, test.description),
+				Push:       true,
+				Tags:       []string{"latest"},
+			})
+			if err != nil {
+				t.Fatalf("NewPublisher(): %v", err)
+			}
+			defer publisher.Close()
+
+			result, err := builder.Build(ctx, importpath)
+			if err != nil {
+				t.Fatalf("builder.Build(): %v", err)
+			}
+			ref, err := publisher.Publish(ctx, result, importpath)
+			if err != nil {
+				t.Fatalf("publisher.Publish(): %v", err)
+			}
+
+			tag, err := ociremote.SBOMTag(ref)
+			if err != nil {
+				t.Fatalf("ociremote.SBOMTag(): %v", err)
+			}
+			_, err = remote.Head(tag)
+			if gotSBOM := err == nil; gotSBOM != test.wantSBOM {
+				t.Errorf("SBOM %s pushed = %t, want %t (%v)", tag, gotSBOM, test.wantSBOM, err)
+			}
+		})
+	}
+}
+
 func TestNewPublisherCanPublish(t *testing.T) {
 	dockerRepo := "registry.example.com/repo"
 	localDomain := "localdomain.example.com/repo"
@@ -268,6 +852,15 @@ func TestNewPublisherCanPublish(t *testing.T) {
 				DockerClient:        &kotesting.MockDaemon{},
 			},
 		},
+		{
+			description:   "containerd",
+			wantImageName: fmt.Sprintf("%s/%s", publish.ContainerdDomain, importpath),
+			po: &options.PublishOptions{
+				Containerd:          true,
+				ContainerdNamespace: "k8s.io",
+				PreserveImportPaths: true,
+			},
+		},
 		{
 			description:   "override DockerClient",
 			wantImageName: strings.ToLower(fmt.Sprintf("%s/%s", localDomain, importpath)),
@@ -279,6 +872,16 @@ func TestNewPublisherCanPublish(t *testing.T) {
 			wantError:   errImageLoad,
 		},
 	}
+	oldRunCtr := containerd.RunCtr
+	defer func() { containerd.RunCtr = oldRunCtr }()
+	containerd.RunCtr = func(_ context.Context, stdin io.Reader, _ ...string) ([]byte, error) {
+		if stdin != nil {
+			if _, err := io.Copy(ioutil.Discard, stdin); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	}
 	for _, test := range tests {
 		t.Run(test.description, func(t *testing.T) {
 			publisher, err := NewPublisher(test.po)
@@ -363,3 +966,14 @@ func yamlToTmpFile(t *testing.T, yaml []byte) string {
 
 	return tmpfile.Name()
 }
+
+func TestResolveRejectsTarballToStdout(t *testing.T) {
+	root := &cobra.Command{Use: "ko", SilenceUsage: true, SilenceErrors: true}
+	addResolve(root)
+	root.SetArgs([]string{"resolve", "--tarball=-", "-f", "config.yaml"})
+	root.SetOut(ioutil.Discard)
+	err := root.Execute()
+	if want := "--tarball=- can only be used with ko build"; err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("Execute() = %v, want error containing %q", err, want)
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/google/ko/pkg/commands/options"
+	"gopkg.in/yaml.v3"
+)
+
+// rolloutKinds are the kinds of the objects whose rollouts "ko apply --wait"
+// waits for.
+var rolloutKinds = map[string]bool{
+	"Deployment":  true,
+	"StatefulSet": true,
+	"DaemonSet":   true,
+}
+
+// workload is an object "kubectl rollout status" can wait for.
+type workload struct {
+	kind, name, namespace string
+}
+
+func (w workload) String() string {
+	return strings.ToLower(w.kind) + "/" + w.name
+}
+
+// workloads returns the workloads in the YAML stream r, in order.
+func workloads(r io.Reader) ([]workload, error) {
+	docs := &yamlDocuments{r: bufio.NewReader(r)}
+	var ws []workload
+	for {
+		doc, err := docs.next()
+		if errors.Is(err, io.EOF) {
+			return ws, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		var obj struct {
+			Kind     string `yaml:"kind"`
+			Metadata struct {
+				Name      string `yaml:"name"`
+				Namespace string `yaml:"namespace"`
+			} `yaml:"metadata"`
+		}
+		if err := yaml.Unmarshal(doc, &obj); err != nil {
+			return nil, fmt.Errorf("decoding document: %w", err)
+		}
+		if !rolloutKinds[obj.Kind] || obj.Metadata.Name == "" {
+			continue
+		}
+		ws = append(ws, workload{
+			kind:      obj.Kind,
+			name:      obj.Metadata.Name,
+			namespace: obj.Metadata.Namespace,
+		})
+	}
+}
+
+// rolloutStatusArgs returns the arguments of the "kubectl rollout status"
+// waiting for w, in the namespace in ko if w doesn't set its own.
+func rolloutStatusArgs(w workload, ko *options.KubectlOptions, timeout time.Duration) []string {
+	args := []string{"rollout", "status", w.String()}
+	if ko.Context != "" {
+		args = append(args, "--context="+ko.Context)
+	}
+	if ns := w.namespace; ns != "" {
+		args = append(args, "--namespace="+ns)
+	} else if ko.Namespace != "" {
+		args = append(args, "--namespace="+ko.Namespace)
+	}
+	return append(args, fmt.Sprintf("--timeout=%s", timeout))
+}
+
+// waitForRollouts waits up to timeout in total for the rollouts of the
+// workloads in the applied YAML stream to complete.
+func waitForRollouts(ctx context.Context, applied []byte, ko *options.KubectlOptions, timeout time.Duration) error {
+	ws, err := workloads(bytes.NewReader(applied))
+	if err != nil {
+		return fmt.Errorf("finding workloads to wait for: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	deadline, _ := ctx.Deadline()
+	for _, w := range ws {
+		remaining := time.Until(deadline).Round(time.Second)
+		if remaining <= 0 {
+			return fmt.Errorf("timed out after %s waiting for the rollout of %s", timeout, w)
+		}
+		kubectlCmd := exec.CommandContext(ctx, "kubectl", rolloutStatusArgs(w, ko, remaining)...)
+		kubectlCmd.Env = os.Environ()
+		kubectlCmd.Stderr = os.Stderr
+		kubectlCmd.Stdout = os.Stdout
+		if err := kubectlCmd.Run(); err != nil {
+			return fmt.Errorf("waiting for the rollout of %s: %w", w, err)
+		}
+	}
+	return nil
+}
+
+// teeWriteCloser writes to Writer, and closes Closer.
+type teeWriteCloser struct {
+	io.Writer
+	io.Closer
+}
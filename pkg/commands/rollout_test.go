@@ -0,0 +1,83 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/ko/pkg/commands/options"
+)
+
+func TestWorkloads(t *testing.T) {
+	applied := `---
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: prod
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+---
+# Only a comment.
+---
+apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: db
+---
+apiVersion: apps/v1
+kind: DaemonSet
+metadata:
+  name: agent
+`
+	got, err := workloads(strings.NewReader(applied))
+	if err != nil {
+		t.Fatalf("workloads() = %v", err)
+	}
+	want := []workload{
+		{kind: "Deployment", name: "web", namespace: "prod"},
+		{kind: "StatefulSet", name: "db"},
+		{kind: "DaemonSet", name: "agent"},
+	}
+	if diff := cmp.Diff(want, got, cmp.AllowUnexported(workload{})); diff != "" {
+		t.Errorf("workloads() (-want +got) = %s", diff)
+	}
+}
+
+func TestRolloutStatusArgs(t *testing.T) {
+	ko := &options.KubectlOptions{Context: "staging", Namespace: "foo"}
+	for _, tc := range []struct {
+		w    workload
+		want []string
+	}{{
+		w:    workload{kind: "Deployment", name: "web"},
+		want: []string{"rollout", "status", "deployment/web", "--context=staging", "--namespace=foo", "--timeout=1m0s"},
+	}, {
+		w:    workload{kind: "StatefulSet", name: "db", namespace: "prod"},
+		want: []string{"rollout", "status", "statefulset/db", "--context=staging", "--namespace=prod", "--timeout=1m0s"},
+	}} {
+		got := rolloutStatusArgs(tc.w, ko, time.Minute)
+		if diff := cmp.Diff(tc.want, got); diff != "" {
+			t.Errorf("rolloutStatusArgs(%s) (-want +got) = %s", tc.w, diff)
+		}
+	}
+}
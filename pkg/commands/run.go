@@ -76,7 +76,7 @@ func addRun(topLevel *cobra.Command) {
 			if err != nil {
 				return fmt.Errorf("error creating builder: %w", err)
 			}
-			publisher, err := makePublisher(po)
+			publisher, err := makePublisher(po, bo.WorkingDirectory)
 			if err != nil {
 				return fmt.Errorf("error creating publisher: %w", err)
 			}
@@ -89,7 +89,7 @@ func addRun(topLevel *cobra.Command) {
 			if strings.HasPrefix(ip, "-") {
 				return fmt.Errorf("expected first arg to be positional, got %q", ip)
 			}
-			imgs, err := publishImages(ctx, importPaths, publisher, builder)
+			imgs, err := publishImages(ctx, withStrictScheme(importPaths, bo.Scheme), publisher, builder, po.PushConcurrency)
 			if err != nil {
 				return fmt.Errorf("failed to publish images: %w", err)
 			}
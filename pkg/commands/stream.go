@@ -0,0 +1,149 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/google/ko/pkg/build"
+	"github.com/google/ko/pkg/commands/options"
+	"github.com/google/ko/pkg/publish"
+)
+
+// streamReadAhead is how many documents of a stream are resolved ahead of
+// the one being written, so their images are built in parallel without
+// reading the whole stream.
+const streamReadAhead = 16
+
+// resolveStream resolves the references in each document of the YAML stream
+// r, sending each one on out as soon as it and the ones before it are done.
+func resolveStream(
+	ctx context.Context,
+	r io.Reader,
+	builder build.Interface,
+	pub publish.Interface,
+	so *options.SelectorOptions,
+	scheme string,
+	out chan<- []byte) error {
+	selector, err := parseSelector(so)
+	if err != nil {
+		return err
+	}
+
+	opts := resolveOptions(so, scheme)
+
+	errs, ctx := errgroup.WithContext(ctx)
+	queue := make(chan chan []byte, streamReadAhead)
+
+	// Read the documents and start resolving each of them.
+	errs.Go(func() error {
+		defer close(queue)
+		docs := &yamlDocuments{r: bufio.NewReader(r)}
+		for i := 0; ; i++ {
+			doc, err := docs.next()
+			if errors.Is(err, io.EOF) {
+				return nil
+			} else if err != nil {
+				return fmt.Errorf("reading document %d: %w", i, err)
+			}
+
+			ch := make(chan []byte, 1)
+			select {
+			case queue <- ch:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			i := i
+			errs.Go(func() error {
+				defer close(ch)
+				b, err := resolveBytes(ctx, "-", doc, builder, pub, selector, opts...)
+				if err != nil {
+					return fmt.Errorf("document %d: %w", i, err)
+				}
+				ch <- b
+				return nil
+			})
+		}
+	})
+
+	// Write them in order, as they are done.
+	for ch := range queue {
+		b, ok := <-ch
+		if !ok || len(bytes.TrimSpace(b)) == 0 {
+			continue
+		}
+		select {
+		case out <- bytes.TrimRight(b, "\n"):
+		case <-ctx.Done():
+		}
+	}
+	return errs.Wait()
+}
+
+// yamlDocuments splits a YAML stream into its documents, at the lines that
+// start with "---", like kubectl does.
+type yamlDocuments struct {
+	r   *bufio.Reader
+	eof bool
+	// rest holds what followed the last separator, e.g. a comment, which
+	// belongs to the next document.
+	rest []byte
+}
+
+// next returns the next document, or io.EOF once there are none left.
+func (d *yamlDocuments) next() ([]byte, error) {
+	var doc bytes.Buffer
+	doc.Write(d.rest)
+	d.rest = nil
+	for !d.eof {
+		line, err := d.r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			d.eof = true
+		} else if err != nil {
+			return nil, err
+		}
+
+		if isSeparator(line) {
+			rest := bytes.TrimLeft(line[len("---"):], " \t\r\n")
+			if len(bytes.TrimSpace(doc.Bytes())) > 0 {
+				d.rest = rest
+				return doc.Bytes(), nil
+			}
+			doc.Reset()
+			line = rest
+		}
+		doc.Write(line)
+	}
+	if len(bytes.TrimSpace(doc.Bytes())) == 0 {
+		return nil, io.EOF
+	}
+	return doc.Bytes(), nil
+}
+
+// isSeparator returns whether line separates two YAML documents.
+func isSeparator(line []byte) bool {
+	if !bytes.HasPrefix(line, []byte("---")) {
+		return false
+	}
+	rest := line[len("---"):]
+	return len(rest) == 0 || rest[0] == ' ' || rest[0] == '\t' || rest[0] == '\r' || rest[0] == '\n'
+}
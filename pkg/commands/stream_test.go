@@ -0,0 +1,119 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/ko/pkg/build"
+	"github.com/google/ko/pkg/commands/options"
+	kotesting "github.com/google/ko/pkg/internal/testing"
+)
+
+func TestResolveStream(t *testing.T) {
+	base := mustRepository("gcr.io/stream")
+	pr, pw := io.Pipe()
+	out := make(chan []byte)
+	done := make(chan error, 1)
+	go func() {
+		done <- resolveStream(context.Background(), pr, testBuilder, kotesting.NewFixedPublish(base, testHashes), &options.SelectorOptions{ResolveAll: true}, build.StrictScheme, out)
+		close(out)
+	}()
+
+	next := func() string {
+		t.Helper()
+		select {
+		case b := <-out:
+			return string(b)
+		case <-time.After(10 * time.Second):
+			t.Fatal("timed out waiting for a document")
+			return ""
+		}
+	}
+
+	// The first document is written as soon as the next one starts,
+	// before the stream ends.
+	fmt.Fprintf(pw, "# foo\nimage: %s%s\n---\n", build.StrictScheme, fooRef)
+	if diff := cmp.Diff(fmt.Sprintf("# foo\nimage: %s", kotesting.ComputeDigest(base, fooRef, fooHash)), next()); diff != "" {
+		t.Errorf("first document (-want +got) = %v", diff)
+	}
+
+	fmt.Fprintf(pw, "--- # bar\nimage: %s%s\n", build.StrictScheme, barRef)
+	pw.Close()
+	if diff := cmp.Diff(fmt.Sprintf("# bar\nimage: %s", kotesting.ComputeDigest(base, barRef, barHash)), next()); diff != "" {
+		t.Errorf("second document (-want +got) = %v", diff)
+	}
+
+	if err := <-done; err != nil {
+		t.Errorf("resolveStream() = %v", err)
+	}
+	if b, ok := <-out; ok {
+		t.Errorf("resolveStream() wrote %q, wanted nothing more", b)
+	}
+}
+
+func TestResolveStreamError(t *testing.T) {
+	base := mustRepository("gcr.io/stream")
+	input := fmt.Sprintf("image: %s%s\n---\nimage: %sexample.com/unknown\n", build.StrictScheme, fooRef, build.StrictScheme)
+	out := make(chan []byte, 2)
+	err := resolveStream(context.Background(), strings.NewReader(input), testBuilder, kotesting.NewFixedPublish(base, testHashes), &options.SelectorOptions{ResolveAll: true}, build.StrictScheme, out)
+	if err == nil || !strings.Contains(err.Error(), "document 1:") {
+		t.Errorf("resolveStream() = %v, wanted an error naming document 1", err)
+	}
+}
+
+func TestYAMLDocuments(t *testing.T) {
+	for _, tc := range []struct {
+		input string
+		want  []string
+	}{{
+		input: "a: 1\n",
+		want:  []string{"a: 1\n"},
+	}, {
+		input: "---\na: 1\n---\nb: 2\n---\n",
+		want:  []string{"a: 1\n", "b: 2\n"},
+	}, {
+		input: "a: 1\n--- # two\nb: 2",
+		want:  []string{"a: 1\n", "# two\nb: 2"},
+	}, {
+		input: "a: |\n  ----\n---\n\n---\nb: 2\r\n",
+		want:  []string{"a: |\n  ----\n", "b: 2\r\n"},
+	}, {
+		input: "",
+	}} {
+		docs := &yamlDocuments{r: bufio.NewReader(strings.NewReader(tc.input))}
+		var got []string
+		for {
+			doc, err := docs.next()
+			if errors.Is(err, io.EOF) {
+				break
+			} else if err != nil {
+				t.Fatalf("next() = %v", err)
+			}
+			got = append(got, string(doc))
+		}
+		if diff := cmp.Diff(tc.want, got); diff != "" {
+			t.Errorf("documents of %q (-want +got) = %v", tc.input, diff)
+		}
+	}
+}
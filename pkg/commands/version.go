@@ -15,7 +15,9 @@
 package commands
 
 import (
+	"encoding/json"
 	"fmt"
+	"runtime"
 	"runtime/debug"
 
 	"github.com/spf13/cobra"
@@ -24,20 +26,54 @@ import (
 // Version is provided by govvv at compile-time
 var Version string
 
+// GitCommit and BuildDate are provided at compile-time like Version, or read
+// from the build information otherwise.
+var (
+	GitCommit string
+	BuildDate string
+)
+
+// versionInfo is the output of ko version --json.
+type versionInfo struct {
+	Version   string `json:"version"`
+	GoVersion string `json:"goVersion"`
+	GitCommit string `json:"gitCommit"`
+	BuildDate string `json:"buildDate"`
+}
+
 // addVersion augments our CLI surface with version.
 func addVersion(topLevel *cobra.Command) {
-	topLevel.AddCommand(&cobra.Command{
+	var jsonOutput bool
+	cmd := &cobra.Command{
 		Use:   "version",
 		Short: `Print ko version.`,
-		Run: func(cmd *cobra.Command, args []string) {
+		RunE: func(cmd *cobra.Command, args []string) error {
 			v := version()
+			if jsonOutput {
+				commit, date := GitCommit, BuildDate
+				if commit == "" && date == "" {
+					commit, date = vcsInfo()
+				}
+				enc := json.NewEncoder(cmd.OutOrStdout())
+				enc.SetIndent("", "  ")
+				return enc.Encode(versionInfo{
+					Version:   v,
+					GoVersion: runtime.Version(),
+					GitCommit: commit,
+					BuildDate: date,
+				})
+			}
 			if v == "" {
-				fmt.Println("could not determine build information")
+				fmt.Fprintln(cmd.OutOrStdout(), "could not determine build information")
 			} else {
-				fmt.Println(v)
+				fmt.Fprintln(cmd.OutOrStdout(), v)
 			}
+			return nil
 		},
-	})
+	}
+	cmd.Flags().BoolVar(&jsonOutput, "json", false,
+		"Print the version, Go version, git commit and build date as a JSON object.")
+	topLevel.AddCommand(cmd)
 }
 
 func version() string {
@@ -0,0 +1,76 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"runtime"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func TestVersionJSON(t *testing.T) {
+	oldVersion, oldCommit, oldDate := Version, GitCommit, BuildDate
+	defer func() { Version, GitCommit, BuildDate = oldVersion, oldCommit, oldDate }()
+	Version, GitCommit, BuildDate = "v1.2.3", "0123456789abcdef", "2022-10-01T12:00:00Z"
+
+	root := &cobra.Command{Use: "ko"}
+	addVersion(root)
+	var out bytes.Buffer
+	root.SetOut(&out)
+	root.SetArgs([]string{"version", "--json"})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("ko version --json = %v", err)
+	}
+
+	var got map[string]string
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatalf("ko version --json printed %q, which isn't a JSON object: %v", out.String(), err)
+	}
+	want := map[string]string{
+		"version":   "v1.2.3",
+		"goVersion": runtime.Version(),
+		"gitCommit": "0123456789abcdef",
+		"buildDate": "2022-10-01T12:00:00Z",
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("ko version --json printed %s %q, want %q", k, got[k], v)
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("ko version --json printed %v, wanted only %v", got, want)
+	}
+}
+
+func TestVersionText(t *testing.T) {
+	oldVersion := Version
+	defer func() { Version = oldVersion }()
+	Version = "v1.2.3"
+
+	root := &cobra.Command{Use: "ko"}
+	addVersion(root)
+	var out bytes.Buffer
+	root.SetOut(&out)
+	root.SetArgs([]string{"version"})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("ko version = %v", err)
+	}
+	if got, want := out.String(), "v1.2.3\n"; got != want {
+		t.Errorf("ko version printed %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,267 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/google/ko/pkg/build"
+	"github.com/google/ko/pkg/commands/options"
+	"golang.org/x/tools/go/packages"
+)
+
+// watchDebounce is how long to wait for more changes after a file changes,
+// before rebuilding, so that e.g. saving several files rebuilds once.
+const watchDebounce = 500 * time.Millisecond
+
+// sourceWatcher watches the files passed to resolveFilesToWriter, and the
+// sources of the import paths they reference, to resolve them again when
+// they change.
+type sourceWatcher struct {
+	watcher *fsnotify.Watcher
+	builder *build.Caching
+	// bo locates the directory and environment each import path is built
+	// in, to find its sources the way the build does.
+	bo *options.BuildOptions
+
+	m sync.Mutex
+	// importPaths are the import paths built for each file.
+	importPaths map[string][]string
+	// sources are the directories of the packages each import path is built
+	// from, and of its kodata, or nil if they're unknown.
+	sources map[string][]string
+	// watched are the directories being watched.
+	watched map[string]bool
+}
+
+func newSourceWatcher(builder *build.Caching, bo *options.BuildOptions) (*sourceWatcher, error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	return &sourceWatcher{
+		watcher:     w,
+		builder:     builder,
+		bo:          bo,
+		importPaths: make(map[string][]string),
+		sources:     make(map[string][]string),
+		watched:     make(map[string]bool),
+	}, nil
+}
+
+// Close stops watching.
+func (sw *sourceWatcher) Close() error {
+	return sw.watcher.Close()
+}
+
+// record records that the file f references importPaths, and watches their
+// sources.
+func (sw *sourceWatcher) record(ctx context.Context, f string, importPaths []string) {
+	sw.m.Lock()
+	defer sw.m.Unlock()
+	sw.importPaths[f] = importPaths
+	sw.watch(filepath.Dir(f))
+	for _, ip := range importPaths {
+		if _, ok := sw.sources[ip]; ok {
+			continue
+		}
+		dir, env := buildDirEnv(sw.bo, ip)
+		dirs, err := sourceDirs(ctx, ip, dir, env)
+		if err != nil {
+			log.Printf("Not watching the sources of %s: %v", ip, err)
+		}
+		sw.sources[ip] = dirs
+		for _, dir := range dirs {
+			sw.watch(dir)
+		}
+	}
+}
+
+// watch watches dir, unless it already is. sw.m must be held.
+func (sw *sourceWatcher) watch(dir string) {
+	dir = filepath.Clean(dir)
+	if sw.watched[dir] {
+		return
+	}
+	if err := sw.watcher.Add(dir); err != nil {
+		log.Printf("Not watching %s: %v", dir, err)
+		return
+	}
+	sw.watched[dir] = true
+}
+
+// files sends the files from enumerated on the channel it returns, and then
+// the files affected by changes, until ctx is done.
+func (sw *sourceWatcher) files(ctx context.Context, enumerated <-chan string) chan string {
+	files := make(chan string)
+	go func() {
+		defer close(files)
+		send := func(f string) bool {
+			select {
+			case files <- f:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		for f := range enumerated {
+			if !send(f) {
+				return
+			}
+		}
+
+		changed := make(map[string]bool)
+		var debounce <-chan time.Time
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-sw.watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Op == fsnotify.Chmod {
+					continue
+				}
+				changed[filepath.Clean(event.Name)] = true
+				debounce = time.After(watchDebounce)
+			case err, ok := <-sw.watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Printf("Error watching files: %v", err)
+			case <-debounce:
+				for _, f := range sw.affected(changed) {
+					log.Printf("Resolving %s again", f)
+					if !send(f) {
+						return
+					}
+				}
+				changed = make(map[string]bool)
+			}
+		}
+	}()
+	return files
+}
+
+// affected invalidates the builds of the import paths whose sources are in
+// changed, and returns the files that have changed or reference them.
+func (sw *sourceWatcher) affected(changed map[string]bool) []string {
+	sw.m.Lock()
+	defer sw.m.Unlock()
+
+	stale := make(map[string]bool)
+	for ip, dirs := range sw.sources {
+		for path := range changed {
+			if isSource(path, dirs) {
+				stale[ip] = true
+				sw.builder.Invalidate(ip)
+				// Watch the sources again, e.g. for new imports.
+				delete(sw.sources, ip)
+				break
+			}
+		}
+	}
+
+	var files []string
+	for f, ips := range sw.importPaths {
+		affected := changed[filepath.Clean(f)]
+		for _, ip := range ips {
+			affected = affected || stale[ip]
+		}
+		if affected {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+// isSource returns whether the file at path is one of the sources in dirs:
+// a file in one of them, or anything below a kodata directory.
+func isSource(path string, dirs []string) bool {
+	for _, dir := range dirs {
+		if filepath.Dir(path) == dir {
+			return true
+		}
+		if filepath.Base(dir) == "kodata" && strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// buildDirEnv returns the directory and environment that the import path ip
+// is built in: the working directory, or the dir of its build config, and
+// the environment with the env of its build config.
+func buildDirEnv(bo *options.BuildOptions, ip string) (string, []string) {
+	dir, env := bo.WorkingDirectory, os.Environ()
+	if bc, ok := bo.BuildConfigs[strings.TrimPrefix(ip, build.StrictScheme)]; ok {
+		dir = filepath.Join(dir, bc.Dir)
+		env = append(env, bc.Env...)
+	}
+	return dir, env
+}
+
+// sourceDirs returns the directories of the packages in the main module
+// that the import path ip is built from in dir with env, including their
+// go.mod's, and of the files in its kodata directory.
+func sourceDirs(ctx context.Context, ip string, dir string, env []string) ([]string, error) {
+	pkgs, err := packages.Load(&packages.Config{
+		Context: ctx,
+		Mode:    packages.NeedName | packages.NeedFiles | packages.NeedImports | packages.NeedDeps | packages.NeedModule,
+		Dir:     dir,
+		Env:     env,
+	}, strings.TrimPrefix(ip, build.StrictScheme))
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var dirs []string
+	add := func(dir string) {
+		if !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	packages.Visit(pkgs, nil, func(pkg *packages.Package) {
+		if pkg.Module == nil || !pkg.Module.Main || len(pkg.GoFiles) == 0 {
+			return
+		}
+		add(filepath.Dir(pkg.GoFiles[0]))
+		add(pkg.Module.Dir)
+	})
+
+	for _, pkg := range pkgs {
+		if len(pkg.GoFiles) == 0 {
+			continue
+		}
+		kodata := filepath.Join(filepath.Dir(pkg.GoFiles[0]), "kodata")
+		filepath.Walk(kodata, func(path string, info os.FileInfo, err error) error {
+			if err == nil && info.IsDir() {
+				add(path)
+			}
+			return nil
+		})
+	}
+	return dirs, nil
+}
@@ -0,0 +1,204 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/ko/pkg/build"
+	"github.com/google/ko/pkg/commands/options"
+)
+
+// countingBuilder counts the builds of each import path.
+type countingBuilder struct {
+	builds map[string]int
+}
+
+func (b *countingBuilder) QualifyImport(ip string) (string, error) { return ip, nil }
+
+func (b *countingBuilder) IsSupportedReference(string) error { return nil }
+
+func (b *countingBuilder) Build(_ context.Context, ip string) (build.Result, error) {
+	b.builds[ip]++
+	return random.Image(1024, 1)
+}
+
+func TestIsSource(t *testing.T) {
+	pkg := filepath.Join("src", "cmd", "app")
+	kodata := filepath.Join(pkg, "kodata")
+	dirs := []string{pkg, kodata}
+	for _, tc := range []struct {
+		path string
+		want bool
+	}{
+		{filepath.Join(pkg, "main.go"), true},
+		{filepath.Join(kodata, "index.html"), true},
+		{filepath.Join(kodata, "new", "dir", "file.txt"), true},
+		{filepath.Join(pkg, "sub", "sub.go"), false},
+		{filepath.Join("src", "other.go"), false},
+		{filepath.Join("src", "cmd", "app2", "main.go"), false},
+	} {
+		if got := isSource(tc.path, dirs); got != tc.want {
+			t.Errorf("isSource(%q) = %t, want %t", tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestAffected(t *testing.T) {
+	ctx := context.Background()
+	counter := &countingBuilder{builds: make(map[string]int)}
+	builder, err := build.NewCaching(counter)
+	if err != nil {
+		t.Fatalf("NewCaching() = %v", err)
+	}
+	sw, err := newSourceWatcher(builder, &options.BuildOptions{})
+	if err != nil {
+		t.Fatalf("newSourceWatcher() = %v", err)
+	}
+	defer sw.Close()
+
+	foo, bar := "ko://example.com/foo", "ko://example.com/bar"
+	for _, ip := range []string{foo, bar} {
+		if _, err := builder.Build(ctx, ip); err != nil {
+			t.Fatalf("Build(%s) = %v", ip, err)
+		}
+	}
+	sw.sources[foo] = []string{filepath.Join("src", "foo")}
+	sw.sources[bar] = []string{filepath.Join("src", "bar")}
+	sw.importPaths[filepath.Join("config", "foo.yaml")] = []string{foo}
+	sw.importPaths[filepath.Join("config", "both.yaml")] = []string{foo, bar}
+	sw.importPaths[filepath.Join("config", "none.yaml")] = nil
+
+	got := sw.affected(map[string]bool{
+		filepath.Join("src", "foo", "main.go"):   true,
+		filepath.Join("config", "none.yaml"):     true,
+		filepath.Join("src", "other", "main.go"): true,
+	})
+	sort.Strings(got)
+	want := []string{
+		filepath.Join("config", "both.yaml"),
+		filepath.Join("config", "foo.yaml"),
+		filepath.Join("config", "none.yaml"),
+	}
+	if len(got) != len(want) {
+		t.Fatalf("affected() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("affected()[%d] = %s, want %s", i, got[i], want[i])
+		}
+	}
+
+	// Only foo's build is invalidated, and its sources are looked up again.
+	if _, ok := sw.sources[foo]; ok {
+		t.Errorf("sources[%s] not forgotten", foo)
+	}
+	if _, ok := sw.sources[bar]; !ok {
+		t.Errorf("sources[%s] forgotten", bar)
+	}
+	for _, ip := range []string{foo, bar} {
+		if _, err := builder.Build(ctx, ip); err != nil {
+			t.Fatalf("Build(%s) = %v", ip, err)
+		}
+	}
+	if counter.builds[foo] != 2 || counter.builds[bar] != 1 {
+		t.Errorf("builds = %v, want foo rebuilt once", counter.builds)
+	}
+}
+
+func TestWatchFiles(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	builder, err := build.NewCaching(&countingBuilder{builds: make(map[string]int)})
+	if err != nil {
+		t.Fatalf("NewCaching() = %v", err)
+	}
+	sw, err := newSourceWatcher(builder, &options.BuildOptions{})
+	if err != nil {
+		t.Fatalf("newSourceWatcher() = %v", err)
+	}
+	defer sw.Close()
+
+	f := filepath.Join(t.TempDir(), "config.yaml")
+	if err := ioutil.WriteFile(f, []byte("a: b\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	enumerated := make(chan string, 1)
+	enumerated <- f
+	close(enumerated)
+	files := sw.files(ctx, enumerated)
+
+	if got := <-files; got != f {
+		t.Fatalf("files() = %s, want %s", got, f)
+	}
+	sw.record(ctx, f, nil)
+
+	if err := ioutil.WriteFile(f, []byte("a: c\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-files:
+		if got != f {
+			t.Errorf("files() = %s, want %s", got, f)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the change")
+	}
+
+	cancel()
+	if _, ok := <-files; ok {
+		t.Error("files() not closed after cancelling")
+	}
+}
+
+func TestSourceDirsBuildConfig(t *testing.T) {
+	ip := build.StrictScheme + "example.com/testapp/cmd/foo"
+	bo := &options.BuildOptions{
+		WorkingDirectory: filepath.Join("options", "testdata", "paths"),
+		BuildConfigs: map[string]build.Config{
+			// The module has no vendor directory, unlike ko's.
+			"example.com/testapp/cmd/foo": {Dir: "app", Env: []string{"GOFLAGS=-mod=mod"}},
+		},
+	}
+	dir, env := buildDirEnv(bo, ip)
+	if want := filepath.Join("options", "testdata", "paths", "app"); dir != want {
+		t.Errorf("buildDirEnv() dir = %s, want %s", dir, want)
+	}
+
+	// The package is only found in the build config's dir and env.
+	dirs, err := sourceDirs(context.Background(), ip, dir, env)
+	if err != nil {
+		t.Fatalf("sourceDirs() = %v", err)
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{filepath.Join(abs, "cmd", "foo"), abs}
+	if len(dirs) != len(want) {
+		t.Fatalf("sourceDirs() = %v, want %v", dirs, want)
+	}
+	for i := range want {
+		if dirs[i] != want[i] {
+			t.Errorf("sourceDirs()[%d] = %s, want %s", i, dirs[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/sigstore/cosign/pkg/oci"
+
+	"github.com/google/ko/pkg/build"
+)
+
+// annotator wraps a publisher implementation in a layer that adds annotations
+// to the image indexes that it publishes.
+type annotator struct {
+	inner       Interface
+	annotations map[string]string
+}
+
+// annotator implements Interface
+var _ Interface = (*annotator)(nil)
+
+// NewAnnotator wraps the provided publish.Interface in an implementation that
+// adds annotations to multi-platform image indexes before publishing them.
+// Single-platform images are published unchanged.
+func NewAnnotator(inner Interface, annotations map[string]string) (Interface, error) {
+	return &annotator{
+		inner:       inner,
+		annotations: annotations,
+	}, nil
+}
+
+// Publish implements Interface
+func (a *annotator) Publish(ctx context.Context, br build.Result, ref string) (name.Reference, error) {
+	mt, err := br.MediaType()
+	if err != nil {
+		return nil, err
+	}
+	switch mt {
+	case types.OCIImageIndex, types.DockerManifestList:
+		idx, ok := br.(v1.ImageIndex)
+		if !ok {
+			return nil, fmt.Errorf("failed to interpret result as index: %v", br)
+		}
+		br = annotateIndex(idx, a.annotations)
+	}
+	return a.inner.Publish(ctx, br, ref)
+}
+
+// Close implements Interface
+func (a *annotator) Close() error {
+	return a.inner.Close()
+}
+
+func annotateIndex(idx v1.ImageIndex, annotations map[string]string) build.Result {
+	annotated := mutate.Annotations(idx, annotations).(v1.ImageIndex)
+	sii, ok := idx.(oci.SignedImageIndex)
+	if !ok {
+		return annotated
+	}
+	// Keep the signatures, attestations and attachments (e.g. the SBOM) of
+	// the original index, as well as its signed images.
+	return &annotatedIndex{
+		signedImageIndex: sii,
+		annotated:        annotated,
+	}
+}
+
+// signedImageIndex lets annotatedIndex embed an oci.SignedImageIndex without
+// the embedded field shadowing its SignedImageIndex method.
+type signedImageIndex interface {
+	oci.SignedImageIndex
+}
+
+// annotatedIndex is an oci.SignedImageIndex with its manifest replaced by an
+// annotated one.
+type annotatedIndex struct {
+	signedImageIndex
+	annotated v1.ImageIndex
+}
+
+// annotatedIndex implements oci.SignedImageIndex
+var _ oci.SignedImageIndex = (*annotatedIndex)(nil)
+
+// Digest implements v1.ImageIndex
+func (a *annotatedIndex) Digest() (v1.Hash, error) {
+	return a.annotated.Digest()
+}
+
+// Size implements v1.ImageIndex
+func (a *annotatedIndex) Size() (int64, error) {
+	return a.annotated.Size()
+}
+
+// IndexManifest implements v1.ImageIndex
+func (a *annotatedIndex) IndexManifest() (*v1.IndexManifest, error) {
+	return a.annotated.IndexManifest()
+}
+
+// RawManifest implements v1.ImageIndex
+func (a *annotatedIndex) RawManifest() ([]byte, error) {
+	return a.annotated.RawManifest()
+}
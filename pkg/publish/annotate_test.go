@@ -0,0 +1,114 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish_test
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/ko/pkg/build"
+	"github.com/google/ko/pkg/publish"
+	ocimutate "github.com/sigstore/cosign/pkg/oci/mutate"
+	"github.com/sigstore/cosign/pkg/oci/signed"
+	"github.com/sigstore/cosign/pkg/oci/static"
+)
+
+func TestAnnotator(t *testing.T) {
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("url.Parse(%v) = %v", server.URL, err)
+	}
+
+	f, err := static.NewFile([]byte("da bom"))
+	if err != nil {
+		t.Fatalf("static.NewFile() = %v", err)
+	}
+	sii, err := ocimutate.AttachFileToImageIndex(signed.ImageIndex(idx), "sbom", f)
+	if err != nil {
+		t.Fatalf("ocimutate.AttachFileToImageIndex() = %v", err)
+	}
+
+	def, err := publish.NewDefault(fmt.Sprintf("%s/blah", u.Host))
+	if err != nil {
+		t.Fatalf("NewDefault() = %v", err)
+	}
+	want := map[string]string{
+		"org.opencontainers.image.source":   "https://github.com/google/ko",
+		"org.opencontainers.image.revision": "0123456789abcdef",
+	}
+	pub, err := publish.NewAnnotator(def, want)
+	if err != nil {
+		t.Fatalf("NewAnnotator() = %v", err)
+	}
+
+	for _, br := range []build.Result{idx, sii} {
+		ref, err := pub.Publish(context.Background(), br, build.StrictScheme+"example.com/app")
+		if err != nil {
+			t.Fatalf("Publish() = %v", err)
+		}
+		got, err := remote.Index(ref)
+		if err != nil {
+			t.Fatalf("remote.Index() = %v", err)
+		}
+		im, err := got.IndexManifest()
+		if err != nil {
+			t.Fatalf("IndexManifest() = %v", err)
+		}
+		if diff := cmp.Diff(want, im.Annotations); diff != "" {
+			t.Errorf("Annotations (-want +got) = %s", diff)
+		}
+		// The images of the index are unchanged.
+		orig, err := idx.IndexManifest()
+		if err != nil {
+			t.Fatalf("IndexManifest() = %v", err)
+		}
+		if diff := cmp.Diff(orig.Manifests, im.Manifests); diff != "" {
+			t.Errorf("Manifests (-want +got) = %s", diff)
+		}
+	}
+
+	// The SBOM is published for the annotated index.
+	h, err := pub.Publish(context.Background(), sii, build.StrictScheme+"example.com/app")
+	if err != nil {
+		t.Fatalf("Publish() = %v", err)
+	}
+	dig := h.(*name.Digest)
+	sbom := dig.Context().Tag(fmt.Sprintf("sha256-%s.sbom", dig.DigestStr()[len("sha256:"):]))
+	if _, err := remote.Head(sbom); err != nil {
+		t.Errorf("remote.Head(%v) = %v", sbom, err)
+	}
+
+	// Single images are published as they are.
+	ref, err := pub.Publish(context.Background(), img, build.StrictScheme+"example.com/app")
+	if err != nil {
+		t.Fatalf("Publish() = %v", err)
+	}
+	wantDigest, err := img.Digest()
+	if err != nil {
+		t.Fatalf("Digest() = %v", err)
+	}
+	if got := ref.(*name.Digest).DigestStr(); got != wantDigest.String() {
+		t.Errorf("Publish() = %v, wanted digest %v", ref, wantDigest)
+	}
+}
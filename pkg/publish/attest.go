@@ -0,0 +1,62 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/sigstore/cosign/pkg/oci"
+	"github.com/sigstore/cosign/pkg/oci/mutate"
+	ociremote "github.com/sigstore/cosign/pkg/oci/remote"
+)
+
+// Attester returns the attestation to attach to the image or index built
+// from importpath, which was pushed as dig.
+type Attester func(ctx context.Context, importpath string, dig name.Digest) (oci.Signature, error)
+
+// attest attaches the attestations d.attesters make for the image or index
+// that was pushed as dig, and pushes them next to it, where cosign looks for
+// them.
+func (d *defalt) attest(ctx context.Context, importpath string, dig name.Digest, ro []remote.Option) error {
+	ociOpts, err := cosignOptions(ro)
+	if err != nil {
+		return err
+	}
+	// Fetch the entity from the registry, so we keep the attestations it
+	// may already have.
+	se, err := ociremote.SignedEntity(dig, ociOpts...)
+	if err != nil {
+		return err
+	}
+	for _, attester := range d.attesters {
+		att, err := attester(ctx, importpath, dig)
+		if err != nil {
+			return fmt.Errorf("attesting %v: %w", dig, err)
+		}
+		se, err = mutate.AttachAttestationToEntity(se, att)
+		if err != nil {
+			return err
+		}
+	}
+	log.Printf("Attesting %v", dig)
+	if err := ociremote.WriteAttestations(dig.Context(), se, ociOpts...); err != nil {
+		return fmt.Errorf("writing attestation: %w", err)
+	}
+	return nil
+}
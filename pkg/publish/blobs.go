@@ -0,0 +1,214 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/logs"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"golang.org/x/sync/errgroup"
+)
+
+// defaultLayerJobs is how many layers are uploaded at once without
+// WithPushConcurrency, as remote.Write does.
+const defaultLayerJobs = 4
+
+// pushedBlobs is the set of layers pushed to each repository by the pushes of
+// a publisher, so that the layers its images share, like those of their base
+// image, are only uploaded once per repository, even by concurrent pushes.
+// Registries keep blobs per repository, so pushing to another repository
+// uploads them again, unless mount is set: then the layer is mounted from the
+// first repository on the same registry it was pushed to.
+type pushedBlobs struct {
+	mount     bool
+	auth      authn.Authenticator
+	t         http.RoundTripper
+	userAgent string
+
+	mu      sync.Mutex
+	uploads map[string]*blobUpload
+	// sources are the first uploads of each layer to each registry.
+	sources map[string]*blobUpload
+}
+
+// blobUpload is a layer that's being, or has been, uploaded to repo. done is
+// closed once it has been.
+type blobUpload struct {
+	repo name.Repository
+	done chan struct{}
+	err  error
+}
+
+func newPushedBlobs(mount bool, auth authn.Authenticator, t http.RoundTripper, userAgent string) *pushedBlobs {
+	return &pushedBlobs{
+		mount:     mount,
+		auth:      auth,
+		t:         t,
+		userAgent: userAgent,
+		uploads:   map[string]*blobUpload{},
+		sources:   map[string]*blobUpload{},
+	}
+}
+
+// writeLayers uploads the layers of img to repo, skipping those that have
+// already been, and waiting for those that other pushes are uploading. The
+// remote.Write of img then finds them all in the registry. Like remote.Write,
+// it leaves out non-distributable layers, such as the foreign layers of
+// Windows base images.
+func (p *pushedBlobs) writeLayers(ctx context.Context, repo name.Repository, img v1.Image, opt []remote.Option, jobs int) error {
+	all, err := img.Layers()
+	if err != nil {
+		return err
+	}
+	var ls []v1.Layer
+	for _, l := range all {
+		mt, err := l.MediaType()
+		if err != nil {
+			return err
+		}
+		if mt.IsDistributable() {
+			ls = append(ls, l)
+		}
+	}
+	if len(ls) == 0 {
+		return nil
+	}
+	if jobs <= 0 {
+		jobs = defaultLayerJobs
+	}
+
+	// Authenticate once for all the layers, rather than once each.
+	t, err := p.transport(ctx, repo, ls)
+	if err != nil {
+		return err
+	}
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(jobs)
+	opt = append(opt[:len(opt):len(opt)], remote.WithContext(gctx), remote.WithTransport(t))
+	for _, l := range ls {
+		l := l
+		g.Go(func() error {
+			return p.writeLayer(gctx, repo, l, opt)
+		})
+	}
+	return g.Wait()
+}
+
+// transport returns a transport authenticated to push ls to repo, and to
+// pull those we'll mount from the repositories they're being pushed to. As
+// it's a transport.Wrapper, remote uses it as is.
+func (p *pushedBlobs) transport(ctx context.Context, repo name.Repository, ls []v1.Layer) (http.RoundTripper, error) {
+	scopes := []string{repo.Scope(transport.PushScope)}
+	if p.mount {
+		seen := map[string]bool{}
+		p.mu.Lock()
+		for _, l := range ls {
+			h, err := l.Digest()
+			if err != nil {
+				p.mu.Unlock()
+				return nil, err
+			}
+			if src := p.sources[repo.RegistryStr()+"@"+h.String()]; src != nil && src.repo != repo && !seen[src.repo.String()] {
+				seen[src.repo.String()] = true
+				scopes = append(scopes, src.repo.Scope(transport.PullScope))
+			}
+		}
+		p.mu.Unlock()
+	}
+
+	// Wrap it as remote would.
+	t := p.t
+	if logs.Enabled(logs.Debug) {
+		t = transport.NewLogger(t)
+	}
+	t = transport.NewRetry(t)
+	if p.userAgent != "" {
+		t = transport.NewUserAgent(t, p.userAgent)
+	}
+	return transport.NewWithContext(ctx, repo.Registry, p.auth, t, scopes)
+}
+
+func (p *pushedBlobs) writeLayer(ctx context.Context, repo name.Repository, l v1.Layer, opt []remote.Option) error {
+	h, err := l.Digest()
+	if err != nil {
+		return err
+	}
+	key := repo.String() + "@" + h.String()
+	sourceKey := repo.RegistryStr() + "@" + h.String()
+	for {
+		p.mu.Lock()
+		u, ok := p.uploads[key]
+		var src *blobUpload
+		if !ok {
+			u = &blobUpload{repo: repo, done: make(chan struct{})}
+			p.uploads[key] = u
+			if src = p.sources[sourceKey]; src == nil {
+				p.sources[sourceKey] = u
+			}
+		}
+		p.mu.Unlock()
+
+		if !ok {
+			u.err = p.upload(ctx, repo, h, l, src, opt)
+			if u.err != nil {
+				// Let the next push of the layer try again.
+				p.mu.Lock()
+				delete(p.uploads, key)
+				if p.sources[sourceKey] == u {
+					delete(p.sources, sourceKey)
+				}
+				p.mu.Unlock()
+			}
+			close(u.done)
+			return u.err
+		}
+
+		select {
+		case <-u.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if u.err == nil {
+			return nil
+		}
+		// The upload we waited for failed, so upload it ourselves.
+	}
+}
+
+// upload uploads l to repo, mounting it from the repository of src, if we
+// are to mount layers and src succeeds. Since src was started first, waiting
+// for it can't deadlock.
+func (p *pushedBlobs) upload(ctx context.Context, repo name.Repository, h v1.Hash, l v1.Layer, src *blobUpload, opt []remote.Option) error {
+	if p.mount && src != nil {
+		select {
+		case <-src.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if src.err == nil {
+			// remote.WriteLayer asks the registry to mount it from there, and
+			// uploads it anyway if the registry doesn't.
+			l = &remote.MountableLayer{Layer: l, Reference: src.repo.Digest(h.String())}
+		}
+	}
+	return remote.WriteLayer(repo, l, opt...)
+}
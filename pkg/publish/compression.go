@@ -0,0 +1,243 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/sigstore/cosign/pkg/oci"
+	ocimutate "github.com/sigstore/cosign/pkg/oci/mutate"
+	"github.com/sigstore/cosign/pkg/oci/signed"
+	"github.com/sigstore/cosign/pkg/oci/static"
+
+	"github.com/google/ko/pkg/build"
+)
+
+// isRejected returns whether err looks like the registry refusing the
+// contents of what was pushed (e.g. a media type it does not support).
+func isRejected(err error) bool {
+	var terr *transport.Error
+	if !errors.As(err, &terr) {
+		return false
+	}
+	switch terr.StatusCode {
+	case http.StatusBadRequest, http.StatusUnsupportedMediaType:
+		return true
+	}
+	return false
+}
+
+// gzipResult returns a copy of br with any zstd layers recompressed with
+// gzip, and whether there were any to recompress.
+func gzipResult(br build.Result) (build.Result, bool, error) {
+	mt, err := br.MediaType()
+	if err != nil {
+		return nil, false, err
+	}
+	switch mt {
+	case types.OCIImageIndex, types.DockerManifestList:
+		idx, ok := br.(v1.ImageIndex)
+		if !ok {
+			return nil, false, fmt.Errorf("failed to interpret result as index: %v", br)
+		}
+		return gzipIndex(idx)
+	case types.OCIManifestSchema1, types.DockerManifestSchema2:
+		img, ok := br.(v1.Image)
+		if !ok {
+			return nil, false, fmt.Errorf("failed to interpret result as image: %v", br)
+		}
+		return gzipImage(img)
+	default:
+		return nil, false, fmt.Errorf("result image media type: %s", mt)
+	}
+}
+
+func gzipIndex(idx v1.ImageIndex) (v1.ImageIndex, bool, error) {
+	mt, err := idx.MediaType()
+	if err != nil {
+		return nil, false, err
+	}
+	im, err := idx.IndexManifest()
+	if err != nil {
+		return nil, false, err
+	}
+
+	changed := false
+	// The SBOMs refer to images by digest, so track how those change.
+	digests := map[v1.Hash]v1.Hash{}
+	adds := make([]ocimutate.IndexAddendum, 0, len(im.Manifests))
+	for _, desc := range im.Manifests {
+		var img v1.Image
+		if sii, ok := idx.(oci.SignedImageIndex); ok {
+			img, err = sii.SignedImage(desc.Digest)
+		} else {
+			img, err = idx.Image(desc.Digest)
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		img, imgChanged, err := gzipImage(img)
+		if err != nil {
+			return nil, false, err
+		}
+		changed = changed || imgChanged
+		si, ok := img.(oci.SignedImage)
+		if !ok {
+			si = signed.Image(img)
+		}
+		if imgChanged {
+			if digests[desc.Digest], err = si.Digest(); err != nil {
+				return nil, false, err
+			}
+		}
+		adds = append(adds, ocimutate.IndexAddendum{
+			Add: si,
+			Descriptor: v1.Descriptor{
+				MediaType:   desc.MediaType,
+				URLs:        desc.URLs,
+				Annotations: desc.Annotations,
+				Platform:    desc.Platform,
+			},
+		})
+	}
+	if !changed {
+		return idx, false, nil
+	}
+
+	base := mutate.IndexMediaType(empty.Index, mt)
+	if len(im.Annotations) > 0 {
+		base = mutate.Annotations(base, im.Annotations).(v1.ImageIndex)
+	}
+	out := ocimutate.AppendManifests(base, adds...)
+
+	if sii, ok := idx.(oci.SignedImageIndex); ok {
+		if f, err := sii.Attachment("sbom"); err == nil {
+			oldDigest, err := idx.Digest()
+			if err != nil {
+				return nil, false, err
+			}
+			if digests[oldDigest], err = out.Digest(); err != nil {
+				return nil, false, err
+			}
+			if f, err = redigestFile(f, digests); err != nil {
+				return nil, false, err
+			}
+			if out, err = ocimutate.AttachFileToImageIndex(out, "sbom", f); err != nil {
+				return nil, false, err
+			}
+		}
+	}
+	return out, true, nil
+}
+
+// redigestFile returns a copy of f with every digest in digests replaced by
+// the digest it maps to, so that an SBOM describes the recompressed images.
+func redigestFile(f oci.File, digests map[v1.Hash]v1.Hash) (oci.File, error) {
+	b, err := f.Payload()
+	if err != nil {
+		return nil, err
+	}
+	mt, err := f.FileMediaType()
+	if err != nil {
+		return nil, err
+	}
+	// Replace just the hex, as SBOMs also write digests URL-encoded.
+	oldnew := make([]string, 0, 2*len(digests))
+	for o, n := range digests {
+		oldnew = append(oldnew, o.Hex, n.Hex)
+	}
+	b = []byte(strings.NewReplacer(oldnew...).Replace(string(b)))
+	return static.NewFile(b, static.WithLayerMediaType(mt))
+}
+
+func gzipImage(img v1.Image) (v1.Image, bool, error) {
+	m, err := img.Manifest()
+	if err != nil {
+		return nil, false, err
+	}
+	// Keep layers typed to match the manifest.
+	layerMediaType := types.OCILayer
+	if m.MediaType == types.DockerManifestSchema2 {
+		layerMediaType = types.DockerLayer
+	}
+
+	layers, err := img.Layers()
+	if err != nil {
+		return nil, false, err
+	}
+	changed := false
+	for i, desc := range m.Layers {
+		if desc.MediaType != build.OCILayerZstd {
+			continue
+		}
+		layers[i], err = tarball.LayerFromOpener(layers[i].Uncompressed, tarball.WithCompressedCaching, tarball.WithMediaType(layerMediaType))
+		if err != nil {
+			return nil, false, err
+		}
+		changed = true
+	}
+	if !changed {
+		return img, false, nil
+	}
+
+	cf, err := img.ConfigFile()
+	if err != nil {
+		return nil, false, err
+	}
+	base := mutate.ConfigMediaType(mutate.MediaType(empty.Image, m.MediaType), m.Config.MediaType)
+	out, err := mutate.AppendLayers(base, layers...)
+	if err != nil {
+		return nil, false, err
+	}
+	// The uncompressed layers are unchanged, so the config still applies.
+	out, err = mutate.ConfigFile(out, cf)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(m.Annotations) > 0 {
+		out = mutate.Annotations(out, m.Annotations).(v1.Image)
+	}
+
+	si := signed.Image(out)
+	if old, ok := img.(oci.SignedImage); ok {
+		if f, err := old.Attachment("sbom"); err == nil {
+			oldDigest, err := img.Digest()
+			if err != nil {
+				return nil, false, err
+			}
+			newDigest, err := si.Digest()
+			if err != nil {
+				return nil, false, err
+			}
+			if f, err = redigestFile(f, map[v1.Hash]v1.Hash{oldDigest: newDigest}); err != nil {
+				return nil, false, err
+			}
+			si, err = ocimutate.AttachFileToImage(si, "sbom", f)
+			if err != nil {
+				return nil, false, err
+			}
+		}
+	}
+	return si, true, nil
+}
@@ -0,0 +1,156 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/ko/pkg/build"
+	"github.com/google/ko/pkg/publish/containerd"
+)
+
+const (
+	// ContainerdDomain is a sentinel "registry" that represents side-loading images into containerd.
+	ContainerdDomain = "containerd.local"
+)
+
+type containerdPublisher struct {
+	client containerd.Client
+	namer  Namer
+	tags   []string
+}
+
+// ContainerdOption is a functional option for NewContainerdPublisher.
+type ContainerdOption func(*containerdPublisher) error
+
+// WithContainerdAddress is a functional option for overriding the containerd socket that images are loaded into.
+func WithContainerdAddress(address string) ContainerdOption {
+	return func(i *containerdPublisher) error {
+		i.client.Address = address
+		return nil
+	}
+}
+
+// WithContainerdNamespace is a functional option for overriding the containerd namespace that images are loaded into.
+func WithContainerdNamespace(namespace string) ContainerdOption {
+	return func(i *containerdPublisher) error {
+		i.client.Namespace = namespace
+		return nil
+	}
+}
+
+// NewContainerdPublisher returns a new publish.Interface that loads images into containerd.
+func NewContainerdPublisher(namer Namer, tags []string, opts ...ContainerdOption) (Interface, error) {
+	c := &containerdPublisher{
+		namer: namer,
+		tags:  tags,
+	}
+	for _, option := range opts {
+		if err := option(c); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Publish implements publish.Interface.
+func (c *containerdPublisher) Publish(ctx context.Context, br build.Result, s string) (name.Reference, error) {
+	s = strings.TrimPrefix(s, build.StrictScheme)
+	// https://github.com/google/go-containerregistry/issues/212
+	s = strings.ToLower(s)
+
+	// There's no way to import an index from a docker tarball, so attempt to downcast it to an image.
+	var img v1.Image
+	switch i := br.(type) {
+	case v1.Image:
+		img = i
+	case v1.ImageIndex:
+		im, err := i.IndexManifest()
+		if err != nil {
+			return nil, err
+		}
+		goos, goarch := os.Getenv("GOOS"), os.Getenv("GOARCH")
+		if goos == "" {
+			goos = "linux"
+		}
+		if goarch == "" {
+			goarch = "amd64"
+		}
+		for _, manifest := range im.Manifests {
+			if manifest.Platform == nil {
+				continue
+			}
+			if manifest.Platform.OS != goos {
+				continue
+			}
+			if manifest.Platform.Architecture != goarch {
+				continue
+			}
+			img, err = i.Image(manifest.Digest)
+			if err != nil {
+				return nil, err
+			}
+			break
+		}
+		if img == nil {
+			return nil, fmt.Errorf("failed to find %s/%s image in index for image: %v", goos, goarch, s)
+		}
+	default:
+		return nil, fmt.Errorf("failed to interpret %s result as image: %v", s, br)
+	}
+
+	h, err := img.Digest()
+	if err != nil {
+		return nil, err
+	}
+
+	// Tag the image with its digest, so that the reference can be resolved
+	// without a registry.
+	digestTag, err := name.NewTag(fmt.Sprintf("%s:%s", c.namer(ContainerdDomain, s), h.Hex))
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Loading %v", digestTag)
+	if err := c.client.Write(ctx, digestTag, img); err != nil {
+		return nil, err
+	}
+	log.Printf("Loaded %v", digestTag)
+
+	for _, tagName := range c.tags {
+		log.Printf("Adding tag %v", tagName)
+		tag, err := name.NewTag(fmt.Sprintf("%s:%s", c.namer(ContainerdDomain, s), tagName))
+		if err != nil {
+			return nil, err
+		}
+
+		if err := c.client.Tag(ctx, digestTag, tag); err != nil {
+			return nil, err
+		}
+		log.Printf("Added tag %v", tagName)
+	}
+
+	return &digestTag, nil
+}
+
+func (c *containerdPublisher) Close() error {
+	return nil
+}
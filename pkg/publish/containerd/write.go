@@ -0,0 +1,92 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package containerd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+)
+
+// Client identifies the containerd socket and namespace that images are
+// loaded into. The zero value uses the defaults of ctr.
+type Client struct {
+	// Address is the path to the containerd socket.
+	Address string
+	// Namespace is the containerd namespace, e.g. k8s.io for the images
+	// used by Kubernetes.
+	Namespace string
+}
+
+// RunCtr runs ctr with args and stdin, and returns its combined output.
+// It is a variable so we can override in tests.
+var RunCtr = func(ctx context.Context, stdin io.Reader, args ...string) ([]byte, error) {
+	var buf bytes.Buffer
+	cmd := exec.CommandContext(ctx, "ctr", args...)
+	cmd.Stdin = stdin
+	cmd.Stdout = &buf
+	cmd.Stderr = &buf
+	err := cmd.Run()
+	return buf.Bytes(), err
+}
+
+func (c Client) ctr(ctx context.Context, stdin io.Reader, args ...string) ([]byte, error) {
+	var flags []string
+	if c.Address != "" {
+		flags = append(flags, "--address="+c.Address)
+	}
+	if c.Namespace != "" {
+		flags = append(flags, "--namespace="+c.Namespace)
+	}
+	return RunCtr(ctx, stdin, append(flags, args...)...)
+}
+
+// Tag adds a tag to an already existent image.
+func (c Client) Tag(ctx context.Context, src, dest name.Tag) error {
+	if out, err := c.ctr(ctx, nil, "images", "tag", "--force", src.String(), dest.String()); err != nil {
+		return fmt.Errorf("failed to tag image: %w\n%s", err, out)
+	}
+	return nil
+}
+
+// Write imports the image into containerd as the given tag.
+func (c Client) Write(ctx context.Context, tag name.Tag, img v1.Image) error {
+	pr, pw := io.Pipe()
+
+	grp := errgroup.Group{}
+	grp.Go(func() error {
+		return pw.CloseWithError(tarball.Write(tag, img, pw))
+	})
+
+	out, err := c.ctr(ctx, pr, "images", "import", "-")
+	// Unblock the tarball writer if ctr stopped reading early.
+	pr.CloseWithError(io.ErrClosedPipe)
+	if err != nil {
+		return fmt.Errorf("failed to import image into containerd: %w\n%s", err, out)
+	}
+
+	if err := grp.Wait(); err != nil {
+		return fmt.Errorf("failed to write intermediate tarball representation: %w", err)
+	}
+	return nil
+}
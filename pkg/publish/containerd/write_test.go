@@ -0,0 +1,130 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package containerd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+)
+
+// fakeCtr replaces RunCtr, recording the commands that are run.
+func fakeCtr(t *testing.T, err error) *[]string {
+	var cmds []string
+	old := RunCtr
+	t.Cleanup(func() { RunCtr = old })
+	RunCtr = func(_ context.Context, stdin io.Reader, args ...string) ([]byte, error) {
+		cmds = append(cmds, strings.Join(append([]string{"ctr"}, args...), " "))
+		if err != nil {
+			return []byte("oops"), err
+		}
+		if stdin != nil {
+			// Check that a loadable tarball is streamed to ctr.
+			b, err := ioutil.ReadAll(stdin)
+			if err != nil {
+				t.Fatalf("ReadAll() = %v", err)
+			}
+			opener := func() (io.ReadCloser, error) { return ioutil.NopCloser(strings.NewReader(string(b))), nil }
+			if _, err := tarball.Image(opener, nil); err != nil {
+				t.Errorf("tarball.Image() = %v", err)
+			}
+		}
+		return nil, nil
+	}
+	return &cmds
+}
+
+func TestWrite(t *testing.T) {
+	img, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	tag, err := name.NewTag("containerd.local/test:new")
+	if err != nil {
+		t.Fatalf("name.NewTag() = %v", err)
+	}
+
+	for _, tc := range []struct {
+		client Client
+		want   string
+	}{{
+		want: "ctr images import -",
+	}, {
+		client: Client{Address: "/run/k3s/containerd/containerd.sock", Namespace: "k8s.io"},
+		want:   "ctr --address=/run/k3s/containerd/containerd.sock --namespace=k8s.io images import -",
+	}} {
+		cmds := fakeCtr(t, nil)
+		if err := tc.client.Write(context.Background(), tag, img); err != nil {
+			t.Fatalf("Write() = %v", err)
+		}
+		if got, want := *cmds, []string{tc.want}; strings.Join(got, "\n") != strings.Join(want, "\n") {
+			t.Errorf("commands = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestTag(t *testing.T) {
+	oldTag, err := name.NewTag("containerd.local/test:test")
+	if err != nil {
+		t.Fatalf("name.NewTag() = %v", err)
+	}
+	newTag, err := name.NewTag("containerd.local/test:new")
+	if err != nil {
+		t.Fatalf("name.NewTag() = %v", err)
+	}
+
+	cmds := fakeCtr(t, nil)
+	client := Client{Namespace: "k8s.io"}
+	if err := client.Tag(context.Background(), oldTag, newTag); err != nil {
+		t.Fatalf("Tag() = %v", err)
+	}
+	want := fmt.Sprintf("ctr --namespace=k8s.io images tag --force %s %s", oldTag, newTag)
+	if got := *cmds; len(got) != 1 || got[0] != want {
+		t.Errorf("commands = %v, want [%s]", got, want)
+	}
+}
+
+func TestFailCommands(t *testing.T) {
+	img, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	oldTag, err := name.NewTag("containerd.local/test:test")
+	if err != nil {
+		t.Fatalf("name.NewTag() = %v", err)
+	}
+	newTag, err := name.NewTag("containerd.local/test:new")
+	if err != nil {
+		t.Fatalf("name.NewTag() = %v", err)
+	}
+
+	errTest := errors.New("test")
+	fakeCtr(t, errTest)
+
+	if err := (Client{}).Write(context.Background(), newTag, img); !errors.Is(err, errTest) {
+		t.Errorf("Write() = %v, want %v", err, errTest)
+	}
+	if err := (Client{}).Tag(context.Background(), oldTag, newTag); !errors.Is(err, errTest) {
+		t.Errorf("Tag() = %v, want %v", err, errTest)
+	}
+}
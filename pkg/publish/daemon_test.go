@@ -18,12 +18,17 @@ package publish_test
 
 import (
 	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"strings"
 	"testing"
 
+	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-containerregistry/pkg/v1/random"
 	kotesting "github.com/google/ko/pkg/internal/testing"
 	"github.com/google/ko/pkg/publish"
+	"github.com/google/ko/pkg/publish/containerd"
 )
 
 func TestDaemon(t *testing.T) {
@@ -94,3 +99,49 @@ func TestDaemonDomain(t *testing.T) {
 		t.Errorf("Publish() = %v, wanted prefix %v", got, want)
 	}
 }
+
+func TestContainerd(t *testing.T) {
+	importpath := "github.com/google/ko"
+	img, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	h, err := img.Digest()
+	if err != nil {
+		t.Fatalf("Digest() = %v", err)
+	}
+
+	var cmds []string
+	oldRunCtr := containerd.RunCtr
+	defer func() { containerd.RunCtr = oldRunCtr }()
+	containerd.RunCtr = func(_ context.Context, stdin io.Reader, args ...string) ([]byte, error) {
+		cmds = append(cmds, strings.Join(args, " "))
+		if stdin != nil {
+			if _, err := io.Copy(ioutil.Discard, stdin); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	}
+
+	def, err := publish.NewContainerdPublisher(md5Hash, []string{"latest"}, publish.WithContainerdNamespace("k8s.io"))
+	if err != nil {
+		t.Fatalf("NewContainerdPublisher() = %v", err)
+	}
+
+	d, err := def.Publish(context.Background(), img, importpath)
+	if err != nil {
+		t.Fatalf("Publish() = %v", err)
+	}
+	// The reference is tagged with the digest, so it can be resolved offline.
+	if got, want := d.String(), md5Hash("containerd.local", importpath)+":"+h.Hex; got != want {
+		t.Errorf("Publish() = %v, want %v", got, want)
+	}
+	want := []string{
+		"--namespace=k8s.io images import -",
+		fmt.Sprintf("--namespace=k8s.io images tag --force %s %s", d, md5Hash("containerd.local", importpath)+":latest"),
+	}
+	if diff := cmp.Diff(want, cmds); diff != "" {
+		t.Errorf("ctr commands (-want +got) = %s", diff)
+	}
+}
@@ -16,6 +16,7 @@ package publish
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"log"
@@ -31,34 +32,55 @@ import (
 	"github.com/sigstore/cosign/pkg/oci"
 	ociremote "github.com/sigstore/cosign/pkg/oci/remote"
 	"github.com/sigstore/cosign/pkg/oci/walk"
+	"golang.org/x/sync/errgroup"
 
 	"github.com/google/ko/pkg/build"
 )
 
 // defalt is intentionally misspelled to avoid keyword collision (and drive Jon nuts).
 type defalt struct {
-	base      string
-	t         http.RoundTripper
-	userAgent string
-	auth      authn.Authenticator
-	namer     Namer
-	tags      []string
-	tagOnly   bool
-	insecure  bool
+	base            string
+	t               http.RoundTripper
+	userAgent       string
+	auth            authn.Authenticator
+	namer           Namer
+	tags            []string
+	tagOnly         bool
+	noTag           bool
+	insecure        bool
+	insecureHosts   insecureHosts
+	retry           *retryPolicy
+	jobs            int
+	createRepo      repoCreator
+	failIfTagExists bool
+	sbomReferrers   bool
+	signer          Signer
+	attesters       []Attester
+	blobs           *pushedBlobs
 }
 
 // Option is a functional option for NewDefault.
 type Option func(*defaultOpener) error
 
 type defaultOpener struct {
-	base      string
-	t         http.RoundTripper
-	userAgent string
-	auth      authn.Authenticator
-	namer     Namer
-	tags      []string
-	tagOnly   bool
-	insecure  bool
+	base            string
+	t               http.RoundTripper
+	userAgent       string
+	auth            authn.Authenticator
+	namer           Namer
+	tags            []string
+	tagOnly         bool
+	noTag           bool
+	insecure        bool
+	insecureHosts   insecureHosts
+	retry           *retryPolicy
+	jobs            int
+	createRepo      repoCreator
+	failIfTagExists bool
+	mountBlobs      bool
+	sbomReferrers   bool
+	signer          Signer
+	attesters       []Attester
 }
 
 // Namer is a function from a supported import path to the portion of the resulting
@@ -83,17 +105,47 @@ func (do *defaultOpener) Open() (Interface, error) {
 		if do.tags[0] == defaultTags[0] {
 			return nil, errors.New("latest tag cannot be used in tag-only references")
 		}
+		if do.noTag {
+			return nil, errors.New("images pushed without a tag cannot be resolved into tag-only references")
+		}
+	}
+
+	t := do.t
+	if ht, ok := t.(*http.Transport); ok && len(do.insecureHosts) > 0 {
+		insecure := ht.Clone()
+		if insecure.TLSClientConfig == nil {
+			insecure.TLSClientConfig = &tls.Config{} //nolint: gosec
+		}
+		insecure.TLSClientConfig.InsecureSkipVerify = true //nolint: gosec
+		t = &hostTransport{
+			hosts:    do.insecureHosts,
+			secure:   ht,
+			insecure: insecure,
+		}
+	}
+	if do.jobs > 0 {
+		t = newLimitedTransport(t, do.jobs)
 	}
 
 	return &defalt{
-		base:      do.base,
-		t:         do.t,
-		userAgent: do.userAgent,
-		auth:      do.auth,
-		namer:     do.namer,
-		tags:      do.tags,
-		tagOnly:   do.tagOnly,
-		insecure:  do.insecure,
+		base:            do.base,
+		t:               t,
+		userAgent:       do.userAgent,
+		auth:            do.auth,
+		namer:           do.namer,
+		tags:            do.tags,
+		tagOnly:         do.tagOnly,
+		noTag:           do.noTag,
+		insecure:        do.insecure,
+		retry:           do.retry,
+		jobs:            do.jobs,
+		createRepo:      do.createRepo,
+		insecureHosts:   do.insecureHosts,
+		failIfTagExists: do.failIfTagExists,
+		sbomReferrers:   do.sbomReferrers,
+		signer:          do.signer,
+		attesters:       do.attesters,
+		blobs:           newPushedBlobs(do.mountBlobs, do.auth, t, do.userAgent),
 	}, nil
 }
 
@@ -118,7 +170,7 @@ func NewDefault(base string, options ...Option) (Interface, error) {
 	return do.Open()
 }
 
-func pushResult(ctx context.Context, tag name.Tag, br build.Result, opt []remote.Option) error {
+func pushResult(ctx context.Context, ref name.Reference, br build.Result, opt []remote.Option, jobs int, rw *referrerWriter, blobs *pushedBlobs) error {
 	mt, err := br.MediaType()
 	if err != nil {
 		return err
@@ -135,6 +187,8 @@ func pushResult(ctx context.Context, tag name.Tag, br build.Result, opt []remote
 		}
 		if (targetRepoOverride != name.Repository{}) {
 			ociOpts = append(ociOpts, ociremote.WithTargetRepository(targetRepoOverride))
+			// Referrers have to be in the same repository as the image.
+			rw = nil
 		}
 		h, err := se.(interface{ Digest() (v1.Hash, error) }).Digest()
 		if err != nil {
@@ -142,7 +196,7 @@ func pushResult(ctx context.Context, tag name.Tag, br build.Result, opt []remote
 		}
 
 		// TODO(mattmoor): We should have a WriteSBOM helper upstream.
-		digest := tag.Context().Digest(h.String()) // Don't *get* the tag, we know the digest
+		digest := ref.Context().Digest(h.String()) // Don't *get* the tag, we know the digest
 		ref, err := ociremote.SBOMTag(digest, ociOpts...)
 		if err != nil {
 			return err
@@ -150,22 +204,10 @@ func pushResult(ctx context.Context, tag name.Tag, br build.Result, opt []remote
 		if f, err := se.Attachment("sbom"); err != nil {
 			// Some levels (e.g. the index) may not have an SBOM,
 			// just like some levels may not have signatures/attestations.
-		} else if err := remote.Write(ref, f, opt...); err != nil {
+		} else if err := writeSBOM(ctx, rw, ref, se, f, opt); err != nil {
 			return fmt.Errorf("writing sbom: %w", err)
-		} else {
-			log.Printf("Published SBOM %v", ref)
 		}
 
-		// TODO(mattmoor): Don't enable this until we start signing or it
-		// will publish empty signatures!
-		// if err := ociremote.WriteSignatures(tag.Context(), se, ociOpts...); err != nil {
-		// 	return err
-		// }
-
-		// TODO(mattmoor): Are there any attestations we want to write?
-		// if err := ociremote.WriteAttestations(tag.Context(), se, ociOpts...); err != nil {
-		// 	return err
-		// }
 		return nil
 	}
 
@@ -180,7 +222,12 @@ func pushResult(ctx context.Context, tag name.Tag, br build.Result, opt []remote
 				return err
 			}
 		}
-		return remote.WriteIndex(tag, idx, opt...)
+		// Push the images of the index first, so the index is only
+		// written once all of them have been pushed.
+		if err := pushChildren(ctx, ref.Context(), idx, opt, jobs, blobs); err != nil {
+			return err
+		}
+		return remote.WriteIndex(ref, idx, opt...)
 	case types.OCIManifestSchema1, types.DockerManifestSchema2:
 		img, ok := br.(v1.Image)
 		if !ok {
@@ -191,36 +238,177 @@ func pushResult(ctx context.Context, tag name.Tag, br build.Result, opt []remote
 				return err
 			}
 		}
-		return remote.Write(tag, img, opt...)
+		return pushImage(ctx, ref, img, opt, jobs, blobs)
 	default:
 		return fmt.Errorf("result image media type: %s", mt)
 	}
 }
 
+// writeSBOM attaches the SBOM f to se, as a referrer if rw is set and the
+// registry supports it, or else with the tag ref.
+func writeSBOM(ctx context.Context, rw *referrerWriter, ref name.Tag, se oci.SignedEntity, f oci.File, opt []remote.Option) error {
+	if rw != nil {
+		rref, err := rw.write(ctx, ref.Context(), se, f, opt)
+		if err != nil {
+			return err
+		}
+		if rref != nil {
+			log.Printf("Published SBOM %v", rref)
+			return nil
+		}
+		log.Printf("%s does not support the referrers API, attaching the SBOM with a tag", ref.RegistryStr())
+	}
+	if err := remote.Write(ref, f, opt...); err != nil {
+		return err
+	}
+	log.Printf("Published SBOM %v", ref)
+	return nil
+}
+
+// pushImage pushes img to ref, uploading the layers that blobs hasn't
+// already.
+func pushImage(ctx context.Context, ref name.Reference, img v1.Image, opt []remote.Option, jobs int, blobs *pushedBlobs) error {
+	if err := blobs.writeLayers(ctx, ref.Context(), img, opt, jobs); err != nil {
+		return err
+	}
+	return remote.Write(ref, img, opt...)
+}
+
+// pushChildren pushes the images of idx to repo by digest, at most jobs at a
+// time, or one at a time if jobs is 0. The first error cancels the pushes
+// that are still running.
+func pushChildren(ctx context.Context, repo name.Repository, idx v1.ImageIndex, opt []remote.Option, jobs int, blobs *pushedBlobs) error {
+	im, err := idx.IndexManifest()
+	if err != nil {
+		return err
+	}
+
+	g, gctx := errgroup.WithContext(ctx)
+	if jobs > 0 {
+		g.SetLimit(jobs)
+	} else {
+		g.SetLimit(1)
+	}
+	opt = append(opt[:len(opt):len(opt)], remote.WithContext(gctx))
+	for _, desc := range im.Manifests {
+		desc := desc
+		switch desc.MediaType {
+		case types.OCIManifestSchema1, types.DockerManifestSchema2:
+		default:
+			// Leave anything else to remote.WriteIndex.
+			continue
+		}
+		g.Go(func() error {
+			img, err := idx.Image(desc.Digest)
+			if err != nil {
+				return err
+			}
+			if err := pushImage(gctx, repo.Digest(desc.Digest.String()), img, opt, jobs, blobs); err != nil {
+				if desc.Platform != nil {
+					return fmt.Errorf("pushing %s for %s: %w", desc.Digest, desc.Platform, err)
+				}
+				return fmt.Errorf("pushing %s: %w", desc.Digest, err)
+			}
+			return nil
+		})
+	}
+	return g.Wait()
+}
+
+// push pushes br to ref, first creating the repository if it does not exist
+// and we have been asked to.
+func (d *defalt) push(ctx context.Context, ref name.Reference, br build.Result, ro []remote.Option) error {
+	var rw *referrerWriter
+	if d.sbomReferrers {
+		rw = &referrerWriter{auth: d.auth, t: d.t}
+	}
+	err := pushResult(ctx, ref, br, ro, d.jobs, rw, d.blobs)
+	if err == nil || d.createRepo == nil || !isRepositoryNotFound(err) {
+		return err
+	}
+	created, cerr := d.createRepo(ctx, ref.Context())
+	if cerr != nil {
+		return cerr
+	}
+	if !created {
+		return err
+	}
+	return pushResult(ctx, ref, br, ro, d.jobs, rw, d.blobs)
+}
+
 // Publish implements publish.Interface
 func (d *defalt) Publish(ctx context.Context, br build.Result, s string) (name.Reference, error) {
 	s = strings.TrimPrefix(s, build.StrictScheme)
+	importpath := s
 	// https://github.com/google/go-containerregistry/issues/212
 	s = strings.ToLower(s)
 
 	ro := []remote.Option{remote.WithAuth(d.auth), remote.WithTransport(d.t), remote.WithContext(ctx), remote.WithUserAgent(d.userAgent)}
+	if d.retry != nil {
+		ro = append(ro, d.retry.options()...)
+	}
+	if d.jobs > 0 {
+		ro = append(ro, remote.WithJobs(d.jobs))
+	}
 	no := []name.Option{}
 	if d.insecure {
 		no = append(no, name.Insecure)
+	} else if repo, err := name.NewRepository(d.namer(d.base, s)); err == nil && d.insecureHosts.match(repo.RegistryStr()) {
+		no = append(no, name.Insecure)
 	}
 
-	for i, tagName := range d.tags {
-		tag, err := name.NewTag(fmt.Sprintf("%s:%s", d.namer(d.base, s), tagName), no...)
-		if err != nil {
+	if d.failIfTagExists && !d.noTag {
+		if err := d.checkTags(br, d.namer(d.base, s), no, ro); err != nil {
+			return nil, err
+		}
+	}
+
+	// The result is pushed by its first tag, or by its digest if it isn't
+	// to be tagged at all.
+	target := func(br build.Result) (name.Reference, error) {
+		if d.noTag {
+			h, err := br.Digest()
+			if err != nil {
+				return nil, err
+			}
+			return name.NewDigest(fmt.Sprintf("%s@%s", d.namer(d.base, s), h), no...)
+		}
+		return name.NewTag(fmt.Sprintf("%s:%s", d.namer(d.base, s), d.tags[0]), no...)
+	}
+	ref, err := target(br)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Publishing %v", ref)
+	if err := d.push(ctx, ref, br, ro); err != nil {
+		if !isRejected(err) {
+			return nil, err
+		}
+		// Not every registry accepts zstd layers, so retry with
+		// them recompressed with gzip.
+		gz, changed, gerr := gzipResult(br)
+		if gerr != nil {
+			return nil, gerr
+		}
+		if !changed {
+			return nil, err
+		}
+		log.Printf("Registry rejected %v (%v), retrying with gzip layers", ref, err)
+		br = gz
+		if ref, err = target(br); err != nil {
+			return nil, err
+		}
+		if err := d.push(ctx, ref, br, ro); err != nil {
 			return nil, err
 		}
+	}
 
-		if i == 0 {
-			log.Printf("Publishing %v", tag)
-			if err := pushResult(ctx, tag, br, ro); err != nil {
+	if !d.noTag {
+		for _, tagName := range d.tags[1:] {
+			tag, err := name.NewTag(fmt.Sprintf("%s:%s", d.namer(d.base, s), tagName), no...)
+			if err != nil {
 				return nil, err
 			}
-		} else {
 			log.Printf("Tagging %v", tag)
 			if err := remote.Tag(tag, br, ro...); err != nil {
 				return nil, err
@@ -228,6 +416,28 @@ func (d *defalt) Publish(ctx context.Context, br build.Result, s string) (name.R
 		}
 	}
 
+	h, err := br.Digest()
+	if err != nil {
+		return nil, err
+	}
+	if d.signer != nil || len(d.attesters) > 0 {
+		repo, err := name.NewRepository(d.namer(d.base, s), no...)
+		if err != nil {
+			return nil, err
+		}
+		dig := repo.Digest(h.String())
+		if d.signer != nil {
+			if err := d.sign(ctx, dig, ro); err != nil {
+				return nil, err
+			}
+		}
+		if len(d.attesters) > 0 {
+			if err := d.attest(ctx, importpath, dig, ro); err != nil {
+				return nil, err
+			}
+		}
+	}
+
 	if d.tagOnly {
 		// We have already validated that there is a single tag (not latest).
 		tag, err := name.NewTag(fmt.Sprintf("%s:%s", d.namer(d.base, s), d.tags[0]))
@@ -237,17 +447,13 @@ func (d *defalt) Publish(ctx context.Context, br build.Result, s string) (name.R
 		return &tag, nil
 	}
 
-	h, err := br.Digest()
-	if err != nil {
-		return nil, err
-	}
-	ref := fmt.Sprintf("%s@%s", d.namer(d.base, s), h)
-	if len(d.tags) == 1 && d.tags[0] != defaultTags[0] {
+	dref := fmt.Sprintf("%s@%s", d.namer(d.base, s), h)
+	if len(d.tags) == 1 && d.tags[0] != defaultTags[0] && !d.noTag {
 		// If a single tag is explicitly set (not latest), then this
 		// is probably a release, so include the tag in the reference.
-		ref = fmt.Sprintf("%s:%s@%s", d.namer(d.base, s), d.tags[0], h)
+		dref = fmt.Sprintf("%s:%s@%s", d.namer(d.base, s), d.tags[0], h)
 	}
-	dig, err := name.NewDigest(ref)
+	dig, err := name.NewDigest(dref)
 	if err != nil {
 		return nil, err
 	}
@@ -15,24 +15,35 @@
 package publish_test
 
 import (
+	"bytes"
 	"context"
 	"crypto/md5"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/google/go-containerregistry/pkg/crane"
 	"github.com/google/go-containerregistry/pkg/name"
 	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
 	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/types"
 	"github.com/google/ko/pkg/build"
 	"github.com/google/ko/pkg/publish"
+	"github.com/sigstore/cosign/pkg/oci"
 	ocimutate "github.com/sigstore/cosign/pkg/oci/mutate"
+	ociremote "github.com/sigstore/cosign/pkg/oci/remote"
 	"github.com/sigstore/cosign/pkg/oci/signed"
 	"github.com/sigstore/cosign/pkg/oci/static"
 )
@@ -173,6 +184,54 @@ func TestDefaultWithTags(t *testing.T) {
 	}
 }
 
+func TestDefaultNoTag(t *testing.T) {
+	for _, br := range []build.Result{img, idx} {
+		server := httptest.NewServer(registry.New())
+		defer server.Close()
+		u, err := url.Parse(server.URL)
+		if err != nil {
+			t.Fatalf("url.Parse(%v) = %v", server.URL, err)
+		}
+
+		repoName := fmt.Sprintf("%s/%s", u.Host, "blah")
+		def, err := publish.NewDefault(repoName,
+			publish.WithNamer(func(base, _ string) string { return base }),
+			publish.WithTags([]string{"v1", "v2"}),
+			publish.WithNoTag())
+		if err != nil {
+			t.Fatalf("NewDefault() = %v", err)
+		}
+		ref, err := def.Publish(context.Background(), br, build.StrictScheme+"example.com/cmd")
+		if err != nil {
+			t.Fatalf("Publish() = %v", err)
+		}
+		h, err := br.Digest()
+		if err != nil {
+			t.Fatalf("Digest() = %v", err)
+		}
+		if want := repoName + "@" + h.String(); ref.String() != want {
+			t.Errorf("Publish() = %v, wanted %v", ref, want)
+		}
+
+		if _, err := crane.Head(ref.String()); err != nil {
+			t.Errorf("crane.Head(%v) = %v", ref, err)
+		}
+		tags, err := crane.ListTags(repoName)
+		if err != nil {
+			t.Fatalf("crane.ListTags() = %v", err)
+		}
+		if len(tags) != 0 {
+			t.Errorf("crane.ListTags() = %v, wanted no tags", tags)
+		}
+	}
+}
+
+func TestDefaultNoTagTagOnly(t *testing.T) {
+	if _, err := publish.NewDefault("example.com/blah", publish.WithTags([]string{"v1"}), publish.WithTagOnly(true), publish.WithNoTag()); err == nil {
+		t.Error("NewDefault() with WithTagOnly and WithNoTag = nil, wanted error")
+	}
+}
+
 func TestDefaultWithReleaseTag(t *testing.T) {
 	img, err := random.Image(1024, 1)
 	if err != nil {
@@ -259,3 +318,848 @@ func TestDefaultWithReleaseTag(t *testing.T) {
 		t.Errorf("Publish() = %v, wanted no digest", d.String())
 	}
 }
+
+func TestDefaultZstdFallback(t *testing.T) {
+	layer, err := random.Layer(1024, types.OCILayer)
+	if err != nil {
+		t.Fatalf("random.Layer() = %v", err)
+	}
+	img, err := mutate.Append(mutate.MediaType(empty.Image, types.OCIManifestSchema1), mutate.Addendum{
+		Layer:     layer,
+		MediaType: build.OCILayerZstd,
+	})
+	if err != nil {
+		t.Fatalf("mutate.Append() = %v", err)
+	}
+
+	// SBOMs that describe the zstd images by digest.
+	attachSBOM := func(se oci.SignedEntity, digests ...v1.Hash) oci.SignedEntity {
+		t.Helper()
+		var b strings.Builder
+		for _, d := range digests {
+			fmt.Fprintf(&b, "describes %s\n", d)
+		}
+		f, err := static.NewFile([]byte(b.String()), static.WithLayerMediaType("spdx+json"))
+		if err != nil {
+			t.Fatalf("static.NewFile() = %v", err)
+		}
+		se, err = ocimutate.AttachFileToEntity(se, "sbom", f)
+		if err != nil {
+			t.Fatalf("AttachFileToEntity() = %v", err)
+		}
+		return se
+	}
+	imgDigest, err := img.Digest()
+	if err != nil {
+		t.Fatalf("Digest() = %v", err)
+	}
+	zimg := attachSBOM(signed.Image(img), imgDigest).(oci.SignedImage)
+	var zidx oci.SignedImageIndex = ocimutate.AppendManifests(mutate.IndexMediaType(empty.Index, types.OCIImageIndex), ocimutate.IndexAddendum{
+		Add: zimg,
+		Descriptor: v1.Descriptor{
+			Platform: &v1.Platform{OS: "linux", Architecture: "amd64"},
+		},
+	})
+	idxDigest, err := zidx.Digest()
+	if err != nil {
+		t.Fatalf("Digest() = %v", err)
+	}
+	zidx = attachSBOM(zidx, idxDigest, imgDigest).(oci.SignedImageIndex)
+
+	// checkSBOM checks that the SBOM of se was rewritten to describe the
+	// gzip images with the digests want, rather than the zstd ones.
+	checkSBOM := func(se oci.SignedEntity, want ...string) {
+		t.Helper()
+		f, err := se.Attachment("sbom")
+		if err != nil {
+			t.Fatalf("Attachment() = %v", err)
+		}
+		payload, err := f.Payload()
+		if err != nil {
+			t.Fatalf("Payload() = %v", err)
+		}
+		for _, w := range want {
+			if !strings.Contains(string(payload), w) {
+				t.Errorf("SBOM = %q, wanted it to describe %v", payload, w)
+			}
+		}
+		if strings.Contains(string(payload), imgDigest.Hex) || strings.Contains(string(payload), idxDigest.Hex) {
+			t.Errorf("SBOM = %q, still describes the zstd images", payload)
+		}
+	}
+
+	for _, br := range []build.Result{zimg, zidx} {
+		base := "blah"
+		importpath := "github.com/Google/go-containerregistry/cmd/crane"
+
+		// A registry that does not support zstd layers.
+		reg := registry.New()
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodPut && strings.Contains(r.URL.Path, "/manifests/") {
+				body, err := ioutil.ReadAll(r.Body)
+				if err != nil {
+					t.Fatalf("ReadAll() = %v", err)
+				}
+				if strings.Contains(string(body), string(build.OCILayerZstd)) {
+					w.WriteHeader(http.StatusBadRequest)
+					fmt.Fprint(w, `{"errors":[{"code":"MANIFEST_INVALID","message":"unsupported layer media type"}]}`)
+					return
+				}
+				r.Body = ioutil.NopCloser(bytes.NewReader(body))
+			}
+			reg.ServeHTTP(w, r)
+		}))
+		defer server.Close()
+		u, err := url.Parse(server.URL)
+		if err != nil {
+			t.Fatalf("url.Parse(%v) = %v", server.URL, err)
+		}
+
+		repoName := fmt.Sprintf("%s/%s", u.Host, base)
+		def, err := publish.NewDefault(repoName)
+		if err != nil {
+			t.Fatalf("NewDefault() = %v", err)
+		}
+		d, err := def.Publish(context.Background(), br, build.StrictScheme+importpath)
+		if err != nil {
+			t.Fatalf("Publish() = %v", err)
+		}
+		want, err := br.Digest()
+		if err != nil {
+			t.Fatalf("Digest() = %v", err)
+		}
+		if strings.HasSuffix(d.String(), want.String()) {
+			t.Errorf("Publish() = %v, want digest of gzip layers", d)
+		}
+		if _, err := crane.Manifest(d.String()); err != nil {
+			t.Errorf("crane.Manifest(%v) = %v", d, err)
+		}
+
+		dig := d.(*name.Digest)
+		if br == zimg {
+			si, err := ociremote.SignedImage(dig)
+			if err != nil {
+				t.Fatalf("SignedImage() = %v", err)
+			}
+			checkSBOM(si, dig.DigestStr())
+			continue
+		}
+		sii, err := ociremote.SignedImageIndex(dig)
+		if err != nil {
+			t.Fatalf("SignedImageIndex() = %v", err)
+		}
+		im, err := sii.IndexManifest()
+		if err != nil {
+			t.Fatalf("IndexManifest() = %v", err)
+		}
+		gzDigest := im.Manifests[0].Digest.String()
+		checkSBOM(sii, dig.DigestStr(), gzDigest)
+		si, err := ociremote.SignedImage(dig.Context().Digest(gzDigest))
+		if err != nil {
+			t.Fatalf("SignedImage() = %v", err)
+		}
+		checkSBOM(si, gzDigest)
+	}
+}
+
+func TestDefaultRetries(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		status   int
+		failures int
+		retries  int
+		wantErr  bool
+		wantPuts int
+	}{{
+		name:     "transient errors are retried",
+		status:   http.StatusServiceUnavailable,
+		failures: 2,
+		retries:  3,
+		wantPuts: 3,
+	}, {
+		name:     "retries run out",
+		status:   http.StatusBadGateway,
+		failures: 5,
+		retries:  2,
+		wantErr:  true,
+		wantPuts: 3,
+	}, {
+		name:     "rate limiting is retried",
+		status:   http.StatusTooManyRequests,
+		failures: 1,
+		retries:  1,
+		wantPuts: 2,
+	}, {
+		name:     "forbidden is not retried",
+		status:   http.StatusForbidden,
+		failures: 5,
+		retries:  3,
+		wantErr:  true,
+		wantPuts: 1,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			importpath := "github.com/Google/go-containerregistry/cmd/crane"
+
+			reg := registry.New()
+			var puts int
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodPut && strings.Contains(r.URL.Path, "/manifests/") {
+					puts++
+					if puts <= tc.failures {
+						w.WriteHeader(tc.status)
+						return
+					}
+				}
+				reg.ServeHTTP(w, r)
+			}))
+			defer server.Close()
+			u, err := url.Parse(server.URL)
+			if err != nil {
+				t.Fatalf("url.Parse(%v) = %v", server.URL, err)
+			}
+
+			repoName := fmt.Sprintf("%s/%s", u.Host, "blah")
+			def, err := publish.NewDefault(repoName, publish.WithRetries(tc.retries, time.Millisecond))
+			if err != nil {
+				t.Fatalf("NewDefault() = %v", err)
+			}
+			_, err = def.Publish(context.Background(), img, build.StrictScheme+importpath)
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Errorf("Publish() = %v, wantErr %v", err, tc.wantErr)
+			}
+			if puts != tc.wantPuts {
+				t.Errorf("manifest PUTs = %d, want %d", puts, tc.wantPuts)
+			}
+		})
+	}
+}
+
+func TestDefaultFailIfTagExists(t *testing.T) {
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("url.Parse(%v) = %v", server.URL, err)
+	}
+	repoName := fmt.Sprintf("%s/blah", u.Host)
+	importpath := build.StrictScheme + "example.com/app"
+	ctx := context.Background()
+
+	other, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	want, err := img.Digest()
+	if err != nil {
+		t.Fatalf("Digest() = %v", err)
+	}
+
+	release, err := publish.NewDefault(repoName, publish.WithTags([]string{"v1.2.3"}), publish.WithFailIfTagExists())
+	if err != nil {
+		t.Fatalf("NewDefault() = %v", err)
+	}
+	if _, err := release.Publish(ctx, img, importpath); err != nil {
+		t.Fatalf("Publish() = %v", err)
+	}
+	// Publishing the same image again is fine.
+	if _, err := release.Publish(ctx, img, importpath); err != nil {
+		t.Errorf("Publish() of the same image = %v", err)
+	}
+	// Publishing a different image to the tag is not.
+	if _, err := release.Publish(ctx, other, importpath); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("Publish() of a different image = %v, wanted already exists error", err)
+	}
+	got, err := crane.Digest(repoName + "/example.com/app:v1.2.3")
+	if err != nil {
+		t.Fatalf("crane.Digest() = %v", err)
+	}
+	if got != want.String() {
+		t.Errorf("v1.2.3 = %s, wanted it to still be %s", got, want)
+	}
+
+	// The default latest tag is not checked.
+	latest, err := publish.NewDefault(repoName, publish.WithFailIfTagExists())
+	if err != nil {
+		t.Fatalf("NewDefault() = %v", err)
+	}
+	for _, br := range []build.Result{img, other} {
+		if _, err := latest.Publish(ctx, br, importpath); err != nil {
+			t.Errorf("Publish() with latest = %v", err)
+		}
+	}
+}
+
+func TestDefaultSBOMReferrer(t *testing.T) {
+	f, err := static.NewFile([]byte("da bom"), static.WithLayerMediaType("spdx+json"))
+	if err != nil {
+		t.Fatalf("static.NewFile() = %v", err)
+	}
+	si, err := ocimutate.AttachFileToImage(signed.Image(img), "sbom", f)
+	if err != nil {
+		t.Fatalf("ocimutate.AttachFileToImage() = %v", err)
+	}
+	want, err := si.Digest()
+	if err != nil {
+		t.Fatalf("Digest() = %v", err)
+	}
+
+	for _, tc := range []struct {
+		name         string
+		referrers    bool
+		wantReferrer bool
+	}{{
+		name:         "registry with referrers API",
+		referrers:    true,
+		wantReferrer: true,
+	}, {
+		name: "registry without referrers API",
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			reg := registry.New()
+			var mu sync.Mutex
+			var manifests []map[string]interface{}
+			var sbomTagged bool
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tc.referrers && strings.Contains(r.URL.Path, "/referrers/") {
+					w.Header().Set("Content-Type", string(types.OCIImageIndex))
+					fmt.Fprint(w, `{"schemaVersion": 2, "mediaType": "application/vnd.oci.image.index.v1+json", "manifests": []}`)
+					return
+				}
+				if r.Method == http.MethodPut && strings.Contains(r.URL.Path, "/manifests/") {
+					b, err := ioutil.ReadAll(r.Body)
+					if err != nil {
+						t.Errorf("ReadAll() = %v", err)
+					}
+					var m map[string]interface{}
+					if err := json.Unmarshal(b, &m); err != nil {
+						t.Errorf("Unmarshal() = %v", err)
+					}
+					mu.Lock()
+					manifests = append(manifests, m)
+					sbomTagged = sbomTagged || strings.HasSuffix(r.URL.Path, ".sbom")
+					mu.Unlock()
+					r.Body = ioutil.NopCloser(bytes.NewReader(b))
+				}
+				reg.ServeHTTP(w, r)
+			}))
+			defer server.Close()
+			u, err := url.Parse(server.URL)
+			if err != nil {
+				t.Fatalf("url.Parse(%v) = %v", server.URL, err)
+			}
+
+			def, err := publish.NewDefault(fmt.Sprintf("%s/blah", u.Host), publish.WithSBOMAttach(publish.SBOMAttachReferrer))
+			if err != nil {
+				t.Fatalf("NewDefault() = %v", err)
+			}
+			if _, err := def.Publish(context.Background(), si, build.StrictScheme+"example.com/app"); err != nil {
+				t.Fatalf("Publish() = %v", err)
+			}
+
+			var referrer map[string]interface{}
+			for _, m := range manifests {
+				if _, ok := m["subject"]; ok {
+					referrer = m
+				}
+			}
+			if gotReferrer := referrer != nil; gotReferrer != tc.wantReferrer {
+				t.Fatalf("pushed a referrer = %t, wanted %t", gotReferrer, tc.wantReferrer)
+			}
+			if sbomTagged == tc.wantReferrer {
+				t.Errorf("pushed a .sbom tag = %t, wanted %t", sbomTagged, !tc.wantReferrer)
+			}
+			if referrer == nil {
+				return
+			}
+			if got := referrer["artifactType"]; got != "application/spdx+json" {
+				t.Errorf("artifactType = %v, wanted application/spdx+json", got)
+			}
+			if got := referrer["subject"].(map[string]interface{})["digest"]; got != want.String() {
+				t.Errorf("subject digest = %v, wanted %v", got, want)
+			}
+		})
+	}
+}
+
+func TestWithSBOMAttachInvalid(t *testing.T) {
+	if _, err := publish.NewDefault("example.com/blah", publish.WithSBOMAttach("attestation")); err == nil {
+		t.Error("NewDefault() with invalid SBOM attach mode = nil, wanted error")
+	}
+}
+
+func TestDefaultSign(t *testing.T) {
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("url.Parse(%v) = %v", server.URL, err)
+	}
+	repoName := fmt.Sprintf("%s/blah", u.Host)
+	importpath := build.StrictScheme + "example.com/app"
+
+	signer := func(_ context.Context, payload []byte) (oci.Signature, error) {
+		return static.NewSignature(payload, "c2lnbmF0dXJl")
+	}
+	def, err := publish.NewDefault(repoName, publish.WithSigner(signer))
+	if err != nil {
+		t.Fatalf("NewDefault() = %v", err)
+	}
+	ref, err := def.Publish(context.Background(), img, importpath)
+	if err != nil {
+		t.Fatalf("Publish() = %v", err)
+	}
+	dig := ref.(*name.Digest)
+
+	si, err := ociremote.SignedImage(dig)
+	if err != nil {
+		t.Fatalf("SignedImage() = %v", err)
+	}
+	sigs, err := si.Signatures()
+	if err != nil {
+		t.Fatalf("Signatures() = %v", err)
+	}
+	got, err := sigs.Get()
+	if err != nil {
+		t.Fatalf("Get() = %v", err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("got %d signatures, wanted 1", len(got))
+	}
+	payload, err := got[0].Payload()
+	if err != nil {
+		t.Fatalf("Payload() = %v", err)
+	}
+	var ss struct {
+		Critical struct {
+			Identity struct {
+				DockerReference string `json:"docker-reference"`
+			} `json:"identity"`
+			Image struct {
+				DockerManifestDigest string `json:"docker-manifest-digest"`
+			} `json:"image"`
+		} `json:"critical"`
+	}
+	if err := json.Unmarshal(payload, &ss); err != nil {
+		t.Fatalf("Unmarshal() = %v", err)
+	}
+	if got, want := ss.Critical.Image.DockerManifestDigest, dig.DigestStr(); got != want {
+		t.Errorf("docker-manifest-digest = %s, wanted %s", got, want)
+	}
+	if got, want := ss.Critical.Identity.DockerReference, dig.Context().String(); got != want {
+		t.Errorf("docker-reference = %s, wanted %s", got, want)
+	}
+}
+
+func TestDefaultAttest(t *testing.T) {
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("url.Parse(%v) = %v", server.URL, err)
+	}
+	repoName := fmt.Sprintf("%s/blah", u.Host)
+	importpath := build.StrictScheme + "example.com/app"
+
+	var gotImportpath string
+	attester := func(_ context.Context, importpath string, dig name.Digest) (oci.Signature, error) {
+		gotImportpath = importpath
+		return static.NewAttestation([]byte(dig.DigestStr()))
+	}
+	def, err := publish.NewDefault(repoName, publish.WithAttester(attester))
+	if err != nil {
+		t.Fatalf("NewDefault() = %v", err)
+	}
+	ref, err := def.Publish(context.Background(), img, importpath)
+	if err != nil {
+		t.Fatalf("Publish() = %v", err)
+	}
+	dig := ref.(*name.Digest)
+	if want := "example.com/app"; gotImportpath != want {
+		t.Errorf("attester got import path %q, wanted %q", gotImportpath, want)
+	}
+
+	si, err := ociremote.SignedImage(dig)
+	if err != nil {
+		t.Fatalf("SignedImage() = %v", err)
+	}
+	atts, err := si.Attestations()
+	if err != nil {
+		t.Fatalf("Attestations() = %v", err)
+	}
+	got, err := atts.Get()
+	if err != nil {
+		t.Fatalf("Get() = %v", err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("got %d attestations, wanted 1", len(got))
+	}
+	payload, err := got[0].Payload()
+	if err != nil {
+		t.Fatalf("Payload() = %v", err)
+	}
+	if string(payload) != dig.DigestStr() {
+		t.Errorf("attestation = %s, wanted the one for %s", payload, dig.DigestStr())
+	}
+}
+
+func TestWithRetriesNegative(t *testing.T) {
+	if _, err := publish.NewDefault("example.com/blah", publish.WithRetries(-1, time.Second)); err == nil {
+		t.Error("NewDefault() with negative retries = nil, wanted error")
+	}
+}
+
+func TestDefaultPushConcurrency(t *testing.T) {
+	const jobs = 2
+	importpath := "github.com/Google/go-containerregistry/cmd/crane"
+
+	reg := registry.New()
+	var mu sync.Mutex
+	var inflight, peak int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inflight++
+		if inflight > peak {
+			peak = inflight
+		}
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			inflight--
+			mu.Unlock()
+		}()
+
+		// Give concurrent requests a chance to overlap.
+		time.Sleep(5 * time.Millisecond)
+		reg.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("url.Parse(%v) = %v", server.URL, err)
+	}
+
+	repoName := fmt.Sprintf("%s/%s", u.Host, "blah")
+	def, err := publish.NewDefault(repoName, publish.WithPushConcurrency(jobs))
+	if err != nil {
+		t.Fatalf("NewDefault() = %v", err)
+	}
+	d, err := def.Publish(context.Background(), idx, build.StrictScheme+importpath)
+	if err != nil {
+		t.Fatalf("Publish() = %v", err)
+	}
+	if _, err := crane.Manifest(d.String()); err != nil {
+		t.Errorf("crane.Manifest(%v) = %v", d, err)
+	}
+	if peak > jobs {
+		t.Errorf("peak requests in flight = %d, want at most %d", peak, jobs)
+	}
+	if peak < 2 {
+		t.Errorf("peak requests in flight = %d, wanted requests in parallel", peak)
+	}
+}
+
+func TestDefaultPushConcurrencyError(t *testing.T) {
+	importpath := "github.com/Google/go-containerregistry/cmd/crane"
+
+	im, err := idx.IndexManifest()
+	if err != nil {
+		t.Fatalf("IndexManifest() = %v", err)
+	}
+	bad, slow := im.Manifests[0].Digest, im.Manifests[1].Digest
+	slowImg, err := idx.Image(slow)
+	if err != nil {
+		t.Fatalf("Image() = %v", err)
+	}
+	ls, err := slowImg.Layers()
+	if err != nil {
+		t.Fatalf("Layers() = %v", err)
+	}
+	slowLayer, err := ls[0].Digest()
+	if err != nil {
+		t.Fatalf("Digest() = %v", err)
+	}
+
+	reg := registry.New()
+	var mu sync.Mutex
+	var indexWritten bool
+	cancelled := make(chan struct{})
+	var once sync.Once
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPut && strings.HasSuffix(r.URL.Path, "/manifests/"+bad.String()):
+			w.WriteHeader(http.StatusForbidden)
+			return
+		case strings.HasSuffix(r.URL.Path, "/blobs/"+slowLayer.String()):
+			// Hold the other image's push until it is cancelled.
+			select {
+			case <-r.Context().Done():
+				once.Do(func() { close(cancelled) })
+			case <-time.After(10 * time.Second):
+			}
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		case r.Method == http.MethodPut && strings.HasSuffix(r.URL.Path, "/manifests/latest"):
+			mu.Lock()
+			indexWritten = true
+			mu.Unlock()
+		}
+		reg.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("url.Parse(%v) = %v", server.URL, err)
+	}
+
+	repoName := fmt.Sprintf("%s/%s", u.Host, "blah")
+	def, err := publish.NewDefault(repoName, publish.WithPushConcurrency(4))
+	if err != nil {
+		t.Fatalf("NewDefault() = %v", err)
+	}
+	if _, err := def.Publish(context.Background(), idx, build.StrictScheme+importpath); err == nil {
+		t.Fatal("Publish() = nil, wanted error")
+	} else if !strings.Contains(err.Error(), bad.String()) {
+		t.Errorf("Publish() = %v, wanted error naming %s", err, bad)
+	}
+
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Error("push of the other image was not cancelled")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if indexWritten {
+		t.Error("index was written after a failed push")
+	}
+}
+
+func TestDefaultSharedLayers(t *testing.T) {
+	shared, err := random.Layer(1024, types.DockerLayer)
+	if err != nil {
+		t.Fatalf("random.Layer() = %v", err)
+	}
+	sharedDigest, err := shared.Digest()
+	if err != nil {
+		t.Fatalf("Digest() = %v", err)
+	}
+	var imgs []v1.Image
+	for i := 0; i < 3; i++ {
+		unique, err := random.Layer(1024, types.DockerLayer)
+		if err != nil {
+			t.Fatalf("random.Layer() = %v", err)
+		}
+		img, err := mutate.AppendLayers(empty.Image, shared, unique)
+		if err != nil {
+			t.Fatalf("AppendLayers() = %v", err)
+		}
+		imgs = append(imgs, img)
+	}
+
+	reg := registry.New()
+	var mu sync.Mutex
+	uploads := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut && r.URL.Query().Get("digest") == sharedDigest.String() {
+			mu.Lock()
+			uploads++
+			mu.Unlock()
+		}
+		reg.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("url.Parse(%v) = %v", server.URL, err)
+	}
+
+	repoName := fmt.Sprintf("%s/%s", u.Host, "blah")
+	def, err := publish.NewDefault(repoName, publish.WithNamer(func(base, _ string) string { return base }))
+	if err != nil {
+		t.Fatalf("NewDefault() = %v", err)
+	}
+	var wg sync.WaitGroup
+	errs := make([]error, len(imgs))
+	for i, img := range imgs {
+		i, img := i, img
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = def.Publish(context.Background(), img, fmt.Sprintf("%sexample.com/cmd%d", build.StrictScheme, i))
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Fatalf("Publish() = %v", err)
+		}
+	}
+
+	if uploads != 1 {
+		t.Errorf("shared layer was uploaded %d times, wanted once", uploads)
+	}
+}
+
+func TestDefaultMountBlobs(t *testing.T) {
+	shared, err := random.Layer(1024, types.DockerLayer)
+	if err != nil {
+		t.Fatalf("random.Layer() = %v", err)
+	}
+	sharedDigest, err := shared.Digest()
+	if err != nil {
+		t.Fatalf("Digest() = %v", err)
+	}
+	var imgs []v1.Image
+	for i := 0; i < 2; i++ {
+		unique, err := random.Layer(1024, types.DockerLayer)
+		if err != nil {
+			t.Fatalf("random.Layer() = %v", err)
+		}
+		img, err := mutate.AppendLayers(empty.Image, shared, unique)
+		if err != nil {
+			t.Fatalf("AppendLayers() = %v", err)
+		}
+		imgs = append(imgs, img)
+	}
+
+	for _, mount := range []bool{false, true} {
+		t.Run(fmt.Sprintf("mount=%v", mount), func(t *testing.T) {
+			// registry.New() shares blobs between repositories, so keep
+			// track of which blobs each repository has, as registries do.
+			reg := registry.New()
+			var mu sync.Mutex
+			has := map[string]bool{}
+			uploads, mounts := 0, 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				repo, rest := r.URL.Path, ""
+				if i := strings.Index(r.URL.Path, "/blobs/"); i >= 0 {
+					repo, rest = strings.TrimPrefix(r.URL.Path[:i], "/v2/"), r.URL.Path[i+len("/blobs/"):]
+				}
+				q := r.URL.Query()
+				mu.Lock()
+				defer mu.Unlock()
+				switch {
+				case r.Method == http.MethodHead && rest != "" && !has[repo+"@"+rest]:
+					w.WriteHeader(http.StatusNotFound)
+					return
+				case r.Method == http.MethodPost && q.Get("mount") != "":
+					if !has[q.Get("from")+"@"+q.Get("mount")] {
+						break
+					}
+					if q.Get("mount") == sharedDigest.String() {
+						mounts++
+					}
+					has[repo+"@"+q.Get("mount")] = true
+					w.Header().Set("Location", "/v2/"+repo+"/blobs/"+q.Get("mount"))
+					w.WriteHeader(http.StatusCreated)
+					return
+				case r.Method == http.MethodPut && q.Get("digest") != "":
+					if q.Get("digest") == sharedDigest.String() {
+						uploads++
+					}
+					has[repo+"@"+q.Get("digest")] = true
+				}
+				reg.ServeHTTP(w, r)
+			}))
+			defer server.Close()
+			u, err := url.Parse(server.URL)
+			if err != nil {
+				t.Fatalf("url.Parse(%v) = %v", server.URL, err)
+			}
+
+			opts := []publish.Option{}
+			if mount {
+				opts = append(opts, publish.WithMountBlobs())
+			}
+			def, err := publish.NewDefault(fmt.Sprintf("%s/%s", u.Host, "blah"), opts...)
+			if err != nil {
+				t.Fatalf("NewDefault() = %v", err)
+			}
+			var wg sync.WaitGroup
+			errs := make([]error, len(imgs))
+			for i, img := range imgs {
+				i, img := i, img
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					_, errs[i] = def.Publish(context.Background(), img, fmt.Sprintf("%sexample.com/cmd%d", build.StrictScheme, i))
+				}()
+			}
+			wg.Wait()
+			for _, err := range errs {
+				if err != nil {
+					t.Fatalf("Publish() = %v", err)
+				}
+			}
+
+			wantUploads, wantMounts := 2, 0
+			if mount {
+				wantUploads, wantMounts = 1, 1
+			}
+			if uploads != wantUploads || mounts != wantMounts {
+				t.Errorf("shared layer was uploaded %d times and mounted %d times, wanted %d and %d", uploads, mounts, wantUploads, wantMounts)
+			}
+		})
+	}
+}
+
+func TestDefaultSkipsForeignLayers(t *testing.T) {
+	foreign, err := random.Layer(1024, types.DockerForeignLayer)
+	if err != nil {
+		t.Fatalf("random.Layer() = %v", err)
+	}
+	foreignDigest, err := foreign.Digest()
+	if err != nil {
+		t.Fatalf("Digest() = %v", err)
+	}
+	img, err := mutate.AppendLayers(empty.Image, foreign)
+	if err != nil {
+		t.Fatalf("AppendLayers() = %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		l, err := random.Layer(1024, types.DockerLayer)
+		if err != nil {
+			t.Fatalf("random.Layer() = %v", err)
+		}
+		if img, err = mutate.AppendLayers(img, l); err != nil {
+			t.Fatalf("AppendLayers() = %v", err)
+		}
+	}
+
+	reg := registry.New()
+	var mu sync.Mutex
+	var foreignRequests []string
+	pings := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		if r.URL.Path == "/v2/" {
+			pings++
+		}
+		if strings.Contains(r.URL.String(), foreignDigest.String()) {
+			foreignRequests = append(foreignRequests, r.Method+" "+r.URL.String())
+		}
+		mu.Unlock()
+		reg.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("url.Parse(%v) = %v", server.URL, err)
+	}
+
+	def, err := publish.NewDefault(fmt.Sprintf("%s/%s", u.Host, "blah"))
+	if err != nil {
+		t.Fatalf("NewDefault() = %v", err)
+	}
+	if _, err := def.Publish(context.Background(), img, build.StrictScheme+"example.com/cmd"); err != nil {
+		t.Fatalf("Publish() = %v", err)
+	}
+
+	if len(foreignRequests) != 0 {
+		t.Errorf("foreign layer was pushed: %v", foreignRequests)
+	}
+	// Once to upload the layers, and once for remote.Write.
+	if pings != 2 {
+		t.Errorf("registry was pinged %d times, wanted 2", pings)
+	}
+}
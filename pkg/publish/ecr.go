@@ -0,0 +1,90 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	ecrtypes "github.com/aws/aws-sdk-go-v2/service/ecr/types"
+	"github.com/awslabs/amazon-ecr-credential-helper/ecr-login/api"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+)
+
+// repoCreator creates repo if it can, and returns whether it did.
+type repoCreator func(ctx context.Context, repo name.Repository) (bool, error)
+
+// ecrRegistry returns the ECR registry that reg refers to, or nil if reg is
+// not a private ECR registry.
+func ecrRegistry(reg name.Registry) *api.Registry {
+	r, err := api.ExtractRegistry(reg.RegistryStr())
+	if err != nil || r.Service != api.ServiceECR {
+		return nil
+	}
+	return r
+}
+
+// createECRRepository creates repo with the ECR CreateRepository API, using
+// the usual AWS credential chain. It does nothing for repositories that
+// aren't in ECR.
+func createECRRepository(ctx context.Context, repo name.Repository) (bool, error) {
+	reg := ecrRegistry(repo.Registry)
+	if reg == nil {
+		return false, nil
+	}
+
+	opts := []func(*config.LoadOptions) error{config.WithRegion(reg.Region)}
+	if reg.FIPS {
+		opts = append(opts, config.WithUseFIPSEndpoint(aws.FIPSEndpointStateEnabled))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return false, fmt.Errorf("loading AWS config: %w", err)
+	}
+
+	log.Printf("Creating ECR repository %s", repo)
+	if _, err := ecr.NewFromConfig(cfg).CreateRepository(ctx, &ecr.CreateRepositoryInput{
+		RegistryId:     aws.String(reg.ID),
+		RepositoryName: aws.String(repo.RepositoryStr()),
+	}); err != nil {
+		// Another push may have created it in the meantime.
+		var exists *ecrtypes.RepositoryAlreadyExistsException
+		if !errors.As(err, &exists) {
+			return false, fmt.Errorf("creating ECR repository %s: %w", repo, err)
+		}
+	}
+	return true, nil
+}
+
+// isRepositoryNotFound returns whether err is the registry telling us that
+// the repository we pushed to does not exist.
+func isRepositoryNotFound(err error) bool {
+	var terr *transport.Error
+	if !errors.As(err, &terr) {
+		return false
+	}
+	for _, e := range terr.Errors {
+		if e.Code == transport.NameUnknownErrorCode {
+			return true
+		}
+	}
+	return false
+}
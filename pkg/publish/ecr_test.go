@@ -0,0 +1,153 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/ko/pkg/build"
+)
+
+func TestECRRegistry(t *testing.T) {
+	for _, tc := range []struct {
+		registry   string
+		wantID     string
+		wantRegion string
+		wantFIPS   bool
+	}{{
+		registry:   "123456789012.dkr.ecr.us-west-2.amazonaws.com",
+		wantID:     "123456789012",
+		wantRegion: "us-west-2",
+	}, {
+		registry:   "123456789012.dkr.ecr-fips.us-gov-west-1.amazonaws.com",
+		wantID:     "123456789012",
+		wantRegion: "us-gov-west-1",
+		wantFIPS:   true,
+	}, {
+		registry:   "123456789012.dkr.ecr.cn-north-1.amazonaws.com.cn",
+		wantID:     "123456789012",
+		wantRegion: "cn-north-1",
+	}, {
+		registry: "public.ecr.aws",
+	}, {
+		registry: "gcr.io",
+	}, {
+		registry: "amazonaws.com.example.com",
+	}} {
+		t.Run(tc.registry, func(t *testing.T) {
+			reg, err := name.NewRegistry(tc.registry)
+			if err != nil {
+				t.Fatalf("NewRegistry() = %v", err)
+			}
+			got := ecrRegistry(reg)
+			if tc.wantID == "" {
+				if got != nil {
+					t.Errorf("ecrRegistry() = %+v, want nil", got)
+				}
+				return
+			}
+			if got == nil {
+				t.Fatal("ecrRegistry() = nil, want ECR registry")
+			}
+			if got.ID != tc.wantID || got.Region != tc.wantRegion || got.FIPS != tc.wantFIPS {
+				t.Errorf("ecrRegistry() = %+v, want ID %s, Region %s, FIPS %v", got, tc.wantID, tc.wantRegion, tc.wantFIPS)
+			}
+		})
+	}
+}
+
+func TestCreateECRRepositoryNotECR(t *testing.T) {
+	repo, err := name.NewRepository("gcr.io/example/app")
+	if err != nil {
+		t.Fatalf("NewRepository() = %v", err)
+	}
+	created, err := createECRRepository(context.Background(), repo)
+	if err != nil || created {
+		t.Errorf("createECRRepository() = %v, %v, want false, nil", created, err)
+	}
+}
+
+func TestPublishCreatesRepo(t *testing.T) {
+	img, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+
+	for _, tc := range []struct {
+		description string
+		create      bool
+		wantCreated int
+		wantErr     bool
+	}{{
+		description: "repository created",
+		create:      true,
+		wantCreated: 1,
+	}, {
+		description: "repository not created",
+		wantErr:     true,
+	}} {
+		t.Run(tc.description, func(t *testing.T) {
+			// A registry that rejects pushes until the repository is created.
+			reg := registry.New()
+			exists := false
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if !exists && strings.HasPrefix(r.URL.Path, "/v2/blah/") {
+					w.WriteHeader(http.StatusNotFound)
+					fmt.Fprint(w, `{"errors":[{"code":"NAME_UNKNOWN","message":"The repository does not exist"}]}`)
+					return
+				}
+				reg.ServeHTTP(w, r)
+			}))
+			defer server.Close()
+			u, err := url.Parse(server.URL)
+			if err != nil {
+				t.Fatalf("url.Parse(%v) = %v", server.URL, err)
+			}
+
+			var created int
+			withCreator := func(do *defaultOpener) error {
+				do.createRepo = func(ctx context.Context, repo name.Repository) (bool, error) {
+					if !tc.create {
+						return false, nil
+					}
+					created++
+					exists = true
+					return true, nil
+				}
+				return nil
+			}
+			def, err := NewDefault(u.Host+"/blah", withCreator)
+			if err != nil {
+				t.Fatalf("NewDefault() = %v", err)
+			}
+			_, err = def.Publish(context.Background(), img, build.StrictScheme+"github.com/google/ko/test")
+			if (err != nil) != tc.wantErr {
+				t.Errorf("Publish() = %v, wantErr %v", err, tc.wantErr)
+			}
+			if created != tc.wantCreated {
+				t.Errorf("created %d repositories, want %d", created, tc.wantCreated)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"net"
+	"net/http"
+	"path"
+)
+
+// insecureHosts are patterns, as in path.Match, of registry hosts that may
+// be reached over plain HTTP or without verifying their TLS certificates.
+type insecureHosts []string
+
+// match returns whether host, with or without its port, matches one of the
+// patterns.
+func (h insecureHosts) match(host string) bool {
+	hostname := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		hostname = h
+	}
+	for _, pattern := range h {
+		if ok, _ := path.Match(pattern, host); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, hostname); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// hostTransport sends requests to insecure hosts with insecure, and all
+// other requests with secure.
+type hostTransport struct {
+	hosts    insecureHosts
+	secure   http.RoundTripper
+	insecure http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *hostTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.hosts.match(req.URL.Host) {
+		return t.insecure.RoundTrip(req)
+	}
+	return t.secure.RoundTrip(req)
+}
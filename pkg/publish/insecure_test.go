@@ -0,0 +1,94 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/ko/pkg/build"
+)
+
+func TestInsecureHostsMatch(t *testing.T) {
+	hosts := insecureHosts{"registry.internal", "*.corp.example.com", "10.0.0.1:5000"}
+	for host, want := range map[string]bool{
+		"registry.internal":           true,
+		"registry.internal:5000":      true,
+		"a.corp.example.com":          true,
+		"a.corp.example.com:443":      true,
+		"corp.example.com":            false,
+		"a.b.corp.example.com":        true,
+		"10.0.0.1:5000":               true,
+		"10.0.0.1:5001":               false,
+		"gcr.io":                      false,
+		"registry.internal.gcr.io":    false,
+		"registry.internal.evil.test": false,
+	} {
+		if got := hosts.match(host); got != want {
+			t.Errorf("match(%q) = %v, want %v", host, got, want)
+		}
+	}
+}
+
+func TestWithInsecureRegistries(t *testing.T) {
+	img, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+
+	// A registry serving a certificate that we don't trust.
+	server := httptest.NewTLSServer(registry.New())
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("url.Parse(%v) = %v", server.URL, err)
+	}
+
+	for _, tc := range []struct {
+		patterns []string
+		wantErr  bool
+	}{{
+		patterns: []string{"registry.internal", u.Hostname()},
+	}, {
+		patterns: []string{u.Host},
+	}, {
+		patterns: []string{"registry.internal"},
+		wantErr:  true,
+	}, {
+		wantErr: true,
+	}} {
+		t.Run(fmt.Sprint(tc.patterns), func(t *testing.T) {
+			def, err := NewDefault(u.Host+"/blah", WithInsecureRegistries(tc.patterns))
+			if err != nil {
+				t.Fatalf("NewDefault() = %v", err)
+			}
+			_, err = def.Publish(context.Background(), img, build.StrictScheme+"github.com/google/ko/test")
+			if (err != nil) != tc.wantErr {
+				t.Errorf("Publish() = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestWithInsecureRegistriesBadPattern(t *testing.T) {
+	if _, err := NewDefault("example.com/blah", WithInsecureRegistries([]string{"[registry"})); err == nil {
+		t.Error("NewDefault() with a bad pattern = nil, wanted error")
+	}
+}
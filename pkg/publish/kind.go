@@ -33,16 +33,43 @@ const (
 )
 
 type kindPublisher struct {
-	namer Namer
-	tags  []string
+	namer   Namer
+	tags    []string
+	cluster string
+	domain  string
+}
+
+// KindOption is a functional option for NewKindPublisher.
+type KindOption func(*kindPublisher)
+
+// WithKindCluster is a functional option for overriding the cluster, which
+// otherwise is $KIND_CLUSTER_NAME or the default kind cluster.
+func WithKindCluster(cluster string) KindOption {
+	return func(t *kindPublisher) {
+		t.cluster = cluster
+	}
+}
+
+// WithKindDomain is a functional option for naming the loaded images with a
+// domain other than kind.local, like that of a registry the cluster pulls
+// from, so the nodes have the images before they're pulled.
+func WithKindDomain(domain string) KindOption {
+	return func(t *kindPublisher) {
+		t.domain = domain
+	}
 }
 
 // NewKindPublisher returns a new publish.Interface that loads images into kind nodes.
-func NewKindPublisher(namer Namer, tags []string) Interface {
-	return &kindPublisher{
-		namer: namer,
-		tags:  tags,
+func NewKindPublisher(namer Namer, tags []string, opts ...KindOption) Interface {
+	t := &kindPublisher{
+		namer:  namer,
+		tags:   tags,
+		domain: KindDomain,
+	}
+	for _, opt := range opts {
+		opt(t)
 	}
+	return t
 }
 
 // Publish implements publish.Interface.
@@ -96,25 +123,25 @@ func (t *kindPublisher) Publish(ctx context.Context, br build.Result, s string)
 		return nil, err
 	}
 
-	digestTag, err := name.NewTag(fmt.Sprintf("%s:%s", t.namer(KindDomain, s), h.Hex))
+	digestTag, err := name.NewTag(fmt.Sprintf("%s:%s", t.namer(t.domain, s), h.Hex))
 	if err != nil {
 		return nil, err
 	}
 
 	log.Printf("Loading %v", digestTag)
-	if err := kind.Write(ctx, digestTag, img); err != nil {
+	if err := kind.ClusterWrite(ctx, t.cluster, digestTag, img); err != nil {
 		return nil, err
 	}
 	log.Printf("Loaded %v", digestTag)
 
 	for _, tagName := range t.tags {
 		log.Printf("Adding tag %v", tagName)
-		tag, err := name.NewTag(fmt.Sprintf("%s:%s", t.namer(KindDomain, s), tagName))
+		tag, err := name.NewTag(fmt.Sprintf("%s:%s", t.namer(t.domain, s), tagName))
 		if err != nil {
 			return nil, err
 		}
 
-		if err := kind.Tag(ctx, digestTag, tag); err != nil {
+		if err := kind.ClusterTag(ctx, t.cluster, digestTag, tag); err != nil {
 			return nil, err
 		}
 		log.Printf("Added tag %v", tagName)
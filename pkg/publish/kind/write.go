@@ -46,7 +46,13 @@ var GetProvider = func() provider {
 
 // Tag adds a tag to an already existent image.
 func Tag(ctx context.Context, src, dest name.Tag) error {
-	return onEachNode(func(n nodes.Node) error {
+	return ClusterTag(ctx, "", src, dest)
+}
+
+// ClusterTag is like Tag, but on the nodes of the named cluster. An empty
+// cluster means $KIND_CLUSTER_NAME, or the default cluster.
+func ClusterTag(ctx context.Context, cluster string, src, dest name.Tag) error {
+	return onEachNode(cluster, func(n nodes.Node) error {
 		var buf bytes.Buffer
 		cmd := n.CommandContext(ctx, "ctr", "--namespace=k8s.io", "images", "tag", "--force", src.String(), dest.String())
 		cmd.SetStdout(&buf)
@@ -60,7 +66,13 @@ func Tag(ctx context.Context, src, dest name.Tag) error {
 
 // Write saves the image into the kind nodes as the given tag.
 func Write(ctx context.Context, tag name.Tag, img v1.Image) error {
-	return onEachNode(func(n nodes.Node) error {
+	return ClusterWrite(ctx, "", tag, img)
+}
+
+// ClusterWrite is like Write, but into the nodes of the named cluster. An
+// empty cluster means $KIND_CLUSTER_NAME, or the default cluster.
+func ClusterWrite(ctx context.Context, cluster string, tag name.Tag, img v1.Image) error {
+	return onEachNode(cluster, func(n nodes.Node) error {
 		pr, pw := io.Pipe()
 
 		grp := errgroup.Group{}
@@ -84,9 +96,10 @@ func Write(ctx context.Context, tag name.Tag, img v1.Image) error {
 	})
 }
 
-// onEachNode executes the given function on each node. Exits on first error.
-func onEachNode(f func(nodes.Node) error) error {
-	nodeList, err := getNodes()
+// onEachNode executes the given function on each node of the cluster. Exits
+// on first error.
+func onEachNode(cluster string, f func(nodes.Node) error) error {
+	nodeList, err := getNodes(cluster)
 	if err != nil {
 		return err
 	}
@@ -99,12 +112,14 @@ func onEachNode(f func(nodes.Node) error) error {
 	return nil
 }
 
-// getNodes gets all the nodes of the default cluster.
-// Returns an error if none were found.
-func getNodes() ([]nodes.Node, error) {
+// getNodes gets all the nodes of the given cluster, or of the default cluster
+// if it's empty. Returns an error if none were found.
+func getNodes(clusterName string) ([]nodes.Node, error) {
 	provider := GetProvider()
 
-	clusterName := os.Getenv(clusterNameEnvKey)
+	if clusterName == "" {
+		clusterName = os.Getenv(clusterNameEnvKey)
+	}
 	if clusterName == "" {
 		clusterName = cluster.DefaultName
 	}
@@ -114,7 +129,7 @@ func getNodes() ([]nodes.Node, error) {
 		return nil, err
 	}
 	if len(nodeList) == 0 {
-		return nil, fmt.Errorf("no nodes found for cluster %q", cluster.DefaultName)
+		return nil, fmt.Errorf("no nodes found for cluster %q", clusterName)
 	}
 
 	return nodeList, nil
@@ -15,6 +15,7 @@
 package kind
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -25,6 +26,7 @@ import (
 
 	"github.com/google/go-containerregistry/pkg/name"
 	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
 	"sigs.k8s.io/kind/pkg/cluster/nodes"
 	"sigs.k8s.io/kind/pkg/exec"
 )
@@ -64,6 +66,53 @@ func TestWrite(t *testing.T) {
 	}
 }
 
+func TestWriteMultiNode(t *testing.T) {
+	ctx := context.Background()
+	img, err := random.Image(1024, 3)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	want, err := img.Digest()
+	if err != nil {
+		t.Fatalf("Digest() = %v", err)
+	}
+
+	tag, err := name.NewTag("kind.local/test:multi")
+	if err != nil {
+		t.Fatalf("name.NewTag() = %v", err)
+	}
+
+	ns := []*fakeNode{{}, {}, {}}
+	GetProvider = func() provider {
+		return &fakeProvider{nodes: []nodes.Node{ns[0], ns[1], ns[2]}}
+	}
+
+	if err := ClusterWrite(ctx, "multi", tag, img); err != nil {
+		t.Fatalf("ClusterWrite() = %v", err)
+	}
+
+	// Each node gets a whole tarball of the image, not a share of one stream.
+	for i, n := range ns {
+		if got, want := len(n.cmds), 1; got != want {
+			t.Fatalf("node %d: len(n.cmds) = %d, want %d", i, got, want)
+		}
+		data := n.cmds[0].data
+		loaded, err := tarball.Image(func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(data)), nil
+		}, &tag)
+		if err != nil {
+			t.Fatalf("node %d: tarball.Image() = %v", i, err)
+		}
+		got, err := loaded.Digest()
+		if err != nil {
+			t.Fatalf("node %d: Digest() = %v", i, err)
+		}
+		if got != want {
+			t.Errorf("node %d: loaded digest = %s, want %s", i, got, want)
+		}
+	}
+}
+
 func TestTag(t *testing.T) {
 	ctx := context.Background()
 	oldTag, err := name.NewTag("kind.local/test:test")
@@ -161,12 +210,51 @@ func TestFailCommands(t *testing.T) {
 	}
 }
 
+func TestClusterName(t *testing.T) {
+	ctx := context.Background()
+	img, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	tag, err := name.NewTag("kind.local/test:new")
+	if err != nil {
+		t.Fatalf("name.NewTag() = %v", err)
+	}
+
+	p := &fakeProvider{nodes: []nodes.Node{&fakeNode{}}}
+	GetProvider = func() provider {
+		return p
+	}
+
+	t.Setenv(clusterNameEnvKey, "")
+	if err := Write(ctx, tag, img); err != nil {
+		t.Fatalf("Write() = %v", err)
+	}
+	t.Setenv(clusterNameEnvKey, "from-env")
+	if err := Write(ctx, tag, img); err != nil {
+		t.Fatalf("Write() = %v", err)
+	}
+	if err := ClusterWrite(ctx, "explicit", tag, img); err != nil {
+		t.Fatalf("ClusterWrite() = %v", err)
+	}
+	if err := ClusterTag(ctx, "explicit", tag, tag); err != nil {
+		t.Fatalf("ClusterTag() = %v", err)
+	}
+
+	want := []string{"kind", "from-env", "explicit", "explicit"}
+	if got := strings.Join(p.names, ","); got != strings.Join(want, ",") {
+		t.Errorf("clusters = %s, want %s", got, strings.Join(want, ","))
+	}
+}
+
 // fakeProvider
 type fakeProvider struct {
 	nodes []nodes.Node
+	names []string
 }
 
 func (f *fakeProvider) ListInternalNodes(name string) ([]nodes.Node, error) {
+	f.names = append(f.names, name)
 	return f.nodes, nil
 }
 
@@ -198,12 +286,13 @@ type fakeCmd struct {
 	cmd   string
 	err   error
 	stdin io.Reader
+	data  []byte
 }
 
 func (f *fakeCmd) Run() error {
 	if f.stdin != nil {
 		// Consume the entire stdin to move the image publish forward.
-		ioutil.ReadAll(f.stdin)
+		f.data, _ = ioutil.ReadAll(f.stdin)
 	}
 	return f.err
 }
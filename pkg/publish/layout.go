@@ -18,13 +18,16 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"strings"
 
 	"github.com/google/go-containerregistry/pkg/name"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/empty"
 	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/match"
 	"github.com/google/go-containerregistry/pkg/v1/types"
 	"github.com/google/ko/pkg/build"
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
 type LayoutPublisher struct {
@@ -43,25 +46,32 @@ func NewLayout(path string) (Interface, error) {
 	return &LayoutPublisher{p}, nil
 }
 
-func (l *LayoutPublisher) writeResult(br build.Result) error {
+// writeResult writes br to the layout, named refName with the
+// org.opencontainers.image.ref.name annotation, which tools like
+// `skopeo copy oci:<path>:<refName>` select images by. Any image previously
+// written with the same name is replaced.
+func (l *LayoutPublisher) writeResult(br build.Result, refName string) error {
 	mt, err := br.MediaType()
 	if err != nil {
 		return err
 	}
 
+	annotations := layout.WithAnnotations(map[string]string{
+		specsv1.AnnotationRefName: refName,
+	})
 	switch mt {
 	case types.OCIImageIndex, types.DockerManifestList:
 		idx, ok := br.(v1.ImageIndex)
 		if !ok {
 			return fmt.Errorf("failed to interpret result as index: %v", br)
 		}
-		return l.p.AppendIndex(idx)
+		return l.p.ReplaceIndex(idx, match.Name(refName), annotations)
 	case types.OCIManifestSchema1, types.DockerManifestSchema2:
 		img, ok := br.(v1.Image)
 		if !ok {
 			return fmt.Errorf("failed to interpret result as image: %v", br)
 		}
-		return l.p.AppendImage(img)
+		return l.p.ReplaceImage(img, match.Name(refName), annotations)
 	default:
 		return fmt.Errorf("result image media type: %s", mt)
 	}
@@ -69,8 +79,9 @@ func (l *LayoutPublisher) writeResult(br build.Result) error {
 
 // Publish implements publish.Interface.
 func (l *LayoutPublisher) Publish(_ context.Context, br build.Result, s string) (name.Reference, error) {
+	s = strings.ToLower(strings.TrimPrefix(s, build.StrictScheme))
 	log.Printf("Saving %v", s)
-	if err := l.writeResult(br); err != nil {
+	if err := l.writeResult(br, s); err != nil {
 		return nil, err
 	}
 	log.Printf("Saved %v", s)
@@ -82,12 +93,39 @@ func (l *LayoutPublisher) Publish(_ context.Context, br build.Result, s string)
 
 	dig, err := name.NewDigest(fmt.Sprintf("%s@%s", l.p, h))
 	if err != nil {
-		return nil, err
+		// Paths aren't repository names, e.g. they may have upper case
+		// letters, but <path>@<digest> is still what oras and skopeo take.
+		return layoutReference{path: string(l.p), digest: h}, nil
 	}
 
 	return dig, nil
 }
 
+// layoutReference is the reference to an image in an OCI image layout whose
+// path doesn't parse as a repository name.
+type layoutReference struct {
+	path   string
+	digest v1.Hash
+}
+
+var _ name.Reference = layoutReference{}
+
+// Context implements name.Reference. The layout has no repository, so this
+// is the zero name.Repository.
+func (r layoutReference) Context() name.Repository { return name.Repository{} }
+
+// Identifier implements name.Reference.
+func (r layoutReference) Identifier() string { return r.digest.String() }
+
+// Name implements name.Reference.
+func (r layoutReference) Name() string { return r.String() }
+
+// String implements name.Reference.
+func (r layoutReference) String() string { return r.path + "@" + r.digest.String() }
+
+// Scope implements name.Reference.
+func (r layoutReference) Scope(string) string { return "" }
+
 func (l *LayoutPublisher) Close() error {
 	return nil
 }
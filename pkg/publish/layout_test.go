@@ -18,10 +18,15 @@ import (
 	"context"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
 	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/ko/pkg/build"
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
 func TestLayout(t *testing.T) {
@@ -47,3 +52,112 @@ func TestLayout(t *testing.T) {
 		t.Errorf("Publish() = %v, wanted prefix %v", d, tmp)
 	}
 }
+
+func TestLayoutReadBack(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	lp, err := NewLayout(dir)
+	if err != nil {
+		t.Fatalf("NewLayout() = %v", err)
+	}
+
+	old, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	img, err := random.Image(1024, 2)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	idx, err := random.Index(1024, 2, 3)
+	if err != nil {
+		t.Fatalf("random.Index() = %v", err)
+	}
+
+	// Publishing the same import path again replaces the old image.
+	for _, p := range []struct {
+		br         build.Result
+		importpath string
+	}{
+		{old, build.StrictScheme + "example.com/cmd/app"},
+		{img, build.StrictScheme + "example.com/cmd/app"},
+		{idx, build.StrictScheme + "example.com/cmd/multi"},
+	} {
+		ref, err := lp.Publish(ctx, p.br, p.importpath)
+		if err != nil {
+			t.Fatalf("Publish(%s) = %v", p.importpath, err)
+		}
+		d, err := p.br.Digest()
+		if err != nil {
+			t.Fatalf("Digest() = %v", err)
+		}
+		if want := dir + "@" + d.String(); ref.String() != want {
+			t.Errorf("Publish(%s) = %s, want %s", p.importpath, ref, want)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "oci-layout")); err != nil {
+		t.Errorf("oci-layout file: %v", err)
+	}
+	p, err := layout.FromPath(dir)
+	if err != nil {
+		t.Fatalf("layout.FromPath() = %v", err)
+	}
+	root, err := p.ImageIndex()
+	if err != nil {
+		t.Fatalf("ImageIndex() = %v", err)
+	}
+	im, err := root.IndexManifest()
+	if err != nil {
+		t.Fatalf("IndexManifest() = %v", err)
+	}
+	byName := map[string]v1.Descriptor{}
+	for _, desc := range im.Manifests {
+		byName[desc.Annotations[specsv1.AnnotationRefName]] = desc
+	}
+	if len(im.Manifests) != 2 || len(byName) != 2 {
+		t.Fatalf("index.json has manifests %v, want one per import path", im.Manifests)
+	}
+
+	wantImg, _ := img.Digest()
+	gotImg, err := root.Image(byName["example.com/cmd/app"].Digest)
+	if err != nil {
+		t.Fatalf("Image() = %v", err)
+	}
+	if d, err := gotImg.Digest(); err != nil || d != wantImg {
+		t.Errorf("example.com/cmd/app digest = %v, %v, want %s", d, err, wantImg)
+	}
+	if _, err := gotImg.RawConfigFile(); err != nil {
+		t.Errorf("RawConfigFile() = %v", err)
+	}
+
+	wantIdx, _ := idx.Digest()
+	gotIdx, err := root.ImageIndex(byName["example.com/cmd/multi"].Digest)
+	if err != nil {
+		t.Fatalf("ImageIndex() = %v", err)
+	}
+	if d, err := gotIdx.Digest(); err != nil || d != wantIdx {
+		t.Errorf("example.com/cmd/multi digest = %v, %v, want %s", d, err, wantIdx)
+	}
+	children, err := gotIdx.IndexManifest()
+	if err != nil {
+		t.Fatalf("IndexManifest() = %v", err)
+	}
+	for _, desc := range children.Manifests {
+		child, err := gotIdx.Image(desc.Digest)
+		if err != nil {
+			t.Fatalf("Image(%s) = %v", desc.Digest, err)
+		}
+		layers, err := child.Layers()
+		if err != nil {
+			t.Fatalf("Layers() = %v", err)
+		}
+		for _, l := range layers {
+			rc, err := l.Compressed()
+			if err != nil {
+				t.Fatalf("Compressed() = %v", err)
+			}
+			rc.Close()
+		}
+	}
+}
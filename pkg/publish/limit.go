@@ -0,0 +1,45 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"net/http"
+
+	"golang.org/x/sync/semaphore"
+)
+
+// limitedTransport composes with another http.RoundTripper to limit the
+// number of requests in flight, across all the pushes that share it.
+type limitedTransport struct {
+	inner     http.RoundTripper
+	semaphore *semaphore.Weighted
+}
+
+func newLimitedTransport(inner http.RoundTripper, n int) *limitedTransport {
+	return &limitedTransport{
+		inner:     inner,
+		semaphore: semaphore.NewWeighted(int64(n)),
+	}
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *limitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.semaphore.Acquire(req.Context(), 1); err != nil {
+		return nil, err
+	}
+	defer t.semaphore.Release(1)
+
+	return t.inner.RoundTrip(req)
+}
@@ -17,6 +17,7 @@ package publish
 import (
 	"context"
 	"errors"
+	"fmt"
 
 	"github.com/google/go-containerregistry/pkg/name"
 	"github.com/google/ko/pkg/build"
@@ -58,3 +59,49 @@ func (p *multiPublisher) Close() (err error) {
 	}
 	return
 }
+
+// References is the name.Reference returned by publishers created with
+// MirrorPublisher. It behaves as the reference to the primary repository,
+// and All holds the references to every repository the image was
+// published to.
+type References struct {
+	name.Reference
+	All []name.Reference
+}
+
+// MirrorPublisher creates a publisher that publishes to all the provided
+// publishers, like MultiPublisher, but for mirroring the same image to
+// several repositories.
+//
+// When calling Publish, the name.Reference returned will be a *References
+// whose primary reference is the return value of publishers[primary].
+func MirrorPublisher(primary int, publishers ...Interface) (Interface, error) {
+	if primary < 0 || primary >= len(publishers) {
+		return nil, fmt.Errorf("primary publisher %d out of range for %d publishers", primary, len(publishers))
+	}
+	return &mirrorPublisher{
+		multiPublisher: multiPublisher{publishers},
+		primary:        primary,
+	}, nil
+}
+
+type mirrorPublisher struct {
+	multiPublisher
+	primary int
+}
+
+// Publish implements publish.Interface.
+func (p *mirrorPublisher) Publish(ctx context.Context, br build.Result, s string) (name.Reference, error) {
+	refs := make([]name.Reference, 0, len(p.publishers))
+	for _, pub := range p.publishers {
+		ref, err := pub.Publish(ctx, br, s)
+		if err != nil {
+			return nil, err
+		}
+		refs = append(refs, ref)
+	}
+	return &References{
+		Reference: refs[p.primary],
+		All:       refs,
+	}, nil
+}
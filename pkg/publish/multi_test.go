@@ -21,7 +21,12 @@ import (
 	"os"
 	"testing"
 
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/ko/pkg/build"
+	kotesting "github.com/google/ko/pkg/internal/testing"
 	"github.com/google/ko/pkg/publish"
 )
 
@@ -75,3 +80,65 @@ func TestMulti_Zero(t *testing.T) {
 		t.Errorf("Publish() got nil error")
 	}
 }
+
+func TestMirror(t *testing.T) {
+	img, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	importpath := "github.com/google/ko/test"
+	h, err := img.Digest()
+	if err != nil {
+		t.Fatalf("Digest() = %v", err)
+	}
+	entries := map[string]v1.Hash{importpath: h}
+	ghcr := name.MustParseReference("ghcr.io/example/app").Context()
+	harbor := name.MustParseReference("harbor.example.com/mirror/app").Context()
+
+	for _, tc := range []struct {
+		primary int
+		want    name.Repository
+	}{{
+		primary: 0,
+		want:    ghcr,
+	}, {
+		primary: 1,
+		want:    harbor,
+	}} {
+		p, err := publish.MirrorPublisher(tc.primary,
+			kotesting.NewFixedPublish(ghcr, entries),
+			kotesting.NewFixedPublish(harbor, entries))
+		if err != nil {
+			t.Fatalf("MirrorPublisher() = %v", err)
+		}
+		ref, err := p.Publish(context.Background(), img, build.StrictScheme+importpath)
+		if err != nil {
+			t.Fatalf("Publish() = %v", err)
+		}
+		if got, want := ref.String(), kotesting.ComputeDigest(tc.want, importpath, h); got != want {
+			t.Errorf("Publish() = %s, want %s", got, want)
+		}
+
+		refs, ok := ref.(*publish.References)
+		if !ok {
+			t.Fatalf("Publish() = %T, want *publish.References", ref)
+		}
+		var got []string
+		for _, ref := range refs.All {
+			got = append(got, ref.String())
+		}
+		want := []string{
+			kotesting.ComputeDigest(ghcr, importpath, h),
+			kotesting.ComputeDigest(harbor, importpath, h),
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("References.All (-want +got) = %s", diff)
+		}
+	}
+}
+
+func TestMirror_PrimaryOutOfRange(t *testing.T) {
+	if _, err := publish.MirrorPublisher(1, publish.MultiPublisher()); err == nil {
+		t.Error("MirrorPublisher() = nil, wanted error")
+	}
+}
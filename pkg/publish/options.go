@@ -16,9 +16,11 @@ package publish
 
 import (
 	"crypto/tls"
+	"fmt"
 	"log"
 	"net/http"
 	"path"
+	"time"
 
 	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/name"
@@ -103,6 +105,134 @@ func WithTagOnly(tagOnly bool) Option {
 	}
 }
 
+// WithNoTag is a functional option for pushing images by digest only,
+// without writing any of the tags, for workflows that only consume digests.
+func WithNoTag() Option {
+	return func(i *defaultOpener) error {
+		i.noTag = true
+		return nil
+	}
+}
+
+// WithRetries is a functional option for retrying registry requests that fail
+// with transient errors, such as 5xx responses or network errors, up to retries
+// times. The first retry waits for delay, and each one after that waits twice
+// as long as the last, plus jitter. Without it, the defaults of
+// go-containerregistry are used.
+func WithRetries(retries int, delay time.Duration) Option {
+	return func(i *defaultOpener) error {
+		if retries < 0 {
+			return fmt.Errorf("push retries must not be negative, got %d", retries)
+		}
+		i.retry = &retryPolicy{retries: retries, delay: delay}
+		return nil
+	}
+}
+
+// WithPushConcurrency is a functional option for uploading the layers and
+// manifests of an image or index in parallel, with at most n requests to the
+// registry in flight at once. Without it, the images of an index are pushed
+// one at a time.
+func WithPushConcurrency(n int) Option {
+	return func(i *defaultOpener) error {
+		if n < 0 {
+			return fmt.Errorf("push concurrency must not be negative, got %d", n)
+		}
+		i.jobs = n
+		return nil
+	}
+}
+
+// WithInsecureRegistries is a functional option for treating only the
+// registries whose host matches one of patterns, as in path.Match (e.g.
+// "*.internal" or "registry.internal:5000"), as insecure: they may be reached
+// over plain HTTP, and their TLS certificates are not verified.
+func WithInsecureRegistries(patterns []string) Option {
+	return func(i *defaultOpener) error {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid insecure registry pattern %q: %w", pattern, err)
+			}
+		}
+		i.insecureHosts = patterns
+		return nil
+	}
+}
+
+// WithECRCreateRepo is a functional option for creating repositories in
+// Amazon ECR that don't exist yet, with the CreateRepository API, when
+// pushing to them fails. It has no effect on other registries.
+func WithECRCreateRepo() Option {
+	return func(i *defaultOpener) error {
+		i.createRepo = createECRRepository
+		return nil
+	}
+}
+
+// WithFailIfTagExists is a functional option for refusing to publish an
+// image if any of its tags, other than the default latest tag, already
+// points at a different image. This is useful with registries that make
+// tags immutable, where overwriting them fails partway through the push.
+func WithFailIfTagExists() Option {
+	return func(i *defaultOpener) error {
+		i.failIfTagExists = true
+		return nil
+	}
+}
+
+// WithMountBlobs is a functional option for mounting the layers of an image
+// from another repository on the same registry that they have already been
+// pushed to, rather than uploading them again, when images are published to
+// several repositories. Registries that don't support cross-repository
+// mounts get the layers uploaded as usual.
+func WithMountBlobs() Option {
+	return func(i *defaultOpener) error {
+		i.mountBlobs = true
+		return nil
+	}
+}
+
+// WithSBOMAttach is a functional option for how SBOMs are attached to the
+// images they describe: SBOMAttachTag, the default, pushes them with a tag
+// derived from the image's digest, and SBOMAttachReferrer pushes them as OCI
+// 1.1 referrers of the image, falling back to a tag if the registry doesn't
+// support the referrers API.
+func WithSBOMAttach(mode string) Option {
+	return func(i *defaultOpener) error {
+		switch mode {
+		case SBOMAttachTag:
+			i.sbomReferrers = false
+		case SBOMAttachReferrer:
+			i.sbomReferrers = true
+		default:
+			return fmt.Errorf("invalid SBOM attach mode %q, must be %q or %q", mode, SBOMAttachTag, SBOMAttachReferrer)
+		}
+		return nil
+	}
+}
+
+// WithSigner is a functional option for signing each image or index once it
+// has been pushed, with a cosign signature made by s. The signature is
+// pushed with a tag derived from the digest of the image, like `cosign sign`
+// does.
+func WithSigner(s Signer) Option {
+	return func(i *defaultOpener) error {
+		i.signer = s
+		return nil
+	}
+}
+
+// WithAttester is a functional option for attaching an attestation made by
+// a to each image or index once it has been pushed, with a tag derived from
+// the digest of the image, like `cosign attest` does. It can be passed more
+// than once to attach several attestations.
+func WithAttester(a Attester) Option {
+	return func(i *defaultOpener) error {
+		i.attesters = append(i.attesters, a)
+		return nil
+	}
+}
+
 func Insecure(b bool) Option {
 	return func(i *defaultOpener) error {
 		i.insecure = b
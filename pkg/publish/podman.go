@@ -0,0 +1,49 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/docker/docker/client"
+)
+
+// podmanHost returns the address of the podman API socket: $CONTAINER_HOST if
+// set, otherwise the rootless socket if it exists, otherwise the rootful one.
+func podmanHost() string {
+	if host := os.Getenv("CONTAINER_HOST"); host != "" {
+		return host
+	}
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		sock := filepath.Join(dir, "podman", "podman.sock")
+		if _, err := os.Stat(sock); err == nil {
+			return "unix://" + sock
+		}
+	}
+	return "unix:///run/podman/podman.sock"
+}
+
+// NewPodman returns a new publish.Interface that publishes images to podman,
+// through the Docker-compatible API of its socket.
+func NewPodman(namer Namer, tags []string, opts ...DaemonOption) (Interface, error) {
+	host := podmanHost()
+	c, err := client.NewClientWithOpts(client.WithHost(host), client.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, fmt.Errorf("connecting to podman at %s: %w", host, err)
+	}
+	return NewDaemon(namer, tags, append([]DaemonOption{WithDockerClient(c)}, opts...)...)
+}
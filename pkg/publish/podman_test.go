@@ -0,0 +1,122 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/random"
+)
+
+func TestPodmanHost(t *testing.T) {
+	dir := t.TempDir()
+
+	t.Setenv("CONTAINER_HOST", "")
+	t.Setenv("XDG_RUNTIME_DIR", dir)
+	if got, want := podmanHost(), "unix:///run/podman/podman.sock"; got != want {
+		t.Errorf("podmanHost() without a rootless socket = %s, want %s", got, want)
+	}
+
+	sock := filepath.Join(dir, "podman", "podman.sock")
+	if err := os.MkdirAll(filepath.Dir(sock), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(sock, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := podmanHost(), "unix://"+sock; got != want {
+		t.Errorf("podmanHost() with a rootless socket = %s, want %s", got, want)
+	}
+
+	t.Setenv("CONTAINER_HOST", "tcp://podman.example.com:8080")
+	if got, want := podmanHost(), "tcp://podman.example.com:8080"; got != want {
+		t.Errorf("podmanHost() with CONTAINER_HOST = %s, want %s", got, want)
+	}
+}
+
+func TestPodman(t *testing.T) {
+	img, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+
+	// Serve the parts of the Docker-compatible podman API that we use.
+	sock := filepath.Join(t.TempDir(), "podman.sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatalf("net.Listen() = %v", err)
+	}
+	var mu sync.Mutex
+	var requests []string
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, r.Method+" "+r.URL.Path[strings.Index(r.URL.Path[1:], "/")+1:]+"?"+r.URL.RawQuery)
+		mu.Unlock()
+		if _, err := io.Copy(ioutil.Discard, r.Body); err != nil {
+			t.Errorf("reading request body: %v", err)
+		}
+		w.Header().Set("Api-Version", "1.40")
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/images/load"):
+			w.Write([]byte(`{"stream":"Loaded image: ko.local/test"}`))
+		case strings.HasSuffix(r.URL.Path, "/tag"):
+			w.WriteHeader(http.StatusCreated)
+		}
+	})}
+	go server.Serve(l)
+	defer server.Close()
+	t.Setenv("CONTAINER_HOST", "unix://"+sock)
+
+	p, err := NewPodman(func(base, _ string) string { return base + "/test" }, []string{"latest"})
+	if err != nil {
+		t.Fatalf("NewPodman() = %v", err)
+	}
+	ref, err := p.Publish(context.Background(), img, "github.com/google/ko/test")
+	if err != nil {
+		t.Fatalf("Publish() = %v", err)
+	}
+	h, err := img.Digest()
+	if err != nil {
+		t.Fatalf("Digest() = %v", err)
+	}
+	if got, want := ref.String(), "ko.local/test:"+h.Hex; got != want {
+		t.Errorf("Publish() = %s, want %s", got, want)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{
+		"POST /images/load?quiet=0",
+		"POST /images/ko.local/test:" + h.Hex + "/tag?repo=ko.local%2Ftest&tag=latest",
+	}
+	var got []string
+	for _, r := range requests {
+		if !strings.HasPrefix(r, "GET /_ping") && !strings.HasPrefix(r, "HEAD /_ping") {
+			got = append(got, r)
+		}
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("requests = %q, want %q", got, want)
+	}
+}
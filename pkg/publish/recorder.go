@@ -52,22 +52,30 @@ func (r *recorder) Publish(ctx context.Context, br build.Result, ref string) (na
 		return nil, err
 	}
 
+	// Record every repository that the image was mirrored to.
+	results := []name.Reference{result}
+	if refs, ok := result.(*References); ok {
+		results = refs.All
+	}
+
 	references := make([]string, 0, 20 /* just try to avoid resizing*/)
-	switch t := br.(type) {
-	case oci.SignedImageIndex:
-		if err := walk.SignedEntity(ctx, t, func(ctx context.Context, se oci.SignedEntity) error {
-			// Both of the SignedEntity types implement Digest()
-			h, err := se.(interface{ Digest() (v1.Hash, error) }).Digest()
-			if err != nil {
-				return err
+	for _, result := range results {
+		switch t := br.(type) {
+		case oci.SignedImageIndex:
+			if err := walk.SignedEntity(ctx, t, func(ctx context.Context, se oci.SignedEntity) error {
+				// Both of the SignedEntity types implement Digest()
+				h, err := se.(interface{ Digest() (v1.Hash, error) }).Digest()
+				if err != nil {
+					return err
+				}
+				references = append(references, result.Context().Digest(h.String()).String())
+				return nil
+			}); err != nil {
+				return nil, err
 			}
-			references = append(references, result.Context().Digest(h.String()).String())
-			return nil
-		}); err != nil {
-			return nil, err
+		default:
+			references = append(references, result.String())
 		}
-	default:
-		references = append(references, result.String())
 	}
 
 	if _, err := r.wc.Write([]byte(strings.Join(references, "\n") + "\n")); err != nil {
@@ -99,3 +99,49 @@ func TestRecorder(t *testing.T) {
 		}
 	}
 }
+
+func TestRecorderMirror(t *testing.T) {
+	repos := []name.Repository{
+		name.MustParseReference("ghcr.io/example/app").Context(),
+		name.MustParseReference("harbor.example.com/mirror/app").Context(),
+	}
+	var mirrors []Interface
+	for _, repo := range repos {
+		repo := repo
+		mirrors = append(mirrors, &cbPublish{cb: func(c context.Context, b build.Result, s string) (name.Reference, error) {
+			h, err := b.Digest()
+			if err != nil {
+				return nil, err
+			}
+			return repo.Digest(h.String()), nil
+		}})
+	}
+	inner, err := MirrorPublisher(0, mirrors...)
+	if err != nil {
+		t.Fatalf("MirrorPublisher() = %v", err)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	recorder, err := NewRecorder(inner, buf)
+	if err != nil {
+		t.Fatalf("NewRecorder() = %v", err)
+	}
+
+	img, err := random.Image(3, 3)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	if _, err := recorder.Publish(context.Background(), img, ""); err != nil {
+		t.Errorf("recorder.Publish() = %v", err)
+	}
+
+	refs := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if got, want := len(refs), len(repos); got != want {
+		t.Fatalf("len(refs) = %d, want %d", got, want)
+	}
+	for i, repo := range repos {
+		if !strings.HasPrefix(refs[i], repo.String()+"@") {
+			t.Errorf("refs[%d] = %s, want a digest in %s", i, refs[i], repo)
+		}
+	}
+}
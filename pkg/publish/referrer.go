@@ -0,0 +1,215 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/sigstore/cosign/pkg/oci"
+)
+
+const (
+	// SBOMAttachTag attaches SBOMs with a tag derived from the digest of
+	// the image, like cosign does.
+	SBOMAttachTag = "tag"
+	// SBOMAttachReferrer attaches SBOMs as OCI 1.1 referrers of the image.
+	SBOMAttachReferrer = "referrer"
+
+	emptyConfigMediaType types.MediaType = "application/vnd.oci.empty.v1+json"
+)
+
+// referrerWriter attaches files to images as OCI 1.1 referrers: image
+// manifests with an artifactType, and a subject pointing at the image.
+type referrerWriter struct {
+	auth authn.Authenticator
+	t    http.RoundTripper
+}
+
+// supported returns whether the registry of repo implements the referrers
+// API, by asking it for the referrers of dig.
+func (rw *referrerWriter) supported(ctx context.Context, repo name.Repository, dig v1.Hash) (bool, error) {
+	tr, err := transport.NewWithContext(ctx, repo.Registry, rw.auth, rw.t, []string{repo.Scope(transport.PullScope)})
+	if err != nil {
+		return false, err
+	}
+	u := url.URL{
+		Scheme: repo.Registry.Scheme(),
+		Host:   repo.RegistryStr(),
+		Path:   fmt.Sprintf("/v2/%s/referrers/%s", repo.RepositoryStr(), dig),
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := (&http.Client{Transport: tr}).Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if err := transport.CheckError(resp, http.StatusOK); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// write attaches f to se in repo as a referrer, if the registry supports it,
+// and returns the reference of the referrer manifest. It returns nil if the
+// registry doesn't support referrers.
+func (rw *referrerWriter) write(ctx context.Context, repo name.Repository, se oci.SignedEntity, f oci.File, opt []remote.Option) (name.Reference, error) {
+	subject, err := describe(se)
+	if err != nil {
+		return nil, err
+	}
+	if ok, err := rw.supported(ctx, repo, subject.Digest); err != nil {
+		return nil, fmt.Errorf("checking for referrers API support: %w", err)
+	} else if !ok {
+		return nil, nil
+	}
+
+	payload, err := f.Payload()
+	if err != nil {
+		return nil, err
+	}
+	fileMT, err := f.FileMediaType()
+	if err != nil {
+		return nil, err
+	}
+	artifactType := normalizeMediaType(fileMT)
+	config := &blob{b: []byte("{}"), mt: emptyConfigMediaType}
+	file := &blob{b: payload, mt: artifactType}
+	for _, l := range []*blob{config, file} {
+		if err := remote.WriteLayer(repo, l, opt...); err != nil {
+			return nil, err
+		}
+	}
+
+	raw, err := json.Marshal(referrerManifest{
+		SchemaVersion: 2,
+		MediaType:     types.OCIManifestSchema1,
+		ArtifactType:  artifactType,
+		Config:        config.descriptor(),
+		Layers:        []v1.Descriptor{file.descriptor()},
+		Subject:       subject,
+	})
+	if err != nil {
+		return nil, err
+	}
+	h, _, err := v1.SHA256(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	ref := repo.Digest(h.String())
+	if err := remote.Put(ref, &rawManifest{raw: raw, mt: types.OCIManifestSchema1}, opt...); err != nil {
+		return nil, err
+	}
+	return ref, nil
+}
+
+// describe returns the descriptor of se, to use as the subject of referrers.
+func describe(se oci.SignedEntity) (*v1.Descriptor, error) {
+	d, ok := se.(interface {
+		Digest() (v1.Hash, error)
+		MediaType() (types.MediaType, error)
+		Size() (int64, error)
+	})
+	if !ok {
+		return nil, fmt.Errorf("unable to describe %T", se)
+	}
+	h, err := d.Digest()
+	if err != nil {
+		return nil, err
+	}
+	mt, err := d.MediaType()
+	if err != nil {
+		return nil, err
+	}
+	size, err := d.Size()
+	if err != nil {
+		return nil, err
+	}
+	return &v1.Descriptor{MediaType: mt, Digest: h, Size: size}, nil
+}
+
+// normalizeMediaType turns the SPDX media type that cosign uses, spdx+json,
+// into a valid one.
+func normalizeMediaType(mt types.MediaType) types.MediaType {
+	if !strings.Contains(string(mt), "/") {
+		return "application/" + mt
+	}
+	return mt
+}
+
+// referrerManifest is an OCI 1.1 image manifest, which go-containerregistry
+// can't represent yet.
+type referrerManifest struct {
+	SchemaVersion int64           `json:"schemaVersion"`
+	MediaType     types.MediaType `json:"mediaType"`
+	ArtifactType  types.MediaType `json:"artifactType"`
+	Config        v1.Descriptor   `json:"config"`
+	Layers        []v1.Descriptor `json:"layers"`
+	Subject       *v1.Descriptor  `json:"subject"`
+}
+
+// rawManifest implements remote.Taggable.
+type rawManifest struct {
+	raw []byte
+	mt  types.MediaType
+}
+
+func (m *rawManifest) RawManifest() ([]byte, error)        { return m.raw, nil }
+func (m *rawManifest) MediaType() (types.MediaType, error) { return m.mt, nil }
+
+// blob is an uncompressed v1.Layer with the given contents.
+type blob struct {
+	b  []byte
+	mt types.MediaType
+}
+
+var _ v1.Layer = (*blob)(nil)
+
+func (b *blob) Digest() (v1.Hash, error) {
+	h, _, err := v1.SHA256(bytes.NewReader(b.b))
+	return h, err
+}
+func (b *blob) DiffID() (v1.Hash, error) { return b.Digest() }
+func (b *blob) Compressed() (io.ReadCloser, error) {
+	return ioutil.NopCloser(bytes.NewReader(b.b)), nil
+}
+func (b *blob) Uncompressed() (io.ReadCloser, error) { return b.Compressed() }
+func (b *blob) Size() (int64, error)                 { return int64(len(b.b)), nil }
+func (b *blob) MediaType() (types.MediaType, error)  { return b.mt, nil }
+
+func (b *blob) descriptor() v1.Descriptor {
+	h, _ := b.Digest()
+	return v1.Descriptor{MediaType: b.mt, Digest: h, Size: int64(len(b.b))}
+}
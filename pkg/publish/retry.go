@@ -0,0 +1,81 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+)
+
+// retryPolicy describes how failed registry requests are retried.
+type retryPolicy struct {
+	// retries is the number of times to retry a failed request, after the
+	// first attempt.
+	retries int
+	// delay is how long to wait before the first retry. Each retry waits
+	// twice as long as the one before, plus jitter.
+	delay time.Duration
+}
+
+// options returns the remote options that apply the policy to every request
+// made while pushing.
+func (p retryPolicy) options() []remote.Option {
+	return []remote.Option{
+		remote.WithRetryBackoff(remote.Backoff{
+			Duration: p.delay,
+			Factor:   2.0,
+			// Add up to 50% jitter, so that concurrent pushes that
+			// failed together don't all retry together.
+			Jitter: 0.5,
+			Steps:  p.retries + 1,
+		}),
+		remote.WithRetryPredicate(func(err error) bool {
+			if !isRetryable(err) {
+				return false
+			}
+			log.Printf("Retrying: %v", err)
+			return true
+		}),
+	}
+}
+
+// isRetryable returns whether err looks like a transient failure of the
+// registry or the network, rather than the registry refusing the request.
+func isRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var terr *transport.Error
+	if errors.As(err, &terr) {
+		// Server errors and rate limiting may succeed later, but the
+		// registry rejecting our credentials or manifest won't.
+		return terr.StatusCode >= http.StatusInternalServerError || terr.StatusCode == http.StatusTooManyRequests
+	}
+	var nerr net.Error
+	if errors.As(err, &nerr) {
+		return true
+	}
+	return errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EPIPE)
+}
@@ -0,0 +1,105 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/sigstore/cosign/pkg/oci"
+	"github.com/sigstore/cosign/pkg/oci/mutate"
+	ociremote "github.com/sigstore/cosign/pkg/oci/remote"
+)
+
+// Signer signs the payload of a cosign signature, returning the signature
+// to attach to the image.
+type Signer func(ctx context.Context, payload []byte) (oci.Signature, error)
+
+// simpleSigning is the payload cosign signs for an image.
+type simpleSigning struct {
+	Critical ssCritical             `json:"critical"`
+	Optional map[string]interface{} `json:"optional"`
+}
+
+type ssCritical struct {
+	Identity ssIdentity `json:"identity"`
+	Image    ssImage    `json:"image"`
+	Type     string     `json:"type"`
+}
+
+type ssIdentity struct {
+	DockerReference string `json:"docker-reference"`
+}
+
+type ssImage struct {
+	DockerManifestDigest string `json:"docker-manifest-digest"`
+}
+
+// sign signs the image or index that was pushed as dig with d.signer, and
+// pushes the signature next to it, where cosign looks for it.
+func (d *defalt) sign(ctx context.Context, dig name.Digest, ro []remote.Option) error {
+	payload, err := json.Marshal(simpleSigning{
+		Critical: ssCritical{
+			Identity: ssIdentity{DockerReference: dig.Context().String()},
+			Image:    ssImage{DockerManifestDigest: dig.DigestStr()},
+			Type:     "cosign container image signature",
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	ociOpts, err := cosignOptions(ro)
+	if err != nil {
+		return err
+	}
+	// Fetch the entity from the registry, so we keep the signatures it
+	// may already have.
+	se, err := ociremote.SignedEntity(dig, ociOpts...)
+	if err != nil {
+		return err
+	}
+	sig, err := d.signer(ctx, payload)
+	if err != nil {
+		return fmt.Errorf("signing %v: %w", dig, err)
+	}
+	se, err = mutate.AttachSignatureToEntity(se, sig)
+	if err != nil {
+		return err
+	}
+	log.Printf("Signing %v", dig)
+	if err := ociremote.WriteSignatures(dig.Context(), se, ociOpts...); err != nil {
+		return fmt.Errorf("writing signature: %w", err)
+	}
+	return nil
+}
+
+// cosignOptions returns the options to read and write the signatures and
+// attestations of images with, respecting COSIGN_REPOSITORY.
+func cosignOptions(ro []remote.Option) ([]ociremote.Option, error) {
+	ociOpts := []ociremote.Option{ociremote.WithRemoteOptions(ro...)}
+	targetRepoOverride, err := ociremote.GetEnvTargetRepository()
+	if err != nil {
+		return nil, err
+	}
+	if (targetRepoOverride != name.Repository{}) {
+		ociOpts = append(ociOpts, ociremote.WithTargetRepository(targetRepoOverride))
+	}
+	return ociOpts, nil
+}
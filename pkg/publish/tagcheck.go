@@ -0,0 +1,59 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+
+	"github.com/google/ko/pkg/build"
+)
+
+// checkTags returns an error if any of d's tags of repo already exists and
+// points at something other than br. The default tag is only used so that
+// registries accept the push, and references don't include it, so it is
+// not checked.
+func (d *defalt) checkTags(br build.Result, repo string, no []name.Option, ro []remote.Option) error {
+	h, err := br.Digest()
+	if err != nil {
+		return err
+	}
+	for _, tagName := range d.tags {
+		if tagName == defaultTags[0] {
+			continue
+		}
+		tag, err := name.NewTag(fmt.Sprintf("%s:%s", repo, tagName), no...)
+		if err != nil {
+			return err
+		}
+		desc, err := remote.Head(tag, ro...)
+		if err != nil {
+			var terr *transport.Error
+			if errors.As(err, &terr) && terr.StatusCode == http.StatusNotFound {
+				continue
+			}
+			return fmt.Errorf("checking whether %v exists: %w", tag, err)
+		}
+		if desc.Digest != h {
+			return fmt.Errorf("tag %v already exists with digest %v, refusing to overwrite it with %v", tag, desc.Digest, h)
+		}
+	}
+	return nil
+}
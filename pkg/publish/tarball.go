@@ -17,7 +17,9 @@ package publish
 import (
 	"context"
 	"fmt"
+	"io"
 	"log"
+	"os"
 	"strings"
 
 	"github.com/google/go-containerregistry/pkg/name"
@@ -26,8 +28,13 @@ import (
 	"github.com/google/ko/pkg/build"
 )
 
+// StdoutTarball is the file that NewTarball streams the tarball to standard
+// output for, rather than saving it.
+const StdoutTarball = "-"
+
 type tar struct {
 	file  string
+	w     io.Writer
 	base  string
 	namer Namer
 	tags  []string
@@ -35,7 +42,13 @@ type tar struct {
 }
 
 // NewTarball returns a new publish.Interface that saves images to a tarball.
+// Every image that is published is saved to the same tarball, with a combined
+// manifest.json, when the publisher is closed. If file is StdoutTarball, the
+// tarball is written to standard output instead.
 func NewTarball(file, base string, namer Namer, tags []string) Interface {
+	if file == StdoutTarball {
+		return NewTarballWriter(os.Stdout, base, namer, tags)
+	}
 	return &tar{
 		file:  file,
 		base:  base,
@@ -45,6 +58,18 @@ func NewTarball(file, base string, namer Namer, tags []string) Interface {
 	}
 }
 
+// NewTarballWriter returns a new publish.Interface that writes images to w as
+// a tarball, like NewTarball does to a file, when the publisher is closed.
+func NewTarballWriter(w io.Writer, base string, namer Namer, tags []string) Interface {
+	return &tar{
+		w:     w,
+		base:  base,
+		namer: namer,
+		tags:  tags,
+		refs:  make(map[name.Reference]v1.Image),
+	}
+}
+
 // Publish implements publish.Interface.
 func (t *tar) Publish(_ context.Context, br build.Result, s string) (name.Reference, error) {
 	s = strings.TrimPrefix(s, build.StrictScheme)
@@ -93,6 +118,14 @@ func (t *tar) Publish(_ context.Context, br build.Result, s string) (name.Refere
 }
 
 func (t *tar) Close() error {
+	if t.w != nil {
+		log.Print("Writing tarball")
+		if err := tarball.MultiRefWrite(t.refs, t.w); err != nil {
+			log.Printf("failed to write tarball: %v", err)
+			return err
+		}
+		return nil
+	}
 	log.Printf("Saving %v", t.file)
 	if err := tarball.MultiRefWriteToFile(t.file, t.refs); err != nil {
 		// Bad practice, but we log  this here because right now we just defer the Close.
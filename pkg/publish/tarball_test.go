@@ -15,15 +15,19 @@
 package publish_test
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"strings"
 	"testing"
 
 	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
 	"github.com/google/ko/pkg/publish"
 )
 
@@ -69,3 +73,103 @@ func TestTarball(t *testing.T) {
 		}
 	}
 }
+
+func TestTarballMultipleImages(t *testing.T) {
+	base := "blah"
+	repoName := fmt.Sprintf("%s/%s", "example.com", base)
+	importpaths := []string{
+		"github.com/google/ko/cmd/foo",
+		"github.com/google/ko/cmd/bar",
+		"github.com/google/ko/cmd/baz",
+	}
+
+	fp, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fp.Close()
+	defer os.Remove(fp.Name())
+
+	tp := publish.NewTarball(fp.Name(), repoName, md5Hash, []string{"latest"})
+	want := map[string]v1.Hash{}
+	for _, importpath := range importpaths {
+		img, err := random.Image(1024, 1)
+		if err != nil {
+			t.Fatalf("random.Image() = %v", err)
+		}
+		if _, err := tp.Publish(context.Background(), img, importpath); err != nil {
+			t.Fatalf("Publish() = %v", err)
+		}
+		h, err := img.Digest()
+		if err != nil {
+			t.Fatalf("Digest() = %v", err)
+		}
+		want[md5Hash(repoName, importpath)+":latest"] = h
+	}
+	if err := tp.Close(); err != nil {
+		t.Fatalf("Close() = %v", err)
+	}
+
+	opener := func() (io.ReadCloser, error) { return os.Open(fp.Name()) }
+	m, err := tarball.LoadManifest(opener)
+	if err != nil {
+		t.Fatalf("LoadManifest() = %v", err)
+	}
+	if got, want := len(m), len(importpaths); got != want {
+		t.Errorf("len(manifest.json) = %d, want %d", got, want)
+	}
+	for ref, h := range want {
+		tag, err := name.NewTag(ref)
+		if err != nil {
+			t.Fatalf("NewTag() = %v", err)
+		}
+		img, err := tarball.Image(opener, &tag)
+		if err != nil {
+			t.Errorf("tarball.Image(%s) = %v", tag, err)
+			continue
+		}
+		if got, err := img.Digest(); err != nil {
+			t.Errorf("Digest() = %v", err)
+		} else if got != h {
+			t.Errorf("tarball.Image(%s) digest = %s, want %s", tag, got, h)
+		}
+	}
+}
+
+func TestTarballWriter(t *testing.T) {
+	img, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	repoName := fmt.Sprintf("%s/%s", "example.com", "blah")
+	importpath := "github.com/google/ko/cmd/foo"
+
+	var buf bytes.Buffer
+	tp := publish.NewTarballWriter(&buf, repoName, md5Hash, []string{"latest"})
+	if _, err := tp.Publish(context.Background(), img, importpath); err != nil {
+		t.Fatalf("Publish() = %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("Publish() wrote %d bytes, wanted the tarball to be written on Close()", buf.Len())
+	}
+	if err := tp.Close(); err != nil {
+		t.Fatalf("Close() = %v", err)
+	}
+
+	tag, err := name.NewTag(md5Hash(repoName, importpath) + ":latest")
+	if err != nil {
+		t.Fatalf("NewTag() = %v", err)
+	}
+	opener := func() (io.ReadCloser, error) { return ioutil.NopCloser(bytes.NewReader(buf.Bytes())), nil }
+	got, err := tarball.Image(opener, &tag)
+	if err != nil {
+		t.Fatalf("tarball.Image(%s) = %v", tag, err)
+	}
+	if gh, err := got.Digest(); err != nil {
+		t.Errorf("Digest() = %v", err)
+	} else if wh, err := img.Digest(); err != nil {
+		t.Errorf("Digest() = %v", err)
+	} else if gh != wh {
+		t.Errorf("tarball.Image(%s) digest = %s, want %s", tag, gh, wh)
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resolve
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EncodeJSON encodes doc, a document decoded from JSON, back into indented
+// JSON. Unlike decoding doc into an interface{} and marshaling that, it keeps
+// the keys of objects in their original order.
+func EncodeJSON(doc *yaml.Node) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := encodeJSON(&buf, doc); err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	if err := json.Indent(&out, buf.Bytes(), "", "  "); err != nil {
+		return nil, err
+	}
+	out.WriteByte('\n')
+	return out.Bytes(), nil
+}
+
+func encodeJSON(buf *bytes.Buffer, n *yaml.Node) error {
+	switch n.Kind {
+	case yaml.DocumentNode:
+		if len(n.Content) != 1 {
+			return fmt.Errorf("document has %d nodes, expected 1", len(n.Content))
+		}
+		return encodeJSON(buf, n.Content[0])
+
+	case yaml.MappingNode:
+		buf.WriteByte('{')
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			k, err := json.Marshal(n.Content[i].Value)
+			if err != nil {
+				return err
+			}
+			buf.Write(k)
+			buf.WriteByte(':')
+			if err := encodeJSON(buf, n.Content[i+1]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+
+	case yaml.SequenceNode:
+		buf.WriteByte('[')
+		for i, c := range n.Content {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := encodeJSON(buf, c); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+
+	case yaml.ScalarNode:
+		switch n.ShortTag() {
+		case "!!null":
+			buf.WriteString("null")
+		case "!!bool", "!!int", "!!float":
+			// Keep numbers as they were written, e.g. 1.0, if they're
+			// valid JSON.
+			if json.Valid([]byte(n.Value)) {
+				buf.WriteString(n.Value)
+				return nil
+			}
+			var v interface{}
+			if err := n.Decode(&v); err != nil {
+				return err
+			}
+			b, err := json.Marshal(v)
+			if err != nil {
+				return err
+			}
+			buf.Write(b)
+		default:
+			b, err := json.Marshal(n.Value)
+			if err != nil {
+				return err
+			}
+			buf.Write(b)
+		}
+
+	case yaml.AliasNode:
+		return encodeJSON(buf, n.Alias)
+
+	default:
+		return fmt.Errorf("unexpected node kind %v", n.Kind)
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resolve
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"gopkg.in/yaml.v3"
+)
+
+func TestEncodeJSON(t *testing.T) {
+	input := `{"zeta": 1.0, "alpha": [true, null, "x"], "nested": {"b": -2, "a": "é"}}`
+	want := `{
+  "zeta": 1.0,
+  "alpha": [
+    true,
+    null,
+    "x"
+  ],
+  "nested": {
+    "b": -2,
+    "a": "é"
+  }
+}
+`
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(input), &doc); err != nil {
+		t.Fatalf("Unmarshal() = %v", err)
+	}
+	got, err := EncodeJSON(&doc)
+	if err != nil {
+		t.Fatalf("EncodeJSON() = %v", err)
+	}
+	if diff := cmp.Diff(want, string(got)); diff != "" {
+		t.Errorf("EncodeJSON() (-want +got) = %v", diff)
+	}
+}
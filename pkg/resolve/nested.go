@@ -0,0 +1,179 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resolve
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// WithNested is a functional option for also resolving the references in the
+// YAML (or JSON) documents embedded in the string values at paths, e.g. a
+// ConfigMap's /data/config.yaml. Paths are JSON pointers (RFC 6901) into
+// each document, where * matches any key or index. Values that aren't valid
+// YAML are left as they are.
+func WithNested(paths ...string) Option {
+	return func(o *options) {
+		o.nested = append(o.nested, paths...)
+	}
+}
+
+// embedded is a string value holding documents whose references are
+// resolved along with the ones of the document it's in.
+type embedded struct {
+	node      *yaml.Node
+	docs      []*yaml.Node
+	positions Positions
+}
+
+// findEmbedded returns the values at o.nested in docs that embed references,
+// with their documents decoded, outermost first.
+func findEmbedded(docs []*yaml.Node, o *options) []*embedded {
+	var es []*embedded
+	for _, node := range nestedValues(docs, o) {
+		var edocs []*yaml.Node
+		decoder := yaml.NewDecoder(strings.NewReader(node.Value))
+		for {
+			var doc yaml.Node
+			if err := decoder.Decode(&doc); err != nil {
+				if !errors.Is(err, io.EOF) {
+					log.Printf("Warning: not resolving the references in the value at line %d, it isn't valid YAML: %v", node.Line, err)
+					edocs = nil
+				}
+				break
+			}
+			edocs = append(edocs, &doc)
+		}
+		if len(edocs) == 0 {
+			continue
+		}
+		es = append(es, &embedded{
+			node:      node,
+			docs:      edocs,
+			positions: findReferences(edocs, o),
+		})
+		es = append(es, findEmbedded(edocs, o)...)
+	}
+	return es
+}
+
+// nestedValues returns the string values at o.nested in docs that mention
+// o.scheme, but aren't references themselves.
+func nestedValues(docs []*yaml.Node, o *options) []*yaml.Node {
+	var nodes []*yaml.Node
+	for _, path := range o.nested {
+		tokens := pathTokens(path)
+		for _, doc := range docs {
+			for _, node := range lookup(doc, tokens) {
+				if node.Kind != yaml.ScalarNode || node.ShortTag() != "!!str" ||
+					!strings.Contains(node.Value, o.scheme) ||
+					strings.HasPrefix(strings.TrimSpace(node.Value), o.scheme) {
+					continue
+				}
+				nodes = append(nodes, node)
+			}
+		}
+	}
+	return nodes
+}
+
+// encode sets the embedding value to its documents, once their references
+// are resolved, keeping its formatting if it can.
+func (e *embedded) encode() error {
+	if b, ok := e.positions.Rewrite([]byte(e.node.Value)); ok {
+		e.node.Value = string(b)
+		return nil
+	}
+
+	buf := &bytes.Buffer{}
+	if isJSONValue(e.node.Value) {
+		for _, doc := range e.docs {
+			b, err := EncodeJSON(doc)
+			if err != nil {
+				return err
+			}
+			buf.Write(b)
+		}
+	} else {
+		enc := yaml.NewEncoder(buf)
+		enc.SetIndent(2)
+		for _, doc := range e.docs {
+			if err := enc.Encode(doc); err != nil {
+				return err
+			}
+		}
+		if err := enc.Close(); err != nil {
+			return err
+		}
+	}
+	e.node.Value = buf.String()
+	return nil
+}
+
+func isJSONValue(s string) bool {
+	s = strings.TrimSpace(s)
+	return strings.HasPrefix(s, "{") || strings.HasPrefix(s, "[")
+}
+
+// pathTokens splits the JSON pointer path into its unescaped reference
+// tokens.
+func pathTokens(path string) []string {
+	path = strings.TrimPrefix(path, "/")
+	if path == "" {
+		return nil
+	}
+	tokens := strings.Split(path, "/")
+	for i, t := range tokens {
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(t)
+	}
+	return tokens
+}
+
+// lookup returns the nodes at the path made of tokens in node.
+func lookup(node *yaml.Node, tokens []string) []*yaml.Node {
+	if node.Kind == yaml.DocumentNode {
+		if len(node.Content) == 0 {
+			return nil
+		}
+		node = node.Content[0]
+	}
+	if len(tokens) == 0 {
+		return []*yaml.Node{node}
+	}
+	token, rest := tokens[0], tokens[1:]
+
+	var nodes []*yaml.Node
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if token == "*" || node.Content[i].Value == token {
+				nodes = append(nodes, lookup(node.Content[i+1], rest)...)
+			}
+		}
+	case yaml.SequenceNode:
+		for i, item := range node.Content {
+			if token == "*" || token == strconv.Itoa(i) {
+				nodes = append(nodes, lookup(item, rest)...)
+			}
+		}
+	}
+	return nodes
+}
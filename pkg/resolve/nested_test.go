@@ -0,0 +1,141 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resolve
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	kotesting "github.com/google/ko/pkg/internal/testing"
+	"gopkg.in/yaml.v3"
+)
+
+func TestNested(t *testing.T) {
+	base := mustRepository("gcr.io/multi-pass")
+	foo := kotesting.ComputeDigest(base, fooRef, fooHash)
+	bar := kotesting.ComputeDigest(base, barRef, barHash)
+	baz := kotesting.ComputeDigest(base, bazRef, bazHash)
+
+	tests := []struct {
+		desc  string
+		paths []string
+		input string
+		want  string
+	}{{
+		desc:  "literal block",
+		paths: []string{"/data/config.yaml"},
+		input: `data:
+  config.yaml: |
+    # The image to run.
+    image: ko://%[1]s
+    sidecar: "ko://%[2]s"
+`,
+		want: `data:
+  config.yaml: |
+    # The image to run.
+    image: %[4]s
+    sidecar: "%[5]s"
+`,
+	}, {
+		desc:  "json and wildcard",
+		paths: []string{"/items/*/value"},
+		input: `items:
+- value: '{"image": "ko://%[1]s"}'
+- value: '{"image": "ko://%[3]s"}'
+`,
+		want: `items:
+  - value: '{"image": "%[4]s"}'
+  - value: '{"image": "%[6]s"}'
+`,
+	}, {
+		desc:  "twice nested",
+		paths: []string{"/outer", "/inner"},
+		input: `outer: |
+  inner: |
+    image: ko://%[2]s
+`,
+		want: `outer: |
+  inner: |
+    image: %[5]s
+`,
+	}, {
+		desc:  "not selected",
+		paths: []string{"/other"},
+		input: `data: |
+  image: ko://%[1]s
+`,
+		want: `data: |
+  image: ko://%[1]s
+`,
+	}, {
+		desc:  "invalid yaml",
+		paths: []string{"/data"},
+		input: `data: |
+  image: ko://%[1]s
+  	bad: [
+`,
+		want: `data: |
+  image: ko://%[1]s
+  	bad: [
+`,
+	}, {
+		desc:  "reference itself",
+		paths: []string{"/image"},
+		input: `image: ko://%[1]s
+`,
+		want: `image: %[4]s
+`,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			doc := strToYAML(t, fmt.Sprintf(test.input, fooRef, barRef, bazRef, foo, bar, baz))
+			if err := ImageReferences(context.Background(), []*yaml.Node{doc}, testBuilder, kotesting.NewFixedPublish(base, testHashes), WithNested(test.paths...)); err != nil {
+				t.Fatalf("ImageReferences() = %v", err)
+			}
+			want := yamlToStr(t, strToYAML(t, fmt.Sprintf(test.want, fooRef, barRef, bazRef, foo, bar, baz)))
+			if diff := cmp.Diff(want, yamlToStr(t, doc)); diff != "" {
+				t.Errorf("ImageReferences(); (-want +got) = %v", diff)
+			}
+		})
+	}
+}
+
+func TestLookup(t *testing.T) {
+	doc := strToYAML(t, `a/b:
+  "~x": [one, two]
+c: three
+`)
+	for path, want := range map[string][]string{
+		"/a~1b/~0x/1": {"two"},
+		"/a~1b/~0x/*": {"one", "two"},
+		"/*":          {"three"},
+		"/c":          {"three"},
+		"/d":          nil,
+		"/c/0":        nil,
+	} {
+		var got []string
+		for _, node := range lookup(doc, pathTokens(path)) {
+			if node.Kind == yaml.ScalarNode {
+				got = append(got, node.Value)
+			}
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("lookup(%s); (-want +got) = %v", path, diff)
+		}
+	}
+}
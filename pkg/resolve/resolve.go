@@ -17,6 +17,7 @@ package resolve
 import (
 	"context"
 	"fmt"
+	gb "go/build"
 	"strings"
 	"sync"
 
@@ -27,19 +28,74 @@ import (
 	"gopkg.in/yaml.v3"
 )
 
+// Option is a functional option for ImageReferences and FindReferences.
+type Option func(*options)
+
+type options struct {
+	scheme         string
+	nested         []string
+	kubernetesOnly bool
+}
+
+// WithScheme is a functional option for recognizing references to images by
+// scheme, e.g. image://, instead of build.StrictScheme. The builder is still
+// passed the references with build.StrictScheme.
+func WithScheme(scheme string) Option {
+	return func(o *options) {
+		o.scheme = scheme
+	}
+}
+
+// WithKubernetesObjectsOnly is a functional option for only resolving the
+// references in documents that look like Kubernetes objects, with an
+// apiVersion and a kind, and leaving other YAML documents as they are. The
+// documents embedded in the objects (see WithNested) are still resolved.
+func WithKubernetesObjectsOnly() Option {
+	return func(o *options) {
+		o.kubernetesOnly = true
+	}
+}
+
+func makeOptions(opts ...Option) *options {
+	o := &options{scheme: build.StrictScheme}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
 // ImageReferences resolves supported references to images within the input yaml
 // to published image digests.
 //
 // If a reference can be built and pushed, its yaml.Node will be mutated.
-func ImageReferences(ctx context.Context, docs []*yaml.Node, builder build.Interface, publisher publish.Interface) error {
-	// First, walk the input objects and collect a list of supported references
+func ImageReferences(ctx context.Context, docs []*yaml.Node, builder build.Interface, publisher publish.Interface, opts ...Option) error {
+	o := makeOptions(opts...)
+	docs = o.documents(docs)
+
+	// First, walk the input objects, and the ones embedded in them, and
+	// collect a list of supported references
 	refs := make(map[string][]*yaml.Node)
 
-	for _, doc := range docs {
-		it := refsFromDoc(doc)
+	embeds := findEmbedded(docs, o)
+	all := append([]*yaml.Node{}, docs...)
+	for _, e := range embeds {
+		all = append(all, e.docs...)
+	}
+	for _, doc := range all {
+		it := refsFromDoc(doc, o.scheme)
 
 		for node, ok := it(); ok; node, ok = it() {
-			ref := strings.TrimSpace(node.Value)
+			ref := build.StrictScheme + strings.TrimPrefix(strings.TrimSpace(node.Value), o.scheme)
+
+			// Build local import paths, like ko://./cmd/app, by their
+			// complete import path, which images are named after.
+			if path := strings.TrimPrefix(ref, build.StrictScheme); gb.IsLocalImport(path) {
+				qualified, err := builder.QualifyImport(path)
+				if err != nil {
+					return fmt.Errorf("found strict reference but %s is not a valid import path: %w", ref, err)
+				}
+				ref = build.StrictScheme + strings.TrimPrefix(qualified, build.StrictScheme)
+			}
 
 			if err := builder.IsSupportedReference(ref); err != nil {
 				return fmt.Errorf("found strict reference but %s is not a valid import path: %w", ref, err)
@@ -50,8 +106,10 @@ func ImageReferences(ctx context.Context, docs []*yaml.Node, builder build.Inter
 	}
 
 	// Next, perform parallel builds for each of the supported references.
+	// The builder bounds how many of them run at once (see build.WithJobs),
+	// and the first failure cancels the rest.
 	var sm sync.Map
-	var errg errgroup.Group
+	errg, ctx := errgroup.WithContext(ctx)
 	for ref := range refs {
 		ref := ref
 		errg.Go(func() error {
@@ -84,13 +142,39 @@ func ImageReferences(ctx context.Context, docs []*yaml.Node, builder build.Inter
 		}
 	}
 
+	// Then put the embedded documents back into their values, innermost
+	// first.
+	for i := len(embeds) - 1; i >= 0; i-- {
+		if err := embeds[i].encode(); err != nil {
+			return fmt.Errorf("error encoding embedded documents at line %d: %w", embeds[i].node.Line, err)
+		}
+	}
+
 	return nil
 }
 
-func refsFromDoc(doc *yaml.Node) yit.Iterator {
+// documents returns the docs whose references are resolved.
+func (o *options) documents(docs []*yaml.Node) []*yaml.Node {
+	if !o.kubernetesOnly {
+		return docs
+	}
+	var objs []*yaml.Node
+	for _, doc := range docs {
+		node := doc
+		if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+			node = node.Content[0]
+		}
+		if kind, err := docKind(node); err == nil && kind != "" {
+			objs = append(objs, doc)
+		}
+	}
+	return objs
+}
+
+func refsFromDoc(doc *yaml.Node, scheme string) yit.Iterator {
 	it := yit.FromNode(doc).
 		RecurseNodes().
 		Filter(yit.StringValue)
 
-	return it.Filter(yit.WithPrefix(build.StrictScheme))
+	return it.Filter(yit.WithPrefix(scheme))
 }
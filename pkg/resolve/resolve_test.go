@@ -17,7 +17,11 @@ package resolve
 import (
 	"bytes"
 	"context"
+	"fmt"
+	"path"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
@@ -250,6 +254,26 @@ func TestYAMLObject(t *testing.T) {
 	}
 }
 
+func TestKubernetesObjectsOnly(t *testing.T) {
+	base := mustRepository("gcr.io/bazinga")
+	object := strToYAML(t, fmt.Sprintf("apiVersion: v1\nkind: Pod\nimage: %s\n", build.StrictScheme+fooRef))
+	config := strToYAML(t, fmt.Sprintf("image: %s\n", build.StrictScheme+barRef))
+	docs := []*yaml.Node{object, config}
+
+	if ps := FindReferences(docs, WithKubernetesObjectsOnly()); len(ps) != 1 {
+		t.Errorf("FindReferences() = %d references, want 1", len(ps))
+	}
+	if err := ImageReferences(context.Background(), docs, testBuilder, kotesting.NewFixedPublish(base, testHashes), WithKubernetesObjectsOnly()); err != nil {
+		t.Fatalf("ImageReferences() = %v", err)
+	}
+	if got, want := yamlToStr(t, object), fmt.Sprintf("apiVersion: v1\nkind: Pod\nimage: %s\n", kotesting.ComputeDigest(base, fooRef, fooHash)); got != want {
+		t.Errorf("ImageReferences() object = %s, want %s", got, want)
+	}
+	if got, want := yamlToStr(t, config), fmt.Sprintf("image: %s\n", build.StrictScheme+barRef); got != want {
+		t.Errorf("ImageReferences() config = %s, want %s", got, want)
+	}
+}
+
 func TestStrict(t *testing.T) {
 	refs := []string{
 		fooRef,
@@ -272,6 +296,29 @@ func TestStrict(t *testing.T) {
 	t.Log(yamlToStr(t, doc))
 }
 
+// qualifyingBuilder qualifies local import paths as packages in module.
+type qualifyingBuilder struct {
+	build.Interface
+	module string
+}
+
+func (q qualifyingBuilder) QualifyImport(ip string) (string, error) {
+	return build.StrictScheme + path.Join(q.module, ip), nil
+}
+
+func TestLocalReference(t *testing.T) {
+	base := mustRepository("gcr.io/multi-pass")
+	doc := strToYAML(t, "image: ko://./foo\n")
+	builder := qualifyingBuilder{testBuilder, "github.com/awesomesauce"}
+
+	if err := ImageReferences(context.Background(), []*yaml.Node{doc}, builder, kotesting.NewFixedPublish(base, testHashes)); err != nil {
+		t.Fatalf("ImageReferences: %v", err)
+	}
+	if got, want := yamlToStr(t, doc), fmt.Sprintf("image: %s\n", kotesting.ComputeDigest(base, fooRef, fooHash)); got != want {
+		t.Errorf("ImageReferences() = %q, want %q", got, want)
+	}
+}
+
 func TestIsSupportedReferenceError(t *testing.T) {
 	ref := build.StrictScheme + fooRef
 
@@ -292,6 +339,80 @@ func TestIsSupportedReferenceError(t *testing.T) {
 	}
 }
 
+// barrierBuild blocks each build until n of them are running at once, and
+// fails the build of fail right away.
+type barrierBuild struct {
+	build.Interface
+	started chan struct{}
+	n       int
+	fail    string
+}
+
+func (b *barrierBuild) Build(ctx context.Context, s string) (build.Result, error) {
+	if s == build.StrictScheme+b.fail {
+		return nil, fmt.Errorf("building %s failed", s)
+	}
+	b.started <- struct{}{}
+	for len(b.started) < b.n {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(time.Millisecond):
+		}
+	}
+	return b.Interface.Build(ctx, s)
+}
+
+func TestParallelBuilds(t *testing.T) {
+	refs := []string{fooRef, barRef, bazRef, fooRef}
+	doc := strToYAML(t, fmt.Sprintf("[%s]", strings.Join(refs, ", ")))
+	for _, node := range doc.Content[0].Content {
+		node.Value = build.StrictScheme + node.Value
+	}
+	base := mustRepository("gcr.io/multi-pass")
+
+	// The builds of the three distinct references only finish if they run
+	// concurrently.
+	builder := &barrierBuild{Interface: testBuilder, started: make(chan struct{}, 3), n: 3}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := ImageReferences(ctx, []*yaml.Node{doc}, builder, kotesting.NewFixedPublish(base, testHashes)); err != nil {
+		t.Fatalf("ImageReferences() = %v", err)
+	}
+
+	var got []string
+	if err := doc.Decode(&got); err != nil {
+		t.Fatalf("Decode() = %v", err)
+	}
+	want := []string{
+		kotesting.ComputeDigest(base, fooRef, fooHash),
+		kotesting.ComputeDigest(base, barRef, barHash),
+		kotesting.ComputeDigest(base, bazRef, bazHash),
+		kotesting.ComputeDigest(base, fooRef, fooHash),
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("ImageReferences(); (-want +got) = %v", diff)
+	}
+}
+
+func TestBuildErrorCancels(t *testing.T) {
+	doc := strToYAML(t, fmt.Sprintf("[%s%s, %s%s]", build.StrictScheme, fooRef, build.StrictScheme, bazRef))
+	base := mustRepository("gcr.io/multi-pass")
+
+	// foo waits for builds that never come, while baz fails, so foo's build
+	// only returns once the error cancels it.
+	builder := &barrierBuild{Interface: testBuilder, started: make(chan struct{}, 3), n: 3, fail: bazRef}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err := ImageReferences(ctx, []*yaml.Node{doc}, builder, kotesting.NewFixedPublish(base, testHashes))
+	if err == nil {
+		t.Fatal("ImageReferences() = nil, wanted an error")
+	}
+	if ctx.Err() != nil {
+		t.Fatalf("ImageReferences() = %v, wanted it to return before the deadline", err)
+	}
+}
+
 func mustRandom() build.Result {
 	img, err := random.Index(1024, 5, 1)
 	if err != nil {
@@ -0,0 +1,152 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resolve
+
+import (
+	"bytes"
+	"sort"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Positions records where the references in some documents were decoded
+// from, so that they can be rewritten in place once ImageReferences has
+// resolved them.
+type Positions []position
+
+type position struct {
+	node   *yaml.Node
+	line   int
+	column int
+	style  yaml.Style
+	value  string
+	// embeds is whether the value embeds documents with references, rather
+	// than being one.
+	embeds bool
+}
+
+// FindReferences returns the positions of the references in docs. It must be
+// called before ImageReferences mutates them, with the same options.
+func FindReferences(docs []*yaml.Node, opts ...Option) Positions {
+	o := makeOptions(opts...)
+	return findReferences(o.documents(docs), o)
+}
+
+func findReferences(docs []*yaml.Node, o *options) Positions {
+	var ps Positions
+	for _, doc := range docs {
+		it := refsFromDoc(doc, o.scheme)
+		for node, ok := it(); ok; node, ok = it() {
+			ps = append(ps, position{
+				node:   node,
+				line:   node.Line,
+				column: node.Column,
+				style:  node.Style,
+				value:  node.Value,
+			})
+		}
+	}
+	for _, node := range nestedValues(docs, o) {
+		ps = append(ps, position{node: node, embeds: true})
+	}
+	return ps
+}
+
+// Rewrite returns b, which the documents were decoded from, with each of the
+// references replaced by the value ImageReferences resolved it to. The rest
+// of b, including comments, key order and formatting, is left as-is.
+//
+// It returns false if a reference can't be rewritten in place, e.g. because
+// it's a block scalar, it has escape sequences or it embeds documents, in
+// which case the documents should be encoded instead.
+func (ps Positions) Rewrite(b []byte) ([]byte, bool) {
+	type edit struct {
+		start, end int
+		text       string
+	}
+	edits := make([]edit, 0, len(ps))
+	lines := lineOffsets(b)
+	for _, p := range ps {
+		if p.embeds {
+			return nil, false
+		}
+		var quote string
+		switch p.style {
+		case 0:
+		case yaml.DoubleQuotedStyle:
+			quote = `"`
+		case yaml.SingleQuotedStyle:
+			quote = `'`
+		default:
+			return nil, false
+		}
+		if p.line < 1 || p.line > len(lines) {
+			return nil, false
+		}
+		start, ok := columnOffset(b, lines[p.line-1], p.column)
+		if !ok {
+			return nil, false
+		}
+		raw := quote + p.value + quote
+		if !bytes.HasPrefix(b[start:], []byte(raw)) {
+			return nil, false
+		}
+		edits = append(edits, edit{
+			start: start,
+			end:   start + len(raw),
+			text:  quote + p.node.Value + quote,
+		})
+	}
+
+	sort.Slice(edits, func(i, j int) bool { return edits[i].start < edits[j].start })
+	var out bytes.Buffer
+	last := 0
+	for _, e := range edits {
+		if e.start < last {
+			return nil, false
+		}
+		out.Write(b[last:e.start])
+		out.WriteString(e.text)
+		last = e.end
+	}
+	out.Write(b[last:])
+	return out.Bytes(), true
+}
+
+// lineOffsets returns the offset in b of the start of each line.
+func lineOffsets(b []byte) []int {
+	offsets := []int{0}
+	for i, c := range b {
+		if c == '\n' {
+			offsets = append(offsets, i+1)
+		}
+	}
+	return offsets
+}
+
+// columnOffset returns the offset in b of the 1-based column, counted in
+// characters like yaml.v3 does, of the line starting at offset start.
+func columnOffset(b []byte, start, column int) (int, bool) {
+	off := start
+	for c := 1; c < column; c++ {
+		if off >= len(b) || b[off] == '\n' {
+			return 0, false
+		}
+		_, size := utf8.DecodeRune(b[off:])
+		off += size
+	}
+	return off, true
+}
@@ -0,0 +1,92 @@
+// Copyright 2022 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resolve
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	kotesting "github.com/google/ko/pkg/internal/testing"
+	"gopkg.in/yaml.v3"
+)
+
+func TestRewrite(t *testing.T) {
+	base := mustRepository("gcr.io/rewrite")
+	foo := kotesting.ComputeDigest(base, fooRef, fooHash)
+	bar := kotesting.ComputeDigest(base, barRef, barHash)
+
+	for _, tc := range []struct {
+		desc   string
+		input  string
+		want   string
+		wantOK bool
+	}{{
+		desc:   "plain and quoted",
+		input:  "a: ko://%[1]s  # foo\nb: [\"ko://%[2]s\", 'ko://%[1]s']\n",
+		want:   "a: %[1]s  # foo\nb: [\"%[2]s\", '%[1]s']\n",
+		wantOK: true,
+	}, {
+		desc:   "multiple documents",
+		input:  "# first\na: ko://%[1]s\n---\n# second\nb: ko://%[2]s\n",
+		want:   "# first\na: %[1]s\n---\n# second\nb: %[2]s\n",
+		wantOK: true,
+	}, {
+		desc:   "multi-byte characters before the reference",
+		input:  "ключ: ko://%[1]s\n",
+		want:   "ключ: %[1]s\n",
+		wantOK: true,
+	}, {
+		desc:  "block scalar",
+		input: "a: |-\n  ko://%[1]s\n",
+	}, {
+		desc:  "escape sequence",
+		input: "a: \"ko://%[1]s\\x20\"\n",
+	}} {
+		t.Run(tc.desc, func(t *testing.T) {
+			input := []byte(fmt.Sprintf(tc.input, fooRef, barRef))
+			var docs []*yaml.Node
+			decoder := yaml.NewDecoder(bytes.NewReader(input))
+			for {
+				var doc yaml.Node
+				if err := decoder.Decode(&doc); errors.Is(err, io.EOF) {
+					break
+				} else if err != nil {
+					t.Fatalf("Decode() = %v", err)
+				}
+				docs = append(docs, &doc)
+			}
+
+			positions := FindReferences(docs)
+			if err := ImageReferences(context.Background(), docs, testBuilder, kotesting.NewFixedPublish(base, testHashes)); err != nil {
+				t.Fatalf("ImageReferences() = %v", err)
+			}
+			got, ok := positions.Rewrite(input)
+			if ok != tc.wantOK {
+				t.Fatalf("Rewrite() = %t, wanted %t", ok, tc.wantOK)
+			}
+			if !ok {
+				return
+			}
+			if diff := cmp.Diff(fmt.Sprintf(tc.want, foo, bar), string(got)); diff != "" {
+				t.Errorf("Rewrite() (-want +got) = %v", diff)
+			}
+		})
+	}
+}
@@ -23,12 +23,25 @@ import (
 )
 
 // MatchesSelector returns true if the Kubernetes object (represented as a
-// yaml.Node) matches the selector. An error is returned if the yaml.Node is
-// not an K8s object or list.
+// yaml.Node) matches the selector, which may be equality or set based.
+// Objects without labels don't match. An error is returned if the yaml.Node
+// is not an K8s object or list.
 //
 // If the document is a list, the yaml.Node will be mutated to only include
 // items that match the selector.
 func MatchesSelector(doc *yaml.Node, selector labels.Selector) (bool, error) {
+	return matchesSelector(doc, "labels", selector)
+}
+
+// MatchesAnnotationSelector is like MatchesSelector, but matches the
+// selector against the annotations of the object rather than its labels.
+func MatchesAnnotationSelector(doc *yaml.Node, selector labels.Selector) (bool, error) {
+	return matchesSelector(doc, "annotations", selector)
+}
+
+// matchesSelector matches selector against the metadata field (labels or
+// annotations) of the object or the items of the list doc.
+func matchesSelector(doc *yaml.Node, field string, selector labels.Selector) (bool, error) {
 	// ignore the document node
 	if doc.Kind == yaml.DocumentNode && len(doc.Content) > 0 {
 		doc = doc.Content[0]
@@ -43,10 +56,10 @@ func MatchesSelector(doc *yaml.Node, selector labels.Selector) (bool, error) {
 	}
 
 	if kind == "List" {
-		return listMatchesSelector(doc, selector)
+		return listMatchesSelector(doc, field, selector)
 	}
 
-	return objMatchesSelector(doc, selector), nil
+	return objMatchesSelector(doc, field, selector), nil
 }
 
 func docKind(doc *yaml.Node) (string, error) {
@@ -79,7 +92,7 @@ func docKind(doc *yaml.Node) (string, error) {
 	return node.Value, nil
 }
 
-func objMatchesSelector(doc *yaml.Node, selector labels.Selector) bool {
+func objMatchesSelector(doc *yaml.Node, field string, selector labels.Selector) bool {
 	it := y.FromNode(doc).
 		Filter(y.WithKind(yaml.MappingNode)).
 		// Return the metadata map
@@ -89,25 +102,26 @@ func objMatchesSelector(doc *yaml.Node, selector labels.Selector) bool {
 			// Value Predicate
 			y.WithKind(yaml.MappingNode),
 		).
-		// Return the labels map
+		// Return the labels (or annotations) map
 		ValuesForMap(
 			// Key Predicate
-			y.WithStringValue("labels"),
+			y.WithStringValue(field),
 			// Value Predicate
 			y.WithKind(yaml.MappingNode),
 		)
 
 	node, ok := it()
 
-	// Object has no metadata.labels, verify matching against an empty set.
+	// Objects without metadata.labels (or annotations) are never selected,
+	// not even by selectors like "!app" that an empty set would match.
 	if !ok {
-		node = emptyMapNode
+		return false
 	}
 
 	return selector.Matches(labelsNode{node})
 }
 
-func listMatchesSelector(doc *yaml.Node, selector labels.Selector) (bool, error) {
+func listMatchesSelector(doc *yaml.Node, field string, selector labels.Selector) (bool, error) {
 	it := y.FromNode(doc).ValuesForMap(
 		// Key Predicate
 		y.WithStringValue("items"),
@@ -128,7 +142,7 @@ func listMatchesSelector(doc *yaml.Node, selector labels.Selector) (bool, error)
 			return false, err
 		}
 
-		if objMatchesSelector(content, selector) {
+		if objMatchesSelector(content, field, selector) {
 			matches = append(matches, content)
 		}
 	}
@@ -137,11 +151,6 @@ func listMatchesSelector(doc *yaml.Node, selector labels.Selector) (bool, error)
 	return len(matches) != 0, nil
 }
 
-var emptyMapNode = &yaml.Node{
-	Kind: yaml.MappingNode,
-	Tag:  "!!map",
-}
-
 type labelsNode struct {
 	*yaml.Node
 }
@@ -29,6 +29,9 @@ var (
 
 	hasSelector    = selector(`app`)
 	notHasSelector = selector(`!app`)
+
+	inSelector    = selector(`app in (web,api)`)
+	notInSelector = selector(`app notin (web,api)`)
 )
 
 const (
@@ -159,8 +162,34 @@ func TestMatchesSelector(t *testing.T) {
 		desc:     "single non-labeled object with not-has selector",
 		input:    podNoLabel,
 		selector: notHasSelector,
-		output:   podNoLabel,
+		matches:  false,
+	}, {
+		desc:     "single object with in selector",
+		input:    webPod,
+		selector: inSelector,
+		output:   webPod,
+		matches:  true,
+	}, {
+		desc:     "single object with non-matching in selector",
+		input:    dbPod,
+		selector: inSelector,
+		matches:  false,
+	}, {
+		desc:     "single object with notin selector",
+		input:    dbPod,
+		selector: notInSelector,
+		output:   dbPod,
 		matches:  true,
+	}, {
+		desc:     "single object with non-matching notin selector",
+		input:    webPod,
+		selector: notInSelector,
+		matches:  false,
+	}, {
+		desc:     "single non-labeled object with notin selector",
+		input:    podNoLabel,
+		selector: notInSelector,
+		matches:  false,
 	}, {
 		desc:     "selector matching elements of list object",
 		input:    podList,
@@ -179,10 +208,21 @@ func TestMatchesSelector(t *testing.T) {
 		selector: hasSelector,
 		matches:  false,
 	}, {
-		desc:     "not-has selector matching all non-labeled elements of list object",
+		desc:     "not-has selector matching no non-labeled element of list object",
 		input:    podListNoLabel,
 		selector: notHasSelector,
-		output:   podListNoLabel,
+		matches:  false,
+	}, {
+		desc:     "in selector matching elements of list object",
+		input:    podList,
+		selector: inSelector,
+		output:   webPodList,
+		matches:  true,
+	}, {
+		desc:     "notin selector matching elements of list object",
+		input:    podList,
+		selector: notInSelector,
+		output:   dbPodList,
 		matches:  true,
 	}, {
 		desc:     "selector matching all elements of list object",
@@ -222,6 +262,103 @@ func TestMatchesSelector(t *testing.T) {
 	}
 }
 
+func TestMatchesAnnotationSelector(t *testing.T) {
+	annotatedPod := `apiVersion: v1
+kind: Pod
+metadata:
+  annotations:
+    app: web
+  name: rss-site
+`
+	annotatedPodList := `apiVersion: v1
+kind: List
+metadata:
+  resourceVersion: ""
+  selfLink: ""
+items:
+- apiVersion: v1
+  kind: Pod
+  metadata:
+    annotations:
+      app: web
+    name: rss-site
+- apiVersion: v1
+  kind: Pod
+  metadata:
+    annotations:
+      app: db
+    name: rss-db
+`
+	annotatedWebPodList := `apiVersion: v1
+kind: List
+metadata:
+  resourceVersion: ""
+  selfLink: ""
+items:
+- apiVersion: v1
+  kind: Pod
+  metadata:
+    annotations:
+      app: web
+    name: rss-site
+`
+	tests := []struct {
+		desc     string
+		input    string
+		selector labels.Selector
+		output   string
+		matches  bool
+	}{{
+		desc:     "single object with matching selector",
+		input:    annotatedPod,
+		selector: webSelector,
+		output:   annotatedPod,
+		matches:  true,
+	}, {
+		desc:     "single object with non-matching selector",
+		input:    annotatedPod,
+		selector: notWebSelector,
+		matches:  false,
+	}, {
+		desc:     "labels aren't annotations",
+		input:    webPod,
+		selector: webSelector,
+		matches:  false,
+	}, {
+		desc:     "single non-annotated object with not-has selector",
+		input:    podNoLabel,
+		selector: notHasSelector,
+		matches:  false,
+	}, {
+		desc:     "selector matching elements of list object",
+		input:    annotatedPodList,
+		selector: inSelector,
+		output:   annotatedWebPodList,
+		matches:  true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			doc := strToYAML(t, test.input)
+			matches, err := MatchesAnnotationSelector(doc, test.selector)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if matches != test.matches {
+				t.Errorf("unexpected result: got %v - want %v", matches, test.matches)
+			}
+
+			if test.output != "" {
+				output := normalizeYAML(t, test.output)
+				if diff := cmp.Diff(output, yamlToStr(t, doc)); diff != "" {
+					t.Errorf("unexpected diff (-want, +got) %v", diff)
+				}
+			}
+		})
+	}
+}
+
 func TestSelectorFailure(t *testing.T) {
 	tests := []struct {
 		desc  string